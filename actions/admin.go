@@ -0,0 +1,382 @@
+package actions
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/auth"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/outbox"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/actions/search"
+	"github.com/leonids/test-buffalo/actions/webhooks"
+	"github.com/leonids/test-buffalo/config"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"gopkg.in/authboss.v1"
+)
+
+// adminRole is the role of the authboss users allowed in /admin.
+const adminRole = "admin"
+
+// basicAuth are the credentials of the scripts calling /api/v1 and
+// /admin.
+var basicAuth = config.Current.BasicAuth
+
+// The audit trail of the dashboard, in the outbox with the other events
+// of the authboss users, or of the feature flags for the flag ones.
+// They're kept out of the activity stream.
+const (
+	adminLocked               = "admin.locked"
+	adminUnlocked             = "admin.unlocked"
+	adminPasswordExpired      = "admin.password_expired"
+	adminSessionsRevoked      = "admin.sessions_revoked"
+	adminImpersonated         = "admin.impersonated"
+	adminImpersonationStopped = "admin.impersonation_stopped"
+	adminFlagSaved            = "admin.flag_saved"
+	adminFlagDeleted          = "admin.flag_deleted"
+)
+
+// adminLock is how long the dashboard locks users out: until they're
+// unlocked, in practice.
+const adminLock = 100 * 365 * 24 * time.Hour
+
+// recoverTokenTTL is how long the link of a forced password reset works,
+// the default of the recover module of authboss.
+const recoverTokenTTL = 24 * time.Hour
+
+// adminPageSize is the number of users the search shows.
+const adminPageSize = 50
+
+// adminAction is the payload of the audit events.
+type adminAction struct {
+	// Admin is the email of the admin, or the basic auth user of a
+	// script.
+	Admin string `json:"admin"`
+	Email string `json:"email"`
+}
+
+func initAdminRoutes(g *buffalo.App) {
+	html := func(summary string) openapi.Operation {
+		return openapi.Operation{Summary: summary, Tags: []string{"admin"}, ContentType: "text/html", Response: ""}
+	}
+	users := html("Search the users")
+	users.Query = []openapi.Parameter{{Name: "q"}}
+	api.Document(g.GET("/users", AdminUsersHandler), users)
+	api.Document(g.GET("/users/{user_id}", AdminUserHandler), html("A user and the state of its account"))
+
+	actions := map[string]buffalo.Handler{
+		"lock":            AdminLockHandler,
+		"unlock":          AdminUnlockHandler,
+		"expire_password": AdminExpirePasswordHandler,
+		"revoke_sessions": AdminRevokeSessionsHandler,
+		"impersonate":     AdminImpersonateHandler,
+	}
+	for _, name := range []string{"lock", "unlock", "expire_password", "revoke_sessions", "impersonate"} {
+		op := html("Act on the account of a user")
+		op.Description = "A form of the dashboard: it takes the csrf_token field and redirects back to the user."
+		op.Status = http.StatusSeeOther
+		api.Document(g.POST("/users/{user_id}/"+name, actions[name]), op)
+	}
+}
+
+// RequireAdmin lets the authboss users with the admin role in, and the
+// scripts with the basic auth credentials. The name of the admin is in
+// the context as "admin".
+func RequireAdmin(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		if name := adminName(c); name != "" {
+			c.Set("admin", name)
+			return next(c)
+		}
+		if user := currentUser(c); user != nil {
+			return problem.Forbidden("%s is not an admin", user.Email)
+		}
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		return problem.Unauthorized("sign in as an admin")
+	}
+}
+
+// adminName is the email of the signed in admin, or the basic auth user
+// of a script, empty for everybody else.
+func adminName(c buffalo.Context) string {
+	if user := currentUser(c); user != nil && user.Role == adminRole {
+		return user.Email
+	}
+	if name, pass, ok := c.Request().BasicAuth(); ok && validBasicAuth(name, pass) {
+		return name
+	}
+	return ""
+}
+
+func validBasicAuth(name, pass string) bool {
+	n := subtle.ConstantTimeCompare([]byte(name), []byte(basicAuth.User))
+	p := subtle.ConstantTimeCompare([]byte(pass), []byte(basicAuth.Password))
+	return n&p == 1
+}
+
+// AdminUsersHandler searches the users by name and email, with the
+// state of their accounts.
+func AdminUsersHandler(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	terms := search.Terms(c.Param("q"))
+	users := &models.Users{}
+	pq := userSearch.Match(pop.Q(tx), terms, true).Order("name").Paginate(1, adminPageSize)
+	if err := pq.All(users); err != nil {
+		return errors.WithStack(err)
+	}
+
+	rows := make([]adminUserRow, len(*users))
+	for i, u := range *users {
+		rows[i] = adminUserRow{
+			userRow: userRow{ID: u.ID, Name: search.Highlight(u.Name, terms), Email: search.Highlight(u.Email, terms)},
+			Account: accountOf(u.Email),
+		}
+	}
+	c.Set("users", rows)
+	c.Set("q", c.Param("q"))
+	return c.Render(200, r.HTML("admin/users/index.html"))
+}
+
+// adminUserRow is a user of the search, with its account.
+type adminUserRow struct {
+	userRow
+	Account *adminAccount
+}
+
+// adminAccount is the state of an authboss user, for the templates.
+type adminAccount struct {
+	Role            string
+	Confirmed       bool
+	Locked          bool
+	LockedUntil     string
+	Attempts        int
+	LastAttempt     string
+	Password        bool
+	RecoverPending  bool
+	RememberTokens  int
+	SessionsRevoked string
+	Links           []adminLink
+}
+
+// adminLink is an OAuth2 user linked to an account.
+type adminLink struct {
+	Provider string
+	UID      string
+	Expiry   string
+}
+
+// accountOf is the state of the authboss user of email, nil without
+// one.
+func accountOf(email string) *adminAccount {
+	if authStore == nil {
+		return nil
+	}
+	u, err := authStore.Get(email)
+	if err != nil {
+		return nil
+	}
+	user := u.(*store.User)
+	a := &adminAccount{
+		Role:            user.Role,
+		Confirmed:       user.Confirmed,
+		Locked:          user.Locked.After(time.Now()),
+		LockedUntil:     formatTime(user.Locked),
+		Attempts:        int(user.AttemptNumber),
+		LastAttempt:     formatTime(user.AttemptTime),
+		Password:        user.Password != "",
+		RecoverPending:  user.RecoverToken != "" && user.RecoverTokenExpiry.After(time.Now()),
+		RememberTokens:  authStore.TokenCount(email),
+		SessionsRevoked: formatTime(user.SessionsRevoked),
+		Links:           []adminLink{},
+	}
+	for _, l := range authStore.Links(email) {
+		a.Links = append(a.Links, adminLink{Provider: l.Oauth2Provider, UID: l.Oauth2Uid, Expiry: formatTime(l.Oauth2Expiry)})
+	}
+	return a
+}
+
+// formatTime is t for the dashboard, empty for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04:05 MST")
+}
+
+// auditEntry is an event of the audit trail of an account.
+type auditEntry struct {
+	Time  string
+	Event string
+	Admin string
+}
+
+// AdminUserHandler shows a user, the state of its account and the audit
+// trail of the dashboard, with the actions.
+func AdminUserHandler(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user, err := findUser(tx, c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+
+	events := &outbox.Events{}
+	err = tx.Where("aggregate = ? AND aggregate_id = ? AND event LIKE ?", "auth_user", user.Email, "admin.%").
+		Order("id DESC").Limit(adminPageSize).All(events)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	trail := make([]auditEntry, len(*events))
+	for i, e := range *events {
+		a := adminAction{}
+		if err := json.Unmarshal([]byte(e.Payload), &a); err != nil {
+			return errors.WithStack(err)
+		}
+		trail[i] = auditEntry{Time: formatTime(e.CreatedAt), Event: e.Type, Admin: a.Admin}
+	}
+
+	c.Set("user", user)
+	c.Set("account", accountOf(user.Email))
+	c.Set("trail", trail)
+	return c.Render(200, r.HTML("admin/users/show.html"))
+}
+
+// adminAct returns the handler of an action of the dashboard on the
+// account of a user: it runs act, records event in the audit trail and
+// goes back to the user with a flash message.
+func adminAct(event string, act func(c buffalo.Context, tx *pop.Connection, email string) error) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		tx := c.Value("tx").(*pop.Connection)
+		user, err := accountUser(tx, c.Param("user_id"))
+		if err != nil {
+			return err
+		}
+		if err := act(c, tx, user.Email); err != nil {
+			return err
+		}
+		if err := audit(c, tx, event, user.Email); err != nil {
+			return err
+		}
+		key := "admin.users.done." + strings.TrimPrefix(event, "admin.")
+		c.Flash().Add("success", translate(c, key, map[string]interface{}{"email": user.Email}))
+		return c.Redirect(http.StatusSeeOther, "/admin/users/%d", user.ID)
+	}
+}
+
+// accountUser loads the user with the given ID, it must have an authboss
+// account.
+func accountUser(tx *pop.Connection, id string) (*models.User, error) {
+	user, err := findUser(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if authStore == nil {
+		return nil, problem.NotFound("%s has no account", user.Email)
+	}
+	if _, err := authStore.Get(user.Email); err != nil {
+		return nil, problem.NotFound("%s has no account", user.Email)
+	}
+	return user, nil
+}
+
+// audit records an action of the admin of c on the account of email, in
+// tx, and logs it.
+func audit(c buffalo.Context, tx *pop.Connection, event, email string) error {
+	admin, _ := c.Value("admin").(string)
+	if err := outbox.Write(tx, "auth_user", email, event, adminAction{Admin: admin, Email: email}); err != nil {
+		return err
+	}
+	c.Logger().WithField("admin", admin).WithField("email", email).Info(event)
+	return nil
+}
+
+// AdminLockHandler locks a user out until it's unlocked, and sends the
+// user.locked webhook.
+var AdminLockHandler = adminAct(adminLocked, func(c buffalo.Context, tx *pop.Connection, email string) error {
+	if err := authStore.Lock(email, time.Now().Add(adminLock)); err != nil {
+		return err
+	}
+	return notify(tx, webhooks.UserLocked, authUser{Email: email, Source: "admin"})
+})
+
+// AdminUnlockHandler unlocks a user, and forgets its failed attempts.
+var AdminUnlockHandler = adminAct(adminUnlocked, func(c buffalo.Context, tx *pop.Connection, email string) error {
+	return authStore.Unlock(email)
+})
+
+// AdminExpirePasswordHandler forces a user to reset its password: it
+// can't sign in with it anymore, its sessions are revoked, and it gets
+// the link of the reset page by mail, see PasswordResetHandler.
+var AdminExpirePasswordHandler = adminAct(adminPasswordExpired, func(c buffalo.Context, tx *pop.Connection, email string) error {
+	token, sum, err := recoverToken()
+	if err != nil {
+		return err
+	}
+	if err := authStore.ExpirePassword(email, sum, time.Now().Add(recoverTokenTTL)); err != nil {
+		return err
+	}
+	if err := authStore.RevokeSessions(email); err != nil {
+		return err
+	}
+	return sendMail(tx, email, "Reset your password", "password_reset", map[string]interface{}{
+		"email":     email,
+		"reset_url": rootURL + "/password/reset?" + url.Values{"token": {token}}.Encode(),
+	})
+})
+
+// recoverToken returns a token for the link, and the checksum the
+// storer keeps, the way the recover module of authboss makes them.
+func recoverToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	return base64.URLEncoding.EncodeToString(b), recoverSum(b), nil
+}
+
+// recoverSum is the checksum of the token b the storer keeps.
+func recoverSum(b []byte) string {
+	sum := md5.Sum(b)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// AdminRevokeSessionsHandler signs a user out everywhere: its sessions
+// and remember tokens.
+var AdminRevokeSessionsHandler = adminAct(adminSessionsRevoked, func(c buffalo.Context, tx *pop.Connection, email string) error {
+	return authStore.RevokeSessions(email)
+})
+
+// initSessionRevocation signs out the sessions started before their user
+// was revoked, see MemStorer.RevokeSessions.
+func initSessionRevocation(ab *authboss.Authboss) {
+	ab.Callbacks.Before(authboss.EventGetUserSession, func(ctx *authboss.Context) (authboss.Interrupt, error) {
+		key, ok := ctx.SessionStorer.Get(authboss.SessionKey)
+		if !ok {
+			return authboss.InterruptNone, nil
+		}
+		var u interface{}
+		var err error
+		if i := strings.IndexByte(key, ';'); i > 0 {
+			u, err = authStore.GetOAuth(key[:i], key[i+1:])
+		} else {
+			u, err = authStore.Get(key)
+		}
+		if err != nil {
+			return authboss.InterruptNone, nil
+		}
+		revoked := u.(*store.User).SessionsRevoked
+		if !revoked.IsZero() && !store.Issued(ctx.SessionStorer).After(revoked) {
+			ctx.SessionStorer.Del(authboss.SessionKey)
+		}
+		return authboss.InterruptNone, nil
+	})
+}
@@ -1,27 +1,76 @@
 package actions
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 
 	"github.com/gobuffalo/buffalo"
-	"github.com/gobuffalo/buffalo/middleware"
 	"github.com/gobuffalo/buffalo/render"
 	"github.com/goji/httpauth"
-	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
 	"github.com/leonids/test-buffalo/actions/auth"
+	"github.com/leonids/test-buffalo/actions/graphql"
+	"github.com/leonids/test-buffalo/actions/jsonapi"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/config"
 	"github.com/leonids/test-buffalo/models"
 	"github.com/markbates/going/defaults"
 	"gopkg.in/authboss.v1"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ENV is used to help switch settings based on where the
 // application is being run. Default is "development".
-var ENV = defaults.String(os.Getenv("GO_ENV"), "development")
+var ENV = config.Current.Env
 
 var app *buffalo.App
 
+// rootURL is the scheme, host and port the app is reached at, for the
+// links of the mails. No trailing slash.
+var rootURL = config.Current.RootURL
+
+// sessionSecret keys the sessions of authboss and the socket tokens. It's
+// random without one outside production, they don't survive a restart
+// then.
+var sessionSecret = func() []byte {
+	if s := config.Current.SessionSecret; s != "" {
+		return []byte(s)
+	}
+	return securecookie.GenerateRandomKey(32)
+}()
+
+// ab is the authboss instance mounted under /api/v2/auth.
+var ab *authboss.Authboss
+
+// authStore keeps the users and remember tokens of authboss.
+var authStore *store.MemStorer
+
+// apiLimiter throttles the API groups. Buckets live in the database in
+// production, so every instance sees the same counts.
+var apiLimiter *mw.RateLimiter
+
+// authLimit is the limit of the writes to /api/v2/auth, per client.
+var authLimit = mw.Limit{Requests: 10, Per: time.Minute}
+
+// apiKeys are the keys of the API clients, see config.Config.APIKeys.
+var apiKeys = mw.NewAPIKeys(config.Current.APIKeys...)
+
+// corsOrigins are the origins allowed to call the API from a browser.
+var corsOrigins = config.Current.CORSOrigins
+
+// Handler is the http.Handler to serve. It's the App with the hook the
+// response rewriting middleware (compression, ETags) relies on.
+func Handler() http.Handler {
+	return mw.ResponseHook(App())
+}
+
 // App is where all routes and middleware for buffalo
 // should be defined. This is the nerve center of your
 // application.
@@ -30,10 +79,37 @@ func App() *buffalo.App {
 	if app == nil {
 		app = buffalo.Automatic(buffalo.Options{
 			Env:         ENV,
-			SessionName: "_test-buffalo_session",
+			Host:        rootURL,
+			SessionName: config.Current.SessionName,
+			// keyed like the sessions of authboss, the CSRF tokens are in it
+			SessionStore: sessions.NewCookieStore(sessionSecret),
+			Logger:       newLogger(ENV),
 		})
 
-		app.Use(middleware.PopTransaction(models.DB))
+		app.Use(mw.Recover)
+		app.Use(mw.SecureHeaders(mw.DefaultSecureOptions(ENV, "/csp-report")))
+		app.Use(translator.Middleware)
+		app.Use(localeForm)
+		app.Use(mw.Compress(gzip.DefaultCompression))
+		app.Use(mw.ConditionalGET)
+		app.Use(mw.Transaction(models.DB))
+		app.Use(jsonapi.Bind)
+		app.Use(mw.Idempotency(mw.IdempotencyOptions{Scope: idempotencyScope}))
+		app.Use(Impersonation)
+		app.Use(features.Middleware(currentUserID))
+
+		initErrorHandlers(app)
+
+		var limits mw.RateLimitStore = mw.NewMemoryRateLimitStore()
+		if ENV == "production" {
+			limits = mw.NewPopRateLimitStore(models.DB)
+		}
+		apiLimiter = mw.NewRateLimiter(limits, mw.Limit{Requests: 60, Per: time.Minute})
+		apiLimiter.Keys = []mw.RateLimitKeyFunc{
+			mw.RateLimitByHeader("X-API-Key", apiKeys.Valid),
+			mw.RateLimitByUser(currentUserID),
+			mw.RateLimitByIP(config.Current.TrustedProxies...),
+		}
 
 		initRoutes(app)
 
@@ -45,36 +121,130 @@ func App() *buffalo.App {
 
 func initRoutes(app *buffalo.App) {
 	// index page
-	app.GET("/", HomeHandler)
+	api.Document(app.GET("/", HomeHandler), openapi.Operation{
+		Summary:     "Home page",
+		ContentType: "text/html",
+		Response:    "",
+	})
+	api.Document(app.POST("/csp-report", CSPReportHandler), openapi.Operation{
+		Summary:     "Receive Content-Security-Policy violation reports",
+		ContentType: "application/csp-report",
+		Request:     cspReport{},
+		Status:      204,
+	})
+	api.Document(app.GET("/api/openapi.json", cacheResponses(10*time.Minute, "openapi")(OpenAPIHandler)), openapi.Operation{
+		Summary:  "This document",
+		Response: openapi.Document{},
+	})
+
+	initLocaleRoutes(app)
+	initPasswordRoutes(app)
 
 	app.Resource("/users", UsersResource{&buffalo.BaseResource{}})
+	documentUsersResource()
+
+	{
+		g := group(app, "/admin")
+		g.Use(RequireAdmin)
+		g.Use(mw.CSRF)
+		// scripts post the imports, with the basic auth
+		g.Middleware.Skip(mw.CSRF, AdminUsersImport)
+		api.Document(g.GET("/jobs", AdminJobsHandler), openapi.Operation{
+			Summary:     "State of the job queues",
+			Tags:        []string{"admin"},
+			ContentType: "text/html",
+			Query:       []openapi.Parameter{{Name: "status"}},
+			Response:    "",
+		})
+		initImportRoutes(g)
+		initExportRoutes(g)
+		initAdminRoutes(g)
+		initFlagRoutes(g)
+		initEventRoutes(g)
+	}
+	initImpersonationRoutes(app)
+
+	if ENV == "development" {
+		initMailPreviewRoutes(app)
+	}
 
 	{
-		g := app.Group("/api/v1")
+		g := group(app, "/api/v1")
+		g.Use(mw.CORS(mw.CORSPolicy{
+			AllowedOrigins:   corsOrigins,
+			AllowedHeaders:   []string{"Accept", "Content-Type", "Authorization", "X-API-Key"},
+			ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}))
+		mw.AllowPreflight(g)
+		api.Skip("OPTIONS", "/api/v1/{path:.*}")
+		g.Use(apiLimiter.Middleware)
 		g.Use(mw.APIAuthorizer)
-		g.Use(mw.WrapHandler(httpauth.SimpleBasicAuth("leonids", "maslovs")))
+		g.Use(mw.WrapHandler(httpauth.SimpleBasicAuth(basicAuth.User, basicAuth.Password)))
 
 		// simple parameter tests
-		g.GET("/username/", func(c buffalo.Context) error {
+		api.Document(g.GET("/username/", func(c buffalo.Context) error {
 			name := "Hello, " + defaults.String(c.Param("name"), "<unknown>")
 			return c.Render(200, render.String(name))
+		}), openapi.Operation{
+			Summary:     "Greet an unknown user",
+			Tags:        []string{"greetings"},
+			ContentType: "text/plain",
+			Query:       []openapi.Parameter{{Name: "name"}},
+			Response:    "",
 		})
-		g.GET("/username/{name}", func(c buffalo.Context) error {
+		api.Document(g.GET("/username/{name}", func(c buffalo.Context) error {
 			name := "Hello, " + c.Param("name")
 			return c.Render(200, render.String(name))
+		}), openapi.Operation{
+			Summary:     "Greet a user by name",
+			Tags:        []string{"greetings"},
+			ContentType: "text/plain",
+			Response:    "",
 		})
+
+		// GraphQL over the models, under the same auth as the rest of v1
+		gqlDoc := openapi.Operation{
+			Summary:  "GraphQL endpoint",
+			Tags:     []string{"graphql"},
+			Request:  graphql.Request{},
+			Response: graphql.Response{},
+		}
+		api.Document(g.POST("/graphql", graphql.Handler(gql)), gqlDoc)
+		gqlDoc.Request, gqlDoc.Query = nil, []openapi.Parameter{{Name: "query", Required: true}, {Name: "operationName"}, {Name: "variables"}}
+		api.Document(g.GET("/graphql", graphql.Handler(gql)), gqlDoc)
+		if ENV == "development" {
+			api.Skip("GET", "/api/v1/graphiql")
+			g.GET("/graphiql", graphql.GraphiQL("/api/v1/graphql"))
+		}
+
+		initWebhookRoutes(g)
+		initEventRoutes(g)
 	}
 
+	initSocketRoutes(app)
+
 	{
-		g := app.Group("/api/v2")
+		g := group(app, "/api/v2")
+		g.Use(mw.CORS(mw.CORSPolicy{
+			AllowedOrigins:   corsOrigins,
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}))
+		mw.AllowPreflight(g)
+		api.Skip("OPTIONS", "/api/v2/{path:.*}")
+		g.Use(apiLimiter.Middleware)
 
-		database := store.NewMemStorer()
+		authStore = store.NewMemStorer()
+		store.SessionName = config.Current.AuthSessionName
+		store.Init(sessionSecret)
 
-		ab := authboss.New() // Usually store this globally
+		ab = authboss.New()
 		ab.MountPath = "/auth"
-		ab.Storer = database
-		ab.OAuth2Storer = database
-		ab.RootURL = `http://localhost:3000`
+		ab.Storer = confirmStorer{authStore}
+		ab.OAuth2Storer = authStore
+		ab.RootURL = rootURL
 		ab.LogWriter = os.Stderr
 
 		ab.XSRFName = "csrf_token"
@@ -85,7 +255,8 @@ func initRoutes(app *buffalo.App) {
 		ab.CookieStoreMaker = store.NewCookieStorer
 		ab.SessionStoreMaker = store.NewSessionStorer
 
-		ab.Mailer = authboss.LogMailer(os.Stdout)
+		ab.Mailer = queuedMailer{}
+		ab.EmailFrom = mailFrom
 
 		ab.Policies = []authboss.Validator{
 			authboss.Rules{
@@ -106,9 +277,62 @@ func initRoutes(app *buffalo.App) {
 			// Handle error, don't let program continue to run
 			log.Fatalln(err)
 		}
+		initAuthWebhooks(ab)
+		initAuthMails(ab)
+		initSessionRevocation(ab)
 
 		// Make sure to put authboss's router somewhere
 		handler := buffalo.WrapHandler(ab.NewRouter())
-		g.ANY("/auth", handler)
+		routes := map[string]func(string, buffalo.Handler) buffalo.RouteInfo{
+			"GET": g.GET, "POST": g.POST, "PUT": g.PUT, "PATCH": g.PATCH,
+			"HEAD": g.HEAD, "OPTIONS": g.OPTIONS, "DELETE": g.DELETE,
+		}
+		for m, route := range routes {
+			ri := route("/auth", handler)
+			// the writes guess passwords and send mails, they get fewer
+			if m != "GET" && m != "HEAD" && m != "OPTIONS" {
+				apiLimiter.Route(ri, authLimit)
+			}
+			// authboss serves its own HTML forms, it's not part of the contract
+			api.Skip(m, "/api/v2/auth")
+		}
+	}
+}
+
+// currentUser is the user signed in through authboss, nil when there's
+// none.
+func currentUser(c buffalo.Context) *store.User {
+	if ab == nil {
+		return nil
+	}
+	u, err := ab.CurrentUser(c.Response(), c.Request())
+	if err != nil {
+		return nil
+	}
+	user, _ := u.(*store.User)
+	return user
+}
+
+// currentUserID returns the ID of the user signed in through authboss,
+// or an empty string.
+func currentUserID(c buffalo.Context) string {
+	if u := currentUser(c); u != nil {
+		return strconv.Itoa(u.ID)
+	}
+	return ""
+}
+
+// idempotencyScope keeps the Idempotency-Keys of clients apart: by API
+// key (hashed, it's a secret) or by signed in user. Anonymous requests,
+// the ones with made up keys too, have none: they aren't made
+// idempotent.
+func idempotencyScope(c buffalo.Context) string {
+	if k := c.Request().Header.Get("X-API-Key"); apiKeys.Valid(k) {
+		sum := sha256.Sum256([]byte(k))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	if id := currentUserID(c); id != "" {
+		return "user:" + id
 	}
+	return ""
 }
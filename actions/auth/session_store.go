@@ -3,15 +3,30 @@ package store
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"gopkg.in/authboss.v1"
 )
 
-const sessionCookieName = "ab_blog"
+// SessionName is the cookie of the sessions, set it before Init.
+var SessionName = "ab_blog"
+
+// IssuedKey is the session value with the time the user signed in, see
+// Issued.
+const IssuedKey = "issued"
 
 var sessionStore *sessions.CookieStore
 
+// Init keys the session and the cookies of authboss with secret. They
+// can't be read or written before.
+func Init(secret []byte) {
+	sessionStore = sessions.NewCookieStore(secret)
+	cookieStore = securecookie.New(secret, nil)
+}
+
 type SessionStorer struct {
 	w http.ResponseWriter
 	r *http.Request
@@ -22,7 +37,7 @@ func NewSessionStorer(w http.ResponseWriter, r *http.Request) authboss.ClientSto
 }
 
 func (s SessionStorer) Get(key string) (string, bool) {
-	session, err := sessionStore.Get(s.r, sessionCookieName)
+	session, err := sessionStore.Get(s.r, SessionName)
 	if err != nil {
 		fmt.Println(err)
 		return "", false
@@ -42,18 +57,21 @@ func (s SessionStorer) Get(key string) (string, bool) {
 }
 
 func (s SessionStorer) Put(key, value string) {
-	session, err := sessionStore.Get(s.r, sessionCookieName)
+	session, err := sessionStore.Get(s.r, SessionName)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
 	session.Values[key] = value
+	if key == authboss.SessionKey {
+		session.Values[IssuedKey] = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
 	session.Save(s.r, s.w)
 }
 
 func (s SessionStorer) Del(key string) {
-	session, err := sessionStore.Get(s.r, sessionCookieName)
+	session, err := sessionStore.Get(s.r, SessionName)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -62,3 +80,91 @@ func (s SessionStorer) Del(key string) {
 	delete(session.Values, key)
 	session.Save(s.r, s.w)
 }
+
+// Issued is when the user of the session signed in, the zero time when
+// it's unknown.
+func Issued(s authboss.ClientStorer) time.Time {
+	v, ok := s.Get(IssuedKey)
+	if !ok {
+		return time.Time{}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// The session values of an impersonation, see Impersonate.
+const (
+	impersonatorKey       = "impersonator"
+	impersonatorNameKey   = "impersonator_name"
+	impersonatorIssuedKey = "impersonator_issued"
+	impersonatingSinceKey = "impersonating_since"
+)
+
+// Impersonation is an admin signed in as another user.
+type Impersonation struct {
+	// Admin is the key of the admin, empty when it had no session.
+	Admin string
+	// Name is the admin for the audit trail.
+	Name string
+	// User is the key of the user impersonated.
+	User  string
+	Since time.Time
+}
+
+// Impersonate signs the session in as the user of key, on behalf of the
+// admin called name. The user the session had is kept, with the time it
+// signed in, for Restore.
+func Impersonate(s authboss.ClientStorer, key, name string) {
+	admin, _ := s.Get(authboss.SessionKey)
+	issued, _ := s.Get(IssuedKey)
+	s.Put(impersonatorKey, admin)
+	s.Put(impersonatorNameKey, name)
+	s.Put(impersonatorIssuedKey, issued)
+	s.Put(impersonatingSinceKey, strconv.FormatInt(time.Now().UnixNano(), 10))
+	s.Put(authboss.SessionKey, key)
+}
+
+// Impersonating returns the impersonation of the session, if any.
+func Impersonating(s authboss.ClientStorer) (Impersonation, bool) {
+	admin, ok := s.Get(impersonatorKey)
+	if !ok {
+		return Impersonation{}, false
+	}
+	imp := Impersonation{Admin: admin}
+	imp.Name, _ = s.Get(impersonatorNameKey)
+	imp.User, _ = s.Get(authboss.SessionKey)
+	if v, ok := s.Get(impersonatingSinceKey); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			imp.Since = time.Unix(0, n)
+		}
+	}
+	return imp, true
+}
+
+// Restore ends the impersonation of the session: it's back to the user
+// it had before, as it was then.
+func Restore(s authboss.ClientStorer) (Impersonation, bool) {
+	imp, ok := Impersonating(s)
+	if !ok {
+		return imp, false
+	}
+	issued, _ := s.Get(impersonatorIssuedKey)
+	if imp.Admin == "" {
+		s.Del(authboss.SessionKey)
+	} else {
+		s.Put(authboss.SessionKey, imp.Admin)
+	}
+	// after the Put, which sets it to now
+	if issued == "" {
+		s.Del(IssuedKey)
+	} else {
+		s.Put(IssuedKey, issued)
+	}
+	for _, k := range []string{impersonatorKey, impersonatorNameKey, impersonatorIssuedKey, impersonatingSinceKey} {
+		s.Del(k)
+	}
+	return imp, true
+}
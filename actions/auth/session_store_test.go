@@ -0,0 +1,64 @@
+package store_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/auth"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/authboss.v1"
+)
+
+func Test_Impersonate(t *testing.T) {
+	r := require.New(t)
+	store.Init([]byte("secret"))
+	s := store.NewSessionStorer(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	s.Put(authboss.SessionKey, "zeratul@heroes.com")
+	issued := store.Issued(s)
+	r.False(issued.IsZero())
+	_, ok := store.Impersonating(s)
+	r.False(ok)
+
+	time.Sleep(time.Millisecond)
+	store.Impersonate(s, "mark@example.com", "zeratul@heroes.com")
+	imp, ok := store.Impersonating(s)
+	r.True(ok)
+	r.Equal("zeratul@heroes.com", imp.Admin)
+	r.Equal("mark@example.com", imp.User)
+	r.False(imp.Since.IsZero())
+	key, _ := s.Get(authboss.SessionKey)
+	r.Equal("mark@example.com", key)
+	r.True(store.Issued(s).After(issued))
+
+	imp, ok = store.Restore(s)
+	r.True(ok)
+	r.Equal("mark@example.com", imp.User)
+	key, _ = s.Get(authboss.SessionKey)
+	r.Equal("zeratul@heroes.com", key)
+	// the admin signed in when it did, its sessions can still be revoked
+	r.Equal(issued, store.Issued(s))
+	_, ok = store.Impersonating(s)
+	r.False(ok)
+	_, ok = store.Restore(s)
+	r.False(ok)
+}
+
+func Test_Impersonate_Without_Session(t *testing.T) {
+	r := require.New(t)
+	store.Init([]byte("secret"))
+	s := store.NewSessionStorer(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// the scripts with the basic auth have no session
+	store.Impersonate(s, "mark@example.com", "leonids")
+	imp, ok := store.Impersonating(s)
+	r.True(ok)
+	r.Equal("", imp.Admin)
+	r.Equal("leonids", imp.Name)
+
+	store.Restore(s)
+	_, ok = s.Get(authboss.SessionKey)
+	r.False(ok)
+	r.True(store.Issued(s).IsZero())
+}
@@ -2,6 +2,8 @@ package store
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -11,8 +13,11 @@ import (
 var nextUserID int
 
 type User struct {
-	ID   int
-	Name string
+	ID     int
+	Name   string
+	Locale string
+	// Role is "admin" for the users of the /admin dashboard.
+	Role string
 
 	// Auth
 	Email    string
@@ -39,11 +44,22 @@ type User struct {
 	RecoverTokenExpiry time.Time
 
 	// Remember is in another table
+
+	// SessionsRevoked signs out the sessions started before it.
+	SessionsRevoked time.Time
+
+	// UserID is the users row of the account once it's linked, see Link.
+	// Unlike the email of the row, it doesn't change.
+	UserID int
 }
 
 type MemStorer struct {
 	Users  map[string]User
 	Tokens map[string][]string
+	// Issued is when the remember tokens were added, see PurgeTokens.
+	Issued map[string]time.Time
+
+	moot *sync.Mutex
 }
 
 func NewMemStorer() *MemStorer {
@@ -55,9 +71,12 @@ func NewMemStorer() *MemStorer {
 				Password:  "$2a$10$XtW/BrS5HeYIuOCXYe8DFuInetDMdaarMUJEOg/VA/JAIDgw3l4aG", // pass = 1234
 				Email:     "zeratul@heroes.com",
 				Confirmed: true,
+				Role:      "admin",
 			},
 		},
 		Tokens: make(map[string][]string),
+		Issued: make(map[string]time.Time),
+		moot:   &sync.Mutex{},
 	}
 }
 
@@ -102,14 +121,210 @@ func (s MemStorer) GetOAuth(uid, provider string) (result interface{}, err error
 	return &user, nil
 }
 
+// SetLocale saves the locale the user picked.
+func (s MemStorer) SetLocale(key, locale string) error {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	user, ok := s.Users[key]
+	if !ok {
+		return authboss.ErrUserNotFound
+	}
+	user.Locale = locale
+	s.Users[key] = user
+	return nil
+}
+
+// Links returns the OAuth2 users of the account of email.
+func (s MemStorer) Links(email string) []User {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	links := []User{}
+	for _, u := range s.Users {
+		if u.Oauth2Provider != "" && u.Email == email {
+			links = append(links, u)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Oauth2Provider < links[j].Oauth2Provider })
+	return links
+}
+
+// Account is a user with the state of its sign-ins.
+type Account struct {
+	User
+	// RememberTokens is the number of remember tokens of the account.
+	RememberTokens int
+	// Providers are the OAuth2 providers linked to the account.
+	Providers []string
+}
+
+// Accounts returns the accounts of emails, the ones that exist, keyed
+// by email. The store is locked once for all of them.
+func (s MemStorer) Accounts(emails []string) map[string]Account {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	accounts := map[string]Account{}
+	for _, email := range emails {
+		if u, ok := s.Users[email]; ok {
+			accounts[email] = Account{User: u, RememberTokens: len(s.Tokens[email]), Providers: []string{}}
+		}
+	}
+	for _, u := range s.Users {
+		if a, ok := accounts[u.Email]; ok && u.Oauth2Provider != "" {
+			a.Providers = append(a.Providers, u.Oauth2Provider)
+			accounts[u.Email] = a
+		}
+	}
+	for email, a := range accounts {
+		sort.Strings(a.Providers)
+		accounts[email] = a
+	}
+	return accounts
+}
+
+// Lock locks the user of key out until the given time.
+func (s MemStorer) Lock(key string, until time.Time) error {
+	return s.update(key, func(u *User) {
+		u.Locked = until
+	})
+}
+
+// Unlock lets the user of key in again, with no failed attempts.
+func (s MemStorer) Unlock(key string) error {
+	return s.update(key, func(u *User) {
+		u.Locked = time.Time{}
+		u.AttemptNumber = 0
+		u.AttemptTime = time.Time{}
+	})
+}
+
+// Fail counts a failed sign in of the user of key at now, the attempts
+// older than window forgotten, and locks it out for lockFor after max of
+// them. It returns whether this attempt locked it.
+func (s MemStorer) Fail(key string, now time.Time, max int, window, lockFor time.Duration) (bool, error) {
+	locked := false
+	err := s.update(key, func(u *User) {
+		if now.Sub(u.AttemptTime) > window {
+			u.AttemptNumber = 0
+		}
+		u.AttemptNumber++
+		u.AttemptTime = now
+		if u.AttemptNumber >= int64(max) && !u.Locked.After(now) {
+			u.Locked = now.Add(lockFor)
+			locked = true
+		}
+	})
+	return locked, err
+}
+
+// ExpirePassword drops the password of the user of key: it can only
+// sign in again after recovering the account with token.
+func (s MemStorer) ExpirePassword(key, token string, expiry time.Time) error {
+	return s.update(key, func(u *User) {
+		u.Password = ""
+		u.RecoverToken = token
+		u.RecoverTokenExpiry = expiry
+	})
+}
+
+// RecoveringUser returns the user whose password was expired with
+// token, until its expiry.
+func (s MemStorer) RecoveringUser(token string, now time.Time) (*User, error) {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	for _, u := range s.Users {
+		if token != "" && u.RecoverToken == token && now.Before(u.RecoverTokenExpiry) {
+			return &u, nil
+		}
+	}
+	return nil, authboss.ErrUserNotFound
+}
+
+// SetPassword sets the password, a bcrypt hash, of the user of key, and
+// drops its recover token.
+func (s MemStorer) SetPassword(key, password string) error {
+	return s.update(key, func(u *User) {
+		u.Password = password
+		u.RecoverToken = ""
+		u.RecoverTokenExpiry = time.Time{}
+	})
+}
+
+// RevokeSessions signs out the sessions of the account of email started
+// until now, the ones of its OAuth2 users too, and drops its remember
+// tokens.
+func (s MemStorer) RevokeSessions(email string) error {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	if _, ok := s.Users[email]; !ok {
+		return authboss.ErrUserNotFound
+	}
+	now := time.Now()
+	for key, u := range s.Users {
+		if key != email && u.Email != email {
+			continue
+		}
+		u.SessionsRevoked = now
+		s.Users[key] = u
+		for _, tok := range s.Tokens[key] {
+			delete(s.Issued, tok)
+		}
+		delete(s.Tokens, key)
+	}
+	return nil
+}
+
+// Link links the account of email, and its OAuth2 users, to the users
+// row of id, unless it has one already. Without an account it does
+// nothing.
+func (s MemStorer) Link(email string, id int) {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	if u, ok := s.Users[email]; !ok || u.UserID != 0 {
+		return
+	}
+	for key, u := range s.Users {
+		if key == email || u.Email == email {
+			u.UserID = id
+			s.Users[key] = u
+		}
+	}
+}
+
+func (s MemStorer) update(key string, f func(*User)) error {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	user, ok := s.Users[key]
+	if !ok {
+		return authboss.ErrUserNotFound
+	}
+	f(&user)
+	s.Users[key] = user
+	return nil
+}
+
 func (s MemStorer) AddToken(key, token string) error {
+	s.moot.Lock()
+	defer s.moot.Unlock()
 	s.Tokens[key] = append(s.Tokens[key], token)
+	s.Issued[token] = time.Now()
 	fmt.Println("AddToken")
 	spew.Dump(s.Tokens)
 	return nil
 }
 
+// TokenCount is the number of remember tokens of key.
+func (s MemStorer) TokenCount(key string) int {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	return len(s.Tokens[key])
+}
+
 func (s MemStorer) DelTokens(key string) error {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	for _, tok := range s.Tokens[key] {
+		delete(s.Issued, tok)
+	}
 	delete(s.Tokens, key)
 	fmt.Println("DelTokens")
 	spew.Dump(s.Tokens)
@@ -117,6 +332,8 @@ func (s MemStorer) DelTokens(key string) error {
 }
 
 func (s MemStorer) UseToken(givenKey, token string) error {
+	s.moot.Lock()
+	defer s.moot.Unlock()
 	toks, ok := s.Tokens[givenKey]
 	if !ok {
 		return authboss.ErrTokenNotFound
@@ -126,6 +343,7 @@ func (s MemStorer) UseToken(givenKey, token string) error {
 		if tok == token {
 			toks[i], toks[len(toks)-1] = toks[len(toks)-1], toks[i]
 			s.Tokens[givenKey] = toks[:len(toks)-1]
+			delete(s.Issued, token)
 			return nil
 		}
 	}
@@ -133,6 +351,31 @@ func (s MemStorer) UseToken(givenKey, token string) error {
 	return authboss.ErrTokenNotFound
 }
 
+// PurgeTokens drops the remember tokens issued before the given time and
+// returns how many it dropped.
+func (s MemStorer) PurgeTokens(before time.Time) int {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	n := 0
+	for key, toks := range s.Tokens {
+		kept := toks[:0]
+		for _, tok := range toks {
+			if s.Issued[tok].Before(before) {
+				delete(s.Issued, tok)
+				n++
+				continue
+			}
+			kept = append(kept, tok)
+		}
+		if len(kept) == 0 {
+			delete(s.Tokens, key)
+		} else {
+			s.Tokens[key] = kept
+		}
+	}
+	return n
+}
+
 func (s MemStorer) ConfirmUser(tok string) (result interface{}, err error) {
 	fmt.Println("==============", tok)
 
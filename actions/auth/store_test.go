@@ -0,0 +1,85 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Accounts(t *testing.T) {
+	r := require.New(t)
+	s := store.NewMemStorer()
+	s.Users["12github"] = store.User{Email: "zeratul@heroes.com", Oauth2Uid: "12", Oauth2Provider: "github"}
+	s.Users["7google"] = store.User{Email: "zeratul@heroes.com", Oauth2Uid: "7", Oauth2Provider: "google"}
+	r.NoError(s.AddToken("zeratul@heroes.com", "token"))
+
+	accounts := s.Accounts([]string{"zeratul@heroes.com", "nobody@example.com"})
+	r.Len(accounts, 1)
+	a := accounts["zeratul@heroes.com"]
+	r.Equal("admin", a.Role)
+	r.Equal(1, a.RememberTokens)
+	r.Equal([]string{"github", "google"}, a.Providers)
+}
+
+func Test_Link(t *testing.T) {
+	r := require.New(t)
+	s := store.NewMemStorer()
+	s.Users["12github"] = store.User{Email: "zeratul@heroes.com", Oauth2Uid: "12", Oauth2Provider: "github"}
+
+	s.Link("nobody@example.com", 7)
+	s.Link("zeratul@heroes.com", 3)
+	r.Equal(3, s.Users["zeratul@heroes.com"].UserID)
+	r.Equal(3, s.Users["12github"].UserID)
+
+	// the first row stays
+	s.Link("zeratul@heroes.com", 4)
+	r.Equal(3, s.Users["zeratul@heroes.com"].UserID)
+}
+
+func Test_Fail(t *testing.T) {
+	r := require.New(t)
+	s := store.NewMemStorer()
+	now := time.Now()
+	fail := func(at time.Time) bool {
+		locked, err := s.Fail("zeratul@heroes.com", at, 3, 5*time.Minute, time.Hour)
+		r.NoError(err)
+		return locked
+	}
+
+	r.False(fail(now))
+	r.False(fail(now.Add(time.Minute)))
+	// the first two are forgotten
+	r.False(fail(now.Add(10 * time.Minute)))
+	r.False(fail(now.Add(11 * time.Minute)))
+	r.True(fail(now.Add(12 * time.Minute)))
+	r.Equal(now.Add(72*time.Minute), s.Users["zeratul@heroes.com"].Locked)
+	// locked already
+	r.False(fail(now.Add(13 * time.Minute)))
+
+	_, err := s.Fail("nobody@example.com", now, 3, time.Minute, time.Hour)
+	r.Error(err)
+}
+
+func Test_ExpirePassword(t *testing.T) {
+	r := require.New(t)
+	s := store.NewMemStorer()
+	now := time.Now()
+	r.NoError(s.ExpirePassword("zeratul@heroes.com", "sum", now.Add(time.Hour)))
+	r.Empty(s.Users["zeratul@heroes.com"].Password)
+
+	u, err := s.RecoveringUser("sum", now)
+	r.NoError(err)
+	r.Equal("zeratul@heroes.com", u.Email)
+	_, err = s.RecoveringUser("sum", now.Add(2*time.Hour))
+	r.Error(err)
+	_, err = s.RecoveringUser("", now)
+	r.Error(err)
+
+	// the token works once
+	r.NoError(s.SetPassword("zeratul@heroes.com", "hash"))
+	r.Equal("hash", s.Users["zeratul@heroes.com"].Password)
+	_, err = s.RecoveringUser("sum", now)
+	r.Error(err)
+}
@@ -0,0 +1,197 @@
+package actions
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/actions/storage"
+	"github.com/leonids/test-buffalo/config"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/markbates/validate"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxUpload is the largest request body of an avatar upload.
+	maxUpload = 5 << 20
+	// maxAvatarSide is the largest width and height of an avatar.
+	maxAvatarSide = 4096
+	// thumbSize is the side of the square avatar thumbnails.
+	thumbSize = 128
+)
+
+// files keeps the uploads: on disk under uploads/ by default, in a
+// bucket with the s3 storage, see config.Storage.
+var files = newStorage()
+
+// fileURLs sign the URLs of /files, they're good for an hour.
+var fileURLs = &storage.Signer{Key: sessionSecret, Prefix: "/files/", TTL: time.Hour}
+
+func newStorage() storage.Storage {
+	cfg := config.Current.Storage
+	if cfg.Driver == "s3" {
+		return storage.NewS3(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+	}
+	return &storage.Local{Dir: cfg.UploadsDir}
+}
+
+// FilesHandler serves the uploads at the URLs of fileURLs.
+var FilesHandler = storage.Handler(files, fileURLs)
+
+func initFileRoutes(app *buffalo.App) {
+	app.Middleware.Skip(mw.Transaction(models.DB), FilesHandler)
+	api.Document(app.GET("/files/{key:.+}", FilesHandler), openapi.Operation{
+		Summary:     "Download an uploaded file",
+		Description: "The URLs are signed and expire, the API hands them out.",
+		Tags:        []string{"files"},
+		Query:       []openapi.Parameter{{Name: "expires"}, {Name: "signature"}},
+		ContentType: "application/octet-stream",
+		Response:    "",
+	})
+	api.Document(app.GET("/users/{user_id}/avatar", UserAvatar), openapi.Operation{
+		Summary:     "Redirect to the avatar of a user",
+		Description: "Pass size=thumb for the thumbnail.",
+		Tags:        []string{"users"},
+		Query:       []openapi.Parameter{{Name: "size"}},
+		Status:      302,
+		Response:    "",
+	})
+}
+
+// UserAvatar redirects to a signed URL of the avatar of a user, or its
+// thumbnail.
+func UserAvatar(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := findUser(tx, c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+	if u.Avatar == "" {
+		return problem.NotFound("user %d has no avatar", u.ID)
+	}
+	key := u.Avatar
+	if c.Param("size") == "thumb" {
+		key = thumbKey(key)
+	}
+	return c.Redirect(302, "%s", fileURLs.URL(key))
+}
+
+// bindMultipart binds the values of a multipart body to u and returns
+// the avatar file, nil without one.
+func bindMultipart(c buffalo.Context, u *models.User) (*multipart.FileHeader, error) {
+	req := c.Request()
+	if req.ContentLength > maxUpload {
+		return nil, problem.TooLarge("the upload is over %d MB", maxUpload>>20)
+	}
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxUpload)
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return nil, problem.TooLarge("the upload is over %d MB", maxUpload>>20)
+		}
+		return nil, problem.BadRequest("can't read the form: %s", err)
+	}
+	// Bind only reads url encoded forms
+	req.PostForm = url.Values(req.MultipartForm.Value)
+	if err := c.Bind(u); err != nil {
+		return nil, problem.BadRequest("can't read the user: %s", err)
+	}
+	if fhs := req.MultipartForm.File["avatar"]; len(fhs) > 0 {
+		return fhs[0], nil
+	}
+	return nil, nil
+}
+
+// saveAvatar checks the uploaded picture, stores it and its thumbnail
+// and points u to them.
+func saveAvatar(c buffalo.Context, u *models.User, fh *multipart.FileHeader) error {
+	f, err := fh.Open()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	ct, rd, err := storage.Sniff(f)
+	if err != nil {
+		return err
+	}
+	ext, ok := storage.Images[ct]
+	if !ok {
+		return problem.UnsupportedMediaType("the avatar is %s, not a JPEG, PNG or GIF picture", ct)
+	}
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	verrs := validate.NewErrors()
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err == nil && (cfg.Width > maxAvatarSide || cfg.Height > maxAvatarSide) {
+		verrs.Add("avatar", fmt.Sprintf("Avatar can't be over %dx%d pixels.", maxAvatarSide, maxAvatarSide))
+		return problem.Validation(verrs)
+	}
+	var img image.Image
+	if err == nil {
+		img, _, err = image.Decode(bytes.NewReader(b))
+	}
+	if err != nil {
+		verrs.Add("avatar", "Avatar is not a valid picture.")
+		return problem.Validation(verrs)
+	}
+	var thumb bytes.Buffer
+	if err := storage.Encode(&thumb, storage.Thumbnail(img, thumbSize), ct); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("avatars/%d/%s%s", u.ID, randomName(), ext)
+	ctx := c.Request().Context()
+	if err := files.Put(ctx, key, bytes.NewReader(b), ct); err != nil {
+		return err
+	}
+	if err := files.Put(ctx, thumbKey(key), &thumb, ct); err != nil {
+		files.Delete(ctx, key)
+		return err
+	}
+	u.Avatar = key
+	return nil
+}
+
+// deleteAvatar removes an avatar and its thumbnail. The user is saved
+// already, a leftover file only takes space: errors are logged.
+func deleteAvatar(c buffalo.Context, key string) {
+	if key == "" {
+		return
+	}
+	for _, k := range []string{key, thumbKey(key)} {
+		if err := files.Delete(c.Request().Context(), k); err != nil {
+			c.Logger().Warn(fmt.Sprintf("can't delete %s: %s", k, err))
+		}
+	}
+}
+
+// thumbKey is the key of the thumbnail of the avatar at key.
+func thumbKey(key string) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "_thumb" + ext
+}
+
+// randomName names the uploads, a new avatar gets a new URL and the
+// cached one goes stale.
+func randomName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
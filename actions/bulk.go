@@ -0,0 +1,240 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/bulk"
+	"github.com/leonids/test-buffalo/actions/jsonapi"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// exportBatch is the number of users read at once by the exports.
+const exportBatch = 500
+
+// importColumns are the columns an import reads, the others are
+// ignored: an export imports back.
+var importColumns = []string{"name", "email"}
+
+// exportColumns are the columns of the exports, fields[users] picks
+// some.
+var exportColumns = []string{"id", "name", "email", "created_at", "updated_at"}
+
+// UsersCSV and UsersNDJSON export the users, in the order and with the
+// fields of the list, the ones of a search with ?q=.
+var (
+	UsersCSV    = exportUsers(bulk.CSV)
+	UsersNDJSON = exportUsers(bulk.NDJSON)
+)
+
+// initExportRoutes mounts the exports in g, the admin group: they have
+// the email of every user.
+func initExportRoutes(g *buffalo.App) {
+	list := []openapi.Parameter{{Name: "q"}, {Name: "sort"}, {Name: "fields[users]"}}
+	handlers := map[string]buffalo.Handler{bulk.CSV: UsersCSV, bulk.NDJSON: UsersNDJSON}
+	for _, format := range []string{bulk.CSV, bulk.NDJSON} {
+		api.Document(g.GET("/users."+format, handlers[format]), openapi.Operation{
+			Summary:     "Export the users as " + strings.ToUpper(format),
+			Tags:        []string{"admin", "users"},
+			Query:       list,
+			ContentType: bulk.ContentTypes[format],
+			Response:    "",
+		})
+	}
+}
+
+func initImportRoutes(g *buffalo.App) {
+	// every batch has a transaction of its own
+	g.Middleware.Skip(mw.Transaction(models.DB), AdminUsersImport)
+	api.Document(g.POST("/users/import", AdminUsersImport), openapi.Operation{
+		Summary:     "Import users from CSV or NDJSON",
+		Description: "The file comes as the file field of a multipart form, or as the body with a text/csv or application/x-ndjson content type. The rows are validated like the ones of POST /users, dry_run=true only checks them. The invalid rows are in the CSV report at report_url.",
+		Tags:        []string{"admin", "users"},
+		Query:       []openapi.Parameter{{Name: "dry_run"}},
+		Response:    importResult{},
+	})
+}
+
+// exportUsers streams the users in format, a batch at a time.
+func exportUsers(format string) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		tx := c.Value("tx").(*pop.Connection)
+		q, err := jsonapi.ParseQuery(c, userSortable...)
+		if err != nil {
+			return err
+		}
+		columns := exportColumns
+		if fields, ok := q.Fields["users"]; ok {
+			for _, f := range fields {
+				if !contains(exportColumns, f) {
+					return problem.BadRequest("users have no field %q", f)
+				}
+			}
+			columns = fields
+		}
+
+		res := c.Response()
+		res.Header().Set("Content-Type", bulk.ContentTypes[format])
+		res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format))
+		w, err := bulk.NewWriter(res, format, columns)
+		if err != nil {
+			return err
+		}
+		for page := 1; ; page++ {
+			users := &models.Users{}
+			pq, _ := searchUsers(c, tx, q)
+			if err := q.Order(pq).Paginate(page, exportBatch).All(users); err != nil {
+				return errors.WithStack(err)
+			}
+			for _, u := range *users {
+				rec, err := userRecord(u)
+				if err != nil {
+					return err
+				}
+				if err := w.Write(rec); err != nil {
+					return err
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if f, ok := res.(http.Flusher); ok {
+				f.Flush()
+			}
+			if len(*users) < exportBatch {
+				return nil
+			}
+		}
+	}
+}
+
+// userRecord is u as the API shows it.
+func userRecord(u models.User) (map[string]interface{}, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	rec := map[string]interface{}{}
+	return rec, errors.WithStack(d.Decode(&rec))
+}
+
+// importUser creates the user of a row, through the rules of
+// UsersResource.Create.
+func importUser(tx *pop.Connection, rec bulk.Record) (map[string][]string, error) {
+	err := createUser(tx, &models.User{Name: rec["name"], Email: rec["email"]})
+	if err == nil {
+		return nil, nil
+	}
+	if p, ok := problem.From(http.StatusUnprocessableEntity, err); ok && p.Status == http.StatusUnprocessableEntity {
+		return p.Errors, nil
+	}
+	return nil, err
+}
+
+// ImportUsers creates the users of r, a file in format.
+func ImportUsers(r io.Reader, format string, dryRun bool) (*bulk.Result, error) {
+	rd, err := bulk.NewReader(r, format)
+	if err != nil {
+		return nil, err
+	}
+	im := bulk.NewImporter(models.DB, importUser)
+	im.DryRun = dryRun
+	res, err := im.Import(rd)
+	if dryRun {
+		return res, err
+	}
+	// the batches aren't transactions of mw.Transaction, what was cached
+	// while they ran is dropped once they're committed
+	if ierr := appCache.Invalidate("user"); ierr != nil && err == nil {
+		err = errors.Wrap(ierr, "imported, but couldn't drop the cached users")
+	}
+	return res, err
+}
+
+// WriteImportReport writes the rows of res that failed as CSV.
+func WriteImportReport(w io.Writer, res *bulk.Result) error {
+	return res.WriteReport(w, importColumns)
+}
+
+type importResult struct {
+	*bulk.Result
+	// ReportURL is the signed URL of the report, if any row failed.
+	ReportURL string `json:"report_url,omitempty"`
+}
+
+// AdminUsersImport imports the users of the uploaded file, streaming it,
+// and keeps the report of the failed rows in the file storage.
+func AdminUsersImport(c buffalo.Context) error {
+	body, format, err := importFile(c)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		return problem.UnsupportedMediaType("send a CSV (text/csv) or NDJSON (application/x-ndjson) file")
+	}
+	res, err := ImportUsers(body, format, c.Param("dry_run") == "true")
+	if err != nil {
+		return err
+	}
+
+	out := importResult{Result: res}
+	if res.Failed > 0 {
+		var report bytes.Buffer
+		if err := WriteImportReport(&report, res); err != nil {
+			return err
+		}
+		key := "imports/" + randomName() + ".csv"
+		if err := files.Put(c.Request().Context(), key, &report, "text/csv"); err != nil {
+			return err
+		}
+		out.ReportURL = fileURLs.URL(key)
+	}
+	return c.Render(200, r.JSON(out))
+}
+
+// importFile returns the uploaded file and its format, "" when unknown:
+// the file part of a multipart form, read as it comes, or the body.
+func importFile(c buffalo.Context) (io.Reader, string, error) {
+	req := c.Request()
+	ct := req.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "multipart/form-data") {
+		return req.Body, bulk.Detect("", ct), nil
+	}
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return nil, "", problem.BadRequest("can't read the form: %s", err)
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", problem.BadRequest("the form has no file field")
+		}
+		if err != nil {
+			return nil, "", problem.BadRequest("can't read the form: %s", err)
+		}
+		if p.FormName() == "file" {
+			return p, bulk.Detect(p.FileName(), p.Header.Get("Content-Type")), nil
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+// Package bulk reads and writes records in bulk, as CSV with a header
+// row or as NDJSON (one JSON object per line), and imports them in
+// batched transactions with a report of the rows that failed.
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The formats.
+const (
+	CSV    = "csv"
+	NDJSON = "ndjson"
+)
+
+// ContentTypes are the media types of the formats.
+var ContentTypes = map[string]string{
+	CSV:    "text/csv; charset=utf-8",
+	NDJSON: "application/x-ndjson",
+}
+
+// Detect returns the format of a file from its name or content type, ""
+// when neither tells.
+func Detect(name, contentType string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return CSV
+	case ".ndjson", ".jsonl":
+		return NDJSON
+	}
+	ct := strings.ToLower(contentType)
+	switch {
+	case strings.HasPrefix(ct, "text/csv"):
+		return CSV
+	case strings.HasPrefix(ct, "application/x-ndjson"), strings.HasPrefix(ct, "application/jsonl"):
+		return NDJSON
+	}
+	return ""
+}
+
+// Record is a row, by column.
+type Record map[string]string
+
+// RowError is a row that couldn't be read, the reader goes on with the
+// next one.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// Reader reads the records of a file. Read returns io.EOF at the end, and
+// a *RowError for a malformed row.
+type Reader interface {
+	Read() (Record, error)
+	// Row is the number of the last row read, from 1. The header row of
+	// a CSV file counts.
+	Row() int
+}
+
+// NewReader returns a reader of r in format.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case CSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		return &csvReader{r: cr}, nil
+	case NDJSON:
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), 1<<20)
+		return &ndjsonReader{s: s}, nil
+	}
+	return nil, errors.Errorf("bulk: unknown format %q", format)
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+	row    int
+}
+
+func (r *csvReader) Row() int { return r.row }
+
+func (r *csvReader) Read() (Record, error) {
+	if r.header == nil {
+		h, err := r.r.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "bulk: can't read the header")
+		}
+		r.row++
+		for i := range h {
+			h[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h[i], "\ufeff")))
+		}
+		r.header = h
+	}
+	fields, err := r.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	r.row++
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			return nil, &RowError{Row: r.row, Err: err}
+		}
+		return nil, errors.WithStack(err)
+	}
+	if len(fields) != len(r.header) {
+		return nil, &RowError{Row: r.row, Err: errors.Errorf("%d fields, the header has %d", len(fields), len(r.header))}
+	}
+	rec := Record{}
+	for i, f := range fields {
+		rec[r.header[i]] = f
+	}
+	return rec, nil
+}
+
+type ndjsonReader struct {
+	s   *bufio.Scanner
+	row int
+}
+
+func (r *ndjsonReader) Row() int { return r.row }
+
+func (r *ndjsonReader) Read() (Record, error) {
+	for r.s.Scan() {
+		r.row++
+		line := bytes.TrimSpace(r.s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		obj := map[string]json.RawMessage{}
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return nil, &RowError{Row: r.row, Err: errors.New("not a JSON object")}
+		}
+		rec := Record{}
+		for k, v := range obj {
+			var s string
+			switch {
+			case json.Unmarshal(v, &s) == nil:
+			case string(v) == "null":
+			default:
+				s = string(v)
+			}
+			rec[strings.ToLower(k)] = s
+		}
+		return rec, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return nil, io.EOF
+}
+
+// Writer writes records with the given columns.
+type Writer interface {
+	Write(v map[string]interface{}) error
+	// Flush writes out the buffered records.
+	Flush() error
+}
+
+// NewWriter returns a writer of format to w. A CSV file starts with the
+// columns as the header.
+func NewWriter(w io.Writer, format string, columns []string) (Writer, error) {
+	switch format {
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &csvWriter{w: cw, columns: columns}, nil
+	case NDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonWriter{w: bw, enc: json.NewEncoder(bw), columns: columns}, nil
+	}
+	return nil, errors.Errorf("bulk: unknown format %q", format)
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (w *csvWriter) Write(v map[string]interface{}) error {
+	fields := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		fields[i] = Format(v[c])
+	}
+	return errors.WithStack(w.w.Write(fields))
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return errors.WithStack(w.w.Error())
+}
+
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	enc     *json.Encoder
+	columns []string
+}
+
+func (w *ndjsonWriter) Write(v map[string]interface{}) error {
+	obj := make(map[string]interface{}, len(w.columns))
+	for _, c := range w.columns {
+		obj[c] = v[c]
+	}
+	return errors.WithStack(w.enc.Encode(obj))
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return errors.WithStack(w.w.Flush())
+}
+
+// Format is the CSV field of a value: times are RFC 3339, nil is empty.
+func Format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
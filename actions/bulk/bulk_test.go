@@ -0,0 +1,150 @@
+package bulk_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/leonids/test-buffalo/actions/bulk"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// insert takes the users with a name, and fails on "boom".
+func insert(tx *pop.Connection, rec bulk.Record) (map[string][]string, error) {
+	if rec["name"] == "" {
+		return map[string][]string{"name": {"Name can not be blank."}}, nil
+	}
+	if rec["name"] == "boom" {
+		return nil, errors.New("boom")
+	}
+	return nil, tx.RawQuery("INSERT INTO users (created_at, updated_at, name, email) VALUES (datetime('now'), datetime('now'), ?, ?)", rec["name"], rec["email"]).Exec()
+}
+
+type user struct {
+	ID int `db:"id"`
+}
+
+func count(t *testing.T, db *pop.Connection) int {
+	n, err := db.Count(&user{})
+	require.NoError(t, err)
+	return n
+}
+
+func readAll(t *testing.T, r bulk.Reader) ([]bulk.Record, []int) {
+	recs, bad := []bulk.Record{}, []int{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs, bad
+		}
+		if re, ok := err.(*bulk.RowError); ok {
+			bad = append(bad, re.Row)
+			continue
+		}
+		require.NoError(t, err)
+		recs = append(recs, rec)
+	}
+}
+
+func Test_Readers(t *testing.T) {
+	r := require.New(t)
+	rd, err := bulk.NewReader(strings.NewReader("\ufeffName, Email\nMark,mark@example.com\nonly one\n\"Jo, Jr\",jo@example.com\n"), bulk.CSV)
+	r.NoError(err)
+	recs, bad := readAll(t, rd)
+	r.Equal([]bulk.Record{
+		{"name": "Mark", "email": "mark@example.com"},
+		{"name": "Jo, Jr", "email": "jo@example.com"},
+	}, recs)
+	r.Equal([]int{3}, bad)
+
+	rd, err = bulk.NewReader(strings.NewReader("{\"name\": \"Mark\", \"Age\": 40, \"email\": null}\n\n[1]\n{\"name\": \"Jo\"}\n"), bulk.NDJSON)
+	r.NoError(err)
+	recs, bad = readAll(t, rd)
+	r.Equal([]bulk.Record{{"name": "Mark", "age": "40", "email": ""}, {"name": "Jo"}}, recs)
+	r.Equal([]int{3}, bad)
+
+	_, err = bulk.NewReader(nil, "xml")
+	r.Error(err)
+	r.Equal(bulk.CSV, bulk.Detect("users.CSV", ""))
+	r.Equal(bulk.NDJSON, bulk.Detect("users", "application/x-ndjson"))
+	r.Equal("", bulk.Detect("users.txt", "text/plain"))
+}
+
+func Test_Writers(t *testing.T) {
+	r := require.New(t)
+	rows := []map[string]interface{}{{"id": 1, "name": "Mark", "email": "a,b"}, {"id": 2, "name": nil}}
+
+	var buf bytes.Buffer
+	w, err := bulk.NewWriter(&buf, bulk.CSV, []string{"id", "name", "email"})
+	r.NoError(err)
+	for _, row := range rows {
+		r.NoError(w.Write(row))
+	}
+	r.NoError(w.Flush())
+	r.Equal("id,name,email\n1,Mark,\"a,b\"\n2,,\n", buf.String())
+
+	buf.Reset()
+	w, err = bulk.NewWriter(&buf, bulk.NDJSON, []string{"id", "name"})
+	r.NoError(err)
+	for _, row := range rows {
+		r.NoError(w.Write(row))
+	}
+	r.NoError(w.Flush())
+	r.Equal("{\"id\":1,\"name\":\"Mark\"}\n{\"id\":2,\"name\":null}\n", buf.String())
+}
+
+const people = `name,email
+Mark,mark@example.com
+,nobody@example.com
+Jo,jo@example.com
+bad,"row
+Ann,ann@example.com
+`
+
+func Test_Import(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	rd, _ := bulk.NewReader(strings.NewReader(people), bulk.CSV)
+	im := bulk.NewImporter(db, insert)
+	im.BatchSize = 2
+	im.DryRun = true
+	res, err := im.Import(rd)
+	r.NoError(err)
+	r.Equal(&bulk.Result{Imported: 2, Failed: 2, DryRun: true, Invalid: res.Invalid}, res)
+	r.Equal(0, count(t, db))
+
+	rd, _ = bulk.NewReader(strings.NewReader(people), bulk.CSV)
+	im.DryRun = false
+	res, err = im.Import(rd)
+	r.NoError(err)
+	r.Equal(2, res.Imported)
+	r.Equal(2, res.Failed)
+	r.Equal(2, count(t, db))
+
+	var buf bytes.Buffer
+	r.NoError(res.WriteReport(&buf, []string{"name", "email"}))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	r.Equal("row,name,email,errors", lines[0])
+	r.Equal("3,,nobody@example.com,Name can not be blank.", lines[1])
+	r.True(strings.HasPrefix(lines[2], "5,,,\"record on line 5"), lines[2])
+	r.Len(lines, 3)
+}
+
+func Test_Import_Error(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	rd, _ := bulk.NewReader(strings.NewReader("name,email\na,a@example.com\nb,b@example.com\nc,c@example.com\nboom,\nd,d@example.com\n"), bulk.CSV)
+	im := bulk.NewImporter(db, insert)
+	im.BatchSize = 2
+	res, err := im.Import(rd)
+	r.Error(err)
+	r.Contains(err.Error(), "row 5")
+	// the first batch stays, the one of boom is rolled back
+	r.Equal(2, res.Imported)
+	r.Equal(2, count(t, db))
+}
@@ -0,0 +1,161 @@
+package bulk
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// DefaultBatchSize is the number of rows per transaction.
+const DefaultBatchSize = 100
+
+// errDryRun rolls back the transactions of a dry run.
+var errDryRun = errors.New("bulk: dry run")
+
+// Invalid is a row that failed, with the messages by field. The problems
+// of the row itself, a malformed CSV line or JSON object, are under
+// "row".
+type Invalid struct {
+	Row    int
+	Record Record
+	Errors map[string][]string
+}
+
+// Result tells how an import went.
+type Result struct {
+	Imported int       `json:"imported"`
+	Failed   int       `json:"failed"`
+	DryRun   bool      `json:"dry_run"`
+	Invalid  []Invalid `json:"-"`
+}
+
+// InsertFunc validates and inserts a record. It returns the messages by
+// field of an invalid record, an error stops the import.
+type InsertFunc func(tx *pop.Connection, rec Record) (map[string][]string, error)
+
+// Importer inserts the records of a reader in batches, each one in a
+// transaction.
+type Importer struct {
+	DB        *pop.Connection
+	Insert    InsertFunc
+	BatchSize int
+	// DryRun validates and inserts the records, then rolls the
+	// transactions back.
+	DryRun bool
+}
+
+// NewImporter returns an importer with the default batch size.
+func NewImporter(db *pop.Connection, insert InsertFunc) *Importer {
+	return &Importer{DB: db, Insert: insert, BatchSize: DefaultBatchSize}
+}
+
+type row struct {
+	n   int
+	rec Record
+}
+
+// Import reads r to the end. On an error the batches before the failing
+// one stay imported, the result counts them.
+func (im *Importer) Import(r Reader) (*Result, error) {
+	res := &Result{DryRun: im.DryRun}
+	size := im.BatchSize
+	if size < 1 {
+		size = DefaultBatchSize
+	}
+	batch := make([]row, 0, size)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if re, ok := err.(*RowError); ok {
+			res.Failed++
+			res.Invalid = append(res.Invalid, Invalid{Row: re.Row, Errors: map[string][]string{"row": {re.Err.Error()}}})
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		batch = append(batch, row{r.Row(), rec})
+		if len(batch) == size {
+			if err := im.flush(batch, res); err != nil {
+				return res, err
+			}
+			batch = batch[:0]
+		}
+	}
+	err := im.flush(batch, res)
+	// the malformed rows come in before the invalid rows of their batch
+	sort.SliceStable(res.Invalid, func(i, j int) bool { return res.Invalid[i].Row < res.Invalid[j].Row })
+	return res, err
+}
+
+// flush inserts a batch, the counts only change once it's committed.
+func (im *Importer) flush(batch []row, res *Result) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	var imported int
+	var invalid []Invalid
+	err := im.DB.Transaction(func(tx *pop.Connection) error {
+		imported, invalid = 0, nil
+		for _, r := range batch {
+			verrs, err := im.Insert(tx, r.rec)
+			if err != nil {
+				return errors.Wrapf(err, "row %d", r.n)
+			}
+			if len(verrs) > 0 {
+				invalid = append(invalid, Invalid{Row: r.n, Record: r.rec, Errors: verrs})
+				continue
+			}
+			imported++
+		}
+		if im.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && errors.Cause(err) != errDryRun {
+		return err
+	}
+	res.Imported += imported
+	res.Failed += len(invalid)
+	res.Invalid = append(res.Invalid, invalid...)
+	return nil
+}
+
+// WriteReport writes the invalid rows as CSV: their number, the columns
+// of the record and the messages.
+func (res *Result) WriteReport(w io.Writer, columns []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{"row"}, columns...), "errors"))
+	for _, inv := range res.Invalid {
+		line := []string{strconv.Itoa(inv.Row)}
+		for _, c := range columns {
+			line = append(line, inv.Record[c])
+		}
+		line = append(line, messages(inv.Errors))
+		cw.Write(line)
+	}
+	cw.Flush()
+	return errors.WithStack(cw.Error())
+}
+
+// messages joins the messages of every field, sorted by field.
+func messages(errs map[string][]string) string {
+	fields := []string{}
+	for f := range errs {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	msgs := []string{}
+	for _, f := range fields {
+		msgs = append(msgs, errs[f]...)
+	}
+	return strings.Join(msgs, " ")
+}
@@ -0,0 +1,42 @@
+package actions
+
+import (
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/cache"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/models"
+)
+
+// appCache keeps the responses, fragments and queries of the app. It's
+// in the database in production, so every instance drops the same
+// entries when a model is saved.
+var appCache = func() *cache.Cache {
+	var s cache.Store = cache.NewMemory(10000)
+	if ENV == "production" {
+		s = cache.NewPopStore(models.DB)
+	}
+	return cache.New(s, 5*time.Minute)
+}()
+
+// cacheResponses caches the responses of a route for ttl, see
+// mw.ResponseCache.
+func cacheResponses(ttl time.Duration, tags ...string) buffalo.MiddlewareFunc {
+	return mw.ResponseCache(mw.ResponseCacheOptions{
+		Cache: appCache,
+		TTL:   ttl,
+		Tags:  func(buffalo.Context) []string { return tags },
+	})
+}
+
+// invalidate drops what's cached about the aggregate of a saved model:
+// the entries tagged with the aggregate, e.g. "user", and with it and
+// its ID, e.g. "user:1". notify calls it once the transaction is
+// committed, a read before would cache the old data again.
+func invalidate(aggregate, id string) error {
+	if aggregate == "" {
+		return nil
+	}
+	return appCache.Invalidate(aggregate, aggregate+":"+id)
+}
@@ -0,0 +1,137 @@
+// Package cache keeps what is costly to compute for a while: responses,
+// template fragments and query results. The entries have tags, and
+// invalidating a tag drops every entry with it; the app does so when it
+// saves a model. The entries are in memory, or in the database for the
+// instances of the app to share them.
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// Store keeps the entries. Implementations must be safe for concurrent
+// use.
+type Store interface {
+	// Get returns the value of key, ok is false when it's missing or
+	// expired.
+	Get(key string) (value []byte, ok bool, err error)
+	// Set keeps value under key for ttl, with tags.
+	Set(key string, value []byte, ttl time.Duration, tags []string) error
+	Delete(key string) error
+	// Invalidate drops the entries with any of tags.
+	Invalidate(tags ...string) error
+}
+
+// Cache is a Store with the read-through helpers.
+type Cache struct {
+	Store Store
+	// TTL is how long the entries are kept when the callers don't say.
+	TTL time.Duration
+}
+
+// New returns a cache of s, keeping the entries for ttl by default.
+func New(s Store, ttl time.Duration) *Cache {
+	return &Cache{Store: s, TTL: ttl}
+}
+
+// Fetch returns the value of key. On a miss it's the one of load, kept
+// for ttl (0 is the TTL of the cache) with tags.
+func (c *Cache) Fetch(key string, ttl time.Duration, tags []string, load func() ([]byte, error)) ([]byte, error) {
+	b, ok, err := c.Store.Get(key)
+	if err != nil || ok {
+		return b, err
+	}
+	b, err = load()
+	if err != nil {
+		return nil, err
+	}
+	return b, c.Store.Set(key, b, c.ttl(ttl), tags)
+}
+
+// FetchValue is Fetch for v, a pointer: load fills it on a miss, it's
+// gob encoded in the store.
+func (c *Cache) FetchValue(key string, ttl time.Duration, tags []string, v interface{}, load func() error) error {
+	b, ok, err := c.Store.Get(key)
+	if err != nil {
+		return err
+	}
+	// a value of another shape, from an older version of the app, is a
+	// miss
+	if ok && gob.NewDecoder(bytes.NewReader(b)).Decode(v) == nil {
+		return nil
+	}
+	if err := load(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return errors.WithStack(err)
+	}
+	return c.Store.Set(key, buf.Bytes(), c.ttl(ttl), tags)
+}
+
+// All reads the models of q through the cache, under key. The paginator
+// of a paginated q is kept along, it has the totals on a hit too.
+func (c *Cache) All(q *pop.Query, key string, models interface{}, ttl time.Duration, tags ...string) error {
+	var v interface{} = models
+	if q.Paginator != nil {
+		v = &page{models: models, paginator: q.Paginator}
+	}
+	return c.FetchValue(key, ttl, tags, v, func() error {
+		return errors.WithStack(q.All(models))
+	})
+}
+
+// page is a page of models and its paginator, gob encoded one after the
+// other.
+type page struct {
+	models    interface{}
+	paginator *pop.Paginator
+}
+
+func (p *page) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(p.paginator); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := enc.Encode(p.models); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (p *page) GobDecode(b []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(b))
+	if err := dec.Decode(p.paginator); err != nil {
+		return err
+	}
+	return dec.Decode(p.models)
+}
+
+// First reads the first model of q through the cache, under key.
+func (c *Cache) First(q *pop.Query, key string, model interface{}, ttl time.Duration, tags ...string) error {
+	return c.FetchValue(key, ttl, tags, model, func() error {
+		return errors.WithStack(q.First(model))
+	})
+}
+
+// Invalidate drops the entries with any of tags.
+func (c *Cache) Invalidate(tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	return c.Store.Invalidate(tags...)
+}
+
+func (c *Cache) ttl(ttl time.Duration) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	return c.TTL
+}
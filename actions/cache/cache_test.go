@@ -0,0 +1,164 @@
+package cache_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/velvet"
+	"github.com/leonids/test-buffalo/actions/cache"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/markbates/pop"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, s cache.Store) {
+	r := require.New(t)
+
+	_, ok, err := s.Get("a")
+	r.NoError(err)
+	r.False(ok)
+
+	r.NoError(s.Set("a", []byte("\x00one"), time.Minute, []string{"user", "user:1"}))
+	r.NoError(s.Set("b", []byte("two"), time.Minute, []string{"user:2"}))
+	r.NoError(s.Set("c", []byte("three"), 50*time.Millisecond, nil))
+	b, ok, err := s.Get("a")
+	r.NoError(err)
+	r.True(ok)
+	r.Equal("\x00one", string(b))
+
+	r.NoError(s.Set("a", []byte("uno"), time.Minute, []string{"user"}))
+	b, _, _ = s.Get("a")
+	r.Equal("uno", string(b))
+
+	time.Sleep(100 * time.Millisecond)
+	_, ok, _ = s.Get("c")
+	r.False(ok)
+
+	// a tag matches as a whole
+	r.NoError(s.Invalidate("user:"))
+	_, ok, _ = s.Get("b")
+	r.True(ok)
+	r.NoError(s.Invalidate("user"))
+	_, ok, _ = s.Get("a")
+	r.False(ok)
+	_, ok, _ = s.Get("b")
+	r.True(ok)
+
+	r.NoError(s.Delete("b"))
+	_, ok, _ = s.Get("b")
+	r.False(ok)
+}
+
+func Test_Memory(t *testing.T) {
+	testStore(t, cache.NewMemory(0))
+}
+
+func Test_Memory_LRU(t *testing.T) {
+	r := require.New(t)
+	m := cache.NewMemory(2)
+	m.Set("a", []byte("a"), time.Minute, []string{"x"})
+	m.Set("b", []byte("b"), time.Minute, nil)
+	m.Get("a")
+	m.Set("c", []byte("c"), time.Minute, nil)
+
+	r.Equal(2, m.Len())
+	_, ok, _ := m.Get("b")
+	r.False(ok)
+	_, ok, _ = m.Get("a")
+	r.True(ok)
+}
+
+func Test_PopStore(t *testing.T) {
+	testStore(t, cache.NewPopStore(modelstest.SQLite(t)))
+}
+
+type widget struct {
+	ID   int    `db:"id"`
+	Name string `db:"name"`
+}
+
+func Test_Cache_All(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	r.NoError(db.RawQuery(`CREATE TABLE widgets (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`).Exec())
+	r.NoError(db.RawQuery(`INSERT INTO widgets (id, name) VALUES (1, 'one'), (2, 'two')`).Exec())
+	c := cache.New(cache.NewMemory(0), time.Minute)
+
+	all := func() []widget {
+		ws := []widget{}
+		r.NoError(c.All(db.Q(), "widgets", &ws, 0, "widget"))
+		return ws
+	}
+	r.Len(all(), 2)
+
+	r.NoError(db.RawQuery(`INSERT INTO widgets (id, name) VALUES (3, 'three')`).Exec())
+	r.Len(all(), 2)
+	r.NoError(c.Invalidate("widget"))
+	ws := all()
+	r.Len(ws, 3)
+	r.Equal("three", ws[2].Name)
+
+	w := widget{}
+	r.NoError(c.First(db.Where("id = ?", 2), "widget:2", &w, 0, "widget"))
+	r.Equal("two", w.Name)
+}
+
+func Test_Cache_All_Paginated(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	r.NoError(db.RawQuery(`CREATE TABLE widgets (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`).Exec())
+	r.NoError(db.RawQuery(`INSERT INTO widgets (id, name) VALUES (1, 'one'), (2, 'two'), (3, 'three')`).Exec())
+	c := cache.New(cache.NewMemory(0), time.Minute)
+
+	first := func() *pop.Paginator {
+		ws := []widget{}
+		q := db.Order("id").Paginate(1, 2)
+		r.NoError(c.All(q, "widgets?page=1", &ws, 0, "widget"))
+		r.Len(ws, 2)
+		return q.Paginator
+	}
+	p := first()
+	r.Equal(3, p.TotalEntriesSize)
+	r.Equal(2, p.TotalPages)
+
+	// a hit has the totals of the miss
+	r.NoError(db.RawQuery(`INSERT INTO widgets (id, name) VALUES (4, 'four'), (5, 'five')`).Exec())
+	p = first()
+	r.Equal(3, p.TotalEntriesSize)
+	r.Equal(2, p.TotalPages)
+	r.Equal(2, p.CurrentEntriesSize)
+}
+
+func Test_Cache_Fetch_Error(t *testing.T) {
+	r := require.New(t)
+	c := cache.New(cache.NewMemory(0), time.Minute)
+	_, err := c.Fetch("a", 0, nil, func() ([]byte, error) {
+		return nil, errors.New("down")
+	})
+	r.Error(err)
+	_, ok, _ := c.Store.Get("a")
+	r.False(ok)
+}
+
+func Test_Cache_Helper(t *testing.T) {
+	r := require.New(t)
+	c := cache.New(cache.NewMemory(0), time.Minute)
+	tpl, err := velvet.Parse(`{{#cache "greeting" tags="greetings"}}<b>{{name}}</b>{{/cache}}`)
+	r.NoError(err)
+	tpl.Helpers.Add("cache", c.Helper("locale"))
+
+	render := func(name, locale string) string {
+		ctx := velvet.NewContext()
+		ctx.Set("name", name)
+		ctx.Set("locale", locale)
+		s, err := tpl.Exec(ctx)
+		r.NoError(err)
+		return s
+	}
+	r.Equal("<b>Mark</b>", render("Mark", "en"))
+	r.Equal("<b>Mark</b>", render("Leo", "en"))
+	r.Equal("<b>Leo</b>", render("Leo", "de"))
+	c.Invalidate("greetings")
+	r.Equal("<b>Leo</b>", render("Leo", "en"))
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/velvet"
+	"github.com/pkg/errors"
+)
+
+// Helper returns the velvet block helper caching the fragment it
+// wraps. The values of the vary keys of the template context, e.g. the
+// locale, are part of the key:
+//
+//	{{#cache "home.routes" ttl="1h" tags="routes"}}...{{/cache}}
+func (c *Cache) Helper(vary ...string) func(string, velvet.HelperContext) (template.HTML, error) {
+	return func(key string, help velvet.HelperContext) (template.HTML, error) {
+		o := help.Context.Options()
+		var ttl time.Duration
+		if s, ok := o["ttl"].(string); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			ttl = d
+		}
+		var tags []string
+		if s, ok := o["tags"].(string); ok {
+			tags = strings.Fields(s)
+		}
+
+		key = "fragment:" + key
+		for _, v := range vary {
+			key += fmt.Sprintf("|%v", help.Get(v))
+		}
+		b, err := c.Fetch(key, ttl, tags, func() ([]byte, error) {
+			s, err := help.Block()
+			return []byte(s), err
+		})
+		return template.HTML(b), err
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Memory is a Store in memory, it drops the least recently used entries
+// past its size.
+type Memory struct {
+	max int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]struct{}
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+	tags    []string
+}
+
+// NewMemory returns a Store of max entries at most, 0 is no limit.
+func NewMemory(max int) *Memory {
+	return &Memory{
+		max:     max,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		tags:    map[string]map[string]struct{}{},
+	}
+}
+
+// Get implements Store.
+func (m *Memory) Get(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		m.remove(el)
+		return nil, false, nil
+	}
+	m.lru.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set implements Store.
+func (m *Memory) Set(key string, value []byte, ttl time.Duration, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	e := &entry{key: key, value: value, expires: time.Now().Add(ttl), tags: tags}
+	m.entries[key] = m.lru.PushFront(e)
+	for _, t := range tags {
+		if m.tags[t] == nil {
+			m.tags[t] = map[string]struct{}{}
+		}
+		m.tags[t][key] = struct{}{}
+	}
+	for m.max > 0 && m.lru.Len() > m.max {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	return nil
+}
+
+// Invalidate implements Store.
+func (m *Memory) Invalidate(tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range tags {
+		for key := range m.tags[t] {
+			if el, ok := m.entries[key]; ok {
+				m.remove(el)
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries, the expired ones included.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+func (m *Memory) remove(el *list.Element) {
+	e := m.lru.Remove(el).(*entry)
+	delete(m.entries, e.key)
+	for _, t := range e.tags {
+		delete(m.tags[t], e.key)
+		if len(m.tags[t]) == 0 {
+			delete(m.tags, t)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// cacheEntry is the database row of an entry, see the
+// create_cache_entries migration.
+type cacheEntry struct {
+	ID        int       `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	CacheKey  string    `db:"cache_key"`
+	Value     string    `db:"value"`
+	Tags      string    `db:"tags"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// PopStore keeps the entries in the cache_entries table, so every
+// instance of the app shares them.
+type PopStore struct {
+	DB *pop.Connection
+}
+
+// NewPopStore returns a store backed by db.
+func NewPopStore(db *pop.Connection) *PopStore {
+	return &PopStore{DB: db}
+}
+
+// Get implements Store.
+func (s *PopStore) Get(key string) ([]byte, bool, error) {
+	e := &cacheEntry{}
+	err := s.DB.RawQuery("SELECT * FROM cache_entries WHERE cache_key = ? AND expires_at > ? ORDER BY id DESC LIMIT 1", key, time.Now()).First(e)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	b, err := base64.StdEncoding.DecodeString(e.Value)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	return b, true, nil
+}
+
+// Set implements Store. The expired entries are dropped along.
+func (s *PopStore) Set(key string, value []byte, ttl time.Duration, tags []string) error {
+	now := time.Now()
+	return s.DB.Transaction(func(tx *pop.Connection) error {
+		err := tx.RawQuery("DELETE FROM cache_entries WHERE cache_key = ? OR expires_at <= ?", key, now).Exec()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(tx.Create(&cacheEntry{
+			CacheKey:  key,
+			Value:     base64.StdEncoding.EncodeToString(value),
+			Tags:      " " + strings.Join(tags, " ") + " ",
+			ExpiresAt: now.Add(ttl),
+		}))
+	})
+}
+
+// Delete implements Store.
+func (s *PopStore) Delete(key string) error {
+	return errors.WithStack(s.DB.RawQuery("DELETE FROM cache_entries WHERE cache_key = ?", key).Exec())
+}
+
+// Invalidate implements Store. The tags are kept space separated, with
+// spaces around, so a tag matches as a word.
+func (s *PopStore) Invalidate(tags ...string) error {
+	for _, t := range tags {
+		err := s.DB.RawQuery("DELETE FROM cache_entries WHERE tags LIKE ?", "% "+t+" %").Exec()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
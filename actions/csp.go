@@ -0,0 +1,45 @@
+package actions
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/gobuffalo/buffalo"
+)
+
+// cspReport is the body browsers POST to the policy's report-uri.
+type cspReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// CSPReportHandler logs Content-Security-Policy violation reports.
+func CSPReportHandler(c buffalo.Context) error {
+	rep := cspReport{}
+	// reports are tiny, anything bigger isn't a browser talking to us
+	err := json.NewDecoder(io.LimitReader(c.Request().Body, 64<<10)).Decode(&rep)
+	if err != nil {
+		return c.Error(400, err)
+	}
+
+	c.Logger().WithFields(map[string]interface{}{
+		"document_uri":        rep.Report.DocumentURI,
+		"blocked_uri":         rep.Report.BlockedURI,
+		"violated_directive":  rep.Report.ViolatedDirective,
+		"effective_directive": rep.Report.EffectiveDirective,
+		"source_file":         rep.Report.SourceFile,
+		"line_number":         rep.Report.LineNumber,
+		"script_sample":       rep.Report.ScriptSample,
+	}).Warn("CSP violation")
+
+	return c.Render(204, nil)
+}
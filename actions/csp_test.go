@@ -0,0 +1,43 @@
+package actions_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leonids/test-buffalo/actions"
+	"github.com/stretchr/testify/require"
+)
+
+func cspReport(body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/csp-report", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/csp-report")
+	res := httptest.NewRecorder()
+	actions.App().ServeHTTP(res, req)
+	return res
+}
+
+func Test_CSPReportHandler(t *testing.T) {
+	r := require.New(t)
+
+	res := cspReport(`{"csp-report":{
+		"document-uri":"http://127.0.0.1:3000/",
+		"blocked-uri":"inline",
+		"violated-directive":"script-src",
+		"line-number":12
+	}}`)
+	r.Equal(204, res.Code)
+	r.Empty(res.Body.String())
+}
+
+func Test_CSPReportHandler_Bad_Body(t *testing.T) {
+	r := require.New(t)
+
+	res := cspReport("not a report")
+	r.Equal(400, res.Code)
+
+	// a browser's report is tiny, an endless body is cut off
+	res = cspReport(`{"csp-report":{"script-sample":"` + strings.Repeat("a", 128<<10) + `"}}`)
+	r.Equal(400, res.Code)
+}
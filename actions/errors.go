@@ -0,0 +1,93 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/leonids/test-buffalo/actions/jsonapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+)
+
+// handledStatuses get errorHandler, anything else falls back to
+// buffalo's default.
+var handledStatuses = []int{400, 401, 403, 404, 405, 409, 410, 413, 415, 422, 429, 500, 502, 503}
+
+func initErrorHandlers(app *buffalo.App) {
+	for _, s := range handledStatuses {
+		app.ErrorHandlers[s] = errorHandler
+	}
+}
+
+// group is app.Group with the error handlers of the app, buffalo starts
+// the groups with its stock ones.
+func group(app *buffalo.App, prefix string) *buffalo.App {
+	g := app.Group(prefix)
+	initErrorHandlers(g)
+	return g
+}
+
+// errorHandler renders err as a problem+json document for API clients and
+// as an HTML page through the layout for browsers. Typed errors from the
+// problem package keep their title, detail and field errors; anything
+// else only shows its message outside of production.
+func errorHandler(status int, err error, c buffalo.Context) error {
+	p, typed := problem.From(status, err)
+	if typed {
+		// renderers fail with a 500, even for a problem of the request
+		status = p.Status
+	}
+	p = localized(translator.Localize(c), p)
+	if status >= 500 {
+		c.Logger().Error(fmt.Sprintf("%+v", err))
+	} else {
+		c.Logger().Warn(err)
+	}
+	if !typed && ENV != "production" {
+		p.Detail = err.Error()
+	}
+
+	if wantsJSONAPI(c) {
+		return c.Render(status, r.JSONAPI(jsonapi.Errors(p), nil))
+	}
+	if wantsJSON(c) {
+		return c.Render(status, render.Func(problem.ContentType, func(w io.Writer, _ render.Data) error {
+			return json.NewEncoder(w).Encode(p)
+		}))
+	}
+
+	c.Set("problem", p)
+	if status >= 500 && ENV == "development" {
+		c.Set("trace", fmt.Sprintf("%+v", err))
+	}
+	return c.Render(status, r.HTML("errors/error.html"))
+}
+
+// wantsJSONAPI is true for clients speaking JSON:API, they get error
+// objects instead of a problem document.
+func wantsJSONAPI(c buffalo.Context) bool {
+	req := c.Request()
+	return strings.Contains(req.Header.Get("Accept"), jsonapi.MediaType) ||
+		strings.HasPrefix(req.Header.Get("Content-Type"), jsonapi.MediaType)
+}
+
+// wantsHTML is true for browsers asking for a page.
+func wantsHTML(c buffalo.Context) bool {
+	return strings.Contains(strings.ToLower(c.Request().Header.Get("Accept")), "text/html")
+}
+
+// wantsJSON tells API clients from browsers.
+func wantsJSON(c buffalo.Context) bool {
+	req := c.Request()
+	accept := strings.ToLower(req.Header.Get("Accept"))
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	if strings.Contains(accept, "json") || strings.Contains(strings.ToLower(req.Header.Get("Content-Type")), "json") {
+		return true
+	}
+	return strings.HasPrefix(req.URL.Path, "/api/")
+}
@@ -0,0 +1,59 @@
+package actions_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/leonids/test-buffalo/actions"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ErrorHandlers_Groups(t *testing.T) {
+	r := require.New(t)
+
+	serve := func(method, path string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept", "application/json")
+		if auth {
+			req.SetBasicAuth("leonids", "maslovs")
+		}
+		res := httptest.NewRecorder()
+		actions.App().ServeHTTP(res, req)
+		return res
+	}
+
+	// /admin
+	res := serve("GET", "/admin/jobs", false)
+	r.Equal(401, res.Code)
+	r.Equal(problem.ContentType, res.Header().Get("Content-Type"))
+	// the exports have the emails of everyone
+	res = serve("GET", "/admin/users.csv", false)
+	r.Equal(401, res.Code)
+
+	// the writes of /users need a user
+	for _, m := range []string{"POST", "PUT", "DELETE"} {
+		path := "/users/1"
+		if m == "POST" {
+			path = "/users"
+		}
+		res = serve(m, path, false)
+		r.Equal(401, res.Code, m)
+	}
+
+	// the reset page of a made up token
+	res = serve("GET", "/password/reset?token=bm9wZQ==", false)
+	r.Equal(404, res.Code)
+
+	// /api/v1
+	res = serve("GET", "/api/v1/graphql?query={users{id}}&variables=x", true)
+	r.Equal(400, res.Code)
+	r.Equal(problem.ContentType, res.Header().Get("Content-Type"))
+
+	// /api/v2, the writes of auth are limited
+	for i := 0; i < 20 && res.Code != 429; i++ {
+		res = serve("POST", "/api/v2/auth", false)
+	}
+	r.Equal(429, res.Code)
+	r.Equal(problem.ContentType, res.Header().Get("Content-Type"))
+}
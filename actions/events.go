@@ -0,0 +1,56 @@
+package actions
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/outbox"
+	"github.com/leonids/test-buffalo/actions/sse"
+	"github.com/leonids/test-buffalo/models"
+)
+
+// activity is the stream of the user events, fed by the outbox: the
+// event IDs are the offsets of the outbox, the same on every instance,
+// so a client can resume on any of them.
+var activity = sse.NewHub(256, 64)
+
+func init() {
+	eventBus.Subscribe(func(e outbox.Event) {
+		if !public(e) {
+			return
+		}
+		activity.Publish(sse.Event{
+			ID:   e.ID,
+			Type: e.Type,
+			Time: e.CreatedAt.UTC(),
+			Data: json.RawMessage(e.Payload),
+		})
+	})
+}
+
+// public tells the events of the activity stream from the ones only the
+// admins see, the audit trail of the dashboard.
+func public(e outbox.Event) bool {
+	return !strings.HasPrefix(e.Type, "admin.")
+}
+
+// EventsHandler streams the user events as Server-Sent Events, to the
+// API clients and to the activity panel of the admins on the home page.
+var EventsHandler = sse.Handler(activity, 15*time.Second)
+
+func initEventRoutes(g *buffalo.App) {
+	// a stream lasts, it mustn't hold a transaction for that long
+	g.Middleware.Skip(mw.Transaction(models.DB), EventsHandler)
+	api.Document(g.GET("/events", EventsHandler), openapi.Operation{
+		Summary:     "Stream of the user events (Server-Sent Events)",
+		Description: "Resume with the Last-Event-ID header. A reset event means events were missed.",
+		Tags:        []string{"events"},
+		ContentType: "text/event-stream",
+		Query:       []openapi.Parameter{{Name: "types"}, {Name: "last_event_id"}},
+		Response:    "",
+	})
+}
@@ -0,0 +1,199 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/flags"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/outbox"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// features are the feature flags of the app. A change shows up on the
+// other instances within 30 seconds.
+var features = flags.New(models.DB, 30*time.Second)
+
+// flagChange is the payload of the audit events of the flags.
+type flagChange struct {
+	// Admin is the email of the admin, the basic auth user of a script,
+	// or the system user running a grift.
+	Admin string     `json:"admin"`
+	Flag  flags.Flag `json:"flag"`
+}
+
+func initFlagRoutes(g *buffalo.App) {
+	html := func(summary string) openapi.Operation {
+		return openapi.Operation{Summary: summary, Tags: []string{"admin"}, ContentType: "text/html", Response: ""}
+	}
+	api.Document(g.GET("/flags", AdminFlagsHandler), html("The feature flags and their audit trail"))
+	save := html("Create or update a feature flag")
+	save.Description = "A form of the dashboard: name, description, enabled, percentage and users (the allow list of user IDs). It takes the csrf_token field and redirects back to the flags."
+	save.Status = http.StatusSeeOther
+	api.Document(g.POST("/flags", AdminSaveFlagHandler), save)
+	del := html("Delete a feature flag")
+	del.Description = "It takes the csrf_token field and redirects back to the flags."
+	del.Status = http.StatusSeeOther
+	api.Document(g.POST("/flags/{name}/delete", AdminDeleteFlagHandler), del)
+}
+
+// flagEntry is an event of the audit trail of the flags.
+type flagEntry struct {
+	Time  string
+	Flag  string
+	Event string
+	Admin string
+}
+
+// AdminFlagsHandler lists the flags, with the forms changing them and
+// the audit trail.
+func AdminFlagsHandler(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	list := []flags.Flag{}
+	if err := tx.Order("name").All(&list); err != nil {
+		return errors.WithStack(err)
+	}
+
+	events := &outbox.Events{}
+	err := tx.Where("aggregate = ?", "feature_flag").Order("id DESC").Limit(adminPageSize).All(events)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	trail := make([]flagEntry, len(*events))
+	for i, e := range *events {
+		ch := flagChange{}
+		if err := json.Unmarshal([]byte(e.Payload), &ch); err != nil {
+			return errors.WithStack(err)
+		}
+		trail[i] = flagEntry{Time: formatTime(e.CreatedAt), Flag: e.AggregateID, Event: e.Type, Admin: ch.Admin}
+	}
+
+	c.Set("flags", list)
+	c.Set("trail", trail)
+	return c.Render(200, r.HTML("admin/flags/index.html"))
+}
+
+// AdminSaveFlagHandler creates or updates the flag of the form.
+func AdminSaveFlagHandler(c buffalo.Context) error {
+	req := c.Request()
+	f := &flags.Flag{
+		Name:        strings.TrimSpace(req.FormValue("name")),
+		Description: req.FormValue("description"),
+		Enabled:     req.FormValue("enabled") != "",
+		Users:       strings.Join(flags.Flag{Users: req.FormValue("users")}.AllowList(), " "),
+	}
+	if p := req.FormValue("percentage"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return problem.BadRequest("percentage %q is not a number", p)
+		}
+		f.Percentage = n
+	}
+	admin, _ := c.Value("admin").(string)
+	if err := saveFlag(c.Value("tx").(*pop.Connection), admin, f); err != nil {
+		return err
+	}
+	c.Logger().WithField("admin", admin).WithField("flag", f.Name).Info(adminFlagSaved)
+	return adminFlagsDone(c, adminFlagSaved, f.Name)
+}
+
+// AdminDeleteFlagHandler deletes a flag.
+func AdminDeleteFlagHandler(c buffalo.Context) error {
+	name := c.Param("name")
+	admin, _ := c.Value("admin").(string)
+	if err := deleteFlag(c.Value("tx").(*pop.Connection), admin, name); err != nil {
+		return err
+	}
+	c.Logger().WithField("admin", admin).WithField("flag", name).Info(adminFlagDeleted)
+	return adminFlagsDone(c, adminFlagDeleted, name)
+}
+
+func adminFlagsDone(c buffalo.Context, event, name string) error {
+	key := "admin.flags.done." + strings.TrimPrefix(event, "admin.")
+	c.Flash().Add("success", translate(c, key, map[string]interface{}{"name": name}))
+	return c.Redirect(http.StatusSeeOther, "/admin/flags")
+}
+
+// saveFlag creates or updates f, by name, and records it in the audit
+// trail, in tx. The flags are read again once it's committed.
+func saveFlag(tx *pop.Connection, admin string, f *flags.Flag) error {
+	verrs, err := features.Save(tx, f)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		return problem.Validation(verrs)
+	}
+	if err := mw.AfterCommit(tx, forgetFlags); err != nil {
+		return err
+	}
+	return outbox.Write(tx, "feature_flag", f.Name, adminFlagSaved, flagChange{Admin: admin, Flag: *f})
+}
+
+// deleteFlag deletes the flag of name and records it in the audit
+// trail, in tx. The flags are read again once it's committed.
+func deleteFlag(tx *pop.Connection, admin, name string) error {
+	ok, err := features.Delete(tx, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return problem.NotFound("there's no flag %s", name)
+	}
+	if err := mw.AfterCommit(tx, forgetFlags); err != nil {
+		return err
+	}
+	return outbox.Write(tx, "feature_flag", name, adminFlagDeleted, flagChange{Admin: admin, Flag: flags.Flag{Name: name}})
+}
+
+// FeatureFlags returns the flags, by name.
+func FeatureFlags() ([]flags.Flag, error) {
+	list := []flags.Flag{}
+	return list, errors.WithStack(models.DB.Order("name").All(&list))
+}
+
+// FindFeatureFlag returns the flag of name, nil without one.
+func FindFeatureFlag(name string) (*flags.Flag, error) {
+	return flags.Find(models.DB, name)
+}
+
+// SaveFeatureFlag creates or updates f on behalf of admin, audited like
+// the changes of the dashboard.
+func SaveFeatureFlag(admin string, f *flags.Flag) error {
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		return saveFlag(tx, admin, f)
+	})
+	// the flags are read again after the transaction, the hook of
+	// saveFlag ran in it
+	if err == nil {
+		features.Forget()
+	}
+	return err
+}
+
+// DeleteFeatureFlag deletes the flag of name on behalf of admin, audited
+// like the changes of the dashboard.
+func DeleteFeatureFlag(admin, name string) error {
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		return deleteFlag(tx, admin, name)
+	})
+	if err == nil {
+		features.Forget()
+	}
+	return err
+}
+
+// forgetFlags makes this instance read the flags again, for
+// mw.AfterCommit. The other ones see the changes after the TTL.
+func forgetFlags() error {
+	features.Forget()
+	return nil
+}
@@ -0,0 +1,284 @@
+// Package flags turns features on gradually. A flag is on for everyone,
+// for a percentage of the signed in users, or for the users of an allow
+// list. The flags are in the feature_flags table, and in memory for a
+// while.
+package flags
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/velvet"
+	"github.com/markbates/pop"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	pop.MapTableName("Flag", "feature_flags")
+}
+
+// UserKey is the context key holding the ID of the user the flags are
+// checked for, see Middleware.
+const UserKey = "feature_user"
+
+// Flag is a feature and who it's on for.
+type Flag struct {
+	ID          int       `json:"-" db:"id"`
+	CreatedAt   time.Time `json:"-" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	// Enabled turns the feature on for everyone.
+	Enabled bool `json:"enabled" db:"enabled"`
+	// Percentage of the signed in users the feature is on for. A user
+	// stays in or out as long as the percentage doesn't go down.
+	Percentage int `json:"percentage" db:"percentage"`
+	// Users is the allow list, space separated user IDs.
+	Users string `json:"users" db:"users"`
+}
+
+// Validate gets run everytime you call a "pop.Validate" method.
+func (f *Flag) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.RegexMatch{Field: f.Name, Name: "Name", Expr: `^[a-z0-9][a-z0-9_.-]*$`},
+		&validators.FuncValidator{
+			Field:   "Percentage",
+			Message: "%s must be between 0 and 100.",
+			Fn:      func() bool { return f.Percentage >= 0 && f.Percentage <= 100 },
+		},
+	), nil
+}
+
+// AllowList is the IDs of Users.
+func (f Flag) AllowList() []string {
+	return strings.Fields(strings.Replace(f.Users, ",", " ", -1))
+}
+
+// On tells whether the feature is on for the user of the ID, the empty
+// one being nobody signed in.
+func (f Flag) On(user string) bool {
+	if f.Enabled {
+		return true
+	}
+	if user == "" {
+		return false
+	}
+	for _, u := range f.AllowList() {
+		if u == user {
+			return true
+		}
+	}
+	return bucket(f.Name, user) < f.Percentage
+}
+
+// bucket puts a user in one of 100 buckets, another one per flag so the
+// same users don't get every new feature first.
+func bucket(name, user string) int {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%s", name, user)
+	return int(h.Sum32() % 100)
+}
+
+// Flags reads the flags from the database, at most every TTL. The
+// changes made by other instances of the app show up after that.
+type Flags struct {
+	DB  *pop.Connection
+	TTL time.Duration
+
+	mu    sync.Mutex
+	flags map[string]Flag
+	// loaded is when the flags were last read, err the error of that
+	// read: a failed one isn't retried before the TTL either.
+	loaded time.Time
+	err    error
+	// loading is closed once the read running is done, nil without one.
+	loading chan struct{}
+	// gen changes with Forget, a read started before is outdated.
+	gen int
+}
+
+// New returns the flags of db, kept in memory for ttl.
+func New(db *pop.Connection, ttl time.Duration) *Flags {
+	return &Flags{DB: db, TTL: ttl}
+}
+
+// load returns the flags by name, reloaded when they're older than the
+// TTL. The ones loaded before are kept when the database fails. One
+// check reads them at a time, outside the lock, and the others wait for
+// it.
+func (s *Flags) load() (map[string]Flag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if !s.loaded.IsZero() && time.Since(s.loaded) < s.TTL {
+			return s.flags, s.err
+		}
+		if s.loading != nil {
+			done, gen := s.loading, s.gen
+			s.mu.Unlock()
+			<-done
+			s.mu.Lock()
+			if gen == s.gen {
+				return s.flags, s.err
+			}
+			continue
+		}
+
+		done, gen := make(chan struct{}), s.gen
+		s.loading = done
+		s.mu.Unlock()
+		list := []Flag{}
+		err := s.DB.All(&list)
+		s.mu.Lock()
+		s.loading = nil
+		close(done)
+		if gen != s.gen {
+			// forgotten meanwhile, the list may miss the change
+			continue
+		}
+
+		s.loaded, s.err = time.Now(), errors.WithStack(err)
+		if err == nil {
+			s.flags = make(map[string]Flag, len(list))
+			for _, f := range list {
+				s.flags[f.Name] = f
+			}
+		}
+		return s.flags, s.err
+	}
+}
+
+// Forget drops the flags in memory, they're read again on the next
+// check.
+func (s *Flags) Forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags, s.loaded, s.err = nil, time.Time{}, nil
+	s.gen++
+}
+
+// Enabled tells whether the feature of name is on for the user of the
+// ID. Unknown flags are off, and so are all of them when they can't be
+// read.
+func (s *Flags) Enabled(name, user string) bool {
+	flags, _ := s.load()
+	f, ok := flags[name]
+	return ok && f.On(user)
+}
+
+// All returns the flags, by name.
+func (s *Flags) All() ([]Flag, error) {
+	flags, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Flag, 0, len(flags))
+	for _, f := range flags {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Find returns the flag of name from tx, nil without one.
+func Find(tx *pop.Connection, name string) (*Flag, error) {
+	list := []Flag{}
+	if err := tx.Where("name = ?", name).All(&list); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return &list[0], nil
+}
+
+// Save creates or updates f, by name, in tx. Forget the flags once tx
+// is committed.
+func (s *Flags) Save(tx *pop.Connection, f *Flag) (*validate.Errors, error) {
+	prev, err := Find(tx, f.Name)
+	if err != nil {
+		return nil, err
+	}
+	var verrs *validate.Errors
+	if prev == nil {
+		f.ID = 0
+		verrs, err = tx.ValidateAndCreate(f)
+	} else {
+		f.ID, f.CreatedAt = prev.ID, prev.CreatedAt
+		verrs, err = tx.ValidateAndUpdate(f)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return verrs, nil
+}
+
+// Delete deletes the flag of name in tx, ok is false when there's none.
+// Forget the flags once tx is committed.
+func (s *Flags) Delete(tx *pop.Connection, name string) (ok bool, err error) {
+	f, err := Find(tx, name)
+	if err != nil || f == nil {
+		return false, err
+	}
+	if err := tx.Destroy(f); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+// Middleware puts the ID of the user the flags are checked for in the
+// context, as UserKey, for On and the helper.
+func (s *Flags) Middleware(user func(buffalo.Context) string) buffalo.MiddlewareFunc {
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			c.Set(UserKey, user(c))
+			return next(c)
+		}
+	}
+}
+
+// On tells whether the feature of name is on for the request.
+func (s *Flags) On(c buffalo.Context, name string) bool {
+	user, _ := c.Value(UserKey).(string)
+	return s.Enabled(name, user)
+}
+
+// Require gates routes behind the feature of name: they're not found
+// while it's off for the request.
+//
+//	g.Use(features.Require("new_signup"))
+func (s *Flags) Require(name string) buffalo.MiddlewareFunc {
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			if !s.On(c, name) {
+				return c.Error(http.StatusNotFound, errors.Errorf("%s is off", name))
+			}
+			return next(c)
+		}
+	}
+}
+
+// Helper is the velvet block helper rendering its block when the
+// feature is on for the page, its else block otherwise:
+//
+//	{{#feature "new_signup"}}...{{else}}...{{/feature}}
+func (s *Flags) Helper(name string, help velvet.HelperContext) (template.HTML, error) {
+	user, _ := help.Get(UserKey).(string)
+	var out string
+	var err error
+	if s.Enabled(name, user) {
+		out, err = help.Block()
+	} else {
+		out, err = help.ElseBlock()
+	}
+	return template.HTML(out), err
+}
@@ -0,0 +1,175 @@
+package flags_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/gobuffalo/velvet"
+	"github.com/leonids/test-buffalo/actions/flags"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Flag_On(t *testing.T) {
+	r := require.New(t)
+
+	r.True(flags.Flag{Name: "a", Enabled: true}.On(""))
+	r.False(flags.Flag{Name: "a", Percentage: 100}.On(""))
+	r.True(flags.Flag{Name: "a", Percentage: 100}.On("1"))
+	r.False(flags.Flag{Name: "a"}.On("1"))
+
+	f := flags.Flag{Name: "a", Users: "3, 7 12"}
+	r.Equal([]string{"3", "7", "12"}, f.AllowList())
+	r.True(f.On("7"))
+	r.False(f.On("1"))
+
+	// about the percentage of the users, the same ones as it grows
+	on := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		if (flags.Flag{Name: "a", Percentage: 20}).On(strconv.Itoa(i)) {
+			on[strconv.Itoa(i)] = true
+		}
+	}
+	r.InDelta(200, len(on), 50)
+	for u := range on {
+		r.True(flags.Flag{Name: "a", Percentage: 50}.On(u))
+	}
+}
+
+func Test_Flags(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	s := flags.New(db, time.Minute)
+
+	r.False(s.Enabled("new_signup", "1"))
+
+	verrs, err := s.Save(db, &flags.Flag{Name: "new_signup", Users: "1"})
+	r.NoError(err)
+	r.False(verrs.HasAny())
+	// until it's forgotten, the flags in memory are the old ones
+	r.False(s.Enabled("new_signup", "1"))
+	s.Forget()
+	r.True(s.Enabled("new_signup", "1"))
+	r.False(s.Enabled("new_signup", "2"))
+
+	// by name
+	verrs, err = s.Save(db, &flags.Flag{Name: "new_signup", Enabled: true})
+	r.NoError(err)
+	r.False(verrs.HasAny())
+	s.Forget()
+	all, err := s.All()
+	r.NoError(err)
+	r.Len(all, 1)
+	r.True(all[0].Enabled)
+
+	verrs, err = s.Save(db, &flags.Flag{Name: "New Signup", Percentage: 101})
+	r.NoError(err)
+	r.Len(verrs.Errors, 2)
+
+	// the changes of other instances show up after the TTL
+	r.NoError(db.RawQuery("UPDATE feature_flags SET enabled = ?", false).Exec())
+	r.True(s.Enabled("new_signup", "2"))
+	s.Forget()
+	r.False(s.Enabled("new_signup", "2"))
+
+	ok, err := s.Delete(db, "new_signup")
+	r.NoError(err)
+	r.True(ok)
+	s.Forget()
+	r.False(s.Enabled("new_signup", "1"))
+	ok, err = s.Delete(db, "new_signup")
+	r.NoError(err)
+	r.False(ok)
+}
+
+func Test_Flags_DB_Down(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	ttl := 50 * time.Millisecond
+	s := flags.New(db, ttl)
+	_, err := s.Save(db, &flags.Flag{Name: "beta", Users: "1"})
+	r.NoError(err)
+	r.True(s.Enabled("beta", "1"))
+
+	time.Sleep(ttl)
+	r.NoError(db.RawQuery("ALTER TABLE feature_flags RENAME TO feature_flags_down").Exec())
+	// the flags read before are kept
+	on := make([]bool, 10)
+	var wg sync.WaitGroup
+	for i := range on {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			on[i] = s.Enabled("beta", "1")
+		}(i)
+	}
+	wg.Wait()
+	r.NotContains(on, false)
+	_, err = s.All()
+	r.Error(err)
+
+	// the failed read isn't retried before the TTL
+	r.NoError(db.RawQuery("ALTER TABLE feature_flags_down RENAME TO feature_flags").Exec())
+	_, err = s.All()
+	r.Error(err)
+	time.Sleep(ttl)
+	all, err := s.All()
+	r.NoError(err)
+	r.Len(all, 1)
+}
+
+func Test_Flags_Require(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	s := flags.New(db, time.Minute)
+	_, err := s.Save(db, &flags.Flag{Name: "beta", Users: "1"})
+	r.NoError(err)
+
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(s.Middleware(func(c buffalo.Context) string { return c.Request().Header.Get("X-User") }))
+	g := a.Group("/beta")
+	g.Use(s.Require("beta"))
+	g.GET("/", func(c buffalo.Context) error {
+		return c.Render(200, render.String("beta"))
+	})
+	a.GET("/", func(c buffalo.Context) error {
+		return c.Render(200, render.String(strconv.FormatBool(s.On(c, "beta"))))
+	})
+
+	get := func(path, user string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("X-User", user)
+		res := httptest.NewRecorder()
+		a.ServeHTTP(res, req)
+		return res
+	}
+	r.Equal(200, get("/beta", "1").Code)
+	r.Equal(404, get("/beta", "2").Code)
+	r.Equal("true", get("/", "1").Body.String())
+	r.Equal("false", get("/", "").Body.String())
+}
+
+func Test_Flags_Helper(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	s := flags.New(db, time.Minute)
+	_, err := s.Save(db, &flags.Flag{Name: "beta", Users: "1"})
+	r.NoError(err)
+
+	tpl, err := velvet.Parse(`{{#feature "beta"}}new{{else}}old{{/feature}}`)
+	r.NoError(err)
+	tpl.Helpers.Add("feature", s.Helper)
+	for user, want := range map[string]string{"1": "new", "2": "old", "": "old"} {
+		ctx := velvet.NewContext()
+		ctx.Set(flags.UserKey, user)
+		out, err := tpl.Exec(ctx)
+		r.NoError(err)
+		r.Equal(want, out)
+	}
+}
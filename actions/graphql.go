@@ -0,0 +1,172 @@
+package actions
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/auth"
+	"github.com/leonids/test-buffalo/actions/graphql"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// gql is the schema served at /api/v1/graphql.
+var gql = newGraphQLSchema()
+
+func newGraphQLSchema() *graphql.Schema {
+	m := graphql.NewModels()
+	user := m.Register(models.User{})
+	sessions := graphql.NewObject("Sessions", "The sign-ins of an account.").
+		AddField("remember_tokens", &graphql.Field{Type: graphql.NonNullOf(graphql.Int)}).
+		AddField("revoked_at", &graphql.Field{
+			Description: "Sessions started before it are signed out.",
+			Type:        graphql.String,
+		}).
+		AddField("providers", &graphql.Field{
+			Description: "The OAuth2 providers linked to the account.",
+			Type:        graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(graphql.String))),
+		})
+	user.AddField("role", &graphql.Field{
+		Description: "The role of the account, null without one.",
+		Type:        graphql.String,
+		Batch: accountsBatch(func(a store.Account) interface{} {
+			if a.Role == "" {
+				return nil
+			}
+			return a.Role
+		}),
+	}).AddField("sessions", &graphql.Field{
+		Description: "The sign-ins of the account, null without one.",
+		Type:        sessions,
+		Batch: accountsBatch(func(a store.Account) interface{} {
+			return &userSessions{RememberTokens: a.RememberTokens, RevokedAt: optionalTime(a.SessionsRevoked), Providers: a.Providers}
+		}),
+	})
+
+	input := &graphql.Input{TypeName: "UserInput", Fields: []*graphql.Argument{
+		{Name: "name", Type: graphql.String},
+		{Name: "email", Type: graphql.String},
+	}}
+	mutation := graphql.NewObject("Mutation", "").
+		AddField("createUser", &graphql.Field{
+			Type: user,
+			Args: []*graphql.Argument{{Name: "input", Type: graphql.NonNullOf(input)}},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				u := &models.User{}
+				if err := graphql.Assign(u, p.Args["input"].(map[string]interface{})); err != nil {
+					return nil, err
+				}
+				if err := createUser(p.Context.Value("tx").(*pop.Connection), u); err != nil {
+					return nil, graphQLError(err)
+				}
+				return u, nil
+			},
+		}).
+		AddField("updateUser", &graphql.Field{
+			Type: user,
+			Args: []*graphql.Argument{
+				{Name: "id", Type: graphql.NonNullOf(graphql.ID)},
+				{Name: "input", Type: graphql.NonNullOf(input)},
+			},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				tx := p.Context.Value("tx").(*pop.Connection)
+				u, err := findUser(tx, p.Args["id"].(string))
+				if err != nil {
+					return nil, graphQLError(err)
+				}
+				id := u.ID
+				if err := graphql.Assign(u, p.Args["input"].(map[string]interface{})); err != nil {
+					return nil, err
+				}
+				u.ID = id
+				if err := updateUser(tx, u); err != nil {
+					return nil, graphQLError(err)
+				}
+				return u, nil
+			},
+		}).
+		AddField("deleteUser", &graphql.Field{
+			Type: graphql.NonNullOf(graphql.Boolean),
+			Args: []*graphql.Argument{{Name: "id", Type: graphql.NonNullOf(graphql.ID)}},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				tx := p.Context.Value("tx").(*pop.Connection)
+				u := &models.User{}
+				if err := tx.Find(u, p.Args["id"]); err != nil {
+					if errors.Cause(err) == sql.ErrNoRows {
+						return false, nil
+					}
+					return nil, err
+				}
+				return true, destroyUser(tx, u)
+			},
+		})
+
+	s, err := graphql.NewSchema(m.Query, mutation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.MaxDepth = 8
+	s.MaxComplexity = 1000
+	return s
+}
+
+// userSessions are the sign-ins of a user, as the Sessions type.
+type userSessions struct {
+	RememberTokens int      `json:"remember_tokens"`
+	RevokedAt      *string  `json:"revoked_at"`
+	Providers      []string `json:"providers"`
+}
+
+// accountsBatch resolves a field of the users from their authboss
+// accounts, looked up once for all the users of a query level. Users
+// without an account get null.
+func accountsBatch(field func(store.Account) interface{}) func(graphql.Params, []interface{}) ([]interface{}, error) {
+	return func(p graphql.Params, sources []interface{}) ([]interface{}, error) {
+		out := make([]interface{}, len(sources))
+		if authStore == nil {
+			return out, nil
+		}
+		emails := make([]string, len(sources))
+		for i, src := range sources {
+			switch u := src.(type) {
+			case models.User:
+				emails[i] = u.Email
+			case *models.User:
+				emails[i] = u.Email
+			}
+		}
+		accounts := authStore.Accounts(emails)
+		for i, email := range emails {
+			if a, ok := accounts[email]; ok {
+				out[i] = field(a)
+			}
+		}
+		return out, nil
+	}
+}
+
+// optionalTime is t in RFC 3339, nil for the zero time.
+func optionalTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	s := t.UTC().Format(time.RFC3339)
+	return &s
+}
+
+// graphQLError carries the status and the field errors of application
+// errors into the extensions of the GraphQL error.
+func graphQLError(err error) error {
+	p, typed := problem.From(0, err)
+	if !typed {
+		return err
+	}
+	ext := map[string]interface{}{"status": p.Status}
+	if len(p.Errors) > 0 {
+		ext["errors"] = p.Errors
+	}
+	return graphql.NewError(p.Error(), ext)
+}
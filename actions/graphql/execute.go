@@ -0,0 +1,805 @@
+package graphql
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// Request is a GraphQL request, as POSTed by clients.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is the result of a request. Data is nil when the request
+// couldn't be executed at all.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error. Resolvers may return one to add extensions,
+// otherwise their error message is used.
+type Error struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError returns an error with extensions.
+func NewError(message string, extensions map[string]interface{}) *Error {
+	return &Error{Message: message, Extensions: extensions}
+}
+
+// object is a result object, keeping the order of the query's fields.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newObject() *object {
+	return &object{values: map[string]interface{}{}}
+}
+
+func (o *object) set(k string, v interface{}) {
+	if _, ok := o.values[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.values[k] = v
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	bb := &bytes.Buffer{}
+	bb.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			bb.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		bb.Write(kb)
+		bb.WriteByte(':')
+		vb, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		bb.Write(vb)
+	}
+	bb.WriteByte('}')
+	return bb.Bytes(), nil
+}
+
+type executor struct {
+	schema *Schema
+	c      buffalo.Context
+	doc    *document
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// Execute runs the request. Field errors end up in the response next to
+// the data; a field that failed is null, the nulls aren't propagated to
+// the parents of non null fields.
+func (s *Schema) Execute(c buffalo.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	e := &executor{schema: s, c: c, doc: doc}
+
+	op, err := e.operation(req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	root := s.Query
+	if op.kind == "mutation" {
+		root = s.Mutation
+	}
+	if root == nil {
+		return &Response{Errors: []*Error{{Message: fmt.Sprintf("the schema has no %s type", op.kind)}}}
+	}
+	if e.vars, err = e.variables(op, req.Variables); err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	cost, err := e.check(root, op.selection, 1, map[string]bool{})
+	if err == nil && s.MaxComplexity > 0 && cost > s.MaxComplexity {
+		err = errors.Errorf("the query is too complex: %d, the limit is %d", cost, s.MaxComplexity)
+	}
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	data := e.object(root, []interface{}{nil}, op.selection, nil)[0]
+	return &Response{Data: data, Errors: e.errors}
+}
+
+func (e *executor) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(e.doc.operations) > 1 {
+			return nil, errors.New("operationName is required for documents with several operations")
+		}
+		return e.doc.operations[0], nil
+	}
+	for _, op := range e.doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, errors.Errorf("unknown operation %q", name)
+}
+
+func (e *executor) fail(path []interface{}, err error) {
+	gerr, ok := errors.Cause(err).(*Error)
+	if !ok {
+		gerr = &Error{Message: err.Error()}
+	}
+	ge := *gerr
+	ge.Path = path
+	e.errors = append(e.errors, &ge)
+}
+
+// fieldDef returns the definition of a field of t, the introspection
+// fields included.
+func (e *executor) fieldDef(t *Object, name string) (*Field, bool) {
+	if t == e.schema.Query {
+		switch name {
+		case "__schema":
+			return &Field{Type: NonNullOf(schemaType), Resolve: func(p Params) (interface{}, error) {
+				return e.schema, nil
+			}}, true
+		case "__type":
+			return &Field{
+				Type: typeType,
+				Args: []*Argument{{Name: "name", Type: NonNullOf(String)}},
+				Resolve: func(p Params) (interface{}, error) {
+					if t, ok := e.schema.types[p.Args["name"].(string)]; ok {
+						return t, nil
+					}
+					return nil, nil
+				},
+			}, true
+		}
+	}
+	f, ok := t.Fields[name]
+	return f, ok
+}
+
+// check validates the selection against the schema, enforces the depth
+// limit and returns the cost of the selection. Introspection isn't
+// limited.
+func (e *executor) check(t *Object, sel []selection, depth int, visiting map[string]bool) (int, error) {
+	if max := e.schema.MaxDepth; max > 0 && depth > max {
+		return 0, errors.Errorf("the query is too deep, the limit is %d", max)
+	}
+	cost := 0
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *field:
+			if strings.HasPrefix(s.name, "__") {
+				continue
+			}
+			def, ok := e.fieldDef(t, s.name)
+			if !ok {
+				return 0, errors.Errorf("line %d: %s has no field %s", s.line, t.TypeName, s.name)
+			}
+			obj, isObj := unwrap(def.Type).(*Object)
+			if isObj && len(s.selection) == 0 {
+				return 0, errors.Errorf("line %d: %s.%s needs a selection", s.line, t.TypeName, s.name)
+			}
+			if !isObj && len(s.selection) > 0 {
+				return 0, errors.Errorf("line %d: %s.%s is a leaf", s.line, t.TypeName, s.name)
+			}
+			c := def.Cost
+			if c == 0 {
+				c = 1
+			}
+			if isObj {
+				sub, err := e.check(obj, s.selection, depth+1, visiting)
+				if err != nil {
+					return 0, err
+				}
+				c += sub * e.multiplier(def, s)
+			}
+			cost += c
+		case *fragmentSpread:
+			f, ok := e.doc.fragments[s.name]
+			if !ok {
+				return 0, errors.Errorf("unknown fragment %s", s.name)
+			}
+			if visiting[s.name] {
+				return 0, errors.Errorf("fragment %s spreads itself", s.name)
+			}
+			visiting[s.name] = true
+			c, err := e.check(t, f.selection, depth, visiting)
+			delete(visiting, s.name)
+			if err != nil {
+				return 0, err
+			}
+			cost += c
+		case *inlineFragment:
+			c, err := e.check(t, s.selection, depth, visiting)
+			if err != nil {
+				return 0, err
+			}
+			cost += c
+		}
+	}
+	return cost, nil
+}
+
+// multiplier of the cost of a list field's selection, its "first"
+// argument.
+func (e *executor) multiplier(def *Field, f *field) int {
+	t := def.Type
+	if nn, ok := t.(*NonNull); ok {
+		t = nn.OfType
+	}
+	if _, ok := t.(*List); !ok {
+		return 1
+	}
+	for _, a := range def.Args {
+		if a.Name != "first" {
+			continue
+		}
+		args, err := e.args(def.Args, f.args)
+		if n, ok := args["first"].(int); err == nil && ok && n > 0 {
+			return n
+		}
+	}
+	return 1
+}
+
+type fieldGroup struct {
+	key    string
+	fields []*field
+}
+
+// collect flattens the fragments of sel and groups the fields by
+// response key.
+func (e *executor) collect(t *Object, sel []selection, groups []*fieldGroup) []*fieldGroup {
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *field:
+			if !e.included(s.directives) {
+				continue
+			}
+			found := false
+			for _, g := range groups {
+				if g.key == s.key() {
+					g.fields = append(g.fields, s)
+					found = true
+				}
+			}
+			if !found {
+				groups = append(groups, &fieldGroup{key: s.key(), fields: []*field{s}})
+			}
+		case *fragmentSpread:
+			f := e.doc.fragments[s.name]
+			if e.included(s.directives) && f != nil && (f.on == "" || f.on == t.TypeName) {
+				groups = e.collect(t, f.selection, groups)
+			}
+		case *inlineFragment:
+			if e.included(s.directives) && (s.on == "" || s.on == t.TypeName) {
+				groups = e.collect(t, s.selection, groups)
+			}
+		}
+	}
+	return groups
+}
+
+// included evaluates @skip and @include.
+func (e *executor) included(dirs []*directive) bool {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+		args, err := e.args([]*Argument{{Name: "if", Type: NonNullOf(Boolean)}}, d.args)
+		if err != nil {
+			continue
+		}
+		if args["if"].(bool) == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+// object resolves the selection for all the sources at once.
+func (e *executor) object(t *Object, sources []interface{}, sel []selection, path []interface{}) []interface{} {
+	results := make([]*object, len(sources))
+	for i := range results {
+		results[i] = newObject()
+	}
+
+	for _, g := range e.collect(t, sel, nil) {
+		f := g.fields[0]
+		fpath := append(append([]interface{}{}, path...), g.key)
+		if f.name == "__typename" {
+			for _, r := range results {
+				r.set(g.key, t.TypeName)
+			}
+			continue
+		}
+
+		def, ok := e.fieldDef(t, f.name)
+		if !ok {
+			e.fail(fpath, errors.Errorf("%s has no field %s", t.TypeName, f.name))
+			continue
+		}
+		values := make([]interface{}, len(sources))
+		args, err := e.args(def.Args, f.args)
+		if err == nil {
+			values = e.resolve(def, f.name, args, sources, fpath)
+		} else {
+			e.fail(fpath, err)
+		}
+
+		sub := []selection{}
+		for _, f := range g.fields {
+			sub = append(sub, f.selection...)
+		}
+		values = e.complete(def.Type, values, sub, fpath)
+		for i, r := range results {
+			r.set(g.key, values[i])
+		}
+	}
+
+	out := make([]interface{}, len(results))
+	for i, r := range results {
+		out[i] = r
+	}
+	return out
+}
+
+func (e *executor) resolve(def *Field, name string, args map[string]interface{}, sources []interface{}, path []interface{}) []interface{} {
+	values := make([]interface{}, len(sources))
+	if len(sources) == 0 {
+		return values
+	}
+	p := Params{Context: e.c, Args: args}
+
+	if def.Batch != nil {
+		vals, err := def.Batch(p, sources)
+		if err == nil && len(vals) != len(sources) {
+			err = errors.Errorf("%s resolved %d values for %d sources", name, len(vals), len(sources))
+		}
+		if err != nil {
+			e.fail(path, err)
+			return values
+		}
+		return vals
+	}
+
+	for i, src := range sources {
+		p.Source = src
+		if def.Resolve == nil {
+			values[i] = defaultResolve(src, name)
+			continue
+		}
+		v, err := def.Resolve(p)
+		if err != nil {
+			e.fail(path, err)
+			continue
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// complete turns resolved values into their results.
+func (e *executor) complete(t Type, values []interface{}, sel []selection, path []interface{}) []interface{} {
+	for i, v := range values {
+		if isNil(v) {
+			values[i] = nil
+		}
+	}
+
+	switch t := t.(type) {
+	case *NonNull:
+		out := e.complete(t.OfType, values, sel, path)
+		for _, v := range out {
+			if v == nil {
+				e.fail(path, errors.New("a non null field resolved to null"))
+				break
+			}
+		}
+		return out
+	case *List:
+		items := []interface{}{}
+		spans := make([][2]int, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			rv := reflect.Indirect(reflect.ValueOf(v))
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				e.fail(path, errors.Errorf("expected a list, got %T", v))
+				values[i] = nil
+				continue
+			}
+			spans[i] = [2]int{len(items), len(items) + rv.Len()}
+			for j := 0; j < rv.Len(); j++ {
+				items = append(items, rv.Index(j).Interface())
+			}
+		}
+		items = e.complete(t.OfType, items, sel, path)
+		out := make([]interface{}, len(values))
+		for i, v := range values {
+			if v != nil {
+				out[i] = items[spans[i][0]:spans[i][1]]
+			}
+		}
+		return out
+	case *Object:
+		idx := []int{}
+		srcs := []interface{}{}
+		for i, v := range values {
+			if v != nil {
+				idx = append(idx, i)
+				srcs = append(srcs, v)
+			}
+		}
+		out := make([]interface{}, len(values))
+		for j, r := range e.object(t, srcs, sel, path) {
+			out[idx[j]] = r
+		}
+		return out
+	case *Scalar:
+		out := make([]interface{}, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			if dv, ok := v.(driver.Valuer); ok {
+				// pop's nulls types
+				var err error
+				if v, err = dv.Value(); err != nil || v == nil {
+					continue
+				}
+			}
+			v = reflect.Indirect(reflect.ValueOf(v)).Interface()
+			s, err := t.Serialize(v)
+			if err != nil {
+				e.fail(path, err)
+				continue
+			}
+			out[i] = s
+		}
+		return out
+	case *Enum:
+		out := make([]interface{}, len(values))
+		for i, v := range values {
+			if v != nil {
+				out[i] = fmt.Sprint(v)
+			}
+		}
+		return out
+	}
+	return make([]interface{}, len(values))
+}
+
+// defaultResolve reads name from a map or from the struct field with
+// that json name.
+func defaultResolve(src interface{}, name string) interface{} {
+	if m, ok := src.(map[string]interface{}); ok {
+		return m[name]
+	}
+	rv := reflect.Indirect(reflect.ValueOf(src))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	if f, ok := jsonField(rv.Type(), name); ok {
+		return rv.FieldByIndex(f.Index).Interface()
+	}
+	return nil
+}
+
+// jsonField finds the field of t named name in json.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if jsonName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func jsonName(f reflect.StructField) string {
+	tag := strings.Split(f.Tag.Get("json"), ",")[0]
+	if tag == "-" {
+		return ""
+	}
+	if tag != "" {
+		return tag
+	}
+	return f.Name
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// variables coerces the variables of the request.
+func (e *executor) variables(op *operation, given map[string]interface{}) (map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+	for _, d := range op.variables {
+		t, err := e.typeOf(d.typ)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := given[d.name]
+		if !ok {
+			if d.def != nil {
+				if vars[d.name], err = e.literal(t, d.def); err != nil {
+					return nil, errors.Wrapf(err, "$%s", d.name)
+				}
+			} else if _, nn := t.(*NonNull); nn {
+				return nil, errors.Errorf("$%s is required", d.name)
+			}
+			continue
+		}
+		if vars[d.name], err = coerce(t, v); err != nil {
+			return nil, errors.Wrapf(err, "$%s", d.name)
+		}
+	}
+	return vars, nil
+}
+
+func (e *executor) typeOf(ref *typeRef) (Type, error) {
+	var t Type
+	if ref.list != nil {
+		inner, err := e.typeOf(ref.list)
+		if err != nil {
+			return nil, err
+		}
+		t = ListOf(inner)
+	} else {
+		named, ok := e.schema.types[ref.name]
+		if !ok {
+			return nil, errors.Errorf("unknown type %s", ref.name)
+		}
+		t = named
+	}
+	if ref.nonNull {
+		t = NonNullOf(t)
+	}
+	return t, nil
+}
+
+// args coerces the arguments of a field.
+func (e *executor) args(defs []*Argument, given []*argument) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for _, a := range given {
+		known := false
+		for _, d := range defs {
+			known = known || d.Name == a.name
+		}
+		if !known {
+			return nil, errors.Errorf("unknown argument %s", a.name)
+		}
+	}
+	for _, d := range defs {
+		var ast *value
+		for _, a := range given {
+			if a.name == d.Name {
+				ast = a.value
+			}
+		}
+		if ast != nil && !(ast.kind == valueVariable && !e.hasVar(ast.raw)) {
+			v, err := e.literal(d.Type, ast)
+			if err != nil {
+				return nil, errors.Wrapf(err, "argument %s", d.Name)
+			}
+			args[d.Name] = v
+			continue
+		}
+		if d.Default != nil {
+			args[d.Name] = d.Default
+		} else if _, nn := d.Type.(*NonNull); nn {
+			return nil, errors.Errorf("argument %s is required", d.Name)
+		}
+	}
+	return args, nil
+}
+
+func (e *executor) hasVar(name string) bool {
+	_, ok := e.vars[name]
+	return ok
+}
+
+// literal coerces a value of the query to t.
+func (e *executor) literal(t Type, v *value) (interface{}, error) {
+	if v.kind == valueVariable {
+		val := e.vars[v.raw]
+		if _, nn := t.(*NonNull); nn && val == nil {
+			return nil, errors.Errorf("$%s can't be null", v.raw)
+		}
+		return val, nil
+	}
+
+	if nn, ok := t.(*NonNull); ok {
+		if v.kind == valueNull {
+			return nil, errors.New("can't be null")
+		}
+		return e.literal(nn.OfType, v)
+	}
+	if v.kind == valueNull {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		list := []interface{}{}
+		items := v.list
+		if v.kind != valueList {
+			items = []*value{v}
+		}
+		for _, item := range items {
+			x, err := e.literal(t.OfType, item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, x)
+		}
+		return list, nil
+	case *Input:
+		if v.kind != valueObject {
+			return nil, errors.Errorf("expected a %s object", t.TypeName)
+		}
+		m := map[string]interface{}{}
+		for _, f := range v.fields {
+			d := inputField(t, f.name)
+			if d == nil {
+				return nil, errors.Errorf("%s has no field %s", t.TypeName, f.name)
+			}
+			x, err := e.literal(d.Type, f.value)
+			if err != nil {
+				return nil, errors.Wrap(err, f.name)
+			}
+			m[f.name] = x
+		}
+		return inputDefaults(t, m)
+	case *Enum:
+		if v.kind != valueEnum || !contains(t.Values, v.raw) {
+			return nil, errors.Errorf("expected a %s", t.TypeName)
+		}
+		return v.raw, nil
+	case *Scalar:
+		var raw interface{}
+		switch v.kind {
+		case valueInt:
+			n, err := strconv.Atoi(v.raw)
+			if err != nil {
+				return nil, errors.Errorf("%s is not an Int", v.raw)
+			}
+			raw = n
+		case valueFloat:
+			f, err := strconv.ParseFloat(v.raw, 64)
+			if err != nil {
+				return nil, errors.Errorf("%s is not a Float", v.raw)
+			}
+			raw = f
+		case valueString:
+			raw = v.raw
+		case valueBoolean:
+			raw = v.raw == "true"
+		default:
+			return nil, errors.Errorf("expected a %s", t.TypeName)
+		}
+		return t.Parse(raw)
+	}
+	return nil, errors.Errorf("%s isn't an input type", t)
+}
+
+// coerce coerces a JSON variable to t.
+func coerce(t Type, v interface{}) (interface{}, error) {
+	if nn, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, errors.New("can't be null")
+		}
+		return coerce(nn.OfType, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v}
+		}
+		list := []interface{}{}
+		for _, item := range items {
+			x, err := coerce(t.OfType, item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, x)
+		}
+		return list, nil
+	case *Input:
+		in, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("expected a %s object", t.TypeName)
+		}
+		m := map[string]interface{}{}
+		for k, x := range in {
+			d := inputField(t, k)
+			if d == nil {
+				return nil, errors.Errorf("%s has no field %s", t.TypeName, k)
+			}
+			var err error
+			if m[k], err = coerce(d.Type, x); err != nil {
+				return nil, errors.Wrap(err, k)
+			}
+		}
+		return inputDefaults(t, m)
+	case *Enum:
+		s, ok := v.(string)
+		if !ok || !contains(t.Values, s) {
+			return nil, errors.Errorf("expected a %s", t.TypeName)
+		}
+		return s, nil
+	case *Scalar:
+		return t.Parse(v)
+	}
+	return nil, errors.Errorf("%s isn't an input type", t)
+}
+
+func inputField(t *Input, name string) *Argument {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func inputDefaults(t *Input, m map[string]interface{}) (map[string]interface{}, error) {
+	for _, f := range t.Fields {
+		if _, ok := m[f.Name]; ok {
+			continue
+		}
+		if f.Default != nil {
+			m[f.Name] = f.Default
+		} else if _, nn := f.Type.(*NonNull); nn {
+			return nil, errors.Errorf("%s.%s is required", t.TypeName, f.Name)
+		}
+	}
+	return m, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
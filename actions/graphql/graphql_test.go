@@ -0,0 +1,211 @@
+package graphql_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/graphql"
+	"github.com/stretchr/testify/require"
+)
+
+type author struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type book struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	AuthorID int    `json:"author_id"`
+}
+
+// library returns a schema over a few books and counts the author loads.
+func library(loads *int) *graphql.Schema {
+	authors := map[int]*author{1: {1, "Ursula"}, 2: {2, "Iain"}}
+	books := []*book{{1, "A Wizard of Earthsea", 1}, {2, "Consider Phlebas", 2}, {3, "Tehanu", 1}}
+
+	authorType := graphql.NewObject("Author", "").
+		AddField("id", &graphql.Field{Type: graphql.NonNullOf(graphql.ID)}).
+		AddField("name", &graphql.Field{Type: graphql.String})
+	bookType := graphql.NewObject("Book", "").
+		AddField("id", &graphql.Field{Type: graphql.NonNullOf(graphql.ID)}).
+		AddField("title", &graphql.Field{Type: graphql.String}).
+		AddField("author", &graphql.Field{
+			Type: authorType,
+			Batch: func(p graphql.Params, sources []interface{}) ([]interface{}, error) {
+				*loads++
+				out := make([]interface{}, len(sources))
+				for i, s := range sources {
+					out[i] = authors[s.(*book).AuthorID]
+				}
+				return out, nil
+			},
+		})
+	authorType.AddField("books", &graphql.Field{
+		Type: graphql.ListOf(bookType),
+		Resolve: func(p graphql.Params) (interface{}, error) {
+			list := []*book{}
+			for _, b := range books {
+				if b.AuthorID == p.Source.(*author).ID {
+					list = append(list, b)
+				}
+			}
+			return list, nil
+		},
+	})
+
+	query := graphql.NewObject("Query", "").
+		AddField("books", &graphql.Field{
+			Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(bookType))),
+			Args: []*graphql.Argument{{Name: "first", Type: graphql.Int, Default: 10}},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				n := p.Args["first"].(int)
+				if n > len(books) {
+					n = len(books)
+				}
+				return books[:n], nil
+			},
+		})
+	mutation := graphql.NewObject("Mutation", "").
+		AddField("addBook", &graphql.Field{
+			Type: bookType,
+			Args: []*graphql.Argument{{Name: "input", Type: graphql.NonNullOf(&graphql.Input{
+				TypeName: "BookInput",
+				Fields: []*graphql.Argument{
+					{Name: "title", Type: graphql.NonNullOf(graphql.String)},
+					{Name: "author_id", Type: graphql.Int, Default: 1},
+				},
+			})}},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				b := &book{ID: len(books) + 1}
+				if err := graphql.Assign(b, p.Args["input"].(map[string]interface{})); err != nil {
+					return nil, err
+				}
+				if b.Title == "" {
+					return nil, graphql.NewError("invalid book", map[string]interface{}{"errors": map[string][]string{"title": {"Title can not be blank."}}})
+				}
+				books = append(books, b)
+				return b, nil
+			},
+		})
+
+	s, err := graphql.NewSchema(query, mutation)
+	if err != nil {
+		panic(err)
+	}
+	s.MaxDepth = 4
+	s.MaxComplexity = 50
+	return s
+}
+
+func toJSON(t *testing.T, res *graphql.Response) string {
+	b, err := json.Marshal(res)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func Test_Execute(t *testing.T) {
+	r := require.New(t)
+	loads := 0
+	s := library(&loads)
+
+	res := s.Execute(nil, graphql.Request{
+		Query: `query Books($n: Int) {
+			list: books(first: $n) { ...bookFields author { name } }
+		}
+		fragment bookFields on Book { id, title }`,
+		Variables: map[string]interface{}{"n": 2},
+	})
+	r.Empty(res.Errors)
+	r.Equal(`{"data":{"list":[{"id":"1","title":"A Wizard of Earthsea","author":{"name":"Ursula"}},{"id":"2","title":"Consider Phlebas","author":{"name":"Iain"}}]}}`, toJSON(t, res))
+	r.Equal(1, loads, "the authors of all the books are loaded at once")
+
+	res = s.Execute(nil, graphql.Request{Query: `{ books { title @skip(if: true) __typename } }`})
+	r.Empty(res.Errors)
+	r.Contains(toJSON(t, res), `{"__typename":"Book"}`)
+}
+
+func Test_Execute_Mutation(t *testing.T) {
+	r := require.New(t)
+	s := library(new(int))
+
+	res := s.Execute(nil, graphql.Request{
+		Query:     `mutation ($in: BookInput!) { addBook(input: $in) { id title author { name } } }`,
+		Variables: map[string]interface{}{"in": map[string]interface{}{"title": "Excession", "author_id": 2}},
+	})
+	r.Empty(res.Errors)
+	r.Equal(`{"data":{"addBook":{"id":"4","title":"Excession","author":{"name":"Iain"}}}}`, toJSON(t, res))
+
+	res = s.Execute(nil, graphql.Request{Query: `mutation { addBook(input: {title: ""}) { id } }`})
+	r.Len(res.Errors, 1)
+	r.Equal("invalid book", res.Errors[0].Message)
+	r.Equal([]interface{}{"addBook"}, res.Errors[0].Path)
+	r.NotNil(res.Errors[0].Extensions["errors"])
+
+	res = s.Execute(nil, graphql.Request{Query: `mutation { addBook(input: {}) { id } }`})
+	r.Len(res.Errors, 1)
+	r.Contains(res.Errors[0].Message, "BookInput.title is required")
+}
+
+func Test_Execute_Limits(t *testing.T) {
+	r := require.New(t)
+	s := library(new(int))
+
+	res := s.Execute(nil, graphql.Request{Query: `{ books { author { books { author { books { id } } } } } }`})
+	r.Nil(res.Data)
+	r.Contains(res.Errors[0].Message, "too deep")
+
+	res = s.Execute(nil, graphql.Request{Query: `{ books(first: 30) { id title author { name } } }`})
+	r.Nil(res.Data)
+	r.Contains(res.Errors[0].Message, "too complex")
+
+	res = s.Execute(nil, graphql.Request{Query: `{ books { isbn } }`})
+	r.Nil(res.Data)
+	r.Contains(res.Errors[0].Message, "Book has no field isbn")
+
+	res = s.Execute(nil, graphql.Request{Query: `{ books { id }`})
+	r.Nil(res.Data)
+	r.Contains(res.Errors[0].Message, "syntax error")
+}
+
+func Test_Execute_Introspection(t *testing.T) {
+	r := require.New(t)
+	s := library(new(int))
+
+	res := s.Execute(nil, graphql.Request{Query: `{
+		__schema { queryType { name } mutationType { name } subscriptionType { name } }
+		__type(name: "Book") { kind fields { name type { kind name ofType { name } } } }
+	}`})
+	r.Empty(res.Errors)
+	out := toJSON(t, res)
+	r.Contains(out, `"queryType":{"name":"Query"},"mutationType":{"name":"Mutation"},"subscriptionType":null`)
+	r.Contains(out, `{"name":"id","type":{"kind":"NON_NULL","name":null,"ofType":{"name":"ID"}}}`)
+}
+
+func Test_Handler(t *testing.T) {
+	r := require.New(t)
+	a := buffalo.Automatic(buffalo.Options{})
+	h := graphql.Handler(library(new(int)))
+	a.GET("/graphql", h)
+	a.POST("/graphql", h)
+
+	res := httptest.NewRecorder()
+	a.ServeHTTP(res, httptest.NewRequest("GET", "/graphql?query=%7Bbooks(first%3A1)%7Btitle%7D%7D", nil))
+	r.Equal(200, res.Code)
+	r.Equal(`{"data":{"books":[{"title":"A Wizard of Earthsea"}]}}`+"\n", res.Body.String())
+
+	res = httptest.NewRecorder()
+	a.ServeHTTP(res, httptest.NewRequest("GET", "/graphql?query=mutation%7BaddBook(input%3A%7Btitle%3A%22x%22%7D)%7Bid%7D%7D", nil))
+	r.Equal(405, res.Code)
+
+	body, _ := json.Marshal(graphql.Request{Query: `mutation { addBook(input: {title: "x"}) { id } }`})
+	req := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res = httptest.NewRecorder()
+	a.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Contains(res.Body.String(), `"addBook":{"id":"4"}`)
+}
@@ -0,0 +1,127 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/problem"
+)
+
+// maxBody caps the size of request bodies.
+const maxBody = 1 << 20
+
+// Handler serves the schema. Queries may be sent with GET (?query=...),
+// mutations only with POST, either as JSON ({"query": ..., "variables":
+// ...}) or as an application/graphql body.
+func Handler(s *Schema) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		req, err := readRequest(c.Request())
+		if err != nil {
+			return err
+		}
+		if c.Request().Method == "GET" && isMutation(req) {
+			return c.Error(http.StatusMethodNotAllowed, fmt.Errorf("mutations must be POSTed"))
+		}
+
+		res := s.Execute(c, req)
+		status := http.StatusOK
+		if res.Data == nil {
+			status = http.StatusBadRequest
+		}
+		return c.Render(status, render.Func("application/json", func(w io.Writer, _ render.Data) error {
+			return json.NewEncoder(w).Encode(res)
+		}))
+	}
+}
+
+func readRequest(r *http.Request) (Request, error) {
+	req := Request{}
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return req, problem.BadRequest("variables isn't a JSON object")
+			}
+		}
+		return req, nil
+	}
+
+	body := http.MaxBytesReader(nil, r.Body, maxBody)
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == "application/graphql" {
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return req, problem.BadRequest("can't read the query: %s", err)
+		}
+		req.Query = string(b)
+		return req, nil
+	}
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		return req, problem.BadRequest("can't read the request: %s", err)
+	}
+	return req, nil
+}
+
+func isMutation(req Request) bool {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return false
+	}
+	for _, op := range doc.operations {
+		if op.kind == "mutation" && (req.OperationName == "" || req.OperationName == op.name) {
+			return true
+		}
+	}
+	return false
+}
+
+var graphiql = template.Must(template.New("graphiql").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <title>GraphiQL</title>
+  <link rel="stylesheet" href="https://unpkg.com/graphiql@0.11.11/graphiql.css" />
+  <style nonce="{{.Nonce}}">body { height: 100vh; margin: 0; } #graphiql { height: 100vh; }</style>
+</head>
+<body>
+  <div id="graphiql">Loading...</div>
+  <script nonce="{{.Nonce}}" src="https://unpkg.com/react@15.6.2/dist/react.min.js"></script>
+  <script nonce="{{.Nonce}}" src="https://unpkg.com/react-dom@15.6.2/dist/react-dom.min.js"></script>
+  <script nonce="{{.Nonce}}" src="https://unpkg.com/graphiql@0.11.11/graphiql.min.js"></script>
+  <script nonce="{{.Nonce}}">
+    function fetcher(params) {
+      return fetch({{.Endpoint}}, {
+        method: "POST",
+        credentials: "same-origin",
+        headers: {"Accept": "application/json", "Content-Type": "application/json"},
+        body: JSON.stringify(params)
+      }).then(function (res) { return res.json(); });
+    }
+    ReactDOM.render(React.createElement(GraphiQL, {fetcher: fetcher}), document.getElementById("graphiql"));
+  </script>
+</body>
+</html>
+`))
+
+// GraphiQL serves the GraphiQL IDE for endpoint. It loads its scripts
+// from unpkg.com, which the production CSP doesn't allow: it's meant for
+// development.
+func GraphiQL(endpoint string) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		nonce, _ := c.Value(mw.CSPNonceKey).(string)
+		return c.Render(200, render.Func("text/html", func(w io.Writer, _ render.Data) error {
+			return graphiql.Execute(w, map[string]string{
+				"Nonce":    nonce,
+				"Endpoint": endpoint,
+			})
+		}))
+	}
+}
@@ -0,0 +1,216 @@
+package graphql
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// The introspection types, enough for GraphiQL and code generators.
+var (
+	schemaType     = NewObject("__Schema", "")
+	typeType       = NewObject("__Type", "")
+	fieldType      = NewObject("__Field", "")
+	inputValueType = NewObject("__InputValue", "")
+	enumValueType  = NewObject("__EnumValue", "")
+	directiveType  = NewObject("__Directive", "")
+	typeKindEnum   = &Enum{TypeName: "__TypeKind", Values: []string{
+		string(KindScalar), string(KindObject), string(KindInputObject),
+		string(KindEnum), string(KindList), string(KindNonNull),
+	}}
+)
+
+type fieldInfo struct {
+	name string
+	*Field
+}
+
+type directiveInfo struct {
+	name        string
+	description string
+	args        []*Argument
+}
+
+var directives = []*directiveInfo{
+	{"include", "Includes the field when if is true.", []*Argument{{Name: "if", Type: NonNullOf(Boolean)}}},
+	{"skip", "Skips the field when if is true.", []*Argument{{Name: "if", Type: NonNullOf(Boolean)}}},
+}
+
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func resolver(fn func(src interface{}) interface{}) func(p Params) (interface{}, error) {
+	return func(p Params) (interface{}, error) {
+		return fn(p.Source), nil
+	}
+}
+
+func init() {
+	schemaType.
+		AddField("types", &Field{Type: NonNullOf(ListOf(NonNullOf(typeType))), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*Schema).Types()
+		})}).
+		AddField("queryType", &Field{Type: NonNullOf(typeType), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*Schema).Query
+		})}).
+		AddField("mutationType", &Field{Type: typeType, Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*Schema).Mutation
+		})}).
+		AddField("subscriptionType", &Field{Type: typeType, Resolve: resolver(func(src interface{}) interface{} {
+			return nil
+		})}).
+		AddField("directives", &Field{Type: NonNullOf(ListOf(NonNullOf(directiveType))), Resolve: resolver(func(src interface{}) interface{} {
+			return directives
+		})})
+
+	typeType.
+		AddField("kind", &Field{Type: NonNullOf(typeKindEnum), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(Type).Kind()
+		})}).
+		AddField("name", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			return optional(src.(Type).Name())
+		})}).
+		AddField("description", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			switch t := src.(type) {
+			case *Scalar:
+				return optional(t.Description)
+			case *Object:
+				return optional(t.Description)
+			case *Input:
+				return optional(t.Description)
+			case *Enum:
+				return optional(t.Description)
+			}
+			return nil
+		})}).
+		AddField("fields", &Field{
+			Type: ListOf(NonNullOf(fieldType)),
+			Args: []*Argument{{Name: "includeDeprecated", Type: Boolean, Default: false}},
+			Resolve: resolver(func(src interface{}) interface{} {
+				o, ok := src.(*Object)
+				if !ok {
+					return nil
+				}
+				names := []string{}
+				for n := range o.Fields {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				list := []*fieldInfo{}
+				for _, n := range names {
+					list = append(list, &fieldInfo{n, o.Fields[n]})
+				}
+				return list
+			}),
+		}).
+		AddField("interfaces", &Field{Type: ListOf(NonNullOf(typeType)), Resolve: resolver(func(src interface{}) interface{} {
+			if _, ok := src.(*Object); ok {
+				return []Type{}
+			}
+			return nil
+		})}).
+		AddField("possibleTypes", &Field{Type: ListOf(NonNullOf(typeType)), Resolve: resolver(func(src interface{}) interface{} {
+			return nil
+		})}).
+		AddField("enumValues", &Field{
+			Type: ListOf(NonNullOf(enumValueType)),
+			Args: []*Argument{{Name: "includeDeprecated", Type: Boolean, Default: false}},
+			Resolve: resolver(func(src interface{}) interface{} {
+				if e, ok := src.(*Enum); ok {
+					return e.Values
+				}
+				return nil
+			}),
+		}).
+		AddField("inputFields", &Field{Type: ListOf(NonNullOf(inputValueType)), Resolve: resolver(func(src interface{}) interface{} {
+			if i, ok := src.(*Input); ok {
+				return i.Fields
+			}
+			return nil
+		})}).
+		AddField("ofType", &Field{Type: typeType, Resolve: resolver(func(src interface{}) interface{} {
+			switch t := src.(type) {
+			case *List:
+				return t.OfType
+			case *NonNull:
+				return t.OfType
+			}
+			return nil
+		})})
+
+	fieldType.
+		AddField("name", &Field{Type: NonNullOf(String), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*fieldInfo).name
+		})}).
+		AddField("description", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			return optional(src.(*fieldInfo).Description)
+		})}).
+		AddField("args", &Field{Type: NonNullOf(ListOf(NonNullOf(inputValueType))), Resolve: resolver(func(src interface{}) interface{} {
+			if args := src.(*fieldInfo).Args; args != nil {
+				return args
+			}
+			return []*Argument{}
+		})}).
+		AddField("type", &Field{Type: NonNullOf(typeType), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*fieldInfo).Type
+		})}).
+		AddField("isDeprecated", &Field{Type: NonNullOf(Boolean), Resolve: resolver(func(src interface{}) interface{} {
+			return false
+		})}).
+		AddField("deprecationReason", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			return nil
+		})})
+
+	inputValueType.
+		AddField("name", &Field{Type: NonNullOf(String), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*Argument).Name
+		})}).
+		AddField("description", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			return optional(src.(*Argument).Description)
+		})}).
+		AddField("type", &Field{Type: NonNullOf(typeType), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*Argument).Type
+		})}).
+		AddField("defaultValue", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			a := src.(*Argument)
+			if a.Default == nil {
+				return nil
+			}
+			if _, ok := unwrap(a.Type).(*Enum); ok {
+				return a.Default
+			}
+			b, _ := json.Marshal(a.Default)
+			return string(b)
+		})})
+
+	enumValueType.
+		AddField("name", &Field{Type: NonNullOf(String), Resolve: resolver(func(src interface{}) interface{} {
+			return src
+		})}).
+		AddField("description", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			return nil
+		})}).
+		AddField("isDeprecated", &Field{Type: NonNullOf(Boolean), Resolve: resolver(func(src interface{}) interface{} {
+			return false
+		})}).
+		AddField("deprecationReason", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			return nil
+		})})
+
+	directiveType.
+		AddField("name", &Field{Type: NonNullOf(String), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*directiveInfo).name
+		})}).
+		AddField("description", &Field{Type: String, Resolve: resolver(func(src interface{}) interface{} {
+			return optional(src.(*directiveInfo).description)
+		})}).
+		AddField("locations", &Field{Type: NonNullOf(ListOf(NonNullOf(String))), Resolve: resolver(func(src interface{}) interface{} {
+			return []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}
+		})}).
+		AddField("args", &Field{Type: NonNullOf(ListOf(NonNullOf(inputValueType))), Resolve: resolver(func(src interface{}) interface{} {
+			return src.(*directiveInfo).args
+		})})
+}
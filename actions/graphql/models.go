@@ -0,0 +1,207 @@
+package graphql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/markbates/inflect"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// DefaultFirst is the page size of the model lists, MaxFirst caps it.
+var (
+	DefaultFirst = 20
+	MaxFirst     = 100
+)
+
+// Models derives the query type of a schema from pop models.
+//
+//	m := graphql.NewModels()
+//	users := m.Register(models.User{})
+//	users.AddField("role", &graphql.Field{Type: graphql.String, Batch: roles})
+//	schema, err := graphql.NewSchema(m.Query, mutation)
+//
+// Resolvers run their queries on the request's transaction, the "tx"
+// value set by the Transaction middleware.
+type Models struct {
+	Query *Object
+
+	objects map[reflect.Type]*Object
+}
+
+// NewModels returns a Models with an empty query type.
+func NewModels() *Models {
+	return &Models{
+		Query:   NewObject("Query", ""),
+		objects: map[reflect.Type]*Object{},
+	}
+}
+
+// Object returns the object type registered for the model's type.
+func (m *Models) Object(model interface{}) *Object {
+	return m.objects[structType(model)]
+}
+
+// Register adds the object type of model and two query fields: the
+// table name (e.g. users) lists the models, filtered on any of their
+// attributes and paginated with first and page, the singular (user)
+// finds one by id.
+func (m *Models) Register(model interface{}) *Object {
+	t := structType(model)
+	obj := NewObject(t.Name(), "")
+	filter := &Input{TypeName: t.Name() + "Filter"}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if f.PkgPath != "" || name == "" {
+			continue
+		}
+		st, ok := scalarOf(f.Type)
+		if !ok {
+			continue
+		}
+		if name == "id" {
+			st = ID
+		}
+		filter.Fields = append(filter.Fields, &Argument{Name: name, Type: st})
+		if st == String {
+			filter.Fields = append(filter.Fields, &Argument{
+				Name:        name + "_contains",
+				Description: "Case sensitive substring match.",
+				Type:        String,
+			})
+		}
+		var ft Type = st
+		if f.Type.Kind() != reflect.Ptr && !isNullable(f.Type) {
+			ft = NonNullOf(st)
+		}
+		obj.AddField(name, &Field{Type: ft})
+	}
+	m.objects[t] = obj
+
+	table := (&pop.Model{Value: reflect.New(t).Interface()}).TableName()
+	m.Query.AddField(table, &Field{
+		Description: fmt.Sprintf("Lists the %s.", table),
+		Type:        NonNullOf(ListOf(NonNullOf(obj))),
+		Args: []*Argument{
+			{Name: "where", Type: filter},
+			{Name: "first", Type: Int, Default: DefaultFirst},
+			{Name: "page", Type: Int, Default: 1},
+		},
+		Resolve: func(p Params) (interface{}, error) {
+			first, page := p.Args["first"].(int), p.Args["page"].(int)
+			if first < 1 || first > MaxFirst || page < 1 {
+				return nil, errors.Errorf("first must be between 1 and %d, page positive", MaxFirst)
+			}
+			q := pop.Q(tx(p))
+			if where, ok := p.Args["where"].(map[string]interface{}); ok {
+				for k, v := range where {
+					if v == nil {
+						continue
+					}
+					col := strings.TrimSuffix(k, "_contains")
+					col = columnOf(t, col)
+					if strings.HasSuffix(k, "_contains") {
+						q = q.Where(col+` like ? escape '\'`, "%"+escapeLike(fmt.Sprint(v))+"%")
+					} else {
+						q = q.Where(col+" = ?", v)
+					}
+				}
+			}
+			list := reflect.New(reflect.SliceOf(t))
+			err := q.Order("id").Paginate(page, first).All(list.Interface())
+			return list.Interface(), errors.WithStack(err)
+		},
+	})
+	m.Query.AddField(inflect.Singularize(table), &Field{
+		Description: fmt.Sprintf("Finds a %s by id.", inflect.Singularize(table)),
+		Type:        obj,
+		Args:        []*Argument{{Name: "id", Type: NonNullOf(ID)}},
+		Resolve: func(p Params) (interface{}, error) {
+			v := reflect.New(t).Interface()
+			if err := tx(p).Find(v, p.Args["id"]); err != nil {
+				if errors.Cause(err) == sql.ErrNoRows {
+					return nil, nil
+				}
+				return nil, errors.WithStack(err)
+			}
+			return v, nil
+		},
+	})
+	return obj
+}
+
+// Assign sets the fields of model from an input object, matching on
+// json names.
+func Assign(model interface{}, input map[string]interface{}) error {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(json.Unmarshal(b, model))
+}
+
+func tx(p Params) *pop.Connection {
+	return p.Context.Value("tx").(*pop.Connection)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func structType(model interface{}) reflect.Type {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// columnOf maps a json name to its db column.
+func columnOf(t reflect.Type, name string) string {
+	f, _ := jsonField(t, name)
+	if col := strings.Split(f.Tag.Get("db"), ",")[0]; col != "" {
+		return col
+	}
+	return inflect.Underscore(f.Name)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// scalarOf maps a Go type to a scalar.
+func scalarOf(t reflect.Type) (*Scalar, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return String, true
+	case t.PkgPath() == "github.com/satori/go.uuid" && t.Name() == "UUID":
+		return ID, true
+	case isNullable(t):
+		return scalarOf(t.Field(0).Type)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return Boolean, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Int, true
+	case reflect.Float32, reflect.Float64:
+		return Float, true
+	case reflect.String:
+		return String, true
+	}
+	return nil, false
+}
+
+// isNullable tells pop's nulls types.
+func isNullable(t reflect.Type) bool {
+	return strings.HasSuffix(t.PkgPath(), "markbates/pop/nulls") && t.Kind() == reflect.Struct && t.NumField() > 0
+}
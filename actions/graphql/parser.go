@@ -0,0 +1,495 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The AST of a query document. Only what the executor needs is kept.
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind      string // query or mutation
+	name      string
+	variables []*variableDef
+	selection []selection
+}
+
+type variableDef struct {
+	name string
+	typ  *typeRef
+	def  *value
+}
+
+type typeRef struct {
+	name    string
+	list    *typeRef
+	nonNull bool
+}
+
+type fragment struct {
+	name      string
+	on        string
+	selection []selection
+}
+
+type directive struct {
+	name string
+	args []*argument
+}
+
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	selection  []selection
+	line       int
+}
+
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+}
+
+type inlineFragment struct {
+	on         string
+	directives []*directive
+	selection  []selection
+}
+
+type argument struct {
+	name  string
+	value *value
+}
+
+type valueKind int
+
+const (
+	valueVariable valueKind = iota
+	valueInt
+	valueFloat
+	valueString
+	valueBoolean
+	valueNull
+	valueEnum
+	valueList
+	valueObject
+)
+
+type value struct {
+	kind   valueKind
+	raw    string // name, number or unquoted string
+	list   []*value
+	fields []*argument
+}
+
+// SyntaxError is returned for malformed queries.
+type SyntaxError struct {
+	Line    int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error on line %d: %s", e.Line, e.Message)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	tok  token
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) {
+	panic(&SyntaxError{Line: l.tok.line, Message: fmt.Sprintf(format, args...)})
+}
+
+// next reads the next token into l.tok.
+func (l *lexer) next() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			l.scan()
+			return
+		}
+	}
+	l.tok = token{kind: tokEOF, line: l.line}
+}
+
+func (l *lexer) scan() {
+	start := l.pos
+	c := l.src[l.pos]
+	l.tok = token{line: l.line}
+	switch {
+	case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+		l.pos++
+		l.tok.kind, l.tok.text = tokPunct, string(c)
+	case c == '.':
+		if !strings.HasPrefix(l.src[l.pos:], "...") {
+			l.errorf("unexpected .")
+		}
+		l.pos += 3
+		l.tok.kind, l.tok.text = tokPunct, "..."
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		l.tok.kind, l.tok.text = tokName, l.src[start:l.pos]
+	case c == '-' || isDigit(c):
+		l.tok.kind = tokInt
+		l.pos++
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			if c == '.' || c == 'e' || c == 'E' || ((c == '+' || c == '-') && l.tok.kind == tokFloat) {
+				l.tok.kind = tokFloat
+			} else if !isDigit(c) {
+				break
+			}
+			l.pos++
+		}
+		l.tok.text = l.src[start:l.pos]
+	case c == '"':
+		l.tok.kind, l.tok.text = tokString, l.str()
+	default:
+		l.errorf("unexpected character %q", c)
+	}
+}
+
+// str reads a string, plain or block.
+func (l *lexer) str() string {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+		if end < 0 {
+			l.errorf("unterminated string")
+		}
+		s := l.src[l.pos+3 : l.pos+3+end]
+		l.line += strings.Count(s, "\n")
+		l.pos += end + 6
+		return strings.TrimSpace(s)
+	}
+
+	l.pos++
+	b := &strings.Builder{}
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			l.errorf("unterminated string")
+		}
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return b.String()
+		case '\\':
+			if l.pos+1 >= len(l.src) {
+				l.errorf("unterminated string")
+			}
+			esc := l.src[l.pos+1]
+			l.pos += 2
+			switch esc {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					l.errorf("bad unicode escape")
+				}
+				r, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					l.errorf("bad unicode escape")
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				b.WriteByte(esc)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type parser struct {
+	*lexer
+}
+
+// parse reads a query document.
+func parse(src string) (doc *document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+
+	p := &parser{&lexer{src: src, line: 1}}
+	p.next()
+	doc = &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", selection: p.selectionSet()})
+		case p.peekName("query"), p.peekName("mutation"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.peekName("fragment"):
+			f := p.fragment()
+			doc.fragments[f.name] = f
+		default:
+			p.errorf("unexpected %q", p.tok.text)
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.errorf("no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == punct
+}
+
+func (p *parser) peekName(name string) bool {
+	return p.tok.kind == tokName && p.tok.text == name
+}
+
+func (p *parser) skip(punct string) bool {
+	if p.peek(punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) {
+	if !p.skip(punct) {
+		p.errorf("expected %q, got %q", punct, p.tok.text)
+	}
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokName {
+		p.errorf("expected a name, got %q", p.tok.text)
+	}
+	n := p.tok.text
+	p.next()
+	return n
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.name()}
+	if p.tok.kind == tokName {
+		op.name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+			v := &variableDef{name: p.name()}
+			p.expect(":")
+			v.typ = p.typeRef()
+			if p.skip("=") {
+				v.def = p.value(true)
+			}
+			op.variables = append(op.variables, v)
+		}
+	}
+	p.directives()
+	op.selection = p.selectionSet()
+	return op
+}
+
+func (p *parser) typeRef() *typeRef {
+	t := &typeRef{}
+	if p.skip("[") {
+		t.list = p.typeRef()
+		p.expect("]")
+	} else {
+		t.name = p.name()
+	}
+	t.nonNull = p.skip("!")
+	return t
+}
+
+func (p *parser) fragment() *fragment {
+	p.next()
+	f := &fragment{name: p.name()}
+	if !p.peekName("on") {
+		p.errorf("expected on")
+	}
+	p.next()
+	f.on = p.name()
+	p.directives()
+	f.selection = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+	list := []selection{}
+	for !p.skip("}") {
+		if p.tok.kind == tokEOF {
+			p.errorf("unexpected end of query")
+		}
+		list = append(list, p.selection())
+	}
+	return list
+}
+
+func (p *parser) selection() selection {
+	if p.skip("...") {
+		if p.peekName("on") || p.peek("{") || p.peek("@") {
+			in := &inlineFragment{}
+			if p.peekName("on") {
+				p.next()
+				in.on = p.name()
+			}
+			in.directives = p.directives()
+			in.selection = p.selectionSet()
+			return in
+		}
+		return &fragmentSpread{name: p.name(), directives: p.directives()}
+	}
+
+	f := &field{line: p.tok.line, name: p.name()}
+	if p.skip(":") {
+		f.alias, f.name = f.name, p.name()
+	}
+	f.args = p.arguments(false)
+	f.directives = p.directives()
+	if p.peek("{") {
+		f.selection = p.selectionSet()
+	}
+	return f
+}
+
+func (p *parser) arguments(constant bool) []*argument {
+	list := []*argument{}
+	if !p.skip("(") {
+		return list
+	}
+	for !p.skip(")") {
+		a := &argument{name: p.name()}
+		p.expect(":")
+		a.value = p.value(constant)
+		list = append(list, a)
+	}
+	return list
+}
+
+func (p *parser) directives() []*directive {
+	list := []*directive{}
+	for p.skip("@") {
+		list = append(list, &directive{name: p.name(), args: p.arguments(false)})
+	}
+	return list
+}
+
+func (p *parser) value(constant bool) *value {
+	t := p.tok
+	switch t.kind {
+	case tokInt:
+		p.next()
+		return &value{kind: valueInt, raw: t.text}
+	case tokFloat:
+		p.next()
+		return &value{kind: valueFloat, raw: t.text}
+	case tokString:
+		p.next()
+		return &value{kind: valueString, raw: t.text}
+	case tokName:
+		p.next()
+		switch t.text {
+		case "true", "false":
+			return &value{kind: valueBoolean, raw: t.text}
+		case "null":
+			return &value{kind: valueNull}
+		}
+		return &value{kind: valueEnum, raw: t.text}
+	}
+
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.errorf("unexpected variable")
+		}
+		return &value{kind: valueVariable, raw: p.name()}
+	case p.skip("["):
+		v := &value{kind: valueList}
+		for !p.skip("]") {
+			v.list = append(v.list, p.value(constant))
+		}
+		return v
+	case p.skip("{"):
+		v := &value{kind: valueObject}
+		for !p.skip("}") {
+			a := &argument{name: p.name()}
+			p.expect(":")
+			a.value = p.value(constant)
+			v.fields = append(v.fields, a)
+		}
+		return v
+	}
+	p.errorf("unexpected %q", t.text)
+	return nil
+}
@@ -0,0 +1,344 @@
+// Package graphql is a small GraphQL server: a schema built in Go, a
+// parser for the query language and an executor that resolves a level of
+// the query at a time, so fields can load the data of all their parents
+// in one go (see Field.Batch) instead of once per parent.
+//
+// Subscriptions, interfaces and unions aren't supported.
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// Kind of a type, as reported by introspection.
+type Kind string
+
+// Kinds of types.
+const (
+	KindScalar      Kind = "SCALAR"
+	KindObject      Kind = "OBJECT"
+	KindInputObject Kind = "INPUT_OBJECT"
+	KindEnum        Kind = "ENUM"
+	KindList        Kind = "LIST"
+	KindNonNull     Kind = "NON_NULL"
+)
+
+// Type is a GraphQL type.
+type Type interface {
+	Kind() Kind
+	// Name is empty for lists and non null types.
+	Name() string
+	String() string
+}
+
+// Scalar is a leaf type.
+type Scalar struct {
+	TypeName    string
+	Description string
+	// Serialize turns a resolved Go value into its JSON value.
+	Serialize func(v interface{}) (interface{}, error)
+	// Parse turns an input (a literal or a variable) into a Go value.
+	Parse func(v interface{}) (interface{}, error)
+}
+
+// Enum is a leaf type with a fixed set of string values.
+type Enum struct {
+	TypeName    string
+	Description string
+	Values      []string
+}
+
+// List wraps a type in a list.
+type List struct {
+	OfType Type
+}
+
+// NonNull marks a type as required.
+type NonNull struct {
+	OfType Type
+}
+
+// ListOf is a shortcut for &List{t}.
+func ListOf(t Type) *List {
+	return &List{OfType: t}
+}
+
+// NonNullOf is a shortcut for &NonNull{t}.
+func NonNullOf(t Type) *NonNull {
+	return &NonNull{OfType: t}
+}
+
+// Object is an output type made of fields.
+type Object struct {
+	TypeName    string
+	Description string
+	Fields      map[string]*Field
+}
+
+// NewObject returns an object type without fields.
+func NewObject(name, description string) *Object {
+	return &Object{TypeName: name, Description: description, Fields: map[string]*Field{}}
+}
+
+// AddField adds (or replaces) a field and returns the object.
+func (o *Object) AddField(name string, f *Field) *Object {
+	o.Fields[name] = f
+	return o
+}
+
+// Input is an input object type, used for complex arguments.
+type Input struct {
+	TypeName    string
+	Description string
+	Fields      []*Argument
+}
+
+func (s *Scalar) Kind() Kind      { return KindScalar }
+func (s *Scalar) Name() string    { return s.TypeName }
+func (s *Scalar) String() string  { return s.TypeName }
+func (e *Enum) Kind() Kind        { return KindEnum }
+func (e *Enum) Name() string      { return e.TypeName }
+func (e *Enum) String() string    { return e.TypeName }
+func (o *Object) Kind() Kind      { return KindObject }
+func (o *Object) Name() string    { return o.TypeName }
+func (o *Object) String() string  { return o.TypeName }
+func (i *Input) Kind() Kind       { return KindInputObject }
+func (i *Input) Name() string     { return i.TypeName }
+func (i *Input) String() string   { return i.TypeName }
+func (l *List) Kind() Kind        { return KindList }
+func (l *List) Name() string      { return "" }
+func (l *List) String() string    { return "[" + l.OfType.String() + "]" }
+func (n *NonNull) Kind() Kind     { return KindNonNull }
+func (n *NonNull) Name() string   { return "" }
+func (n *NonNull) String() string { return n.OfType.String() + "!" }
+
+// Params are handed to resolvers.
+type Params struct {
+	Context buffalo.Context
+	// Source is the parent value, nil for the root fields.
+	Source interface{}
+	// Args are the coerced arguments of the field, defaults included.
+	Args map[string]interface{}
+}
+
+// Field of an object type.
+type Field struct {
+	Description string
+	Type        Type
+	Args        []*Argument
+	// Resolve returns the value of the field for Params.Source. When
+	// neither Resolve nor Batch are set the value is read from Source:
+	// a map key or a struct field with the same json name.
+	Resolve func(p Params) (interface{}, error)
+	// Batch returns the values of the field for all the sources of a
+	// level of the query at once, in the same order.
+	Batch func(p Params, sources []interface{}) ([]interface{}, error)
+	// Cost of the field towards the complexity limit, 1 when zero. The
+	// cost of list fields with a "first" argument is multiplied by it.
+	Cost int
+}
+
+// Argument of a field, or field of an input object.
+type Argument struct {
+	Name        string
+	Description string
+	Type        Type
+	// Default is used when the argument isn't given.
+	Default interface{}
+}
+
+// Schema is the entry point of the API.
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+	// MaxDepth of a query, unlimited when zero.
+	MaxDepth int
+	// MaxComplexity of a query, unlimited when zero. See Field.Cost.
+	MaxComplexity int
+
+	types map[string]Type
+}
+
+// NewSchema checks the schema and collects its types for introspection.
+func NewSchema(query, mutation *Object) (*Schema, error) {
+	s := &Schema{Query: query, Mutation: mutation, types: map[string]Type{}}
+	for _, t := range []Type{Int, Float, String, Boolean, ID} {
+		s.types[t.Name()] = t
+	}
+	if err := s.collect(query); err != nil {
+		return nil, err
+	}
+	if mutation != nil {
+		if err := s.collect(mutation); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.collect(schemaType); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) collect(t Type) error {
+	t = unwrap(t)
+	if old, ok := s.types[t.Name()]; ok {
+		if old != t {
+			return errors.Errorf("graphql: two types named %s", t.Name())
+		}
+		return nil
+	}
+	s.types[t.Name()] = t
+	switch t := t.(type) {
+	case *Object:
+		for name, f := range t.Fields {
+			if f.Type == nil {
+				return errors.Errorf("graphql: %s.%s has no type", t.TypeName, name)
+			}
+			if err := s.collect(f.Type); err != nil {
+				return err
+			}
+			for _, a := range f.Args {
+				if err := s.collect(a.Type); err != nil {
+					return err
+				}
+			}
+		}
+	case *Input:
+		for _, a := range t.Fields {
+			if err := s.collect(a.Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Types returns the named types of the schema, sorted by name.
+func (s *Schema) Types() []Type {
+	names := make([]string, 0, len(s.types))
+	for n := range s.types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	list := make([]Type, 0, len(names))
+	for _, n := range names {
+		list = append(list, s.types[n])
+	}
+	return list
+}
+
+// unwrap strips the list and non null wrappers of t.
+func unwrap(t Type) Type {
+	for {
+		switch w := t.(type) {
+		case *List:
+			t = w.OfType
+		case *NonNull:
+			t = w.OfType
+		default:
+			return t
+		}
+	}
+}
+
+// The built-in scalars.
+var (
+	Int = &Scalar{
+		TypeName: "Int",
+		Serialize: func(v interface{}) (interface{}, error) {
+			switch n := v.(type) {
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+				return n, nil
+			}
+			return nil, errors.Errorf("%v is not an Int", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			switch n := v.(type) {
+			case int:
+				return n, nil
+			case float64:
+				if n == float64(int(n)) {
+					return int(n), nil
+				}
+			}
+			return nil, errors.Errorf("%v is not an Int", v)
+		},
+	}
+	Float = &Scalar{
+		TypeName: "Float",
+		Serialize: func(v interface{}) (interface{}, error) {
+			switch n := v.(type) {
+			case float32, float64, int, int64:
+				return n, nil
+			}
+			return nil, errors.Errorf("%v is not a Float", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			switch n := v.(type) {
+			case int:
+				return float64(n), nil
+			case float64:
+				return n, nil
+			}
+			return nil, errors.Errorf("%v is not a Float", v)
+		},
+	}
+	String = &Scalar{
+		TypeName: "String",
+		Serialize: func(v interface{}) (interface{}, error) {
+			switch s := v.(type) {
+			case string:
+				return s, nil
+			case time.Time:
+				return s.Format(time.RFC3339), nil
+			case fmt.Stringer:
+				return s.String(), nil
+			}
+			return nil, errors.Errorf("%v is not a String", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, errors.Errorf("%v is not a String", v)
+		},
+	}
+	Boolean = &Scalar{
+		TypeName: "Boolean",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, errors.Errorf("%v is not a Boolean", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, errors.Errorf("%v is not a Boolean", v)
+		},
+	}
+	ID = &Scalar{
+		TypeName: "ID",
+		Serialize: func(v interface{}) (interface{}, error) {
+			return fmt.Sprint(v), nil
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			switch id := v.(type) {
+			case string:
+				return id, nil
+			case int:
+				return strconv.Itoa(id), nil
+			case float64:
+				return strconv.FormatFloat(id, 'f', -1, 64), nil
+			}
+			return nil, errors.Errorf("%v is not an ID", v)
+		},
+	}
+)
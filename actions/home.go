@@ -5,5 +5,9 @@ import "github.com/gobuffalo/buffalo"
 // HomeHandler is a default handler to serve up
 // a home page.
 func HomeHandler(c buffalo.Context) error {
+	// the activity carries the emails of the users, it's for the admins
+	if u := currentUser(c); u != nil && u.Role == adminRole {
+		c.Set("activity", true)
+	}
 	return c.Render(200, r.HTML("index.html"))
 }
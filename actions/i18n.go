@@ -0,0 +1,126 @@
+package actions
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	rice "github.com/GeertJohan/go.rice"
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/auth"
+	"github.com/leonids/test-buffalo/actions/i18n"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+)
+
+// DynamicKeys are the prefixes of the translation keys built at runtime,
+// see localized.
+var DynamicKeys = []string{"validation.", "fields.", "problems.", "admin.users.done.", "admin.flags.done."}
+
+// translator picks the locale of a request: the one of the signed in
+// user, the locale cookie, then Accept-Language.
+var translator = func() *i18n.Translator {
+	t := i18n.NewTranslator(loadLocales())
+	t.Prefer = preferredLocale
+	return t
+}()
+
+// loadLocales reads the translations of locales/, English is the
+// default.
+func loadLocales() *i18n.Bundle {
+	b := i18n.NewBundle("en")
+	box := rice.MustFindBox("../locales")
+	err := box.Walk("", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return b.Parse(info.Name(), box.MustBytes(path))
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
+// translate translates key to the locale of the request.
+func translate(c buffalo.Context, key string, data map[string]interface{}) string {
+	return translator.T(c, key, data)
+}
+
+// preferredLocale is the locale the signed in user picked.
+func preferredLocale(c buffalo.Context) string {
+	if ab == nil {
+		return ""
+	}
+	u, err := ab.CurrentUser(c.Response(), c.Request())
+	if err != nil {
+		return ""
+	}
+	if u, ok := u.(*store.User); ok {
+		return u.Locale
+	}
+	return ""
+}
+
+// LocaleHandler switches the locale: it goes in a cookie, and in the
+// preferences of the signed in user. Back to the page it came from.
+func LocaleHandler(c buffalo.Context) error {
+	locale := i18n.Normalize(c.Param("locale"))
+	if translator.Bundle.Match(locale) != locale {
+		return problem.BadRequest("unknown locale %q", c.Param("locale"))
+	}
+	translator.SetCookie(c, locale)
+	if ab != nil {
+		if u, err := ab.CurrentUser(c.Response(), c.Request()); err == nil {
+			if u, ok := u.(*store.User); ok {
+				authStore.SetLocale(u.Email, locale)
+			}
+		}
+	}
+	// only to a page of the app
+	back := "/"
+	if u, err := url.Parse(c.Request().Referer()); err == nil && strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(u.Path, "//") {
+		back = u.Path
+		if u.RawQuery != "" {
+			back += "?" + u.RawQuery
+		}
+	}
+	return c.Redirect(303, "%s", back)
+}
+
+// localeForm gives the locale form of the layout, on every page, the
+// CSRF token it posts back.
+func localeForm(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		if c.Request().Method == "GET" {
+			if _, err := mw.CSRFToken(c); err != nil {
+				return err
+			}
+		}
+		return next(c)
+	}
+}
+
+func initLocaleRoutes(app *buffalo.App) {
+	api.Document(app.POST("/locale", mw.CSRF(LocaleHandler)), openapi.Operation{
+		Summary: "Switch the locale of the pages",
+		Query:   []openapi.Parameter{{Name: "locale", Required: true}},
+		Status:  303,
+	})
+}
+
+// localized returns a copy of p in locale: the title, and the messages
+// of the invalid fields.
+func localized(locale string, p *problem.Error) *problem.Error {
+	lp := *p
+	if key := "problems." + strconv.Itoa(p.Status); translator.Bundle.Has(locale, key) {
+		lp.Title = translator.Bundle.Translate(locale, key, nil)
+	}
+	if p.Errors != nil {
+		lp.Errors = translator.Bundle.Errors(locale, p.Errors)
+	}
+	return &lp
+}
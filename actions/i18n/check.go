@@ -0,0 +1,102 @@
+package i18n
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Usage is the files using each key.
+type Usage map[string][]string
+
+// uses find the keys of the t helper in the templates, and of the
+// translate(c, key, data) calls in the Go code, the literal keys only.
+var uses = map[string]*regexp.Regexp{
+	".html": regexp.MustCompile(`\{\{\s*t\s+"([^"]+)"`),
+	".go":   regexp.MustCompile(`\btranslate\(\w+, "([^"]+)"`),
+}
+
+// Scan returns the keys used in the files under dirs.
+func Scan(dirs ...string) (Usage, error) {
+	used := Usage{}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			expr, ok := uses[filepath.Ext(path)]
+			if !ok || strings.HasSuffix(path, "_test.go") {
+				return nil
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			for _, m := range expr.FindAllSubmatch(b, -1) {
+				key := string(m[1])
+				used[key] = append(used[key], path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return used, nil
+}
+
+// Report is what Check found.
+type Report struct {
+	// Missing are the keys each locale lacks: the ones used, and the
+	// ones of the default locale.
+	Missing map[string][]string
+	// Unused are the keys no file uses.
+	Unused []string
+}
+
+// Check compares the keys of b to the used ones. The keys starting with
+// one of dynamic are built at runtime, they count as used.
+func Check(b *Bundle, used Usage, dynamic ...string) Report {
+	isDynamic := func(key string) bool {
+		for _, p := range dynamic {
+			if strings.HasPrefix(key, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	wanted := map[string]bool{}
+	for key := range used {
+		wanted[key] = true
+	}
+	for _, key := range b.Keys(b.Default) {
+		wanted[key] = true
+	}
+
+	r := Report{Missing: map[string][]string{}}
+	unused := map[string]bool{}
+	for _, l := range b.Locales() {
+		for key := range wanted {
+			if _, ok := b.messages[l][key]; !ok {
+				r.Missing[l] = append(r.Missing[l], key)
+			}
+		}
+		sort.Strings(r.Missing[l])
+		for key := range b.messages[l] {
+			if _, ok := used[key]; !ok && !isDynamic(key) {
+				unused[key] = true
+			}
+		}
+	}
+	for key := range unused {
+		r.Unused = append(r.Unused, key)
+	}
+	sort.Strings(r.Unused)
+	return r
+}
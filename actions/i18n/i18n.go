@@ -0,0 +1,285 @@
+// Package i18n translates the strings of the app. A Bundle holds the
+// translations of every locale, loaded from YAML or JSON files named
+// after their locale (en.yaml, validation.de.json, ...):
+//
+//	users:
+//	  title: Users
+//	  count:
+//	    zero: No users
+//	    one: "{count} user"
+//	    other: "{count} users"
+//
+// Nested keys are joined with dots (users.count), the maps of plural
+// forms pick their form by the count with the rules of the language,
+// and {name} is replaced with the value of name.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Message is a translation, with a form per plural category when it
+// depends on a count, or a single "other" form.
+type Message map[string]string
+
+// Bundle is the translations of the app.
+type Bundle struct {
+	// Default is the locale used when no other matches, and the one the
+	// missing translations come from.
+	Default  string
+	messages map[string]map[string]Message
+}
+
+// NewBundle returns an empty bundle falling back to locale def.
+func NewBundle(def string) *Bundle {
+	return &Bundle{Default: Normalize(def), messages: map[string]map[string]Message{}}
+}
+
+// Normalize lowercases a locale and uses dashes: en_GB is en-gb.
+func Normalize(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// base is the language of a locale, de for de-at.
+func base(locale string) string {
+	if i := strings.Index(locale, "-"); i > 0 {
+		return locale[:i]
+	}
+	return locale
+}
+
+// LoadDir adds the translation files of dir.
+func (b *Bundle) LoadDir(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return b.Parse(info.Name(), data)
+	})
+}
+
+// Parse adds the translations of a file, name tells the locale and the
+// format: de.yaml, users.de.yaml, de.json.
+func (b *Bundle) Parse(name string, data []byte) error {
+	ext := filepath.Ext(name)
+	parts := strings.Split(strings.TrimSuffix(name, ext), ".")
+	locale := Normalize(parts[len(parts)-1])
+
+	var tree interface{}
+	var err error
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".json":
+		err = json.Unmarshal(data, &tree)
+	default:
+		return errors.Errorf("i18n: %s is neither YAML nor JSON", name)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "i18n: can't read %s", name)
+	}
+	messages, ok := b.messages[locale]
+	if !ok {
+		messages = map[string]Message{}
+		b.messages[locale] = messages
+	}
+	return errors.Wrapf(flatten("", tree, messages), "i18n: can't read %s", name)
+}
+
+// flatten adds the messages of tree under prefix.
+func flatten(prefix string, tree interface{}, messages map[string]Message) error {
+	switch v := tree.(type) {
+	case nil:
+		return nil
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			m[fmt.Sprint(k)] = val
+		}
+		return flatten(prefix, m, messages)
+	case map[string]interface{}:
+		if prefix != "" && isPlural(v) {
+			msg := Message{}
+			for form, s := range v {
+				msg[form] = fmt.Sprint(s)
+			}
+			messages[prefix] = msg
+			return nil
+		}
+		for k, val := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			if err := flatten(key, val, messages); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		return errors.Errorf("%s is a list", prefix)
+	default:
+		if prefix == "" {
+			return errors.New("not a map of translations")
+		}
+		messages[prefix] = Message{"other": fmt.Sprint(v)}
+		return nil
+	}
+}
+
+// isPlural tells the maps of plural forms from the ones of nested keys:
+// they have an other form, and only plural forms.
+func isPlural(m map[string]interface{}) bool {
+	if _, ok := m["other"]; !ok {
+		return false
+	}
+	for k, v := range m {
+		if !Categories[k] {
+			return false
+		}
+		if _, ok := v.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Locales returns the locales of the bundle, sorted.
+func (b *Bundle) Locales() []string {
+	list := []string{}
+	for l := range b.messages {
+		list = append(list, l)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Keys returns the keys translated in locale, sorted.
+func (b *Bundle) Keys(locale string) []string {
+	list := []string{}
+	for k := range b.messages[Normalize(locale)] {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Match returns the first of the locales the bundle has, by exact
+// match or by language (de for de-at), or an empty string.
+func (b *Bundle) Match(locales ...string) string {
+	for _, l := range locales {
+		l = Normalize(l)
+		if _, ok := b.messages[l]; ok {
+			return l
+		}
+		if _, ok := b.messages[base(l)]; ok {
+			return base(l)
+		}
+	}
+	return ""
+}
+
+// lookup finds key in locale, its language, then the default locale.
+func (b *Bundle) lookup(locale, key string) (Message, string, bool) {
+	locale = Normalize(locale)
+	for _, l := range []string{locale, base(locale), b.Default} {
+		if msg, ok := b.messages[l][key]; ok {
+			return msg, l, true
+		}
+	}
+	return nil, "", false
+}
+
+// Has tells if key has a translation for locale, the default one
+// included.
+func (b *Bundle) Has(locale, key string) bool {
+	_, _, ok := b.lookup(locale, key)
+	return ok
+}
+
+// Translate returns the translation of key in locale with data filled
+// in. The count of data picks the plural form. A missing key comes back
+// as is, so it shows on the page.
+func (b *Bundle) Translate(locale, key string, data map[string]interface{}) string {
+	msg, l, ok := b.lookup(locale, key)
+	if !ok {
+		return key
+	}
+	s, ok := msg["other"]
+	if count, has := data["count"]; has {
+		if n, err := toInt(count); err == nil {
+			s, ok = msg.form(Plural(l, n), n)
+		}
+	}
+	if !ok {
+		for _, f := range msg {
+			s = f
+			break
+		}
+	}
+	return interpolate(s, data)
+}
+
+// form returns the form of category, zero for a count of 0 when there's
+// one, then other.
+func (m Message) form(category string, n int) (string, bool) {
+	if n == 0 {
+		if s, ok := m["zero"]; ok {
+			return s, true
+		}
+	}
+	if s, ok := m[category]; ok {
+		return s, true
+	}
+	s, ok := m["other"]
+	return s, ok
+}
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+func interpolate(s string, data map[string]interface{}) string {
+	if len(data) == 0 {
+		return s
+	}
+	return placeholder.ReplaceAllStringFunc(s, func(p string) string {
+		v, ok := data[p[1:len(p)-1]]
+		if !ok || v == nil {
+			return p
+		}
+		return fmt.Sprint(v)
+	})
+}
+
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	case string:
+		return strconv.Atoi(n)
+	}
+	return strconv.Atoi(fmt.Sprint(v))
+}
@@ -0,0 +1,216 @@
+package i18n_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/gobuffalo/velvet"
+	"github.com/leonids/test-buffalo/actions/i18n"
+	"github.com/stretchr/testify/require"
+)
+
+const en = `
+users:
+  title: Users
+  hello: Hello, {name}!
+  count:
+    zero: No users
+    one: "{count} user"
+    other: "{count} users"
+fields:
+  email: Email
+validation:
+  blank: "{field} can not be blank."
+  inclusion: "{field} is not in the list [{list}]."
+`
+
+const lv = `
+users:
+  title: Lietotāji
+  count:
+    zero: "{count} lietotāju"
+    one: "{count} lietotājs"
+    other: "{count} lietotāji"
+fields:
+  email: E-pasts
+validation:
+  blank: "{field} nedrīkst būt tukšs."
+`
+
+func bundle(t *testing.T) *i18n.Bundle {
+	b := i18n.NewBundle("en")
+	require.NoError(t, b.Parse("en.yaml", []byte(en)))
+	require.NoError(t, b.Parse("lv.yaml", []byte(lv)))
+	require.NoError(t, b.Parse("users.de.json", []byte(`{"users": {"title": "Benutzer", "count": {"one": "{count} Benutzer", "other": "{count} Benutzer"}}}`)))
+	return b
+}
+
+func Test_Translate(t *testing.T) {
+	r := require.New(t)
+	b := bundle(t)
+	r.Equal([]string{"de", "en", "lv"}, b.Locales())
+
+	r.Equal("Users", b.Translate("en", "users.title", nil))
+	r.Equal("Lietotāji", b.Translate("lv", "users.title", nil))
+	r.Equal("Benutzer", b.Translate("de-AT", "users.title", nil))
+	// from the default locale
+	r.Equal("Hello, Mark!", b.Translate("lv", "users.hello", map[string]interface{}{"name": "Mark"}))
+	r.Equal("Hello, {name}!", b.Translate("lv", "users.hello", nil))
+	r.Equal("users.nope", b.Translate("en", "users.nope", nil))
+	r.True(b.Has("lv", "users.hello"))
+	r.False(b.Has("lv", "users.nope"))
+
+	for n, want := range map[int]string{0: "No users", 1: "1 user", 2: "2 users"} {
+		r.Equal(want, b.Translate("en", "users.count", map[string]interface{}{"count": n}))
+	}
+	for n, want := range map[int]string{0: "0 lietotāju", 1: "1 lietotājs", 11: "11 lietotāju", 21: "21 lietotājs", 22: "22 lietotāji"} {
+		r.Equal(want, b.Translate("lv", "users.count", map[string]interface{}{"count": n}))
+	}
+	r.Equal("0 Benutzer", b.Translate("de", "users.count", map[string]interface{}{"count": "0"}))
+
+	r.Error(b.Parse("en.yaml", []byte("- a list")))
+	r.Error(b.Parse("en.toml", []byte("")))
+}
+
+func Test_Plural(t *testing.T) {
+	r := require.New(t)
+	for _, tt := range []struct {
+		locale string
+		n      int
+		want   string
+	}{
+		{"en", 1, "one"},
+		{"en-GB", 0, "other"},
+		{"fr", 0, "one"},
+		{"ru", 21, "one"},
+		{"ru", 3, "few"},
+		{"ru", 12, "many"},
+		{"pl", 1, "one"},
+		{"pl", 22, "few"},
+		{"pl", 21, "many"},
+		{"lt", 11, "other"},
+		{"lv", 10, "zero"},
+		{"ja", 1, "other"},
+		{"xx", 1, "one"},
+	} {
+		r.Equal(tt.want, i18n.Plural(tt.locale, tt.n), "%s %d", tt.locale, tt.n)
+	}
+}
+
+func Test_Match(t *testing.T) {
+	r := require.New(t)
+	b := bundle(t)
+	r.Equal([]string{"lv", "de-at", "en"}, i18n.AcceptLanguage("en;q=0.5, lv, *;q=0.1, fr;q=0, de-at;q=0.8"))
+	r.Empty(i18n.AcceptLanguage(""))
+	r.Equal("de", b.Match(i18n.AcceptLanguage("fr-CH, de-AT;q=0.9, en;q=0.8")...))
+	r.Equal("", b.Match("fr"))
+}
+
+func Test_Errors(t *testing.T) {
+	r := require.New(t)
+	b := bundle(t)
+	errs := map[string][]string{
+		"email": {"Email can not be blank.", "something else"},
+		"name":  {"Name can not be blank.", "Name is not in the list [a, b]."},
+	}
+	r.Equal(map[string][]string{
+		"email": {"E-pasts nedrīkst būt tukšs.", "something else"},
+		"name":  {"Name nedrīkst būt tukšs.", "Name is not in the list [a, b]."},
+	}, b.Errors("lv", errs))
+	r.Equal(errs, b.Errors("en", errs))
+}
+
+func Test_Translator(t *testing.T) {
+	r := require.New(t)
+	tr := i18n.NewTranslator(bundle(t))
+	prefer := ""
+	tr.Prefer = func(buffalo.Context) string { return prefer }
+
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(tr.Middleware)
+	a.GET("/", func(c buffalo.Context) error {
+		return c.Render(200, page(tr, c))
+	})
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+
+	get := func(lang, cookie string) (string, string) {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req.Header.Set("Accept-Language", lang)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "locale", Value: cookie})
+		}
+		res, err := http.DefaultClient.Do(req)
+		r.NoError(err)
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.Header.Get("Content-Language"), string(body)
+	}
+
+	lang, body := get("lv-LV, en;q=0.5", "")
+	r.Equal("lv", lang)
+	r.Equal("Lietotāji 2 lietotāji", body)
+	lang, _ = get("fr", "")
+	r.Equal("en", lang)
+	lang, _ = get("lv", "de")
+	r.Equal("de", lang)
+	// not a locale of the bundle
+	lang, _ = get("lv", "fr")
+	r.Equal("lv", lang)
+	prefer = "en"
+	lang, body = get("lv", "de")
+	r.Equal("en", lang)
+	r.Equal("Users 2 users", body)
+}
+
+// page renders the title through the t helper, and a count through T.
+func page(tr *i18n.Translator, c buffalo.Context) render.Renderer {
+	return render.Func("text/plain", func(w io.Writer, data render.Data) error {
+		tpl, err := velvet.Parse(`{{t "users.title"}} `)
+		if err != nil {
+			return err
+		}
+		tpl.Helpers.Add("t", tr.Helper)
+		s, err := tpl.Exec(velvet.NewContextWith(data))
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, s+tr.T(c, "users.count", map[string]interface{}{"count": 2}))
+		return err
+	})
+}
+
+func Test_Check(t *testing.T) {
+	r := require.New(t)
+	dir, err := ioutil.TempDir("", "i18n")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(`<h1>{{t "users.title"}}</h1>{{t "users.new"  count=1}}`), 0644))
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "users.go"), []byte(`translate(c, "users.hello", nil)`), 0644))
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "users_test.go"), []byte(`translate(c, "users.test", nil)`), 0644))
+
+	used, err := i18n.Scan(dir)
+	r.NoError(err)
+	r.Len(used, 3)
+	r.Equal([]string{filepath.Join(dir, "index.html")}, used["users.new"])
+
+	b := bundle(t)
+	report := i18n.Check(b, used, "validation.", "fields.")
+	r.Equal([]string{"users.new"}, report.Missing["en"])
+	r.Equal([]string{"users.hello", "users.new", "validation.inclusion"}, report.Missing["lv"])
+	r.Equal([]string{"fields.email", "users.hello", "users.new", "validation.blank", "validation.inclusion"}, report.Missing["de"])
+	r.Equal([]string{"users.count"}, report.Unused)
+
+	b = i18n.NewBundle("en")
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "en.yaml"), []byte(en), 0644))
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not translations"), 0644))
+	r.NoError(b.LoadDir(dir))
+	r.Equal([]string{"en"}, b.Locales())
+}
@@ -0,0 +1,94 @@
+package i18n
+
+// Categories are the CLDR plural categories.
+var Categories = map[string]bool{
+	"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true,
+}
+
+// PluralRules pick the plural category of a count, by language. The
+// languages without a rule count like English.
+var PluralRules = map[string]func(n int) string{
+	"en": one,
+	"de": one,
+	"nl": one,
+	"sv": one,
+	"et": one,
+	"fr": func(n int) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"lv": func(n int) string {
+		switch {
+		case n%10 == 0 || teen(n):
+			return "zero"
+		case n%10 == 1:
+			return "one"
+		}
+		return "other"
+	},
+	"lt": func(n int) string {
+		switch {
+		case teen(n):
+			return "other"
+		case n%10 == 1:
+			return "one"
+		case n%10 >= 2:
+			return "few"
+		}
+		return "other"
+	},
+	"ru": slavic,
+	"uk": slavic,
+	"pl": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		if f := slavic(n); f == "few" {
+			return f
+		}
+		return "many"
+	},
+	"ja": other,
+	"zh": other,
+	"ko": other,
+}
+
+// Plural returns the plural category of n in locale.
+func Plural(locale string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	rule, ok := PluralRules[base(Normalize(locale))]
+	if !ok {
+		rule = one
+	}
+	return rule(n)
+}
+
+func one(n int) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+func other(int) string {
+	return "other"
+}
+
+// teen is 11 to 19, give or take the hundreds.
+func teen(n int) bool {
+	return n%100 >= 11 && n%100 <= 19
+}
+
+func slavic(n int) string {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return "one"
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return "few"
+	}
+	return "many"
+}
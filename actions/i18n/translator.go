@@ -0,0 +1,141 @@
+package i18n
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/velvet"
+)
+
+// LocaleKey is where the locale of a request is in its context, and in
+// the data of its templates.
+const LocaleKey = "locale"
+
+// Translator picks the locale of the requests and translates with it.
+type Translator struct {
+	Bundle *Bundle
+	// Prefer returns the locale the signed in user picked, if any.
+	Prefer func(c buffalo.Context) string
+	// Cookie is the cookie remembering the locale picked, "locale" by
+	// default.
+	Cookie string
+}
+
+// NewTranslator returns a translator of b.
+func NewTranslator(b *Bundle) *Translator {
+	return &Translator{Bundle: b, Cookie: "locale"}
+}
+
+// Locale returns the locale of the request: the one of the user, the
+// cookie, or the best of Accept-Language the bundle has, in that order.
+func (t *Translator) Locale(c buffalo.Context) string {
+	candidates := []string{}
+	if t.Prefer != nil {
+		candidates = append(candidates, t.Prefer(c))
+	}
+	if ck, err := c.Request().Cookie(t.Cookie); err == nil {
+		candidates = append(candidates, ck.Value)
+	}
+	for _, l := range candidates {
+		if l = Normalize(l); l != "" && t.Bundle.Match(l) == l {
+			return l
+		}
+	}
+	if l := t.Bundle.Match(AcceptLanguage(c.Request().Header.Get("Accept-Language"))...); l != "" {
+		return l
+	}
+	return t.Bundle.Default
+}
+
+// Localize sets the locale of the request, for the templates and T,
+// and returns it.
+func (t *Translator) Localize(c buffalo.Context) string {
+	if l, ok := c.Value(LocaleKey).(string); ok {
+		return l
+	}
+	l := t.Locale(c)
+	c.Set(LocaleKey, l)
+	c.Set("locales", t.Bundle.Locales())
+	h := c.Response().Header()
+	h.Set("Content-Language", l)
+	h.Add("Vary", "Accept-Language")
+	return l
+}
+
+// Middleware localizes the requests.
+func (t *Translator) Middleware(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		t.Localize(c)
+		return next(c)
+	}
+}
+
+// T translates key to the locale of the request.
+func (t *Translator) T(c buffalo.Context, key string, data map[string]interface{}) string {
+	return t.Bundle.Translate(t.Localize(c), key, data)
+}
+
+// SetCookie remembers locale for the next requests.
+func (t *Translator) SetCookie(c buffalo.Context, locale string) {
+	http.SetCookie(c.Response(), &http.Cookie{
+		Name:     t.Cookie,
+		Value:    locale,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+	})
+}
+
+// Helper is the velvet helper translating to the locale of the page.
+// The options are the data of the translation:
+//
+//	{{t "users.count" count=total}}
+func (t *Translator) Helper(key string, help velvet.HelperContext) string {
+	locale, _ := help.Get(LocaleKey).(string)
+	if locale == "" {
+		locale = t.Bundle.Default
+	}
+	return t.Bundle.Translate(locale, key, help.Context.Options())
+}
+
+// AcceptLanguage returns the languages of an Accept-Language header,
+// the preferred first. The wildcard and the refused ones (q=0) are left
+// out.
+func AcceptLanguage(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	langs := []lang{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
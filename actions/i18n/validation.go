@@ -0,0 +1,52 @@
+package i18n
+
+import (
+	"regexp"
+)
+
+// validations are the messages of github.com/markbates/validate's
+// validators and the keys of their translations. The validators only
+// speak English, their messages are matched to translate them.
+var validations = []struct {
+	expr *regexp.Regexp
+	key  string
+	// params names the groups after the field name
+	params []string
+}{
+	{regexp.MustCompile(`^(.+) can not be blank\.$`), "validation.blank", nil},
+	{regexp.MustCompile(`^(.+) can not be empty\.$`), "validation.empty", nil},
+	{regexp.MustCompile(`^(.+) does not match the expected format\.$`), "validation.format", nil},
+	{regexp.MustCompile(`^(.+) is not in the list \[(.*)\]\.$`), "validation.inclusion", []string{"list"}},
+	{regexp.MustCompile(`^(.+) must be before (.+)\.$`), "validation.before", []string{"other"}},
+}
+
+// Errors translates the messages of validation errors, keyed by field,
+// to locale. {field} is the name of the field, translated with the key
+// fields.<field> when there's one. Unknown messages stay as they are.
+func (b *Bundle) Errors(locale string, errs map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(errs))
+	for field, messages := range errs {
+		for _, m := range messages {
+			out[field] = append(out[field], b.validation(locale, field, m))
+		}
+	}
+	return out
+}
+
+func (b *Bundle) validation(locale, field, message string) string {
+	for _, v := range validations {
+		groups := v.expr.FindStringSubmatch(message)
+		if groups == nil || !b.Has(locale, v.key) {
+			continue
+		}
+		data := map[string]interface{}{"field": groups[1]}
+		if b.Has(locale, "fields."+field) {
+			data["field"] = b.Translate(locale, "fields."+field, nil)
+		}
+		for i, p := range v.params {
+			data[p] = groups[i+2]
+		}
+		return b.Translate(locale, v.key, data)
+	}
+	return message
+}
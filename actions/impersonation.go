@@ -0,0 +1,109 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/auth"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+func initImpersonationRoutes(app *buffalo.App) {
+	api.Document(app.POST("/impersonation/stop", mw.CSRF(StopImpersonatingHandler)), openapi.Operation{
+		Summary:     "Stop impersonating a user",
+		Description: "Signs the admin back in, the way it was. It takes the csrf_token field.",
+		Tags:        []string{"admin"},
+		ContentType: "text/html",
+		Status:      http.StatusSeeOther,
+		Response:    "",
+	})
+}
+
+// Impersonation shows the banner of the admins impersonating a user, as
+// impersonating, and keeps them from the destructive requests.
+func Impersonation(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		if ab == nil {
+			return next(c)
+		}
+		imp, ok := store.Impersonating(ab.SessionStoreMaker(c.Response(), c.Request()))
+		if !ok {
+			return next(c)
+		}
+		// for the form of the banner
+		if _, err := mw.CSRFToken(c); err != nil {
+			return err
+		}
+		c.Set("impersonating", imp)
+		if destructive(c.Request()) {
+			return problem.Forbidden("%s can't do that while impersonating %s", imp.Name, imp.User)
+		}
+		return next(c)
+	}
+}
+
+// destructive tells the requests an admin impersonating a user can't
+// make: the deletions, the ones of authboss (passwords, sign out...) and
+// the ones of the dashboard, which it isn't signed in to anyway.
+func destructive(req *http.Request) bool {
+	switch {
+	case req.URL.Path == "/impersonation/stop":
+		return false
+	case req.Method == "DELETE":
+		return true
+	case req.URL.Path == "/admin" || strings.HasPrefix(req.URL.Path, "/admin/"):
+		return true
+	case strings.HasPrefix(req.URL.Path, "/api/v2/auth"):
+		return req.Method != "GET" && req.Method != "HEAD"
+	}
+	return false
+}
+
+// AdminImpersonateHandler signs the admin in as a user, until
+// StopImpersonatingHandler.
+func AdminImpersonateHandler(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user, err := accountUser(tx, c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+	if err := audit(c, tx, adminImpersonated, user.Email); err != nil {
+		return err
+	}
+	admin, _ := c.Value("admin").(string)
+	store.Impersonate(ab.SessionStoreMaker(c.Response(), c.Request()), user.Email, admin)
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+// StopImpersonatingHandler signs the admin impersonating a user back in,
+// with the session it had, and goes back to the user on the dashboard.
+func StopImpersonatingHandler(c buffalo.Context) error {
+	imp, ok := store.Restore(ab.SessionStoreMaker(c.Response(), c.Request()))
+	if !ok {
+		return problem.BadRequest("not impersonating anyone")
+	}
+	tx := c.Value("tx").(*pop.Connection)
+	c.Set("admin", imp.Name)
+	c.LogField("impersonated_for", time.Since(imp.Since).String())
+	if err := audit(c, tx, adminImpersonationStopped, imp.User); err != nil {
+		return err
+	}
+
+	user := &models.User{}
+	err := tx.Where("email = ?", imp.User).First(user)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return c.Redirect(http.StatusSeeOther, "/admin/users")
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/admin/users/%d", user.ID)
+}
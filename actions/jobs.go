@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/jobs"
+	"github.com/leonids/test-buffalo/actions/webhooks"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// The jobs of the app.
+const (
+	mailJob     = "mail.deliver"
+	webhooksJob = "webhooks.dispatch"
+)
+
+// NewJobWorker returns a worker running the jobs of the app.
+func NewJobWorker() *jobs.Worker {
+	w := jobs.NewWorker(models.DB)
+	w.Register(mailJob, deliverMail(newMailer()))
+	w.Register(webhooksJob, dispatchWebhooks)
+	return w
+}
+
+// dispatchWebhooks sends the due deliveries and schedules another run
+// for the retries.
+func dispatchWebhooks(ctx context.Context, j *jobs.Job) error {
+	d := webhooks.NewDispatcher(models.DB)
+	if _, err := d.Dispatch(); err != nil {
+		return err
+	}
+	next, ok, err := d.NextAttempt()
+	if err != nil || !ok {
+		return err
+	}
+	// one job per retry time: a single unique job scheduled for the
+	// latest retry would hold back the earlier ones
+	return jobs.Enqueue(models.DB, webhooksJob, nil, jobs.Options{
+		Queue:  "webhooks",
+		RunAt:  next,
+		Unique: webhooksJob + ":" + strconv.FormatInt(next.Unix(), 10),
+	})
+}
+
+// AdminJobsHandler shows the state of the job queues: the counts by
+// queue and state, and the latest jobs (?status= filters them).
+func AdminJobsHandler(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	counts, err := jobs.Counts(tx)
+	if err != nil {
+		return err
+	}
+
+	q := tx.Order("id DESC").Limit(50)
+	status := c.Param("status")
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	list := &jobs.Jobs{}
+	if err := q.All(list); err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.Set("counts", counts)
+	c.Set("jobs", list)
+	c.Set("status", status)
+	c.Set("statuses", []string{jobs.Queued, jobs.Running, jobs.Done, jobs.Dead})
+	return c.Render(200, r.HTML("admin/jobs.html"))
+}
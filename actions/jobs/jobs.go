@@ -0,0 +1,146 @@
+// Package jobs runs work in the background. Jobs are rows of the jobs
+// table: Enqueue adds them, in the caller's transaction, and a Worker
+// claims and runs them with the Handler registered under their name,
+// retrying failures with a backoff until they are done or dead.
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/pkg/errors"
+)
+
+// The states of a job.
+const (
+	Queued  = "queued"
+	Running = "running"
+	Done    = "done"
+	// Dead jobs ran out of attempts. They stay in the table until
+	// retried or purged.
+	Dead = "dead"
+)
+
+// DefaultQueue is the queue of jobs enqueued without one.
+const DefaultQueue = "default"
+
+// Job is a unit of background work.
+type Job struct {
+	ID          int       `json:"id" db:"id"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	Queue       string    `json:"queue" db:"queue"`
+	Name        string    `json:"name" db:"name"`
+	Args        string    `json:"args" db:"args"`
+	Status      string    `json:"status" db:"status"`
+	Attempts    int       `json:"attempts" db:"attempts"`
+	MaxAttempts int       `json:"max_attempts" db:"max_attempts"`
+	RunAt       time.Time `json:"run_at" db:"run_at"`
+	LockedAt    time.Time `json:"locked_at" db:"locked_at"`
+	LockedBy    string    `json:"locked_by" db:"locked_by"`
+	// UniqueKey is only set while the job is queued, see Options.Unique.
+	UniqueKey nulls.String `json:"unique_key" db:"unique_key"`
+	LastError string       `json:"last_error" db:"last_error"`
+}
+
+// Jobs is a list of jobs.
+type Jobs []Job
+
+// Bind decodes the arguments of the job into v.
+func (j *Job) Bind(v interface{}) error {
+	return errors.WithStack(json.Unmarshal([]byte(j.Args), v))
+}
+
+// Options of a job.
+type Options struct {
+	// Queue of the job, DefaultQueue when empty.
+	Queue string
+	// RunAt is the earliest time the job runs, now when zero.
+	RunAt time.Time
+	// MaxAttempts before the job is dead, 5 when zero.
+	MaxAttempts int
+	// Unique is a key only one queued job can have at a time: enqueuing
+	// another job with the same key is a no-op until the first one starts.
+	Unique string
+}
+
+// Enqueue adds a job running the handler called name with args, which
+// are stored as JSON. Call it with the transaction of the request, so
+// the job only exists if the request succeeds.
+func Enqueue(tx *pop.Connection, name string, args interface{}, o Options) error {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	now := time.Now().UTC()
+	j := &Job{
+		Queue:       o.Queue,
+		Name:        name,
+		Args:        string(b),
+		Status:      Queued,
+		MaxAttempts: o.MaxAttempts,
+		RunAt:       o.RunAt.UTC(),
+	}
+	if j.Queue == "" {
+		j.Queue = DefaultQueue
+	}
+	if j.MaxAttempts == 0 {
+		j.MaxAttempts = 5
+	}
+	if o.RunAt.IsZero() {
+		j.RunAt = now
+	}
+	if o.Unique == "" {
+		return errors.WithStack(tx.Create(j))
+	}
+
+	// a failed insert would abort the whole transaction on Postgres, so
+	// duplicates are skipped by the database instead
+	return errors.WithStack(tx.RawQuery(`INSERT INTO jobs
+		(created_at, updated_at, queue, name, args, status, attempts, max_attempts, run_at, locked_at, locked_by, unique_key, last_error)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?, ?, '', ?, '')
+		ON CONFLICT (unique_key) DO NOTHING`,
+		now, now, j.Queue, j.Name, j.Args, j.Status, j.MaxAttempts, j.RunAt, time.Time{}, o.Unique).Exec())
+}
+
+// Retry queues a dead job again, with a fresh set of attempts.
+func Retry(tx *pop.Connection, id int) error {
+	j := &Job{}
+	if err := tx.Find(j, id); err != nil {
+		return errors.WithStack(err)
+	}
+	if j.Status != Dead {
+		return errors.Errorf("job %d is %s, not dead", id, j.Status)
+	}
+	j.Status = Queued
+	j.Attempts = 0
+	j.RunAt = time.Now().UTC()
+	return errors.WithStack(tx.Update(j))
+}
+
+// Count is the number of jobs of a queue in a state.
+type Count struct {
+	Queue  string `json:"queue" db:"queue"`
+	Status string `json:"status" db:"status"`
+	Count  int    `json:"count" db:"count"`
+}
+
+// Counts returns the number of jobs by queue and state.
+func Counts(tx *pop.Connection) ([]Count, error) {
+	counts := []Count{}
+	err := tx.RawQuery("SELECT queue, status, COUNT(*) AS count FROM jobs GROUP BY queue, status ORDER BY queue, status").All(&counts)
+	return counts, errors.WithStack(err)
+}
+
+// placeholders returns "?, ?, ?" for n arguments.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func isNoRows(err error) bool {
+	return errors.Cause(err) == sql.ErrNoRows
+}
@@ -0,0 +1,178 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/jobs"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/markbates/pop"
+	"github.com/stretchr/testify/require"
+)
+
+func job(t *testing.T, db *pop.Connection, id int) *jobs.Job {
+	j := &jobs.Job{}
+	require.NoError(t, db.Find(j, id))
+	return j
+}
+
+func Test_Worker(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	var got []string
+	w := jobs.NewWorker(db)
+	w.Backoff = func(int) time.Duration { return 0 }
+	w.Register("greet", func(ctx context.Context, j *jobs.Job) error {
+		var args struct{ Name string }
+		if err := j.Bind(&args); err != nil {
+			return err
+		}
+		got = append(got, args.Name)
+		return nil
+	})
+	fails := 0
+	w.Register("flaky", func(ctx context.Context, j *jobs.Job) error {
+		fails++
+		if fails < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	w.Register("broken", func(ctx context.Context, j *jobs.Job) error {
+		panic("boom")
+	})
+
+	r.NoError(jobs.Enqueue(db, "greet", map[string]string{"Name": "mark"}, jobs.Options{}))
+	r.NoError(jobs.Enqueue(db, "greet", map[string]string{"Name": "later"}, jobs.Options{RunAt: time.Now().Add(time.Hour)}))
+	r.NoError(jobs.Enqueue(db, "flaky", nil, jobs.Options{}))
+	r.NoError(jobs.Enqueue(db, "broken", nil, jobs.Options{MaxAttempts: 2}))
+	r.NoError(jobs.Enqueue(db, "unknown", nil, jobs.Options{}))
+
+	for i := 0; i < 10; i++ {
+		worked, err := w.Work()
+		r.NoError(err)
+		if !worked {
+			break
+		}
+	}
+	r.Equal([]string{"mark"}, got, "jobs run at their time")
+
+	j := job(t, db, 1)
+	r.Equal(jobs.Done, j.Status)
+	r.Equal(1, j.Attempts)
+	r.Equal(jobs.Queued, job(t, db, 2).Status)
+
+	j = job(t, db, 3)
+	r.Equal(jobs.Done, j.Status)
+	r.Equal(2, j.Attempts)
+	r.Empty(j.LastError)
+
+	j = job(t, db, 4)
+	r.Equal(jobs.Dead, j.Status)
+	r.Equal(2, j.Attempts)
+	r.Equal("panic: boom", j.LastError)
+
+	j = job(t, db, 5)
+	r.Equal(jobs.Dead, j.Status)
+	r.Equal("no handler for unknown", j.LastError)
+
+	r.NoError(jobs.Retry(db, 4))
+	r.Equal(jobs.Queued, job(t, db, 4).Status)
+	r.Error(jobs.Retry(db, 1))
+
+	counts, err := jobs.Counts(db)
+	r.NoError(err)
+	r.Equal([]jobs.Count{
+		{Queue: "default", Status: "dead", Count: 1},
+		{Queue: "default", Status: "done", Count: 2},
+		{Queue: "default", Status: "queued", Count: 2},
+	}, counts)
+}
+
+func Test_Enqueue_Unique(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	r.NoError(jobs.Enqueue(db, "sync", nil, jobs.Options{Unique: "sync"}))
+	r.NoError(jobs.Enqueue(db, "sync", nil, jobs.Options{Unique: "sync"}))
+	n, err := db.Count(&jobs.Job{})
+	r.NoError(err)
+	r.Equal(1, n)
+
+	// once started another one can be queued
+	w := jobs.NewWorker(db)
+	w.Register("sync", func(ctx context.Context, j *jobs.Job) error {
+		return jobs.Enqueue(db, "sync", nil, jobs.Options{Unique: "sync"})
+	})
+	worked, err := w.Work()
+	r.NoError(err)
+	r.True(worked)
+	n, err = db.Count(&jobs.Job{})
+	r.NoError(err)
+	r.Equal(2, n)
+}
+
+func Test_Enqueue_Transaction(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	err := db.Transaction(func(tx *pop.Connection) error {
+		r.NoError(jobs.Enqueue(tx, "greet", nil, jobs.Options{}))
+		return errors.New("the request failed")
+	})
+	r.Error(err)
+	n, err := db.Count(&jobs.Job{})
+	r.NoError(err)
+	r.Equal(0, n, "jobs of failed requests are rolled back")
+}
+
+func Test_Worker_Queues(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	r.NoError(jobs.Enqueue(db, "noop", nil, jobs.Options{Queue: "mail"}))
+	w := jobs.NewWorker(db)
+	w.Queues = []string{"default", "critical"}
+	w.Register("noop", func(ctx context.Context, j *jobs.Job) error { return nil })
+	worked, err := w.Work()
+	r.NoError(err)
+	r.False(worked)
+
+	w.Queues = []string{"mail"}
+	worked, err = w.Work()
+	r.NoError(err)
+	r.True(worked)
+}
+
+func Test_Worker_Run(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	started := make(chan struct{})
+	var finished int32
+	w := jobs.NewWorker(db)
+	w.PollInterval = 10 * time.Millisecond
+	w.Register("slow", func(ctx context.Context, j *jobs.Job) error {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		atomic.AddInt32(&finished, 1)
+		return nil
+	})
+	r.NoError(jobs.Enqueue(db, "slow", nil, jobs.Options{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+	r.Equal(int32(1), atomic.LoadInt32(&finished), "running jobs finish on shutdown")
+	r.Equal(jobs.Done, job(t, db, 1).Status)
+}
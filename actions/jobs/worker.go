@@ -0,0 +1,238 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// Handler does the work of a job. A returned error (or a panic) fails
+// the attempt. ctx is cancelled when the worker is shut down and the
+// grace period is over.
+type Handler func(ctx context.Context, j *Job) error
+
+// Worker claims and runs jobs.
+type Worker struct {
+	DB     *pop.Connection
+	Logger buffalo.Logger
+	// ID tells the workers apart in the locked_by column.
+	ID string
+	// Queues the worker takes jobs from, all of them when empty.
+	Queues []string
+	// Concurrency is the number of jobs run at once, 1 when zero.
+	Concurrency int
+	// PollInterval is the wait when there's nothing to do, a second when
+	// zero.
+	PollInterval time.Duration
+	// Backoff is the delay before the retry following the given attempt,
+	// DefaultBackoff when nil.
+	Backoff func(attempt int) time.Duration
+	// StaleAfter is how long a job can run before it's thought to belong
+	// to a dead worker and is run again, 15 minutes when zero.
+	StaleAfter time.Duration
+	// GracePeriod is how long running jobs get to finish on shutdown
+	// before their context is cancelled, 30 seconds when zero.
+	GracePeriod time.Duration
+
+	handlers map[string]Handler
+}
+
+// NewWorker returns a worker of the jobs in db without handlers.
+func NewWorker(db *pop.Connection) *Worker {
+	host, _ := os.Hostname()
+	return &Worker{
+		DB:       db,
+		Logger:   buffalo.NewLogger("info"),
+		ID:       host + ":" + strconv.Itoa(os.Getpid()),
+		handlers: map[string]Handler{},
+	}
+}
+
+// Register sets the handler of the jobs called name.
+func (w *Worker) Register(name string, h Handler) {
+	w.handlers[name] = h
+}
+
+// DefaultBackoff waits attempt² × 15 seconds: 15s, 1m, 2m15s, 4m…
+func DefaultBackoff(attempt int) time.Duration {
+	return time.Duration(attempt*attempt) * 15 * time.Second
+}
+
+// Run works until ctx is done, then waits for the running jobs to
+// finish.
+func (w *Worker) Run(ctx context.Context) {
+	poll := w.PollInterval
+	if poll == 0 {
+		poll = time.Second
+	}
+	grace := w.GracePeriod
+	if grace == 0 {
+		grace = 30 * time.Second
+	}
+	n := w.Concurrency
+	if n == 0 {
+		n = 1
+	}
+
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	finished := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		select {
+		case <-time.After(grace):
+			cancelJobs()
+		case <-finished:
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				worked, err := w.work(jobCtx)
+				if err != nil {
+					w.Logger.Errorf("jobs: %+v", err)
+				}
+				if worked && err == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(poll):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(finished)
+}
+
+// Work runs a single job, if one is due, and tells whether it did.
+func (w *Worker) Work() (bool, error) {
+	return w.work(context.Background())
+}
+
+func (w *Worker) work(ctx context.Context) (bool, error) {
+	j, err := w.claim()
+	if err != nil || j == nil {
+		return false, err
+	}
+	return true, w.perform(ctx, j)
+}
+
+// claim takes the next due job, or a stale one, and marks it as running.
+// Postgres hands the job to a single worker with SKIP LOCKED. Elsewhere
+// the update only succeeds for the worker that still sees the job in the
+// state it was selected in; the others come back empty handed.
+func (w *Worker) claim() (*Job, error) {
+	now := time.Now().UTC()
+	stale := w.StaleAfter
+	if stale == 0 {
+		stale = 15 * time.Minute
+	}
+	due := "((status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?))"
+	args := []interface{}{Queued, now, Running, now.Add(-stale)}
+	if len(w.Queues) > 0 {
+		due += " AND queue IN (" + placeholders(len(w.Queues)) + ")"
+		for _, q := range w.Queues {
+			args = append(args, q)
+		}
+	}
+	set := "status = ?, attempts = attempts + 1, locked_at = ?, locked_by = ?, unique_key = NULL, updated_at = ?"
+	setArgs := []interface{}{Running, now, w.ID, now}
+
+	j := &Job{}
+	if w.DB.Dialect.Details().Dialect == "postgres" {
+		q := "UPDATE jobs SET " + set + " WHERE id = (SELECT id FROM jobs WHERE " + due + " ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING *"
+		err := w.DB.RawQuery(q, append(setArgs, args...)...).First(j)
+		if err != nil {
+			if isNoRows(err) {
+				return nil, nil
+			}
+			return nil, errors.WithStack(err)
+		}
+		return j, nil
+	}
+
+	var claimed bool
+	err := w.DB.Transaction(func(tx *pop.Connection) error {
+		var id struct {
+			ID int `db:"id"`
+		}
+		err := tx.RawQuery("SELECT id FROM jobs WHERE "+due+" ORDER BY run_at, id LIMIT 1", args...).First(&id)
+		if err != nil {
+			if isNoRows(err) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		stmt, sargs := tx.RawQuery("UPDATE jobs SET "+set+" WHERE id = ? AND "+due, append(append(setArgs, id.ID), args...)...).ToSQL(nil)
+		res, err := tx.Store.Exec(stmt, sargs...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return errors.WithStack(err)
+		}
+		claimed = true
+		return errors.WithStack(tx.Find(j, id.ID))
+	})
+	if err != nil || !claimed {
+		return nil, err
+	}
+	return j, nil
+}
+
+// perform runs j and records the outcome: done, queued again for a
+// retry, or dead.
+func (w *Worker) perform(ctx context.Context, j *Job) error {
+	start := time.Now()
+	err := w.call(ctx, j)
+
+	j.LockedBy = ""
+	switch {
+	case err == nil:
+		j.Status = Done
+		j.LastError = ""
+		w.Logger.Infof("jobs: %s #%d done in %s", j.Name, j.ID, time.Since(start))
+	case j.Attempts >= j.MaxAttempts:
+		j.Status = Dead
+		j.LastError = err.Error()
+		w.Logger.Errorf("jobs: %s #%d is dead after %d attempts: %s", j.Name, j.ID, j.Attempts, err)
+	default:
+		backoff := w.Backoff
+		if backoff == nil {
+			backoff = DefaultBackoff
+		}
+		j.Status = Queued
+		j.LastError = err.Error()
+		j.RunAt = time.Now().UTC().Add(backoff(j.Attempts))
+		w.Logger.Warnf("jobs: %s #%d failed, retrying at %s: %s", j.Name, j.ID, j.RunAt.Format(time.RFC3339), err)
+	}
+	return errors.WithStack(w.DB.Update(j))
+}
+
+func (w *Worker) call(ctx context.Context, j *Job) (err error) {
+	h, ok := w.handlers[j.Name]
+	if !ok {
+		// no point in retrying, the job is dead right away
+		j.Attempts = j.MaxAttempts
+		return errors.Errorf("no handler for %s", j.Name)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, j)
+}
@@ -0,0 +1,76 @@
+package jsonapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/problem"
+)
+
+// Bind lets c.Bind read JSON:API request bodies. buffalo's Bind only
+// knows plain JSON, so the resource object is flattened into the JSON
+// the models decode (id, attributes and <relationship>_id for to-one
+// relationships) before the handler runs.
+func Bind(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		req := c.Request()
+		mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if mt != MediaType || req.Body == nil {
+			return next(c)
+		}
+
+		doc := struct {
+			Data *struct {
+				Type          string                     `json:"type"`
+				ID            string                     `json:"id"`
+				Attributes    map[string]json.RawMessage `json:"attributes"`
+				Relationships map[string]struct {
+					Data json.RawMessage `json:"data"`
+				} `json:"relationships"`
+			} `json:"data"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(&doc); err != nil || doc.Data == nil {
+			return problem.BadRequest("the body isn't a JSON:API document")
+		}
+		req.Body.Close()
+
+		flat := map[string]json.RawMessage{}
+		for k, v := range doc.Data.Attributes {
+			flat[k] = v
+		}
+		if doc.Data.ID != "" {
+			flat["id"] = rawID(doc.Data.ID)
+		}
+		for name, rel := range doc.Data.Relationships {
+			id := struct {
+				ID string `json:"id"`
+			}{}
+			if json.Unmarshal(rel.Data, &id) == nil && id.ID != "" {
+				flat[strings.Replace(name, "-", "_", -1)+"_id"] = rawID(id.ID)
+			}
+		}
+
+		b, err := json.Marshal(flat)
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		req.ContentLength = int64(len(b))
+		req.Header.Set("Content-Type", "application/json")
+		return next(c)
+	}
+}
+
+// rawID keeps numeric ids numbers, so they decode into int ID fields.
+func rawID(id string) json.RawMessage {
+	var n json.Number
+	if json.Unmarshal([]byte(id), &n) == nil {
+		return json.RawMessage(id)
+	}
+	b, _ := json.Marshal(id)
+	return b
+}
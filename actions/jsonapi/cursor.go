@@ -0,0 +1,93 @@
+package jsonapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxCursor caps the length of page[cursor].
+const maxCursor = 1024
+
+func init() {
+	// the keys are interface values
+	gob.Register(time.Time{})
+}
+
+// cursor is the position of page[cursor], after the last row of the
+// previous page: the values of its sort columns, the ID last, or its
+// offset for the queries paged by offset.
+type cursor struct {
+	// Sort is the sort of the query, the cursor of another one starts
+	// over.
+	Sort   string
+	Keys   []interface{}
+	Offset int
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	if len(s) > maxCursor {
+		return nil, errors.New("the cursor is too long")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c := &cursor{}
+	return c, errors.WithStack(gob.NewDecoder(bytes.NewReader(b)).Decode(c))
+}
+
+func (c *cursor) encode() (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// keyset is the condition of the rows after the ones with the values of
+// the columns, ordered by them, the descending ones prefixed with "-":
+//
+//	(a > ?) OR (a = ? AND b < ?) OR ...
+func keyset(columns []string, values []interface{}) (string, []interface{}) {
+	or := []string{}
+	args := []interface{}{}
+	for i, col := range columns {
+		and := []string{}
+		for j := 0; j < i; j++ {
+			and = append(and, strings.TrimPrefix(columns[j], "-")+" = ?")
+			args = append(args, values[j])
+		}
+		if strings.HasPrefix(col, "-") {
+			and = append(and, col[1:]+" < ?")
+		} else {
+			and = append(and, col+" > ?")
+		}
+		args = append(args, values[i])
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	return "(" + strings.Join(or, " OR ") + ")", args
+}
+
+// columnValue returns the value of the field of model, a pointer to a
+// struct, of the column: by db tag, or json tag without one.
+func columnValue(model interface{}, column string) (interface{}, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(model))
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name := f.Tag.Get("db")
+		if name == "" {
+			name = strings.Split(f.Tag.Get("json"), ",")[0]
+		}
+		if name == column {
+			return rv.Field(i).Interface(), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,51 @@
+package jsonapi
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/leonids/test-buffalo/actions/problem"
+)
+
+// ErrorObject is a JSON:API error object.
+type ErrorObject struct {
+	Status string       `json:"status"`
+	Code   string       `json:"code,omitempty"`
+	Title  string       `json:"title"`
+	Detail string       `json:"detail,omitempty"`
+	Source *ErrorSource `json:"source,omitempty"`
+}
+
+// ErrorSource points at the part of the request that caused the error.
+type ErrorSource struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// Errors converts a problem into an error document. Field errors get an
+// error object each, pointing at the attribute.
+func Errors(p *problem.Error) *Document {
+	doc := newDocument()
+	status := strconv.Itoa(p.Status)
+	if len(p.Errors) == 0 {
+		doc.Errors = []*ErrorObject{{Status: status, Title: p.Title, Detail: p.Detail}}
+		return doc
+	}
+
+	fields := make([]string, 0, len(p.Errors))
+	for f := range p.Errors {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		for _, msg := range p.Errors[f] {
+			doc.Errors = append(doc.Errors, &ErrorObject{
+				Status: status,
+				Title:  p.Title,
+				Detail: msg,
+				Source: &ErrorSource{Pointer: "/data/attributes/" + f},
+			})
+		}
+	}
+	return doc
+}
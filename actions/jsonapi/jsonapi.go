@@ -0,0 +1,233 @@
+// Package jsonapi renders pop models as JSON:API (http://jsonapi.org)
+// documents and reads JSON:API request bodies.
+//
+//	q, err := jsonapi.ParseQuery(c, "name", "email")
+//	if err != nil {
+//		return err
+//	}
+//	users := &models.Users{}
+//	if err := q.Apply(pop.Q(tx)).All(users); err != nil {
+//		return err
+//	}
+//	return c.Render(200, r.JSONAPI(users, q))
+//
+// The type of a resource is its table name, its id the ID field and its
+// attributes every other field, named after the json tags.
+package jsonapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// MediaType of JSON:API documents.
+const MediaType = "application/vnd.api+json"
+
+// Document is a top level JSON:API document.
+type Document struct {
+	// Data is a *Resource, a []*Resource or nil.
+	Data     interface{}            `json:"data,omitempty"`
+	Errors   []*ErrorObject         `json:"errors,omitempty"`
+	Included []*Resource            `json:"included,omitempty"`
+	Links    map[string]string      `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	JSONAPI  map[string]string      `json:"jsonapi"`
+}
+
+// Resource is a resource object.
+type Resource struct {
+	Type          string                   `json:"type"`
+	ID            string                   `json:"id"`
+	Attributes    map[string]interface{}   `json:"attributes,omitempty"`
+	Relationships map[string]*Relationship `json:"relationships,omitempty"`
+	Meta          map[string]interface{}   `json:"meta,omitempty"`
+}
+
+// Relationship is the resource linkage of a relationship, Data is a
+// *Identifier, a []*Identifier or nil.
+type Relationship struct {
+	Data interface{} `json:"data"`
+}
+
+// Identifier identifies a resource.
+type Identifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func newDocument() *Document {
+	return &Document{JSONAPI: map[string]string{"version": "1.0"}}
+}
+
+// Marshal builds the document of v, a pointer to a model or to a slice of
+// models. q may be nil; otherwise its sparse fieldsets and includes are
+// honored and, if it paginated the query, the page is cut to its size
+// and the pagination links are added.
+func Marshal(v interface{}, q *Query) (*Document, error) {
+	if q == nil {
+		q = &Query{}
+	}
+	doc := newDocument()
+	m := &marshaler{q: q, seen: map[Identifier]bool{}}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Slice {
+		list := []*Resource{}
+		for i := 0; i < q.PageLen(rv.Len()); i++ {
+			res, err := m.resource(rv.Index(i).Addr().Interface(), true)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, res)
+		}
+		doc.Data = list
+		links, err := q.links(rv)
+		if err != nil {
+			return nil, err
+		}
+		doc.Links = links
+	} else {
+		res, err := m.resource(rv.Addr().Interface(), true)
+		if err != nil {
+			return nil, err
+		}
+		doc.Data = res
+	}
+	doc.Included = m.included
+	return doc, nil
+}
+
+type marshaler struct {
+	q        *Query
+	included []*Resource
+	seen     map[Identifier]bool
+}
+
+// resource turns model, a pointer to a struct, into a resource object.
+// Relationships are only loaded for primary data: included resources
+// don't include further.
+func (m *marshaler) resource(model interface{}, primary bool) (*Resource, error) {
+	id := identify(model)
+	res := &Resource{Type: id.Type, ID: id.ID}
+
+	b, err := json.Marshal(model)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	delete(attrs, "id")
+	if fields, ok := m.q.Fields[res.Type]; ok {
+		sparse := map[string]interface{}{}
+		for _, f := range fields {
+			if v, ok := attrs[f]; ok {
+				sparse[f] = v
+			}
+		}
+		attrs = sparse
+	}
+	res.Attributes = attrs
+
+	if !primary {
+		return res, nil
+	}
+	if m.q.Meta != nil {
+		res.Meta = m.q.Meta(model)
+	}
+	for _, name := range m.q.Include {
+		rel, ok := lookupRelation(res.Type, name)
+		if !ok {
+			return nil, problem.BadRequest("%s has no relationship %q", res.Type, name)
+		}
+		if m.q.Tx == nil {
+			return nil, errors.Errorf("can't include %q without a connection", name)
+		}
+		related, err := rel.Load(m.q.Tx, model)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		linkage, err := m.include(related)
+		if err != nil {
+			return nil, err
+		}
+		if res.Relationships == nil {
+			res.Relationships = map[string]*Relationship{}
+		}
+		res.Relationships[name] = &Relationship{Data: linkage}
+	}
+	return res, nil
+}
+
+// include adds related (nil, a pointer to a model or to a slice of
+// models) to the included resources, once, and returns its linkage.
+func (m *marshaler) include(related interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(related)
+	if related == nil || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return nil, nil
+	}
+	rv = reflect.Indirect(rv)
+	add := func(model interface{}) (*Identifier, error) {
+		id := identify(model)
+		if !m.seen[id] {
+			res, err := m.resource(model, false)
+			if err != nil {
+				return nil, err
+			}
+			m.seen[id] = true
+			m.included = append(m.included, res)
+		}
+		return &id, nil
+	}
+	if rv.Kind() != reflect.Slice {
+		return add(rv.Addr().Interface())
+	}
+	ids := []*Identifier{}
+	for i := 0; i < rv.Len(); i++ {
+		id, err := add(rv.Index(i).Addr().Interface())
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func identify(model interface{}) Identifier {
+	pm := &pop.Model{Value: model}
+	return Identifier{Type: pm.TableName(), ID: fmt.Sprint(pm.ID())}
+}
+
+type renderer struct {
+	value interface{}
+	query *Query
+}
+
+func (s renderer) ContentType() string {
+	return MediaType
+}
+
+func (s renderer) Render(w io.Writer, data render.Data) error {
+	doc, ok := s.value.(*Document)
+	if !ok {
+		var err error
+		if doc, err = Marshal(s.value, s.query); err != nil {
+			return err
+		}
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// Render renders v, a model, a slice of models or a ready made
+// *Document, using the JSON:API media type.
+func Render(v interface{}, q *Query) render.Renderer {
+	return renderer{value: v, query: q}
+}
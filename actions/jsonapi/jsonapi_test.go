@@ -0,0 +1,218 @@
+package jsonapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/leonids/test-buffalo/actions/jsonapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/markbates/pop"
+	"github.com/markbates/validate"
+	"github.com/stretchr/testify/require"
+)
+
+type Author struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type Book struct {
+	ID       int    `json:"id" db:"id"`
+	Title    string `json:"title" db:"title"`
+	Year     int    `json:"year" db:"year"`
+	AuthorID int    `json:"author_id" db:"author_id"`
+}
+
+type Books []Book
+
+var authors = map[int]*Author{1: {ID: 1, Name: "Ursula"}}
+
+func init() {
+	jsonapi.Relate("books", "author", jsonapi.Relation{
+		Load: func(tx *pop.Connection, model interface{}) (interface{}, error) {
+			return authors[model.(*Book).AuthorID], nil
+		},
+	})
+}
+
+func Test_Marshal(t *testing.T) {
+	r := require.New(t)
+
+	books := &Books{{ID: 1, Title: "A Wizard of Earthsea", Year: 1968, AuthorID: 1}, {ID: 2, Title: "The Tombs of Atuan", Year: 1970, AuthorID: 1}}
+	doc, err := jsonapi.Marshal(books, &jsonapi.Query{
+		Include: []string{"author"},
+		Fields:  map[string][]string{"books": {"title"}},
+		Tx:      &pop.Connection{},
+		Meta: func(model interface{}) map[string]interface{} {
+			return map[string]interface{}{"year": model.(*Book).Year}
+		},
+	})
+	r.NoError(err)
+
+	list := doc.Data.([]*jsonapi.Resource)
+	r.Len(list, 2)
+	r.Equal("books", list[0].Type)
+	r.Equal("1", list[0].ID)
+	r.Equal(map[string]interface{}{"title": "A Wizard of Earthsea"}, list[0].Attributes)
+	r.Equal(&jsonapi.Identifier{Type: "authors", ID: "1"}, list[1].Relationships["author"].Data)
+	r.Equal(map[string]interface{}{"year": 1970}, list[1].Meta)
+
+	r.Len(doc.Included, 1)
+	r.Equal("Ursula", doc.Included[0].Attributes["name"])
+	r.Nil(doc.Included[0].Meta)
+
+	_, err = jsonapi.Marshal(books, &jsonapi.Query{Include: []string{"reviews"}, Tx: &pop.Connection{}})
+	p, typed := problem.From(500, err)
+	r.True(typed)
+	r.Equal(400, p.Status)
+}
+
+func Test_ParseQuery(t *testing.T) {
+	r := require.New(t)
+
+	var q *jsonapi.Query
+	a := buffalo.Automatic(buffalo.Options{})
+	a.GET("/books", func(c buffalo.Context) error {
+		var err error
+		q, err = jsonapi.ParseQuery(c, "title", "year")
+		if err != nil {
+			return err
+		}
+		return c.Render(200, jsonapi.Render(&Books{}, q))
+	})
+
+	res := httptest.NewRecorder()
+	a.ServeHTTP(res, httptest.NewRequest("GET", "/books?sort=-year,title&fields[books]=title,year&page[size]=5", nil))
+	r.Equal(200, res.Code)
+	r.Equal(jsonapi.MediaType, res.Header().Get("Content-Type"))
+	r.Equal([]string{"-year", "title"}, q.Sort)
+	r.Equal([]string{"title", "year"}, q.Fields["books"])
+	r.Equal(5, q.Size)
+
+	res = httptest.NewRecorder()
+	a.ServeHTTP(res, httptest.NewRequest("GET", "/books?sort=password", nil))
+	r.Equal(400, res.Code)
+
+	res = httptest.NewRecorder()
+	a.ServeHTTP(res, httptest.NewRequest("GET", "/books?page[cursor]=nope", nil))
+	r.Equal(400, res.Code)
+}
+
+func Test_Pagination(t *testing.T) {
+	r := require.New(t)
+	db, err := pop.NewConnection(&pop.ConnectionDetails{
+		Dialect:  "sqlite3",
+		Database: filepath.Join(t.TempDir(), "test.sqlite"),
+	})
+	r.NoError(err)
+	r.NoError(db.Open())
+	r.NoError(db.RawQuery(`CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT NOT NULL, year INTEGER NOT NULL, author_id INTEGER NOT NULL)`).Exec())
+	r.NoError(db.RawQuery(`INSERT INTO books (id, title, year, author_id) VALUES
+		(1, 'A Wizard of Earthsea', 1968, 1), (2, 'The Tombs of Atuan', 1970, 1),
+		(3, 'The Lathe of Heaven', 1970, 1), (4, 'The Farthest Shore', 1972, 1),
+		(5, 'The Dispossessed', 1974, 1)`).Exec())
+
+	a := buffalo.Automatic(buffalo.Options{})
+	a.GET("/books", func(c buffalo.Context) error {
+		q, err := jsonapi.ParseQuery(c, "title", "year")
+		if err != nil {
+			return err
+		}
+		q.Offset = c.Param("offset") != ""
+		books := &Books{}
+		if err := q.Apply(db.Q()).All(books); err != nil {
+			return err
+		}
+		return c.Render(200, jsonapi.Render(books, q))
+	})
+	// pages returns the IDs of the books of every page, from path on
+	pages := func(path string, between func()) [][]string {
+		ids := [][]string{}
+		for path != "" {
+			res := httptest.NewRecorder()
+			a.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+			r.Equal(200, res.Code, res.Body.String())
+			doc := struct {
+				Data  []jsonapi.Resource `json:"data"`
+				Links map[string]string  `json:"links"`
+			}{}
+			r.NoError(json.Unmarshal(res.Body.Bytes(), &doc))
+			page := []string{}
+			for _, d := range doc.Data {
+				page = append(page, d.ID)
+			}
+			ids = append(ids, page)
+			r.Contains(doc.Links["first"], "page%5Bsize%5D=2")
+			r.NotContains(doc.Links["first"], "cursor")
+			path = doc.Links["next"]
+			if between != nil {
+				between()
+				between = nil
+			}
+		}
+		return ids
+	}
+
+	r.Equal([][]string{{"5", "4"}, {"2", "3"}, {"1"}}, pages("/books?sort=-year&page[size]=2", nil))
+	r.Equal([][]string{{"1", "5"}, {"4", "3"}, {"2"}}, pages("/books?sort=title&page[size]=2", nil))
+	// the books added meanwhile don't shift the pages after the cursor
+	r.Equal([][]string{{"5", "4"}, {"2", "3"}, {"1"}}, pages("/books?sort=-year&page[size]=2", func() {
+		r.NoError(db.RawQuery(`INSERT INTO books (id, title, year, author_id) VALUES (6, 'Tehanu', 1990, 1)`).Exec())
+	}))
+	r.Equal([][]string{{"1", "2"}, {"3", "4"}, {"5", "6"}}, pages("/books?offset=1&page[size]=2", nil))
+}
+
+func Test_Bind(t *testing.T) {
+	r := require.New(t)
+
+	b := &Book{}
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(jsonapi.Bind)
+	a.POST("/books", func(c buffalo.Context) error {
+		if err := c.Bind(b); err != nil {
+			return err
+		}
+		return c.Render(201, render.JSON(b))
+	})
+
+	body := `{"data":{"type":"books","id":"7","attributes":{"title":"Tehanu","year":1990},"relationships":{"author":{"data":{"type":"authors","id":"1"}}}}}`
+	req := httptest.NewRequest("POST", "/books", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", jsonapi.MediaType)
+	res := httptest.NewRecorder()
+	a.ServeHTTP(res, req)
+
+	r.Equal(201, res.Code)
+	r.Equal(&Book{ID: 7, Title: "Tehanu", Year: 1990, AuthorID: 1}, b)
+}
+
+func Test_Render_Errors(t *testing.T) {
+	r := require.New(t)
+
+	res := httptest.NewRecorder()
+	doc := jsonapi.Errors(problemWithFields())
+	r.NoError(jsonapi.Render(doc, nil).Render(res, nil))
+
+	out := struct {
+		Errors []jsonapi.ErrorObject `json:"errors"`
+	}{}
+	r.NoError(json.NewDecoder(res.Body).Decode(&out))
+	r.Len(out.Errors, 2)
+	r.Equal("422", out.Errors[0].Status)
+	r.Equal("/data/attributes/email", out.Errors[0].Source.Pointer)
+	r.Equal("/data/attributes/name", out.Errors[1].Source.Pointer)
+	r.Equal(http.StatusText(422), out.Errors[1].Title)
+}
+
+func problemWithFields() *problem.Error {
+	verrs := validate.NewErrors()
+	verrs.Add("name", "Name can not be blank.")
+	verrs.Add("email", "Email does not match the expected format.")
+	p, _ := problem.From(500, problem.Validation(verrs))
+	return p
+}
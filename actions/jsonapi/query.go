@@ -0,0 +1,219 @@
+package jsonapi
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// DefaultPageSize is the page size when page[size] isn't given.
+var DefaultPageSize = 20
+
+// MaxPageSize caps page[size].
+var MaxPageSize = 100
+
+// Query holds the JSON:API query parameters of a request: include,
+// fields[type], sort, page[cursor] and page[size]. The cursor is opaque,
+// it keys the rows after the last one of the previous page by their sort
+// columns and ID, so the rows added meanwhile don't shift the pages. The
+// sort columns can't be NULL.
+type Query struct {
+	Include []string
+	Fields  map[string][]string
+	// Sort lists the sort fields, descending ones prefixed with "-".
+	Sort []string
+	Size int
+	// Offset pages by offset rather than by key, for the queries ordered
+	// by more than columns, e.g. a search rank.
+	Offset bool
+	// Tx loads the included relationships.
+	Tx *pop.Connection
+	// URL is the request's, for the pagination links.
+	URL *url.URL
+	// Meta returns the meta of the resource of a primary model, if set.
+	Meta func(model interface{}) map[string]interface{}
+
+	cursor *cursor
+	// keys are the columns Apply ordered by, the ID last, nil when it
+	// didn't run
+	keys []string
+}
+
+// ParseQuery reads the query parameters of the request. Only the
+// sortable attributes, which must be column names, may be sorted on.
+// The transaction of the request, if any, is used for includes.
+func ParseQuery(c buffalo.Context, sortable ...string) (*Query, error) {
+	req := c.Request()
+	v := req.URL.Query()
+	q := &Query{
+		Fields: map[string][]string{},
+		Size:   DefaultPageSize,
+		URL:    req.URL,
+	}
+	if tx, ok := c.Value("tx").(*pop.Connection); ok {
+		q.Tx = tx
+	}
+
+	q.Include = splitList(v.Get("include"))
+	for k := range v {
+		if strings.HasPrefix(k, "fields[") && strings.HasSuffix(k, "]") {
+			q.Fields[k[len("fields["):len(k)-1]] = splitList(v.Get(k))
+		}
+	}
+
+	for _, s := range splitList(v.Get("sort")) {
+		if !contains(sortable, strings.TrimPrefix(s, "-")) {
+			return nil, problem.BadRequest("can't sort on %q", s)
+		}
+		q.Sort = append(q.Sort, s)
+	}
+
+	if s := v.Get("page[size]"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return nil, problem.BadRequest("page[size] must be between 1 and %d", MaxPageSize)
+		}
+		q.Size = n
+	}
+	if s := v.Get("page[cursor]"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return nil, problem.BadRequest("page[cursor] isn't a cursor of these pages")
+		}
+		q.cursor = c
+	}
+	return q, nil
+}
+
+// Apply orders pq, by the ID last, and reads the page of the cursor. It
+// reads a row more than the page size, to tell if there's a next page:
+// PageLen is the number of the rows on the page. A cursor of another
+// sort starts over.
+func (q *Query) Apply(pq *pop.Query) *pop.Query {
+	q.keys = append([]string{}, q.Sort...)
+	if !contains(q.keys, "id") && !contains(q.keys, "-id") {
+		q.keys = append(q.keys, "id")
+	}
+	pq = q.Order(pq)
+	if !contains(q.Sort, "id") && !contains(q.Sort, "-id") {
+		pq = pq.Order("id")
+	}
+	c := q.cursor
+	if c != nil && c.Sort != q.sortKey() {
+		c = nil
+	}
+	if q.Offset {
+		pq.Paginator = &pop.Paginator{Page: 1, PerPage: q.Size + 1}
+		if c != nil {
+			pq.Paginator.Offset = c.Offset
+		}
+		return pq
+	}
+	if c != nil && len(c.Keys) == len(q.keys) {
+		cond, args := keyset(q.keys, c.Keys)
+		pq = pq.Where(cond, args...)
+	}
+	return pq.Limit(q.Size + 1)
+}
+
+// PageLen is the number of the n rows read by Apply that are on the
+// page.
+func (q *Query) PageLen(n int) int {
+	if q.keys != nil && n > q.Size {
+		return q.Size
+	}
+	return n
+}
+
+// sortKey tells the sorts, and the offsets, apart.
+func (q *Query) sortKey() string {
+	if q.Offset {
+		return "offset:" + strings.Join(q.Sort, ",")
+	}
+	return strings.Join(q.Sort, ",")
+}
+
+// Order orders pq by the sort fields.
+func (q *Query) Order(pq *pop.Query) *pop.Query {
+	for _, s := range q.Sort {
+		if strings.HasPrefix(s, "-") {
+			pq = pq.Order(s[1:] + " desc")
+		} else {
+			pq = pq.Order(s)
+		}
+	}
+	return pq
+}
+
+// links are the pagination links of the rows read by Apply, a slice
+// of models. The next page is after the last model on the page.
+func (q *Query) links(rows reflect.Value) (map[string]string, error) {
+	if q.keys == nil || q.URL == nil {
+		return nil, nil
+	}
+	links := map[string]string{"self": q.URL.RequestURI(), "first": q.pageURL("")}
+	n := q.PageLen(rows.Len())
+	if n == rows.Len() {
+		return links, nil
+	}
+	next := &cursor{Sort: q.sortKey()}
+	if q.Offset {
+		next.Offset = n
+		if q.cursor != nil && q.cursor.Sort == next.Sort {
+			next.Offset += q.cursor.Offset
+		}
+	} else {
+		last := rows.Index(n - 1).Addr().Interface()
+		for _, k := range q.keys {
+			v, ok := columnValue(last, strings.TrimPrefix(k, "-"))
+			if !ok {
+				return nil, errors.Errorf("%T has no field of the column %s", last, k)
+			}
+			next.Keys = append(next.Keys, v)
+		}
+	}
+	s, err := next.encode()
+	if err != nil {
+		return nil, err
+	}
+	links["next"] = q.pageURL(s)
+	return links, nil
+}
+
+// pageURL is the URL of the page of the cursor, the first page without
+// one.
+func (q *Query) pageURL(cursor string) string {
+	u := *q.URL
+	v := u.Query()
+	v.Del("page[cursor]")
+	if cursor != "" {
+		v.Set("page[cursor]", cursor)
+	}
+	u.RawQuery = v.Encode()
+	return u.RequestURI()
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
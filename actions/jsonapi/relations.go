@@ -0,0 +1,71 @@
+package jsonapi
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// Relation loads the related resources of a model so they can be
+// included, see Relate.
+type Relation struct {
+	// Load returns nil, a pointer to a model or to a slice of models.
+	Load func(tx *pop.Connection, model interface{}) (interface{}, error)
+}
+
+var relations = struct {
+	*sync.RWMutex
+	m map[string]map[string]Relation
+}{&sync.RWMutex{}, map[string]map[string]Relation{}}
+
+// Relate makes the relationship name of typ (a table name) includable
+// with ?include=name.
+func Relate(typ, name string, rel Relation) {
+	relations.Lock()
+	defer relations.Unlock()
+	if relations.m[typ] == nil {
+		relations.m[typ] = map[string]Relation{}
+	}
+	relations.m[typ][name] = rel
+}
+
+func lookupRelation(typ, name string) (Relation, bool) {
+	relations.RLock()
+	defer relations.RUnlock()
+	rel, ok := relations.m[typ][name]
+	return rel, ok
+}
+
+// HasMany loads the models of slice's type that belong to the parent,
+// through pop's BelongsTo (the <parent>_id column).
+//
+//	jsonapi.Relate("users", "posts", jsonapi.HasMany(models.Posts{}))
+func HasMany(slice interface{}) Relation {
+	t := reflect.TypeOf(slice)
+	return Relation{Load: func(tx *pop.Connection, model interface{}) (interface{}, error) {
+		list := reflect.New(t).Interface()
+		err := tx.BelongsTo(model).All(list)
+		return list, errors.WithStack(err)
+	}}
+}
+
+// BelongsTo loads the single model of target's type whose ID is held by
+// the field fk of the child, e.g. UserID.
+//
+//	jsonapi.Relate("posts", "author", jsonapi.BelongsTo(models.User{}, "UserID"))
+func BelongsTo(target interface{}, fk string) Relation {
+	t := reflect.TypeOf(target)
+	return Relation{Load: func(tx *pop.Connection, model interface{}) (interface{}, error) {
+		id := reflect.Indirect(reflect.ValueOf(model)).FieldByName(fk)
+		if !id.IsValid() {
+			return nil, errors.Errorf("%T has no field %s", model, fk)
+		}
+		parent := reflect.New(t).Interface()
+		if err := tx.Find(parent, id.Interface()); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return parent, nil
+	}}
+}
@@ -0,0 +1,94 @@
+// Package logfile is a log file that can be rotated while it's being
+// written to.
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// File is an append-only log file. It's safe for concurrent use.
+type File struct {
+	moot *sync.Mutex
+	path string
+	f    *os.File
+}
+
+// Open opens (or creates) the log file at path, creating its directory
+// if needed.
+func Open(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	f, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{moot: &sync.Mutex{}, path: path, f: f}, nil
+}
+
+func open(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	return f, errors.WithStack(err)
+}
+
+// Path of the file.
+func (l *File) Path() string {
+	return l.path
+}
+
+// Write implements io.Writer.
+func (l *File) Write(p []byte) (int, error) {
+	l.moot.Lock()
+	defer l.moot.Unlock()
+	return l.f.Write(p)
+}
+
+// Close closes the file.
+func (l *File) Close() error {
+	l.moot.Lock()
+	defer l.moot.Unlock()
+	return errors.WithStack(l.f.Close())
+}
+
+// Rotate moves the file aside, to <path>.<timestamp>, and starts a new
+// one. Only the keep most recent rotated files are kept. Empty files
+// aren't rotated.
+func (l *File) Rotate(keep int) error {
+	l.moot.Lock()
+	defer l.moot.Unlock()
+
+	if fi, err := l.f.Stat(); err == nil && fi.Size() == 0 {
+		return nil
+	}
+	rotated := l.path + "." + time.Now().UTC().Format("20060102T150405")
+	if err := os.Rename(l.path, rotated); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := open(l.path)
+	if err != nil {
+		return err
+	}
+	l.f.Close()
+	l.f = f
+
+	old, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// the timestamps sort in time order
+	sort.Sort(sort.Reverse(sort.StringSlice(old)))
+	for i, p := range old {
+		if i >= keep {
+			if err := os.Remove(p); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package logfile_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/logfile"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Rotate(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "logs", "test.log")
+	f, err := logfile.Open(path)
+	r.NoError(err)
+	defer f.Close()
+
+	// nothing to rotate
+	r.NoError(f.Rotate(2))
+	old, _ := filepath.Glob(path + ".*")
+	r.Len(old, 0)
+
+	for i, line := range []string{"one\n", "two\n", "three\n"} {
+		kept := i + 1
+		if kept > 2 {
+			kept = 2
+		}
+		_, err = f.Write([]byte(line))
+		r.NoError(err)
+		r.NoError(f.Rotate(2))
+		old, _ = filepath.Glob(path + ".*")
+		r.Len(old, kept)
+		// the rotated files are named to the second
+		time.Sleep(time.Second)
+	}
+
+	_, err = f.Write([]byte("four\n"))
+	r.NoError(err)
+	b, err := ioutil.ReadFile(path)
+	r.NoError(err)
+	r.Equal("four\n", string(b))
+
+	b, err = ioutil.ReadFile(old[len(old)-1])
+	r.NoError(err)
+	r.Equal("three\n", string(b))
+}
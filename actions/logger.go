@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/logfile"
+)
+
+// logFile is the JSON log of the app, logs/<env>.log. The logs:rotate
+// task rotates it.
+var logFile *logfile.File
+
+// newLogger logs like buffalo does, text to stdout and JSON to the log
+// file, except the file is appended to rather than truncated on start,
+// and can be rotated.
+func newLogger(env string) buffalo.Logger {
+	dir := "logs"
+	if env == "test" {
+		dir = os.TempDir()
+	}
+	var err error
+	logFile, err = logfile.Open(filepath.Join(dir, env+".log"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l := logrus.New()
+	l.Level = logrus.DebugLevel
+	l.Formatter = &logrus.TextFormatter{}
+	l.Hooks.Add(fileHook{logFile, &logrus.JSONFormatter{}})
+	return logger{l}
+}
+
+// logger is a buffalo.Logger over logrus.
+type logger struct {
+	logrus.FieldLogger
+}
+
+func (l logger) WithField(key string, value interface{}) buffalo.Logger {
+	return logger{l.FieldLogger.WithField(key, value)}
+}
+
+func (l logger) WithFields(fields map[string]interface{}) buffalo.Logger {
+	return logger{l.FieldLogger.WithFields(fields)}
+}
+
+// fileHook writes the entries to the log file too.
+type fileHook struct {
+	file      *logfile.File
+	formatter logrus.Formatter
+}
+
+func (h fileHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h fileHook) Fire(e *logrus.Entry) error {
+	b, err := h.formatter.Format(e)
+	if err != nil {
+		return err
+	}
+	_, err = h.file.Write(b)
+	return err
+}
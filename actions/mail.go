@@ -0,0 +1,164 @@
+package actions
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/leonids/test-buffalo/actions/jobs"
+	"github.com/leonids/test-buffalo/actions/mail"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/config"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"gopkg.in/authboss.v1"
+)
+
+// mailFrom is the sender of the mails of the app.
+var mailFrom = config.Current.Mail.From
+
+// newMailer returns the mailer of the app. Mails go out over SMTP when
+// it's configured, see config.Mail. Otherwise they're written to
+// tmp/mails in development, and to stdout elsewhere.
+func newMailer() *mail.Mailer {
+	var transport authboss.Mailer
+	switch cfg := config.Current.Mail; {
+	case cfg.SMTPAddr != "":
+		var auth smtp.Auth
+		if cfg.SMTPUser != "" {
+			host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+			auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, host)
+		}
+		transport = authboss.SMTPMailer(cfg.SMTPAddr, auth)
+	case ENV == "development":
+		transport = mail.Dir("tmp/mails")
+	default:
+		transport = authboss.LogMailer(os.Stdout)
+	}
+	m := mail.NewMailer(models.DB, mailTemplates(), transport)
+	m.From, m.FromName = mailFrom, "Test Buffalo"
+	return m
+}
+
+// mailTemplates are the templates under templates/mailers/.
+func mailTemplates() mail.Templates {
+	t := mail.NewTemplates(r.Engine)
+	t.Data = map[string]interface{}{"root_url": rootURL}
+	return t
+}
+
+// sendMail queues the mail rendering template with data.
+func sendMail(tx *pop.Connection, to, subject, template string, data map[string]interface{}) error {
+	m, err := mail.New([]string{to}, subject, template, data)
+	if err != nil {
+		return err
+	}
+	return queueMail(tx, m)
+}
+
+func queueMail(tx *pop.Connection, m *mail.Message) error {
+	if err := mail.Enqueue(tx, m); err != nil {
+		return err
+	}
+	return jobs.Enqueue(tx, mailJob, mailArgs{ID: m.ID}, jobs.Options{Queue: "mail"})
+}
+
+type mailArgs struct {
+	ID int `json:"id"`
+}
+
+func deliverMail(m *mail.Mailer) jobs.Handler {
+	return func(ctx context.Context, j *jobs.Job) error {
+		args := mailArgs{}
+		if err := j.Bind(&args); err != nil {
+			return err
+		}
+		return m.Deliver(args.ID)
+	}
+}
+
+// queuedMailer hands the emails of authboss over to the mail queue, so
+// they're sent (and retried) outside of the request. They come rendered
+// by authboss.
+type queuedMailer struct{}
+
+// Send implements authboss.Mailer.
+func (queuedMailer) Send(e authboss.Email) error {
+	return models.DB.Transaction(func(tx *pop.Connection) error {
+		return queueMail(tx, mail.FromEmail(e))
+	})
+}
+
+// initAuthMails sends the mails of the authboss account events.
+func initAuthMails(ab *authboss.Authboss) {
+	// authboss fires it with the user of the session, the reset page
+	// signs it in first
+	ab.Callbacks.After(authboss.EventPasswordReset, func(ctx *authboss.Context) error {
+		if err := ctx.LoadSessionUser(); err == authboss.ErrUserNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		email, _ := ctx.User.String(authboss.StoreEmail)
+		if email == "" {
+			return nil
+		}
+		return models.DB.Transaction(func(tx *pop.Connection) error {
+			return sendMail(tx, email, "Your password was changed", "password_changed", map[string]interface{}{"email": email})
+		})
+	})
+}
+
+// mailPreviews is sample data for every template under
+// templates/mailers/, for the previews.
+var mailPreviews = map[string]map[string]interface{}{
+	"welcome":          {"email": "zeratul@heroes.com"},
+	"password_changed": {"email": "zeratul@heroes.com"},
+	"password_reset":   {"email": "zeratul@heroes.com", "reset_url": "http://localhost:3000/password/reset?token=preview"},
+}
+
+// initMailPreviewRoutes serves the previews of the mail templates, in
+// development.
+func initMailPreviewRoutes(app *buffalo.App) {
+	api.Skip("GET", "/mailers")
+	api.Skip("GET", "/mailers/{name}")
+	app.GET("/mailers", MailPreviewsHandler)
+	app.GET("/mailers/{name}", MailPreviewHandler)
+}
+
+// MailPreviewsHandler lists the mail templates.
+func MailPreviewsHandler(c buffalo.Context) error {
+	names := make([]string, 0, len(mailPreviews))
+	for name := range mailPreviews {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.Set("names", names)
+	return c.Render(200, r.HTML("mailers/previews/index.html"))
+}
+
+// MailPreviewHandler renders a mail template with its sample data, the
+// HTML body, or the text one with ?format=text.
+func MailPreviewHandler(c buffalo.Context) error {
+	data, ok := mailPreviews[c.Param("name")]
+	if !ok {
+		return problem.NotFound("no mail template %s", c.Param("name"))
+	}
+	htmlBody, textBody, err := mailTemplates().Render(c.Param("name"), data)
+	if err != nil {
+		return err
+	}
+	contentType, body := "text/html", htmlBody
+	if c.Param("format") == "text" {
+		contentType, body = "text/plain", textBody
+	}
+	return c.Render(200, render.Func(contentType, func(w io.Writer, _ render.Data) error {
+		_, err := io.WriteString(w, body)
+		return err
+	}))
+}
@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/authboss.v1"
+)
+
+// Dir returns a transport writing the emails to files in dir rather than
+// sending them, to open them in a browser in development (a "letter
+// opener"): <time>-<subject>.html has the headers and the HTML body, the
+// .txt file next to it the text body.
+func Dir(dir string) authboss.Mailer {
+	return &dirMailer{dir: dir}
+}
+
+type dirMailer struct {
+	dir string
+	seq uint32
+}
+
+var notSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+func (d *dirMailer) Send(e authboss.Email) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	slug := strings.Trim(notSlug.ReplaceAllString(strings.ToLower(e.Subject), "-"), "-")
+	// the sequence tells apart the mails sent within a millisecond
+	seq := atomic.AddUint32(&d.seq, 1)
+	base := filepath.Join(d.dir, fmt.Sprintf("%s-%03d-%s", time.Now().UTC().Format("20060102T150405.000"), seq%1000, slug))
+
+	headers := fmt.Sprintf("From: %s <%s>\nTo: %s\nSubject: %s\n",
+		e.FromName, e.From, strings.Join(e.To, ", "), e.Subject)
+	body := e.HTMLBody
+	if body == "" {
+		body = "<pre>" + template.HTMLEscapeString(e.TextBody) + "</pre>"
+	}
+	page := "<pre>" + template.HTMLEscapeString(headers) + "</pre><hr>\n" + body
+	if err := ioutil.WriteFile(base+".html", []byte(page), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(base+".txt", []byte(headers+"\n"+e.TextBody), 0644))
+}
@@ -0,0 +1,111 @@
+// Package mail sends the emails of the app. A Message names a template
+// under templates/mailers/ and carries its data; it's stored in the
+// caller's transaction and a Mailer renders and sends it later, over
+// SMTP or, in development, to files. The outcome of every message is
+// recorded: sent, failed (and retried) or bounced.
+package mail
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"gopkg.in/authboss.v1"
+)
+
+// The states of a message.
+const (
+	Queued  = "queued"
+	Sent    = "sent"
+	Failed  = "failed"
+	Bounced = "bounced"
+)
+
+func init() {
+	pop.MapTableName("Message", "mail_messages")
+}
+
+// Addresses is a list of email addresses, stored comma separated.
+type Addresses []string
+
+// Value implements driver.Valuer.
+func (a Addresses) Value() (driver.Value, error) {
+	return strings.Join(a, ","), nil
+}
+
+// Scan implements sql.Scanner.
+func (a *Addresses) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return errors.Errorf("can't scan %T into Addresses", src)
+	}
+	*a = Addresses{}
+	if s != "" {
+		*a = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// Message is an email, in the mail_messages table.
+type Message struct {
+	ID        int       `json:"id" db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	To        Addresses `json:"to" db:"to_addrs"`
+	Subject   string    `json:"subject" db:"subject"`
+	// Template is rendered into the bodies on delivery, see Templates.
+	// Messages without one are sent with the bodies they have.
+	Template string `json:"template" db:"template"`
+	// Data of the template, as JSON.
+	Data     string    `json:"data" db:"data"`
+	TextBody string    `json:"text_body" db:"text_body"`
+	HTMLBody string    `json:"html_body" db:"html_body"`
+	Status   string    `json:"status" db:"status"`
+	Attempts int       `json:"attempts" db:"attempts"`
+	Error    string    `json:"error" db:"error"`
+	SentAt   time.Time `json:"sent_at" db:"sent_at"`
+}
+
+// Messages is a list of messages.
+type Messages []Message
+
+// New returns a message rendering template with data.
+func New(to []string, subject, template string, data interface{}) (*Message, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Message{To: to, Subject: subject, Template: template, Data: string(b)}, nil
+}
+
+// FromEmail returns a message sending e as it is.
+func FromEmail(e authboss.Email) *Message {
+	return &Message{To: e.To, Subject: e.Subject, TextBody: e.TextBody, HTMLBody: e.HTMLBody}
+}
+
+// Values returns the data of the template.
+func (m *Message) Values() (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	if m.Data == "" || m.Data == "null" {
+		return data, nil
+	}
+	return data, errors.WithStack(json.Unmarshal([]byte(m.Data), &data))
+}
+
+// Enqueue stores m, queued for delivery.
+func Enqueue(tx *pop.Connection, m *Message) error {
+	if len(m.To) == 0 {
+		return errors.New("mail: a message needs a recipient")
+	}
+	m.Status = Queued
+	return errors.WithStack(tx.Create(m))
+}
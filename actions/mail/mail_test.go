@@ -0,0 +1,143 @@
+package mail_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/leonids/test-buffalo/actions/mail"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/authboss.v1"
+)
+
+// templates are the ones of the app
+func templates() mail.Templates {
+	t := mail.NewTemplates(render.New(render.Options{TemplatesPath: "../../templates"}))
+	t.Data = map[string]interface{}{"root_url": "http://localhost:3000"}
+	return t
+}
+
+// transport records the emails, or fails with err.
+type transport struct {
+	sent []authboss.Email
+	err  error
+}
+
+func (t *transport) Send(e authboss.Email) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.sent = append(t.sent, e)
+	return nil
+}
+
+func Test_Templates(t *testing.T) {
+	r := require.New(t)
+	htmlBody, textBody, err := templates().Render("welcome", map[string]interface{}{"email": "a&b@example.com"})
+	r.NoError(err)
+	r.Contains(htmlBody, "<strong>a&amp;b@example.com</strong>")
+	r.Contains(htmlBody, "<!DOCTYPE html>")
+	r.Contains(textBody, "Your account, a&b@example.com, is ready.")
+	r.Contains(textBody, "http://localhost:3000/")
+	r.NotContains(textBody, "<")
+
+	_, _, err = templates().Render("nope", nil)
+	r.Error(err)
+}
+
+func Test_Deliver(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	tr := &transport{}
+	m := mail.NewMailer(db, templates(), tr)
+	m.From = "no-reply@example.com"
+
+	msg, err := mail.New([]string{"zeratul@heroes.com"}, "Welcome", "welcome", map[string]interface{}{"email": "zeratul@heroes.com"})
+	r.NoError(err)
+	r.NoError(mail.Enqueue(db, msg))
+	r.Equal(mail.Queued, msg.Status)
+
+	r.NoError(m.Deliver(msg.ID))
+	r.Len(tr.sent, 1)
+	r.Equal([]string{"zeratul@heroes.com"}, tr.sent[0].To)
+	r.Equal("no-reply@example.com", tr.sent[0].From)
+	r.Contains(tr.sent[0].HTMLBody, "zeratul@heroes.com")
+	r.Contains(tr.sent[0].TextBody, "zeratul@heroes.com")
+
+	r.NoError(db.Reload(msg))
+	r.Equal(mail.Sent, msg.Status)
+	r.Equal(1, msg.Attempts)
+	r.False(msg.SentAt.IsZero())
+	r.Contains(msg.HTMLBody, "zeratul@heroes.com")
+
+	// delivered once
+	r.NoError(m.Deliver(msg.ID))
+	r.Len(tr.sent, 1)
+
+	// as it is
+	msg = mail.FromEmail(authboss.Email{To: []string{"a@example.com"}, Subject: "Hi", TextBody: "hi"})
+	r.NoError(mail.Enqueue(db, msg))
+	r.NoError(m.Deliver(msg.ID))
+	r.Len(tr.sent, 2)
+	r.Equal("hi", tr.sent[1].TextBody)
+
+	r.Error(mail.Enqueue(db, &mail.Message{Subject: "nobody"}))
+}
+
+func Test_Deliver_Failures(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	tr := &transport{err: errors.New("connection refused")}
+	m := mail.NewMailer(db, templates(), tr)
+
+	msg := mail.FromEmail(authboss.Email{To: []string{"a@example.com"}, Subject: "Hi", TextBody: "hi"})
+	r.NoError(mail.Enqueue(db, msg))
+	r.EqualError(m.Deliver(msg.ID), "connection refused")
+	r.NoError(db.Reload(msg))
+	r.Equal(mail.Failed, msg.Status)
+	r.Equal("connection refused", msg.Error)
+
+	// the retry bounces
+	tr.err = &textproto.Error{Code: 550, Msg: "no such user"}
+	r.NoError(m.Deliver(msg.ID))
+	r.NoError(db.Reload(msg))
+	r.Equal(mail.Bounced, msg.Status)
+	r.Equal(2, msg.Attempts)
+	r.Contains(msg.Error, "no such user")
+
+	tr.err = nil
+	r.NoError(m.Deliver(msg.ID))
+	r.Len(tr.sent, 0)
+
+	r.False(mail.Permanent(&textproto.Error{Code: 451, Msg: "try again later"}))
+}
+
+func Test_Dir(t *testing.T) {
+	r := require.New(t)
+	dir := filepath.Join(t.TempDir(), "mails")
+	d := mail.Dir(dir)
+	r.NoError(d.Send(authboss.Email{
+		To:       []string{"a@example.com"},
+		From:     "no-reply@example.com",
+		Subject:  "Hello, World!",
+		TextBody: "hi <there>",
+	}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	r.NoError(err)
+	r.Len(files, 2)
+	r.True(strings.HasSuffix(files[0], "-hello-world.html"), files[0])
+
+	b, err := ioutil.ReadFile(files[0])
+	r.NoError(err)
+	r.Contains(string(b), "Subject: Hello, World!")
+	r.Contains(string(b), "hi &lt;there&gt;")
+	b, err = ioutil.ReadFile(files[1])
+	r.NoError(err)
+	r.Contains(string(b), "\nhi <there>")
+}
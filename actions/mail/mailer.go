@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"net/textproto"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"gopkg.in/authboss.v1"
+)
+
+// Mailer delivers the stored messages.
+type Mailer struct {
+	DB       *pop.Connection
+	Renderer Renderer
+	// Transport sends the emails: authboss.SMTPMailer, Dir, LogMailer…
+	Transport authboss.Mailer
+	From      string
+	FromName  string
+	Logger    buffalo.Logger
+}
+
+// NewMailer returns a mailer rendering with r and sending through
+// transport.
+func NewMailer(db *pop.Connection, r Renderer, transport authboss.Mailer) *Mailer {
+	return &Mailer{
+		DB:        db,
+		Renderer:  r,
+		Transport: transport,
+		Logger:    buffalo.NewLogger("info"),
+	}
+}
+
+// Deliver sends the message id and records the outcome. It returns the
+// error of a failed delivery, for it to be retried; bounces are final
+// and aren't errors. Sent and bounced messages aren't sent again.
+func (m *Mailer) Deliver(id int) error {
+	msg := &Message{}
+	if err := m.DB.Find(msg, id); err != nil {
+		return errors.WithStack(err)
+	}
+	if msg.Status == Sent || msg.Status == Bounced {
+		return nil
+	}
+
+	msg.Attempts++
+	err := m.send(msg)
+	switch {
+	case err == nil:
+		msg.Status, msg.Error, msg.SentAt = Sent, "", time.Now().UTC()
+	case Permanent(err):
+		msg.Status, msg.Error = Bounced, err.Error()
+		m.Logger.Warnf("mail: message %d to %v bounced: %s", msg.ID, msg.To, err)
+		err = nil
+	default:
+		msg.Status, msg.Error = Failed, err.Error()
+	}
+	if uerr := m.DB.Update(msg); uerr != nil {
+		return errors.WithStack(uerr)
+	}
+	return err
+}
+
+func (m *Mailer) send(msg *Message) error {
+	if msg.Template != "" {
+		data, err := msg.Values()
+		if err != nil {
+			return err
+		}
+		msg.HTMLBody, msg.TextBody, err = m.Renderer.Render(msg.Template, data)
+		if err != nil {
+			return err
+		}
+	}
+	return m.Transport.Send(authboss.Email{
+		To:       msg.To,
+		From:     m.From,
+		FromName: m.FromName,
+		Subject:  msg.Subject,
+		TextBody: msg.TextBody,
+		HTMLBody: msg.HTMLBody,
+	})
+}
+
+// Permanent tells if err is a permanent SMTP failure (5xx), a rejected
+// recipient for instance: sending the message again won't help.
+func Permanent(err error) bool {
+	e, ok := errors.Cause(err).(*textproto.Error)
+	return ok && e.Code >= 500
+}
@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"bytes"
+	"html"
+	"path"
+
+	"github.com/gobuffalo/buffalo/render"
+)
+
+// Renderer renders the bodies of a template.
+type Renderer interface {
+	Render(template string, data map[string]interface{}) (htmlBody, textBody string, err error)
+}
+
+// Templates renders <Dir>/<template>.html and <Dir>/<template>.txt with
+// the engine of the app, so the mails have its helpers, inside the
+// <Dir>/layout.html and <Dir>/layout.txt layouts.
+type Templates struct {
+	Engine *render.Engine
+	Dir    string
+	// Data every template gets, the data of the message wins.
+	Data map[string]interface{}
+}
+
+// NewTemplates returns the templates of the mailers directory.
+func NewTemplates(e *render.Engine) Templates {
+	return Templates{Engine: e, Dir: "mailers"}
+}
+
+// Render implements Renderer.
+func (t Templates) Render(template string, data map[string]interface{}) (string, string, error) {
+	htmlBody, err := t.render("text/html", template+".html", "layout.html", data)
+	if err != nil {
+		return "", "", err
+	}
+	textBody, err := t.render("text/plain", template+".txt", "layout.txt", data)
+	if err != nil {
+		return "", "", err
+	}
+	// velvet escapes for HTML, there's nothing to escape in text
+	return htmlBody, html.UnescapeString(textBody), nil
+}
+
+func (t Templates) render(contentType, name, layout string, data map[string]interface{}) (string, error) {
+	d := render.Data{}
+	for k, v := range t.Data {
+		d[k] = v
+	}
+	for k, v := range data {
+		d[k] = v
+	}
+	buf := &bytes.Buffer{}
+	err := t.Engine.Template(contentType, path.Join(t.Dir, name), path.Join(t.Dir, layout)).Render(buf, d)
+	return buf.String(), err
+}
@@ -1,6 +1,30 @@
 package middleware
 
-import "github.com/gobuffalo/buffalo"
+import (
+	"crypto/sha256"
+
+	"github.com/gobuffalo/buffalo"
+)
+
+// APIKeys are the keys of the API clients, kept hashed.
+type APIKeys struct {
+	sums map[[sha256.Size]byte]bool
+}
+
+// NewAPIKeys returns the store of keys.
+func NewAPIKeys(keys ...string) *APIKeys {
+	k := &APIKeys{sums: map[[sha256.Size]byte]bool{}}
+	for _, key := range keys {
+		k.sums[sha256.Sum256([]byte(key))] = true
+	}
+	return k
+}
+
+// Valid tells if key is one of the keys. They're looked up by hash, the
+// time taken doesn't tell how much of a key is right.
+func (k *APIKeys) Valid(key string) bool {
+	return key != "" && k.sums[sha256.Sum256([]byte(key))]
+}
 
 // HomeHandler is a default handler to serve up
 // a home page.
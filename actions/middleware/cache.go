@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/cache"
+	"github.com/pkg/errors"
+)
+
+// ResponseCacheOptions configures ResponseCache.
+type ResponseCacheOptions struct {
+	Cache *cache.Cache
+	// TTL of the stored responses, the TTL of the cache when zero.
+	TTL time.Duration
+	// Tags of the stored responses, invalidating one drops them.
+	Tags func(buffalo.Context) []string
+	// Skip, when it returns true, passes the request through.
+	Skip func(buffalo.Context) bool
+}
+
+// cachedResponse is what's stored for a response.
+type cachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// ResponseCache stores the 200 responses to GET and HEAD requests and
+// serves them again, with X-Cache: HIT, until they expire or their tags
+// are invalidated. They're keyed by route and URL, and by the request
+// headers named in the Vary header of the response.
+//
+// Responses that are private, no-store, streamed, or HTML pages carrying
+// a CSP nonce aren't stored, nor are the cookies of any response. The
+// app must be served through ResponseHook.
+func ResponseCache(o ResponseCacheOptions) buffalo.MiddlewareFunc {
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			req := c.Request()
+			if req.Method != "GET" && req.Method != "HEAD" || o.Skip != nil && o.Skip(c) {
+				return next(c)
+			}
+
+			base := "response:" + req.URL.RequestURI()
+			if ri, ok := c.Value("current_route").(buffalo.RouteInfo); ok {
+				base = "response:" + ri.Path + "|" + req.URL.RequestURI()
+			}
+			vary, err := cachedVary(o.Cache, base)
+			if err != nil {
+				c.Logger().Errorf("response cache: %+v", err)
+				return next(c)
+			}
+			if vary != nil {
+				b, ok, err := o.Cache.Store.Get(varyKey(base, vary, req))
+				if err != nil {
+					c.Logger().Errorf("response cache: %+v", err)
+				}
+				if ok {
+					res := &cachedResponse{}
+					if err := json.Unmarshal(b, res); err == nil {
+						return replayCached(c, res)
+					}
+				}
+			}
+
+			before := cloneHeader(c.Response().Header())
+			bw := &bufferedWriter{status: http.StatusOK}
+			restore, ok := swapResponse(c, func(w http.ResponseWriter) http.ResponseWriter {
+				bw.ResponseWriter = w
+				return bw
+			})
+			if !ok {
+				c.Logger().Warn("ResponseCache needs the app to be served through ResponseHook")
+				return next(c)
+			}
+			defer restore()
+			err = next(c)
+			if err != nil || bw.streaming {
+				return err
+			}
+
+			h := bw.Header()
+			h.Set("X-Cache", "MISS")
+			if cacheable(c, bw.status, h) {
+				if err := storeCached(o, c, base, bw, before); err != nil {
+					c.Logger().Errorf("response cache: %+v", err)
+				}
+			}
+
+			bw.ResponseWriter.WriteHeader(bw.status)
+			if req.Method != "HEAD" {
+				_, err = bw.ResponseWriter.Write(bw.buf.Bytes())
+			}
+			return errors.WithStack(err)
+		}
+	}
+}
+
+func cacheable(c buffalo.Context, status int, h http.Header) bool {
+	if status != http.StatusOK {
+		return false
+	}
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	if strings.Contains(cc, "no-store") || strings.Contains(cc, "private") {
+		return false
+	}
+	for _, v := range varyOf(h) {
+		if v == "*" {
+			return false
+		}
+	}
+	// the nonce is another one on every request
+	_, nonce := c.Value(CSPNonceKey).(string)
+	return !(nonce && strings.HasPrefix(h.Get("Content-Type"), "text/html"))
+}
+
+func storeCached(o ResponseCacheOptions, c buffalo.Context, base string, bw *bufferedWriter, before http.Header) error {
+	header := changedHeaders(before, bw.Header())
+	header.Del("Set-Cookie")
+	header.Del("X-Cache")
+	b, err := json.Marshal(&cachedResponse{Status: bw.status, Header: header, Body: bw.buf.Bytes()})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var tags []string
+	if o.Tags != nil {
+		tags = o.Tags(c)
+	}
+	ttl := o.TTL
+	if ttl == 0 {
+		ttl = o.Cache.TTL
+	}
+	vary := varyOf(bw.Header())
+	if err := o.Cache.Store.Set(base, []byte(strings.Join(vary, ",")), ttl, tags); err != nil {
+		return err
+	}
+	return o.Cache.Store.Set(varyKey(base, vary, c.Request()), b, ttl, tags)
+}
+
+func replayCached(c buffalo.Context, res *cachedResponse) error {
+	h := c.Response().Header()
+	for k, v := range res.Header {
+		h[k] = v
+	}
+	h.Set("X-Cache", "HIT")
+	c.Response().WriteHeader(res.Status)
+	if c.Request().Method == "HEAD" {
+		return nil
+	}
+	_, err := c.Response().Write(res.Body)
+	return errors.WithStack(err)
+}
+
+// cachedVary returns the Vary header stored for base, nil when there's
+// no response for it.
+func cachedVary(cc *cache.Cache, base string) ([]string, error) {
+	b, ok, err := cc.Store.Get(base)
+	if err != nil || !ok {
+		return nil, err
+	}
+	vary := []string{}
+	for _, v := range strings.Split(string(b), ",") {
+		if v != "" {
+			vary = append(vary, v)
+		}
+	}
+	return vary, nil
+}
+
+// varyOf returns the canonical names of the Vary header of h.
+func varyOf(h http.Header) []string {
+	var vary []string
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return vary
+}
+
+func varyKey(base string, vary []string, req *http.Request) string {
+	key := base
+	for _, name := range vary {
+		key += "|" + name + "=" + req.Header.Get(name)
+	}
+	return key
+}
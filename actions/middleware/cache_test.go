@@ -0,0 +1,70 @@
+package middleware_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/leonids/test-buffalo/actions/cache"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ResponseCache(t *testing.T) {
+	r := require.New(t)
+	calls := 0
+	c := cache.New(cache.NewMemory(0), time.Minute)
+
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(mw.ResponseCache(mw.ResponseCacheOptions{
+		Cache: c,
+		Tags:  func(buffalo.Context) []string { return []string{"users"} },
+	}))
+	a.GET("/users", func(c buffalo.Context) error {
+		calls++
+		h := c.Response().Header()
+		h.Set("Vary", "Accept-Language")
+		h.Set("X-Lang", c.Request().Header.Get("Accept-Language"))
+		return c.Render(200, render.String(c.Request().Header.Get("Accept-Language")))
+	})
+	a.GET("/private", func(c buffalo.Context) error {
+		calls++
+		c.Response().Header().Set("Cache-Control", "private")
+		return c.Render(200, render.String("mine"))
+	})
+	a.GET("/missing", func(c buffalo.Context) error {
+		calls++
+		return c.Render(404, render.String("missing"))
+	})
+	h := mw.ResponseHook(a)
+
+	res := get(h, "/users", map[string]string{"Accept-Language": "de"})
+	r.Equal(200, res.Code)
+	r.Equal("MISS", res.Header().Get("X-Cache"))
+	res = get(h, "/users", map[string]string{"Accept-Language": "de"})
+	r.Equal("HIT", res.Header().Get("X-Cache"))
+	r.Equal("de", res.Body.String())
+	r.Equal("de", res.Header().Get("X-Lang"))
+	r.Equal(1, calls)
+
+	// another variant
+	res = get(h, "/users", map[string]string{"Accept-Language": "lv"})
+	r.Equal("MISS", res.Header().Get("X-Cache"))
+	r.Equal("lv", res.Body.String())
+	r.Equal(2, calls)
+	res = get(h, "/users?page=2", map[string]string{"Accept-Language": "lv"})
+	r.Equal("MISS", res.Header().Get("X-Cache"))
+	r.Equal(3, calls)
+
+	r.NoError(c.Invalidate("users"))
+	res = get(h, "/users", map[string]string{"Accept-Language": "de"})
+	r.Equal("MISS", res.Header().Get("X-Cache"))
+	r.Equal(4, calls)
+
+	get(h, "/private", nil)
+	get(h, "/private", nil)
+	get(h, "/missing", nil)
+	get(h, "/missing", nil)
+	r.Equal(8, calls)
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// CompressibleTypes are the content types Compress encodes by default.
+// Entries ending in "/" match a whole family.
+var CompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/vnd.api+json",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+// Compress gzip or deflate encodes responses, depending on the client's
+// Accept-Encoding and the response's Content-Type. Streaming handlers
+// keep working: Flush flushes the encoder first, Hijack bypasses it.
+func Compress(level int, types ...string) buffalo.MiddlewareFunc {
+	if len(types) == 0 {
+		types = CompressibleTypes
+	}
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			enc := negotiateEncoding(c.Request().Header.Get("Accept-Encoding"))
+			addVary(c.Response().Header(), "Accept-Encoding")
+			if enc == "" || c.Request().Method == "HEAD" {
+				return next(c)
+			}
+
+			cw := &compressWriter{encoding: enc, level: level, types: types}
+			restore, ok := swapResponse(c, func(w http.ResponseWriter) http.ResponseWriter {
+				cw.ResponseWriter = w
+				return cw
+			})
+			if !ok {
+				return next(c)
+			}
+			defer restore()
+			defer cw.Close()
+			return next(c)
+		}
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding
+// header, honoring q-values. An empty result means identity.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name == "gzip" || name == "deflate") && q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter decides whether to encode once the status and headers
+// of the response are known.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	level       int
+	types       []string
+	wroteHeader bool
+	enc         io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && w.compressible(h.Get("Content-Type")) {
+		switch w.encoding {
+		case "gzip":
+			if gw, err := gzip.NewWriterLevel(w.ResponseWriter, w.level); err == nil {
+				w.enc = gw
+			}
+		case "deflate":
+			// HTTP's "deflate" is the zlib format, not raw deflate
+			if zw, err := zlib.NewWriterLevel(w.ResponseWriter, w.level); err == nil {
+				w.enc = zw
+			}
+		}
+		if w.enc != nil {
+			h.Set("Content-Encoding", w.encoding)
+			h.Del("Content-Length")
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) compressible(ct string) bool {
+	ct = strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+	for _, t := range w.types {
+		if ct == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(ct, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush pushes everything encoded so far to the client, for SSE.
+func (w *compressWriter) Flush() {
+	if f, ok := w.enc.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the raw connection over, e.g. to a websocket upgrade.
+// Nothing must have been written by then.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.wroteHeader {
+		return nil, nil, errors.New("can't hijack a response that has been written to")
+	}
+	w.wroteHeader = true
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("does not implement http.Hijacker")
+}
+
+func (w *compressWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Close writes the encoder's trailer.
+func (w *compressWriter) Close() error {
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package middleware_test
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func compressApp() http.Handler {
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(mw.Compress(gzip.DefaultCompression))
+	a.Use(mw.ConditionalGET)
+	a.GET("/users", func(c buffalo.Context) error {
+		return c.Render(200, render.JSON([]string{"mark", "leonids"}))
+	})
+	a.GET("/logo.png", func(c buffalo.Context) error {
+		return c.Render(200, render.Func("image/png", func(w io.Writer, d render.Data) error {
+			_, err := w.Write([]byte("\x89PNG"))
+			return err
+		}))
+	})
+	a.GET("/events", func(c buffalo.Context) error {
+		es, err := render.NewEventSource(c.Response())
+		if err != nil {
+			return err
+		}
+		return es.Write("hello", "world")
+	})
+	return mw.ResponseHook(a)
+}
+
+func get(h http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func Test_Compress(t *testing.T) {
+	r := require.New(t)
+	h := compressApp()
+
+	res := get(h, "/users", map[string]string{"Accept-Encoding": "deflate;q=0.5, gzip"})
+	r.Equal(200, res.Code)
+	r.Equal("gzip", res.Header().Get("Content-Encoding"))
+	r.Equal("Accept-Encoding", res.Header().Get("Vary"))
+
+	gr, err := gzip.NewReader(res.Body)
+	r.NoError(err)
+	b, err := ioutil.ReadAll(gr)
+	r.NoError(err)
+	r.Equal("[\"mark\",\"leonids\"]\n", string(b))
+
+	res = get(h, "/users", nil)
+	r.Empty(res.Header().Get("Content-Encoding"))
+	r.Equal("[\"mark\",\"leonids\"]\n", res.Body.String())
+
+	res = get(h, "/logo.png", map[string]string{"Accept-Encoding": "gzip"})
+	r.Empty(res.Header().Get("Content-Encoding"))
+}
+
+func Test_Compress_Streaming(t *testing.T) {
+	r := require.New(t)
+
+	res := get(compressApp(), "/events", map[string]string{"Accept-Encoding": "gzip"})
+	r.True(res.Flushed)
+	r.Equal("gzip", res.Header().Get("Content-Encoding"))
+	r.Empty(res.Header().Get("ETag"))
+
+	gr, err := gzip.NewReader(res.Body)
+	r.NoError(err)
+	b, err := ioutil.ReadAll(gr)
+	r.NoError(err)
+	r.Contains(string(b), `"type":"hello"`)
+}
+
+func Test_ConditionalGET(t *testing.T) {
+	r := require.New(t)
+	h := compressApp()
+
+	res := get(h, "/users", nil)
+	etag := res.Header().Get("ETag")
+	r.Contains(etag, `W/"`)
+
+	res = get(h, "/users", map[string]string{"If-None-Match": etag})
+	r.Equal(304, res.Code)
+	r.Equal(etag, res.Header().Get("ETag"))
+	r.Empty(res.Body.String())
+
+	res = get(h, "/users", map[string]string{"If-None-Match": `"something-else"`})
+	r.Equal(200, res.Code)
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// ConditionalGET buffers GET and HEAD responses, tags successful ones
+// with a weak ETag (unless the handler set its own) and answers 304 Not
+// Modified when the client's If-None-Match or If-Modified-Since (against
+// a handler provided Last-Modified) says it already has them.
+//
+// Responses that get flushed or hijacked (SSE, websockets) are streamed
+// as they are.
+func ConditionalGET(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		req := c.Request()
+		if req.Method != "GET" && req.Method != "HEAD" {
+			return next(c)
+		}
+
+		bw := &bufferedWriter{status: http.StatusOK}
+		restore, ok := swapResponse(c, func(w http.ResponseWriter) http.ResponseWriter {
+			bw.ResponseWriter = w
+			return bw
+		})
+		if !ok {
+			return next(c)
+		}
+
+		defer restore()
+		err := next(c)
+		if bw.streaming {
+			return err
+		}
+		if err != nil {
+			// let the error handlers write to the real response
+			return err
+		}
+
+		h := bw.Header()
+		if bw.status == http.StatusOK {
+			if h.Get("ETag") == "" && bw.buf.Len() > 0 {
+				sum := sha1.Sum(bw.buf.Bytes())
+				h.Set("ETag", `W/"`+base64.RawURLEncoding.EncodeToString(sum[:])+`"`)
+			}
+			if notModified(req, h) {
+				for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+					h.Del(k)
+				}
+				bw.ResponseWriter.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
+
+		bw.ResponseWriter.WriteHeader(bw.status)
+		if req.Method != "HEAD" {
+			_, err = bw.ResponseWriter.Write(bw.buf.Bytes())
+		}
+		return errors.WithStack(err)
+	}
+}
+
+// notModified implements the precedence rules of RFC 7232 section 6.
+func notModified(req *http.Request, h http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := h.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || weakMatch(t, etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, lm := req.Header.Get("If-Modified-Since"), h.Get("Last-Modified")
+	if ims == "" || lm == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// bufferedWriter holds the response until the handler is done, unless
+// the handler starts streaming.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	streaming   bool
+	buf         bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	if w.streaming {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.buf.Write(b)
+}
+
+// Flush switches to streaming: what was buffered goes out and every
+// later write is passed straight through.
+func (w *bufferedWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *bufferedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.streaming = true
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("does not implement http.Hijacker")
+}
+
+func (w *bufferedWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+)
+
+// CORSPolicy describes which cross-origin requests a group accepts.
+//
+// AllowedOrigins entries are either exact origins ("https://app.example.com"),
+// wildcard subdomains ("https://*.example.com") or "*" for any origin.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+var defaultCORSHeaders = []string{"Accept", "Accept-Language", "Content-Type", "Authorization", "X-Requested-With"}
+
+// CORS answers preflight requests and decorates actual requests with the
+// Access-Control-* headers of the policy. Use it first in the group, so
+// preflights never reach authentication:
+//
+//	g := app.Group("/api/v1")
+//	g.Use(mw.CORS(policy))
+//	mw.AllowPreflight(g)
+func CORS(p CORSPolicy) buffalo.MiddlewareFunc {
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = defaultCORSMethods
+	}
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = defaultCORSHeaders
+	}
+
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			req := c.Request()
+			h := c.Response().Header()
+			origin := req.Header.Get("Origin")
+			preflight := req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != ""
+
+			if !p.anyOrigin() {
+				addVary(h, "Origin")
+			}
+			if preflight {
+				addVary(h, "Access-Control-Request-Method", "Access-Control-Request-Headers")
+			}
+
+			if origin == "" || !p.allowsOrigin(origin) {
+				if preflight {
+					return c.Render(http.StatusNoContent, nil)
+				}
+				return next(c)
+			}
+
+			if p.anyOrigin() && !p.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if p.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if len(p.ExposedHeaders) > 0 {
+					h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+				}
+				return next(c)
+			}
+
+			method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+			headers := splitHeaderList(req.Header.Get("Access-Control-Request-Headers"))
+			if !containsFold(p.AllowedMethods, method) || !p.allowsHeaders(headers) {
+				h.Del("Access-Control-Allow-Origin")
+				h.Del("Access-Control-Allow-Credentials")
+				return c.Render(http.StatusNoContent, nil)
+			}
+
+			h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+			if len(headers) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			}
+			if p.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+			}
+			return c.Render(http.StatusNoContent, nil)
+		}
+	}
+}
+
+// AllowPreflight routes OPTIONS requests for every path under the group,
+// so preflights reach the CORS middleware even for routes that only
+// registered GET or POST.
+func AllowPreflight(g *buffalo.App) {
+	g.OPTIONS("/{path:.*}", func(c buffalo.Context) error {
+		c.Response().Header().Set("Allow", strings.Join(defaultCORSMethods, ", ")+", OPTIONS")
+		return c.Render(http.StatusNoContent, nil)
+	})
+}
+
+func (p CORSPolicy) anyOrigin() bool {
+	return containsFold(p.AllowedOrigins, "*")
+}
+
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin() {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, allowed := range p.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+		i := strings.Index(allowed, "://*.")
+		if i < 0 {
+			continue
+		}
+		scheme, domain := allowed[:i], allowed[i+len("://*"):]
+		if strings.EqualFold(u.Scheme, scheme) && strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CORSPolicy) allowsHeaders(headers []string) bool {
+	for _, h := range headers {
+		if !containsFold(p.AllowedHeaders, h) {
+			return false
+		}
+	}
+	return true
+}
+
+// addVary appends to the Vary header without repeating values.
+func addVary(h http.Header, values ...string) {
+	current := splitHeaderList(strings.Join(h["Vary"], ","))
+	for _, v := range values {
+		if !containsFold(current, v) {
+			current = append(current, v)
+		}
+	}
+	h.Set("Vary", strings.Join(current, ", "))
+}
+
+func splitHeaderList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
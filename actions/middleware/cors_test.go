@@ -0,0 +1,76 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func corsApp() *buffalo.App {
+	a := buffalo.Automatic(buffalo.Options{})
+	g := a.Group("/api")
+	g.Use(mw.CORS(mw.CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}))
+	g.GET("/users", func(c buffalo.Context) error {
+		return c.Render(200, render.String("users"))
+	})
+	mw.AllowPreflight(g)
+	return a
+}
+
+func corsRequest(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "/api/users", nil)
+	req.Header.Set("Origin", origin)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res := httptest.NewRecorder()
+	corsApp().ServeHTTP(res, req)
+	return res
+}
+
+func Test_CORS_Preflight(t *testing.T) {
+	r := require.New(t)
+
+	res := corsRequest("OPTIONS", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "content-type",
+	})
+	r.Equal(204, res.Code)
+	r.Equal("https://app.example.com", res.Header().Get("Access-Control-Allow-Origin"))
+	r.Equal("true", res.Header().Get("Access-Control-Allow-Credentials"))
+	r.Equal("content-type", res.Header().Get("Access-Control-Allow-Headers"))
+	r.Equal("3600", res.Header().Get("Access-Control-Max-Age"))
+	r.Equal("Origin, Access-Control-Request-Method, Access-Control-Request-Headers", res.Header().Get("Vary"))
+
+	res = corsRequest("OPTIONS", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method": "TRACE",
+	})
+	r.Equal(204, res.Code)
+	r.Empty(res.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func Test_CORS_Origins(t *testing.T) {
+	r := require.New(t)
+
+	res := corsRequest("GET", "https://api.example.org", nil)
+	r.Equal(200, res.Code)
+	r.Equal("https://api.example.org", res.Header().Get("Access-Control-Allow-Origin"))
+	r.Equal("Origin", res.Header().Get("Vary"))
+
+	for _, o := range []string{"http://api.example.org", "https://example.org", "https://evil.com"} {
+		res = corsRequest("GET", o, nil)
+		r.Equal(200, res.Code)
+		r.Empty(res.Header().Get("Access-Control-Allow-Origin"), o)
+		r.Equal("Origin", res.Header().Get("Vary"))
+	}
+}
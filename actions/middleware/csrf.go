@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/velvet"
+	"github.com/pkg/errors"
+)
+
+// CSRFKey is the session value and the context key with the CSRF token,
+// and the form field sending it back.
+const CSRFKey = "csrf_token"
+
+// CSRFHeader sends the CSRF token from scripts.
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRF keeps other sites from posting to the app on behalf of its users:
+// the requests other than GET, HEAD and OPTIONS must send the token of
+// the session, in the csrf_token field or the X-CSRF-Token header. The
+// templates get it as csrf_token, see CSRFFieldHelper.
+func CSRF(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		token, err := CSRFToken(c)
+		if err != nil {
+			return err
+		}
+
+		req := c.Request()
+		switch req.Method {
+		case "GET", "HEAD", "OPTIONS":
+			return next(c)
+		}
+		sent := req.Header.Get(CSRFHeader)
+		if sent == "" {
+			sent = req.PostFormValue(CSRFKey)
+		}
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			return c.Error(http.StatusForbidden, errors.New("the CSRF token is missing or wrong"))
+		}
+		return next(c)
+	}
+}
+
+// CSRFToken returns the CSRF token of the session, a new one if it has
+// none, and gives it to the templates.
+func CSRFToken(c buffalo.Context) (string, error) {
+	s := c.Session()
+	token, _ := s.Get(CSRFKey).(string)
+	if token == "" {
+		t, err := newNonce()
+		if err != nil {
+			return "", err
+		}
+		token = t
+		s.Set(CSRFKey, token)
+		if err := s.Save(); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	c.Set(CSRFKey, token)
+	return token, nil
+}
+
+// CSRFFieldHelper is the velvet helper for the hidden field of the CSRF
+// token, in the forms:
+//
+//	<form method="POST" action="/admin/users/1/lock">{{csrf_field}}</form>
+func CSRFFieldHelper(help velvet.HelperContext) template.HTML {
+	token, _ := help.Get(CSRFKey).(string)
+	return template.HTML(`<input type="hidden" name="` + CSRFKey + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
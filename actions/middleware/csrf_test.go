@@ -0,0 +1,66 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/gorilla/sessions"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CSRF(t *testing.T) {
+	r := require.New(t)
+
+	a := buffalo.Automatic(buffalo.Options{SessionStore: sessions.NewCookieStore([]byte("secret"))})
+	a.Use(mw.CSRF)
+	a.GET("/form", func(c buffalo.Context) error {
+		return c.Render(200, render.String(c.Value(mw.CSRFKey).(string)))
+	})
+	a.POST("/form", func(c buffalo.Context) error {
+		return c.Render(200, render.String("posted"))
+	})
+
+	send := func(req *http.Request, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		res := httptest.NewRecorder()
+		a.ServeHTTP(res, req)
+		return res
+	}
+	post := func(form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/form", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return send(req, cookies)
+	}
+
+	req, _ := http.NewRequest("GET", "/form", nil)
+	res := send(req, nil)
+	r.Equal(200, res.Code)
+	token := res.Body.String()
+	r.NotEmpty(token)
+	cookies := res.Result().Cookies()
+	r.NotEmpty(cookies)
+	// buffalo saves the session more than once, browsers keep the last
+	cookies = cookies[len(cookies)-1:]
+
+	// the token stays the same for the session
+	req, _ = http.NewRequest("GET", "/form", nil)
+	r.Equal(token, send(req, cookies).Body.String())
+
+	r.Equal(200, post(url.Values{mw.CSRFKey: {token}}, cookies).Code)
+	r.Equal(403, post(url.Values{mw.CSRFKey: {"forged"}}, cookies).Code)
+	r.Equal(403, post(url.Values{}, cookies).Code)
+	// another session has another token
+	r.Equal(403, post(url.Values{mw.CSRFKey: {token}}, nil).Code)
+
+	req, _ = http.NewRequest("POST", "/form", nil)
+	req.Header.Set(mw.CSRFHeader, token)
+	r.Equal(200, send(req, cookies).Code)
+}
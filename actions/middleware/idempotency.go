@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// IdempotencyHeader is the request header carrying the client's key.
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyOptions configures Idempotency.
+type IdempotencyOptions struct {
+	// Scope separates the keys of different clients, e.g. by API key or
+	// user. Every client shares the same keys when nil. The requests it
+	// returns "" for, of anonymous clients, aren't made idempotent: their
+	// keys would clash.
+	Scope func(buffalo.Context) string
+	// TTL of the stored responses, a day when zero.
+	TTL time.Duration
+	// MaxBody is the largest request body fingerprinted, 10MB when zero.
+	MaxBody int64
+}
+
+// idempotencyKey is the database row of a key, see the
+// create_idempotency_keys migration.
+type idempotencyKey struct {
+	ID          int       `db:"id"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+	Scope       string    `db:"scope"`
+	IdemKey     string    `db:"idem_key"`
+	Fingerprint string    `db:"fingerprint"`
+	Status      int       `db:"status"`
+	Headers     string    `db:"headers"`
+	Body        string    `db:"body"`
+}
+
+// Idempotency makes POST, PUT and PATCH requests carrying an
+// Idempotency-Key safe to retry. The first response for a key is stored
+// in the idempotency_keys table along with a fingerprint of the request,
+// and replayed, with an Idempotent-Replayed header, when the same request
+// comes again. Reusing a key for a different request is a 422.
+//
+// It must run inside Transaction: the key is stored in the request's
+// transaction, so it's committed with the work of the handler or not at
+// all. Failed requests aren't stored and may be retried. Of two
+// concurrent requests with the same key only the first one commits, the
+// other gets a 409.
+func Idempotency(o IdempotencyOptions) buffalo.MiddlewareFunc {
+	if o.TTL == 0 {
+		o.TTL = 24 * time.Hour
+	}
+	if o.MaxBody == 0 {
+		o.MaxBody = 10 << 20
+	}
+
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			req := c.Request()
+			key := req.Header.Get(IdempotencyHeader)
+			if key == "" || (req.Method != "POST" && req.Method != "PUT" && req.Method != "PATCH") {
+				return next(c)
+			}
+			if len(key) > 255 {
+				return c.Error(http.StatusBadRequest, errors.New("Idempotency-Key is too long"))
+			}
+			scope := ""
+			if o.Scope != nil {
+				if scope = o.Scope(c); scope == "" {
+					return next(c)
+				}
+			}
+			tx, ok := c.Value("tx").(*pop.Connection)
+			if !ok {
+				return errors.New("Idempotency must run inside Transaction")
+			}
+
+			body, err := ioutil.ReadAll(io.LimitReader(req.Body, o.MaxBody+1))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if int64(len(body)) > o.MaxBody {
+				return c.Error(http.StatusRequestEntityTooLarge, errors.New("the body is too large"))
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			fp := fingerprint(req, body)
+
+			rec := &idempotencyKey{}
+			err = tx.Where("scope = ? AND idem_key = ?", scope, key).First(rec)
+			switch {
+			case errors.Cause(err) == sql.ErrNoRows:
+			case err != nil:
+				return errors.WithStack(err)
+			case time.Since(rec.CreatedAt) > o.TTL:
+				if err := tx.Destroy(rec); err != nil {
+					return errors.WithStack(err)
+				}
+			case rec.Fingerprint != fp:
+				return c.Error(http.StatusUnprocessableEntity, errors.New("Idempotency-Key was already used for a different request"))
+			default:
+				return replay(c, rec)
+			}
+
+			before := cloneHeader(c.Response().Header())
+			bw := &bufferedWriter{status: http.StatusOK}
+			restore, ok := swapResponse(c, func(w http.ResponseWriter) http.ResponseWriter {
+				bw.ResponseWriter = w
+				return bw
+			})
+			if !ok {
+				c.Logger().Warn("Idempotency needs the app to be served through ResponseHook")
+				return next(c)
+			}
+			defer restore()
+			err = next(c)
+			if err != nil || bw.streaming {
+				return err
+			}
+
+			headers, err := json.Marshal(changedHeaders(before, bw.Header()))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			rec = &idempotencyKey{
+				Scope:       scope,
+				IdemKey:     key,
+				Fingerprint: fp,
+				Status:      bw.status,
+				Headers:     string(headers),
+				Body:        base64.StdEncoding.EncodeToString(bw.buf.Bytes()),
+			}
+			if err := tx.Create(rec); err != nil {
+				if strings.Contains(strings.ToLower(err.Error()), "unique") {
+					return c.Error(http.StatusConflict, errors.New("a request with the same Idempotency-Key is in progress"))
+				}
+				return errors.WithStack(err)
+			}
+
+			bw.ResponseWriter.WriteHeader(bw.status)
+			_, err = bw.ResponseWriter.Write(bw.buf.Bytes())
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// fingerprint identifies a request: method, path, query and body.
+func fingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, req.Method+" "+req.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(c buffalo.Context, rec *idempotencyKey) error {
+	headers := http.Header{}
+	if err := json.Unmarshal([]byte(rec.Headers), &headers); err != nil {
+		return errors.WithStack(err)
+	}
+	body, err := base64.StdEncoding.DecodeString(rec.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	h := c.Response().Header()
+	for k, v := range headers {
+		h[k] = v
+	}
+	h.Set("Idempotent-Replayed", "true")
+	c.Response().WriteHeader(rec.Status)
+	_, err = c.Response().Write(body)
+	return errors.WithStack(err)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := http.Header{}
+	for k, v := range h {
+		c[k] = append([]string{}, v...)
+	}
+	return c
+}
+
+// changedHeaders are the headers the handler set. The ones set by the
+// middleware around it are set again on replays anyway.
+func changedHeaders(before, after http.Header) http.Header {
+	changed := http.Header{}
+	for k, v := range after {
+		if strings.Join(before[k], "\n") != strings.Join(v, "\n") {
+			changed[k] = v
+		}
+	}
+	return changed
+}
@@ -0,0 +1,96 @@
+package middleware_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/middleware"
+	"github.com/gobuffalo/buffalo/render"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Idempotency(t *testing.T) {
+	r := require.New(t)
+	created := 0
+
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(mw.Recover)
+	a.Use(middleware.PopTransaction(modelstest.SQLite(t)))
+	a.Use(mw.Idempotency(mw.IdempotencyOptions{
+		Scope: func(c buffalo.Context) string { return c.Request().Header.Get("X-API-Key") },
+	}))
+	a.POST("/users", func(c buffalo.Context) error {
+		if c.Request().URL.Query().Get("fail") != "" {
+			return errors.New("boom")
+		}
+		if c.Request().URL.Query().Get("panic") != "" {
+			panic("boom")
+		}
+		created++
+		c.Response().Header().Set("Location", "/users/1")
+		return c.Render(201, render.JSON(map[string]int{"created": created}))
+	})
+	h := mw.ResponseHook(a)
+
+	post := func(path, key, apiKey, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", apiKey)
+		if key != "" {
+			req.Header.Set(mw.IdempotencyHeader, key)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	res := post("/users", "abc", "mobile", `{"name":"mark"}`)
+	r.Equal(201, res.Code)
+	r.Equal(`{"created":1}`+"\n", res.Body.String())
+
+	res = post("/users", "abc", "mobile", `{"name":"mark"}`)
+	r.Equal(201, res.Code)
+	r.Equal("true", res.Header().Get("Idempotent-Replayed"))
+	r.Equal("/users/1", res.Header().Get("Location"))
+	r.Equal("application/json", res.Header().Get("Content-Type"))
+	r.Equal(`{"created":1}`+"\n", res.Body.String())
+	r.Equal(1, created)
+
+	res = post("/users", "abc", "mobile", `{"name":"leonids"}`)
+	r.Equal(422, res.Code)
+
+	// keys are per client
+	res = post("/users", "abc", "web", `{"name":"leonids"}`)
+	r.Equal(201, res.Code)
+	r.Equal(2, created)
+
+	// failures aren't stored
+	res = post("/users?fail=1", "def", "mobile", `{}`)
+	r.Equal(500, res.Code)
+	res = post("/users", "def", "mobile", `{}`)
+	r.Equal(201, res.Code)
+	r.Empty(res.Header().Get("Idempotent-Replayed"))
+
+	// a panic gets to the error page, not an empty response
+	res = post("/users?panic=1", "ghi", "mobile", `{}`)
+	r.Equal(500, res.Code)
+	r.NotEmpty(res.Body.String())
+
+	res = post("/users", "", "mobile", `{"name":"mark"}`)
+	r.Equal(201, res.Code)
+	r.Equal(4, created)
+
+	// anonymous clients have no keys of their own
+	res = post("/users", "abc", "", `{"name":"mark"}`)
+	r.Equal(201, res.Code)
+	res = post("/users", "abc", "", `{"name":"mark"}`)
+	r.Equal(201, res.Code)
+	r.Empty(res.Header().Get("Idempotent-Replayed"))
+	r.Equal(6, created)
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// Limit describes a token bucket: Requests tokens are refilled every Per,
+// and at most Burst tokens (defaults to Requests) can be spent at once.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// rate is the number of tokens refilled per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitKeyFunc identifies the client a request is counted against.
+// An empty key means "can't tell", so the next key func is tried.
+type RateLimitKeyFunc func(buffalo.Context) string
+
+// RateLimiter is a token bucket rate limiting middleware. Clients are
+// identified by the first non empty key returned by Keys, requests are
+// counted against Limit unless a per-route limit was registered with Route.
+//
+//	rl := mw.NewRateLimiter(mw.NewMemoryRateLimitStore(), mw.Limit{Requests: 60, Per: time.Minute})
+//	g.Use(rl.Middleware)
+//	rl.Route(g.POST("/expensive", h), mw.Limit{Requests: 5, Per: time.Minute})
+type RateLimiter struct {
+	Store RateLimitStore
+	Limit Limit
+	Keys  []RateLimitKeyFunc
+
+	moot   *sync.RWMutex
+	routes map[string]Limit
+}
+
+// NewRateLimiter returns a limiter backed by the given store. Clients are
+// keyed by their IP address unless Keys is changed.
+func NewRateLimiter(store RateLimitStore, limit Limit) *RateLimiter {
+	return &RateLimiter{
+		Store:  store,
+		Limit:  limit,
+		Keys:   []RateLimitKeyFunc{RateLimitByIP()},
+		moot:   &sync.RWMutex{},
+		routes: map[string]Limit{},
+	}
+}
+
+// Route overrides the limit for a single route, e.g. the one returned
+// by g.GET(...). The route gets its own bucket per client.
+func (rl *RateLimiter) Route(ri buffalo.RouteInfo, l Limit) {
+	rl.moot.Lock()
+	defer rl.moot.Unlock()
+	rl.routes[ri.Method+" "+ri.Path] = l
+}
+
+func (rl *RateLimiter) limitFor(c buffalo.Context) (string, Limit) {
+	ri, ok := c.Value("current_route").(buffalo.RouteInfo)
+	if !ok {
+		return "", rl.Limit
+	}
+	key := ri.Method + " " + ri.Path
+
+	rl.moot.RLock()
+	defer rl.moot.RUnlock()
+	if l, ok := rl.routes[key]; ok {
+		return key, l
+	}
+	return "", rl.Limit
+}
+
+func (rl *RateLimiter) clientKey(c buffalo.Context) string {
+	for _, fn := range rl.Keys {
+		if k := fn(c); k != "" {
+			return k
+		}
+	}
+	return "anonymous"
+}
+
+// Middleware takes a token for the current client and rejects the
+// request with a 429 once the bucket is empty. RateLimit-* headers are
+// set on every response, Retry-After on rejected ones.
+func (rl *RateLimiter) Middleware(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		route, limit := rl.limitFor(c)
+		key := rl.clientKey(c)
+		if route != "" {
+			key = key + "|" + route
+		}
+
+		res, err := rl.Store.Take(key, limit, time.Now())
+		if err != nil {
+			// don't lock everybody out because the store is down
+			c.Logger().Errorf("rate limit store: %+v", err)
+			return next(c)
+		}
+
+		h := c.Response().Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.LogField("rate_limited", key)
+			return c.Error(http.StatusTooManyRequests, errors.Errorf("rate limit exceeded for %s", key))
+		}
+		return next(c)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// RateLimitByHeader keys clients by an API key sent in the given header,
+// when valid tells it's one: made up keys fall through to the next
+// RateLimitKeyFunc rather than getting a fresh bucket each. The key is
+// hashed, it's a secret.
+func RateLimitByHeader(name string, valid func(key string) bool) RateLimitKeyFunc {
+	return func(c buffalo.Context) string {
+		v := c.Request().Header.Get(name)
+		if v == "" || !valid(v) {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(v))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// RateLimitByUser keys clients by the ID of the signed in user, as found
+// by the given lookup.
+func RateLimitByUser(userID func(buffalo.Context) string) RateLimitKeyFunc {
+	return func(c buffalo.Context) string {
+		if id := userID(c); id != "" {
+			return "user:" + id
+		}
+		return ""
+	}
+}
+
+// RateLimitByIP keys clients by their IP address. X-Forwarded-For is only
+// honored when the request comes from one of the trusted proxies (CIDRs
+// or plain IPs).
+func RateLimitByIP(trustedProxies ...string) RateLimitKeyFunc {
+	nets := parseCIDRs(trustedProxies)
+	return func(c buffalo.Context) string {
+		return "ip:" + ClientIP(c.Request(), nets)
+	}
+}
+
+// ClientIP returns the address of the client that sent r. The
+// X-Forwarded-For chain is walked from the right, skipping trusted proxies.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, s := range cidrs {
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(fmt.Sprintf("invalid trusted proxy %q: %s", s, err))
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// RateLimitStore keeps token buckets. Implementations must make Take
+// atomic per key.
+type RateLimitStore interface {
+	Take(key string, l Limit, now time.Time) (RateLimitResult, error)
+}
+
+// bucket is the state of a single token bucket.
+type bucket struct {
+	Tokens  float64
+	TakenAt time.Time
+}
+
+// take refills the bucket for the time elapsed since the last take and
+// tries to spend a single token.
+func (b *bucket) take(l Limit, now time.Time) RateLimitResult {
+	capacity, rate := l.capacity(), l.rate()
+	if b.TakenAt.IsZero() {
+		b.Tokens = capacity
+	} else if elapsed := now.Sub(b.TakenAt).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed*rate)
+	}
+	b.TakenAt = now
+
+	res := RateLimitResult{Limit: int(capacity)}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.Tokens) / rate)
+	}
+	res.Remaining = int(math.Floor(b.Tokens))
+	res.Reset = seconds((capacity - b.Tokens) / rate)
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// MemoryRateLimitStore keeps buckets in process memory. Limits are
+// per instance, use PopRateLimitStore to share them.
+type MemoryRateLimitStore struct {
+	moot    *sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		moot:    &sync.Mutex{},
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Take implements RateLimitStore.
+func (s *MemoryRateLimitStore) Take(key string, l Limit, now time.Time) (RateLimitResult, error) {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{}
+		s.buckets[key] = b
+	}
+	res := b.take(l, now)
+	s.sweep(l, now)
+	return res, nil
+}
+
+// sweep drops buckets that are full again once in a while, so the map
+// doesn't grow with every client ever seen.
+func (s *MemoryRateLimitStore) sweep(l Limit, now time.Time) {
+	if len(s.buckets) < 10000 {
+		return
+	}
+	for k, b := range s.buckets {
+		if now.Sub(b.TakenAt) > l.Per {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+// rateBucket is the database row of a bucket, see the
+// create_rate_buckets migration.
+type rateBucket struct {
+	ID        int       `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	BucketKey string    `db:"bucket_key"`
+	Tokens    float64   `db:"tokens"`
+	TakenAt   time.Time `db:"taken_at"`
+}
+
+// PopRateLimitStore keeps buckets in the rate_buckets table, so every
+// instance of the app shares the same limits.
+type PopRateLimitStore struct {
+	DB *pop.Connection
+}
+
+// NewPopRateLimitStore returns a store backed by db.
+func NewPopRateLimitStore(db *pop.Connection) *PopRateLimitStore {
+	return &PopRateLimitStore{DB: db}
+}
+
+// Take implements RateLimitStore. A new bucket is inserted full before
+// its row is locked for the duration of the transaction, so instances
+// taking the same new key wait on each other (SQLite serializes writers
+// on its own).
+func (s *PopRateLimitStore) Take(key string, l Limit, now time.Time) (RateLimitResult, error) {
+	var res RateLimitResult
+	err := s.DB.Transaction(func(tx *pop.Connection) error {
+		err := tx.RawQuery(`INSERT INTO rate_buckets (created_at, updated_at, bucket_key, tokens, taken_at)
+			VALUES (?, ?, ?, ?, ?) ON CONFLICT (bucket_key) DO NOTHING`, now, now, key, l.capacity(), now).Exec()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		q := "SELECT * FROM rate_buckets WHERE bucket_key = ?"
+		if tx.Dialect.Details().Dialect != "sqlite3" {
+			q += " FOR UPDATE"
+		}
+
+		row := &rateBucket{}
+		if err := tx.RawQuery(q, key).First(row); err != nil {
+			return errors.WithStack(err)
+		}
+
+		b := &bucket{Tokens: row.Tokens, TakenAt: row.TakenAt}
+		res = b.take(l, now)
+
+		row.Tokens = b.Tokens
+		row.TakenAt = b.TakenAt
+		return errors.WithStack(tx.Update(row))
+	})
+	return res, err
+}
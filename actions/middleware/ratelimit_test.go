@@ -0,0 +1,106 @@
+package middleware_test
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/markbates/willie"
+	"github.com/stretchr/testify/require"
+)
+
+func rlApp() *buffalo.App {
+	h := func(c buffalo.Context) error {
+		return c.Render(200, render.String("ok"))
+	}
+	rl := mw.NewRateLimiter(mw.NewMemoryRateLimitStore(), mw.Limit{Requests: 2, Per: time.Minute})
+	keys := mw.NewAPIKeys("secret")
+	rl.Keys = []mw.RateLimitKeyFunc{mw.RateLimitByHeader("X-API-Key", keys.Valid), mw.RateLimitByIP()}
+
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(rl.Middleware)
+	a.GET("/", h)
+	rl.Route(a.GET("/strict", h), mw.Limit{Requests: 1, Per: time.Hour})
+	return a
+}
+
+func Test_RateLimiter(t *testing.T) {
+	r := require.New(t)
+	w := willie.New(rlApp())
+
+	res := w.Request("/").Get()
+	r.Equal(200, res.Code)
+	r.Equal("2", res.Header().Get("RateLimit-Limit"))
+	r.Equal("1", res.Header().Get("RateLimit-Remaining"))
+
+	r.Equal(200, w.Request("/").Get().Code)
+
+	res = w.Request("/").Get()
+	r.Equal(429, res.Code)
+	r.Equal("0", res.Header().Get("RateLimit-Remaining"))
+	r.Equal("30", res.Header().Get("Retry-After"))
+
+	// an API key gets its own bucket
+	req := w.Request("/")
+	req.Headers["X-API-Key"] = "secret"
+	r.Equal(200, req.Get().Code)
+
+	// a made up one is limited by IP
+	for _, key := range []string{"made up", "another one"} {
+		req = w.Request("/")
+		req.Headers["X-API-Key"] = key
+		r.Equal(429, req.Get().Code)
+	}
+}
+
+func Test_RateLimiter_Route(t *testing.T) {
+	r := require.New(t)
+	w := willie.New(rlApp())
+
+	r.Equal(200, w.Request("/strict").Get().Code)
+	r.Equal(429, w.Request("/strict").Get().Code)
+
+	// the default bucket is untouched
+	r.Equal(200, w.Request("/").Get().Code)
+}
+
+func Test_PopRateLimitStore(t *testing.T) {
+	r := require.New(t)
+	store := mw.NewPopRateLimitStore(modelstest.SQLite(t))
+	l := mw.Limit{Requests: 2, Per: time.Minute}
+	now := time.Now()
+
+	for i, allowed := range []bool{true, true, false} {
+		res, err := store.Take("ip:1.2.3.4", l, now)
+		r.NoError(err)
+		r.Equal(allowed, res.Allowed, "take %d", i)
+	}
+
+	res, err := store.Take("ip:5.6.7.8", l, now)
+	r.NoError(err)
+	r.True(res.Allowed)
+	r.Equal(1, res.Remaining)
+
+	res, err = store.Take("ip:1.2.3.4", l, now.Add(30*time.Second))
+	r.NoError(err)
+	r.True(res.Allowed)
+}
+
+func Test_ClientIP(t *testing.T) {
+	r := require.New(t)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+	r.Equal("10.0.0.1", mw.ClientIP(req, nil))
+
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	_, lb, _ := net.ParseCIDR("5.6.7.8/32")
+	r.Equal("5.6.7.8", mw.ClientIP(req, []*net.IPNet{proxies}))
+	r.Equal("1.2.3.4", mw.ClientIP(req, []*net.IPNet{proxies, lb}))
+}
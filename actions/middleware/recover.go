@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// Recover turns a panicking handler into a 500. The stack trace is
+// logged through the request's logger, so it carries the request_id set
+// by buffalo's RequestLogger.
+//
+// Use it first, so it also covers the middleware. Transaction rolls
+// back on the way out, buffalo's PopTransaction doesn't.
+func Recover(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) (err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			c.Logger().WithField("stack", string(debug.Stack())).Errorf("panic: %v", r)
+			err = c.Error(http.StatusInternalServerError, errors.Errorf("panic: %v", r))
+		}()
+		return next(c)
+	}
+}
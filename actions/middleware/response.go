@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+type responseHookKey struct{}
+
+// hookedWriter sits underneath buffalo's own response wrapper. Buffalo
+// doesn't let middleware replace the writer of a context, so middleware
+// swaps the writer inside the hook instead (see swapResponse).
+type hookedWriter struct {
+	http.ResponseWriter
+}
+
+func (w *hookedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *hookedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("does not implement http.Hijacker")
+}
+
+func (w *hookedWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// ResponseHook must wrap the app for the middleware that rewrites
+// responses (Compress, ConditionalGET, ...) to do anything:
+//
+//	http.ListenAndServe(":3000", mw.ResponseHook(actions.App()))
+func ResponseHook(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &hookedWriter{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), responseHookKey{}, hw)
+		h.ServeHTTP(hw, r.WithContext(ctx))
+	})
+}
+
+// swapResponse replaces the writer everything in the request writes to
+// with the one returned by wrap. The returned func puts the previous
+// writer back. ok is false when the app isn't served through ResponseHook.
+func swapResponse(c buffalo.Context, wrap func(http.ResponseWriter) http.ResponseWriter) (restore func(), ok bool) {
+	hw, ok := c.Value(responseHookKey{}).(*hookedWriter)
+	if !ok {
+		return func() {}, false
+	}
+	prev := hw.ResponseWriter
+	hw.ResponseWriter = wrap(prev)
+	return func() { hw.ResponseWriter = prev }, true
+}
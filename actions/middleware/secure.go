@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/velvet"
+)
+
+// CSPNonceKey is the context key holding the CSP nonce of the request.
+const CSPNonceKey = "csp_nonce"
+
+// SecureOptions configures SecureHeaders. Every occurrence of {nonce} in
+// ContentSecurityPolicy is replaced with a fresh nonce per request.
+type SecureOptions struct {
+	ContentSecurityPolicy string
+	CSPReportOnly         bool
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	FrameOptions          string
+	ReferrerPolicy        string
+	NoSniff               bool
+}
+
+// DefaultSecureOptions returns the headers we want for env. Outside of
+// production the policy is only reported (buffalo's dev error pages use
+// inline styles) and HSTS is off, so localhost isn't pinned to https.
+func DefaultSecureOptions(env, reportURI string) SecureOptions {
+	csp := []string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-{nonce}'",
+		"style-src 'self' 'nonce-{nonce}'",
+		"img-src 'self' data:",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"frame-ancestors 'none'",
+		"form-action 'self'",
+	}
+	if reportURI != "" {
+		csp = append(csp, "report-uri "+reportURI)
+	}
+
+	o := SecureOptions{
+		ContentSecurityPolicy: strings.Join(csp, "; "),
+		CSPReportOnly:         true,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+		NoSniff:               true,
+	}
+	if env == "production" {
+		o.CSPReportOnly = false
+		o.HSTSMaxAge = 365 * 24 * time.Hour
+		o.HSTSIncludeSubdomains = true
+	}
+	return o
+}
+
+// SecureHeaders sets the security related response headers and makes
+// the request's CSP nonce available to templates as csp_nonce.
+func SecureHeaders(o SecureOptions) buffalo.MiddlewareFunc {
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			h := c.Response().Header()
+
+			if o.ContentSecurityPolicy != "" {
+				nonce, err := newNonce()
+				if err != nil {
+					return err
+				}
+				c.Set(CSPNonceKey, nonce)
+
+				name := "Content-Security-Policy"
+				if o.CSPReportOnly {
+					name += "-Report-Only"
+				}
+				h.Set(name, strings.Replace(o.ContentSecurityPolicy, "{nonce}", nonce, -1))
+			}
+			if o.HSTSMaxAge > 0 {
+				v := fmt.Sprintf("max-age=%d", int(o.HSTSMaxAge.Seconds()))
+				if o.HSTSIncludeSubdomains {
+					v += "; includeSubDomains"
+				}
+				h.Set("Strict-Transport-Security", v)
+			}
+			if o.FrameOptions != "" {
+				h.Set("X-Frame-Options", o.FrameOptions)
+			}
+			if o.ReferrerPolicy != "" {
+				h.Set("Referrer-Policy", o.ReferrerPolicy)
+			}
+			if o.NoSniff {
+				h.Set("X-Content-Type-Options", "nosniff")
+			}
+			return next(c)
+		}
+	}
+}
+
+// CSPNonceHelper is the velvet helper for the request's nonce:
+//
+//	<script nonce="{{csp_nonce}}" src="/assets/application.js"></script>
+func CSPNonceHelper(help velvet.HelperContext) string {
+	n, _ := help.Get(CSPNonceKey).(string)
+	return n
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
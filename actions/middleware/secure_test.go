@@ -0,0 +1,81 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func secureRequest(o mw.SecureOptions) *httptest.ResponseRecorder {
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(mw.SecureHeaders(o))
+	a.GET("/", func(c buffalo.Context) error {
+		n, _ := c.Value(mw.CSPNonceKey).(string)
+		return c.Render(200, render.String(n))
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+	a.ServeHTTP(res, req)
+	return res
+}
+
+func Test_SecureHeaders_Production(t *testing.T) {
+	r := require.New(t)
+
+	res := secureRequest(mw.DefaultSecureOptions("production", "/csp-report"))
+	r.Equal(200, res.Code)
+	r.Equal("DENY", res.Header().Get("X-Frame-Options"))
+	r.Equal("strict-origin-when-cross-origin", res.Header().Get("Referrer-Policy"))
+	r.Equal("nosniff", res.Header().Get("X-Content-Type-Options"))
+	r.Equal("max-age=31536000; includeSubDomains", res.Header().Get("Strict-Transport-Security"))
+	r.Empty(res.Header().Get("Content-Security-Policy-Report-Only"))
+
+	csp := res.Header().Get("Content-Security-Policy")
+	r.Contains(csp, "default-src 'self'")
+	r.Contains(csp, "report-uri /csp-report")
+	r.NotContains(csp, "{nonce}")
+
+	nonce := res.Body.String()
+	r.NotEmpty(nonce)
+	r.Contains(csp, "script-src 'self' 'nonce-"+nonce+"'")
+	r.Contains(csp, "style-src 'self' 'nonce-"+nonce+"'")
+}
+
+func Test_SecureHeaders_Development(t *testing.T) {
+	r := require.New(t)
+
+	res := secureRequest(mw.DefaultSecureOptions("development", ""))
+	r.Empty(res.Header().Get("Strict-Transport-Security"))
+	r.Empty(res.Header().Get("Content-Security-Policy"))
+
+	csp := res.Header().Get("Content-Security-Policy-Report-Only")
+	r.NotEmpty(csp)
+	r.NotContains(csp, "report-uri")
+}
+
+func Test_SecureHeaders_Fresh_Nonce(t *testing.T) {
+	r := require.New(t)
+
+	o := mw.DefaultSecureOptions("production", "")
+	first := secureRequest(o).Body.String()
+	second := secureRequest(o).Body.String()
+	r.NotEqual(first, second)
+}
+
+func Test_SecureHeaders_Empty_Options(t *testing.T) {
+	r := require.New(t)
+
+	res := secureRequest(mw.SecureOptions{})
+	r.Equal(200, res.Code)
+	r.Empty(res.Body.String())
+	for _, h := range []string{"Content-Security-Policy", "Strict-Transport-Security", "X-Frame-Options", "Referrer-Policy", "X-Content-Type-Options"} {
+		r.Empty(res.Header().Get(h), h)
+	}
+	r.Empty(res.Header().Get("Content-Security-Policy-Report-Only"))
+}
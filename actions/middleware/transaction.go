@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// Transaction is buffalo's PopTransaction that also rolls back when the
+// handler panics: the request runs in a transaction, as tx, committed
+// when it returns no error. The panic goes on to Recover. The functions
+// of AfterCommit run once it's committed.
+func Transaction(db *pop.Connection) buffalo.MiddlewareFunc {
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			var committed []func() error
+			err := db.Dialect.Lock(func() error {
+				tx, err := db.NewTransaction()
+				if err != nil {
+					return err
+				}
+				trackCommit(tx)
+				defer untrackCommit(tx)
+				start := tx.Elapsed
+				defer func() {
+					c.LogField("db", time.Duration(tx.Elapsed-start))
+				}()
+				defer func() {
+					if r := recover(); r != nil {
+						tx.TX.Rollback()
+						panic(r)
+					}
+				}()
+
+				c.Set("tx", tx)
+				if err := next(c); err != nil {
+					if rerr := tx.TX.Rollback(); rerr != nil {
+						c.Logger().Error(errors.Wrap(rerr, "couldn't roll back the transaction"))
+					}
+					return err
+				}
+				if err := tx.TX.Commit(); err != nil {
+					return errors.Wrap(err, "couldn't commit the transaction")
+				}
+				committed = untrackCommit(tx)
+				return nil
+			})
+			for _, f := range committed {
+				if err := f(); err != nil {
+					c.Logger().Errorf("after commit: %+v", err)
+				}
+			}
+			return err
+		}
+	}
+}
+
+// commitHooks are the functions of AfterCommit, by transaction.
+var commitHooks = struct {
+	sync.Mutex
+	m map[*pop.Connection][]func() error
+}{m: map[*pop.Connection][]func() error{}}
+
+// AfterCommit runs f once tx, a transaction of Transaction, is
+// committed, and not at all when it's rolled back; the error of f is
+// logged then. Any other tx isn't tracked, f runs right away.
+func AfterCommit(tx *pop.Connection, f func() error) error {
+	commitHooks.Lock()
+	hooks, ok := commitHooks.m[tx]
+	if ok {
+		commitHooks.m[tx] = append(hooks, f)
+	}
+	commitHooks.Unlock()
+	if ok {
+		return nil
+	}
+	return f()
+}
+
+func trackCommit(tx *pop.Connection) {
+	commitHooks.Lock()
+	defer commitHooks.Unlock()
+	commitHooks.m[tx] = nil
+}
+
+// untrackCommit returns the functions to run after tx, and forgets it.
+func untrackCommit(tx *pop.Connection) []func() error {
+	commitHooks.Lock()
+	defer commitHooks.Unlock()
+	hooks := commitHooks.m[tx]
+	delete(commitHooks.m, tx)
+	return hooks
+}
@@ -0,0 +1,70 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Transaction(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	keys := func() []string {
+		var k []string
+		r.NoError(db.RawQuery(`SELECT idem_key FROM idempotency_keys`).All(&k))
+		return k
+	}
+
+	a := buffalo.Automatic(buffalo.Options{})
+	a.Use(mw.Recover)
+	a.Use(mw.Transaction(db))
+	var after []string
+	a.GET("/{outcome}", func(c buffalo.Context) error {
+		tx := c.Value("tx").(*pop.Connection)
+		r.NoError(mw.AfterCommit(tx, func() error {
+			// committed already
+			r.Contains(keys(), c.Param("outcome"))
+			after = append(after, c.Param("outcome"))
+			return nil
+		}))
+		err := tx.RawQuery(`INSERT INTO idempotency_keys
+			(created_at, updated_at, scope, idem_key, fingerprint, status, headers, body)
+			VALUES (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, '', ?, '', 200, '', '')`, c.Param("outcome")).Exec()
+		r.NoError(err)
+
+		switch c.Param("outcome") {
+		case "fail":
+			return errors.New("boom")
+		case "panic":
+			panic("boom")
+		}
+		return c.Render(200, render.String("ok"))
+	})
+
+	for path, status := range map[string]int{"/ok": 200, "/fail": 500, "/panic": 500} {
+		req, _ := http.NewRequest("GET", path, nil)
+		res := httptest.NewRecorder()
+		a.ServeHTTP(res, req)
+		r.Equal(status, res.Code, path)
+	}
+	r.Equal([]string{"ok"}, keys())
+	r.Equal([]string{"ok"}, after)
+
+	// outside of Transaction it runs right away
+	ran := false
+	r.NoError(mw.AfterCommit(db, func() error {
+		ran = true
+		return nil
+	}))
+	r.True(ran)
+	r.Error(mw.AfterCommit(db, func() error { return errors.New("boom") }))
+}
@@ -0,0 +1,43 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/jsonapi"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/models"
+)
+
+// api collects the documentation of every route, see initRoutes.
+var api = openapi.NewRegistry()
+
+// OpenAPI returns the OpenAPI 3 document of the app.
+func OpenAPI() *openapi.Document {
+	a := App()
+	return api.Generate(openapi.Info{
+		Title:   "Test Buffalo",
+		Version: "1.0.0",
+	}, a.Host, a.Routes())
+}
+
+// OpenAPIHandler serves the OpenAPI document.
+func OpenAPIHandler(c buffalo.Context) error {
+	return c.Render(200, r.JSON(OpenAPI()))
+}
+
+func documentUsersResource() {
+	tags := []string{"users"}
+	list := []openapi.Parameter{{Name: "q"}, {Name: "sort"}, {Name: "fields[users]"}, {Name: "page[cursor]"}, {Name: "page[size]"}}
+	api.Describe("GET", "/users", openapi.Operation{Summary: "List users", Description: "q searches the users by the beginnings of the words of their name and email, the best matches first unless sorted.", Tags: tags, Query: list, ContentType: jsonapi.MediaType, Response: jsonapi.Document{}})
+	api.Describe("GET", "/users/new", openapi.Operation{Summary: "New user form", Tags: tags, ContentType: "text/html", Response: ""})
+	api.Describe("GET", "/users/{user_id}", openapi.Operation{Summary: "Show a user", Tags: tags, ContentType: jsonapi.MediaType, Response: jsonapi.Document{}})
+	api.Describe("GET", "/users/{user_id}/edit", openapi.Operation{Summary: "Edit user form", Tags: tags, ContentType: "text/html", Response: ""})
+	api.Describe("POST", "/users", openapi.Operation{Summary: "Create a user", Description: "For the admins.", Tags: tags, ContentType: jsonapi.MediaType, Request: models.User{}, Response: jsonapi.Document{}, Status: 201})
+	api.Describe("PUT", "/users/{user_id}", openapi.Operation{Summary: "Update a user", Description: "For the user and the admins. A multipart/form-data body may carry an avatar picture, a JPEG, PNG or GIF up to 5 MB.", Tags: tags, ContentType: jsonapi.MediaType, Request: models.User{}, Response: jsonapi.Document{}})
+	api.Describe("DELETE", "/users/{user_id}", openapi.Operation{Summary: "Delete a user", Description: "For the user and the admins.", Tags: tags, Status: 204})
+}
+
+// Undocumented lists the routes that are missing from the OpenAPI
+// document without being skipped on purpose.
+func Undocumented() buffalo.RouteList {
+	return api.Undocumented(App().Routes())
+}
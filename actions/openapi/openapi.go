@@ -0,0 +1,263 @@
+// Package openapi builds an OpenAPI 3 document out of the routes of a
+// buffalo.App. Routes are annotated when they are registered:
+//
+//	api.Document(g.GET("/users/{user_id}", h), openapi.Operation{
+//		Summary:  "Show a user",
+//		Response: models.User{},
+//	})
+//
+// Path parameters are inferred from the {name} segments of the route,
+// schemas from the Go types of Request and Response.
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gobuffalo/buffalo"
+)
+
+// Operation describes a single route.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Query lists the query string parameters the route understands.
+	Query []Parameter
+	// Request and Response are (zero) values of the body types, e.g.
+	// models.User{} or []models.User{}. Response may be nil for routes
+	// without a body.
+	Request  interface{}
+	Response interface{}
+	// Status of a successful response, 200 by default.
+	Status int
+	// ContentType of the bodies, application/json by default.
+	ContentType string
+}
+
+// Parameter is a path or query parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Registry collects the operations of an app.
+type Registry struct {
+	moot    *sync.RWMutex
+	ops     map[string]Operation
+	skipped map[string]bool
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		moot:    &sync.RWMutex{},
+		ops:     map[string]Operation{},
+		skipped: map[string]bool{},
+	}
+}
+
+func routeKey(method, path string) string {
+	return method + " " + path
+}
+
+// Document annotates the route just registered and hands it back.
+func (r *Registry) Document(ri buffalo.RouteInfo, op Operation) buffalo.RouteInfo {
+	r.Describe(ri.Method, ri.Path, op)
+	return ri
+}
+
+// Describe annotates a route by method and full path, for routes that
+// aren't registered one by one, like the ones of app.Resource.
+func (r *Registry) Describe(method, path string, op Operation) {
+	r.moot.Lock()
+	defer r.moot.Unlock()
+	r.ops[routeKey(method, path)] = op
+}
+
+// Skip leaves a route out of the document on purpose (internal
+// endpoints, preflight catch-alls, ...).
+func (r *Registry) Skip(method, path string) {
+	r.moot.Lock()
+	defer r.moot.Unlock()
+	r.skipped[routeKey(method, path)] = true
+}
+
+// Undocumented returns the routes that were neither documented nor
+// skipped.
+func (r *Registry) Undocumented(routes buffalo.RouteList) buffalo.RouteList {
+	r.moot.RLock()
+	defer r.moot.RUnlock()
+	list := buffalo.RouteList{}
+	for _, ri := range routes {
+		k := routeKey(ri.Method, ri.Path)
+		if _, ok := r.ops[k]; !ok && !r.skipped[k] {
+			list = append(list, ri)
+		}
+	}
+	return list
+}
+
+// Info is the info object of the document.
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Document is an OpenAPI 3 document. Only the parts we generate are
+// modeled.
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Servers    []map[string]string             `json:"servers,omitempty"`
+	Paths      map[string]map[string]*PathItem `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// PathItem is a single operation of the document.
+type PathItem struct {
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	OperationID string               `json:"operationId"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *body                `json:"requestBody,omitempty"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type media struct {
+	Schema *Schema `json:"schema"`
+}
+
+type body struct {
+	Required bool              `json:"required"`
+	Content  map[string]*media `json:"content"`
+}
+
+type response struct {
+	Description string            `json:"description"`
+	Content     map[string]*media `json:"content,omitempty"`
+}
+
+// paramRx matches the {name} and {name:regexp} segments of mux paths.
+var paramRx = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Generate builds the document for the documented routes.
+func (r *Registry) Generate(info Info, host string, routes buffalo.RouteList) *Document {
+	r.moot.RLock()
+	defer r.moot.RUnlock()
+
+	doc := &Document{
+		OpenAPI: "3.0.0",
+		Info:    info,
+		Paths:   map[string]map[string]*PathItem{},
+	}
+	if host != "" {
+		doc.Servers = []map[string]string{{"url": host}}
+	}
+	schemas := newSchemaBuilder()
+
+	for _, ri := range routes {
+		op, ok := r.ops[routeKey(ri.Method, ri.Path)]
+		if !ok {
+			continue
+		}
+		path := paramRx.ReplaceAllString(ri.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*PathItem{}
+		}
+		doc.Paths[path][strings.ToLower(ri.Method)] = r.item(ri, op, schemas)
+	}
+
+	doc.Components.Schemas = schemas.components
+	return doc
+}
+
+func (r *Registry) item(ri buffalo.RouteInfo, op Operation, schemas *schemaBuilder) *PathItem {
+	ct := op.ContentType
+	if ct == "" {
+		ct = "application/json"
+	}
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	pi := &PathItem{
+		Summary:     op.Summary,
+		Description: op.Description,
+		OperationID: operationID(ri),
+		Tags:        op.Tags,
+		Responses:   map[string]*response{},
+	}
+
+	for _, m := range paramRx.FindAllStringSubmatch(ri.Path, -1) {
+		pi.Parameters = append(pi.Parameters, Parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	for _, q := range op.Query {
+		q.In = "query"
+		if q.Schema == nil {
+			q.Schema = &Schema{Type: "string"}
+		}
+		pi.Parameters = append(pi.Parameters, q)
+	}
+
+	if op.Request != nil {
+		pi.RequestBody = &body{
+			Required: true,
+			Content:  map[string]*media{ct: {Schema: schemas.schemaFor(op.Request)}},
+		}
+	}
+
+	res := &response{Description: http.StatusText(status)}
+	if op.Response != nil {
+		res.Content = map[string]*media{ct: {Schema: schemas.schemaFor(op.Response)}}
+	}
+	pi.Responses[strconv.Itoa(status)] = res
+	pi.Responses["default"] = &response{
+		Description: "Error",
+		Content: map[string]*media{
+			"application/problem+json": {Schema: &Schema{Ref: "#/components/schemas/Problem"}},
+		},
+	}
+	schemas.components["Problem"] = problemSchema
+	return pi
+}
+
+// operationID is derived from the method and the path, e.g.
+// GET /api/v1/username/{name} => getApiV1UsernameName.
+func operationID(ri buffalo.RouteInfo) string {
+	parts := strings.FieldsFunc(paramRx.ReplaceAllString(ri.Path, "$1"), func(r rune) bool {
+		return r == '/' || r == '_' || r == '-' || r == '.'
+	})
+	id := strings.ToLower(ri.Method)
+	for _, p := range parts {
+		id += strings.ToUpper(p[:1]) + p[1:]
+	}
+	return id
+}
+
+var problemSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"type":   {Type: "string"},
+		"title":  {Type: "string"},
+		"status": {Type: "integer"},
+		"detail": {Type: "string"},
+		"errors": {Type: "object", AdditionalProperties: &Schema{Type: "array", Items: &Schema{Type: "string"}}},
+	},
+}
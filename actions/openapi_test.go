@@ -0,0 +1,41 @@
+package actions_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/leonids/test-buffalo/actions"
+	"github.com/markbates/willie"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OpenAPI_Documents_Every_Route(t *testing.T) {
+	r := require.New(t)
+
+	doc := actions.OpenAPI()
+	documented := map[string]bool{}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented[method+" "+path] = true
+		}
+	}
+	r.NotEmpty(documented)
+
+	for _, ri := range actions.Undocumented() {
+		r.Fail("route is not documented", "%s %s (%s)", ri.Method, ri.Path, ri.HandlerName)
+	}
+}
+
+func Test_OpenAPIHandler(t *testing.T) {
+	r := require.New(t)
+
+	w := willie.New(actions.App())
+	res := w.Request("/api/openapi.json").Get()
+	r.Equal(200, res.Code)
+
+	doc := map[string]interface{}{}
+	r.NoError(json.Unmarshal(res.Body.Bytes(), &doc))
+	r.Equal("3.0.0", doc["openapi"])
+	r.Contains(res.Body.String(), `"/api/v1/username/{name}"`)
+	r.Contains(res.Body.String(), `"#/components/schemas/User"`)
+}
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"strconv"
+
+	"github.com/leonids/test-buffalo/actions/jobs"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/outbox"
+	"github.com/leonids/test-buffalo/actions/webhooks"
+	"github.com/leonids/test-buffalo/config"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+)
+
+// eventBus gets the events of the outbox in this process.
+var eventBus = outbox.NewBus()
+
+// NewRelay returns the relay of the outbox of the app. The events go to
+// an NDJSON file (logs/outbox.ndjson by default), to a URL when there's
+// one, see config.Outbox, and to the subscribers of eventBus.
+func NewRelay() (*outbox.Relay, error) {
+	r := outbox.NewRelay(models.DB)
+	r.Add("file", &outbox.FileSink{Path: config.Current.Outbox.File})
+	if url := config.Current.Outbox.URL; url != "" {
+		r.Add("http", outbox.NewHTTPSink(url))
+	}
+	if err := r.AddLocal("bus", eventBus); err != nil {
+		return nil, err
+	}
+	// the activity stream has nothing before that
+	positions, err := r.Offsets()
+	if err != nil {
+		return nil, err
+	}
+	activity.Skip(positions["bus"])
+	return r, nil
+}
+
+// notify records event in the outbox and queues its webhooks, and the
+// job sending them, all in tx. What's cached about the data is dropped
+// once tx is committed.
+func notify(tx *pop.Connection, event string, data interface{}) error {
+	aggregate, id := aggregateOf(data)
+	if err := mw.AfterCommit(tx, func() error { return invalidate(aggregate, id) }); err != nil {
+		return err
+	}
+	if err := outbox.Write(tx, aggregate, id, event, data); err != nil {
+		return err
+	}
+	if err := webhooks.Enqueue(tx, event, data); err != nil {
+		return err
+	}
+	return jobs.Enqueue(tx, webhooksJob, nil, jobs.Options{Queue: "webhooks", Unique: webhooksJob})
+}
+
+// aggregateOf tells what the data of an event is about. The users of
+// authboss are told apart by their email.
+func aggregateOf(data interface{}) (string, string) {
+	switch v := data.(type) {
+	case *models.User:
+		return "user", strconv.Itoa(v.ID)
+	case models.User:
+		return "user", strconv.Itoa(v.ID)
+	case authUser:
+		return "auth_user", v.Email
+	}
+	return "", ""
+}
@@ -0,0 +1,87 @@
+// Package outbox publishes the domain events of the app reliably. The
+// events are written to the outbox table in the transaction of the
+// change they're about, so there's no event without the change and no
+// change without its event; a Relay then reads the table in order and
+// hands every event to the sinks (files, HTTP endpoints, in-process
+// subscribers), at least once and in the order they were written, which
+// keeps the events of an aggregate in order too.
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	pop.MapTableName("Event", "outbox")
+	pop.MapTableName("Offset", "outbox_offsets")
+}
+
+// RawJSON is JSON stored as text, it's marshaled as is.
+type RawJSON string
+
+// MarshalJSON implements json.Marshaler.
+func (j RawJSON) MarshalJSON() ([]byte, error) {
+	if j == "" {
+		return []byte("null"), nil
+	}
+	return []byte(j), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (j *RawJSON) UnmarshalJSON(b []byte) error {
+	*j = RawJSON(b)
+	return nil
+}
+
+// Event is a row of the outbox. Its ID is its offset in the outbox.
+type Event struct {
+	ID        int       `json:"id" db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"-" db:"updated_at"`
+	// Aggregate and AggregateID tell what the event is about, "user"
+	// and "42".
+	Aggregate   string  `json:"aggregate" db:"aggregate"`
+	AggregateID string  `json:"aggregate_id" db:"aggregate_id"`
+	Type        string  `json:"type" db:"event"`
+	Payload     RawJSON `json:"payload" db:"payload"`
+}
+
+// Events is a list of events.
+type Events []Event
+
+// Write adds an event to the outbox. tx must be the transaction of the
+// change the event is about.
+func Write(tx *pop.Connection, aggregate, aggregateID, event string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	e := &Event{Aggregate: aggregate, AggregateID: aggregateID, Type: event, Payload: RawJSON(b)}
+	return errors.WithStack(tx.Create(e))
+}
+
+// Head returns the offset of the last event, 0 when there's none.
+func Head(tx *pop.Connection) (int, error) {
+	var head struct {
+		ID int `db:"id"`
+	}
+	err := tx.RawQuery("SELECT COALESCE(MAX(id), 0) AS id FROM outbox").First(&head)
+	return head.ID, errors.WithStack(err)
+}
+
+// Offset is how far a sink got in the outbox: Position is the offset of
+// the last event it was sent.
+type Offset struct {
+	ID        int       `json:"id" db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	Sink      string    `json:"sink" db:"sink"`
+	Position  int       `json:"position" db:"position"`
+}
+
+// Offsets is a list of offsets.
+type Offsets []Offset
@@ -0,0 +1,270 @@
+package outbox_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/outbox"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/markbates/pop"
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func write(t *testing.T, db *pop.Connection, id int, event, name string) {
+	require.NoError(t, db.Transaction(func(tx *pop.Connection) error {
+		return outbox.Write(tx, "user", "1", event, user{ID: id, Name: name})
+	}))
+}
+
+// recorder records the events it gets, or fails with err.
+type recorder struct {
+	events []outbox.Event
+	err    error
+}
+
+func (r *recorder) Publish(ctx context.Context, e outbox.Event) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) types() []string {
+	list := []string{}
+	for _, e := range r.events {
+		list = append(list, e.Type)
+	}
+	return list
+}
+
+func Test_Write_Transaction(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	write(t, db, 1, "user.created", "Zeratul")
+	err := db.Transaction(func(tx *pop.Connection) error {
+		r.NoError(outbox.Write(tx, "user", "2", "user.created", user{ID: 2}))
+		return errors.New("rolled back")
+	})
+	r.Error(err)
+
+	events := outbox.Events{}
+	r.NoError(db.All(&events))
+	r.Len(events, 1)
+	r.Equal("user", events[0].Aggregate)
+	r.Equal("user.created", events[0].Type)
+	r.JSONEq(`{"id":1,"name":"Zeratul"}`, string(events[0].Payload))
+
+	b, err := json.Marshal(events[0])
+	r.NoError(err)
+	r.Contains(string(b), `"payload":{"id":1,"name":"Zeratul"}`)
+}
+
+func Test_Relay(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	ctx := context.Background()
+
+	write(t, db, 1, "user.created", "Zeratul")
+	write(t, db, 1, "user.updated", "Tassadar")
+
+	rec := &recorder{}
+	relay := outbox.NewRelay(db)
+	relay.Add("rec", rec)
+
+	n, err := relay.Relay(ctx, "rec")
+	r.NoError(err)
+	r.Equal(2, n)
+	r.Equal([]string{"user.created", "user.updated"}, rec.types())
+
+	// the offset is kept, nothing is sent twice
+	n, err = relay.Relay(ctx, "rec")
+	r.NoError(err)
+	r.Equal(0, n)
+	positions, err := relay.Offsets()
+	r.NoError(err)
+	r.Equal(2, positions["rec"])
+
+	// failures stop the relay until the sink is back
+	write(t, db, 1, "user.deleted", "")
+	rec.err = errors.New("down")
+	n, err = relay.Relay(ctx, "rec")
+	r.Error(err)
+	r.Equal(0, n)
+	rec.err = nil
+	n, err = relay.Relay(ctx, "rec")
+	r.NoError(err)
+	r.Equal(1, n)
+	r.Equal([]string{"user.created", "user.updated", "user.deleted"}, rec.types())
+
+	// another relay, on another instance, picks up from the same offset
+	other := outbox.NewRelay(db)
+	other.Add("rec", rec)
+	n, err = other.Relay(ctx, "rec")
+	r.NoError(err)
+	r.Equal(0, n)
+
+	r.NoError(other.Rewind("rec", 2))
+	n, err = relay.Relay(ctx, "rec")
+	r.NoError(err)
+	r.Equal(2, n)
+	r.Equal([]string{"user.created", "user.updated", "user.deleted", "user.updated", "user.deleted"}, rec.types())
+
+	_, err = relay.Relay(ctx, "nope")
+	r.Error(err)
+}
+
+func Test_Relay_Batch(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	for i := 0; i < 5; i++ {
+		write(t, db, i, "user.created", "")
+	}
+	rec := &recorder{}
+	relay := outbox.NewRelay(db)
+	relay.Batch = 2
+	relay.Add("rec", rec)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx, time.Hour)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		if positions, _ := relay.Offsets(); positions["rec"] == 5 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	r.Len(rec.events, 5)
+	for i, e := range rec.events {
+		r.Equal(i+1, e.ID)
+	}
+}
+
+func Test_Relay_Gap(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	ctx := context.Background()
+
+	write(t, db, 1, "user.created", "")
+	write(t, db, 2, "user.created", "")
+	write(t, db, 3, "user.created", "")
+	// a transaction still running, as far as the relay can tell
+	r.NoError(db.RawQuery("DELETE FROM outbox WHERE id = 2").Exec())
+
+	rec := &recorder{}
+	relay := outbox.NewRelay(db)
+	relay.Add("rec", rec)
+	n, err := relay.Relay(ctx, "rec")
+	r.NoError(err)
+	r.Equal(1, n)
+
+	// it didn't show up in time
+	relay.GapTimeout = 0
+	n, err = relay.Relay(ctx, "rec")
+	r.NoError(err)
+	r.Equal(1, n)
+	r.Equal(3, rec.events[1].ID)
+}
+
+func Test_Relay_Local(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	ctx := context.Background()
+
+	write(t, db, 1, "user.created", "")
+	bus := outbox.NewBus()
+	got := []string{}
+	unsubscribe := bus.Subscribe(func(e outbox.Event) {
+		got = append(got, e.Type)
+	})
+	relay := outbox.NewRelay(db)
+	r.NoError(relay.AddLocal("bus", bus))
+	r.True(relay.Local("bus"))
+
+	// from the head on
+	n, err := relay.Relay(ctx, "bus")
+	r.NoError(err)
+	r.Equal(0, n)
+
+	write(t, db, 1, "user.updated", "")
+	_, err = relay.Relay(ctx, "bus")
+	r.NoError(err)
+	r.Equal([]string{"user.updated"}, got)
+
+	// nothing in the database
+	var count struct {
+		N int `db:"n"`
+	}
+	r.NoError(db.RawQuery("SELECT COUNT(*) AS n FROM outbox_offsets").First(&count))
+	r.Equal(0, count.N)
+
+	unsubscribe()
+	write(t, db, 1, "user.deleted", "")
+	_, err = relay.Relay(ctx, "bus")
+	r.NoError(err)
+	r.Equal([]string{"user.updated"}, got)
+}
+
+func Test_FileSink(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "logs", "outbox.ndjson")
+	s := &outbox.FileSink{Path: path}
+	for i := 1; i <= 2; i++ {
+		r.NoError(s.Publish(context.Background(), outbox.Event{ID: i, Type: "user.created", Payload: `{"id":1}`}))
+	}
+
+	f, err := os.Open(path)
+	r.NoError(err)
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	ids := []int{}
+	for lines.Scan() {
+		e := outbox.Event{}
+		r.NoError(json.Unmarshal(lines.Bytes(), &e))
+		r.JSONEq(`{"id":1}`, string(e.Payload))
+		ids = append(ids, e.ID)
+	}
+	r.Equal([]int{1, 2}, ids)
+}
+
+func Test_HTTPSink(t *testing.T) {
+	r := require.New(t)
+	status := 200
+	var got []byte
+	var id string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got, _ = ioutil.ReadAll(req.Body)
+		id = req.Header.Get("Outbox-Event-Id")
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	s := outbox.NewHTTPSink(ts.URL)
+	r.NoError(s.Publish(context.Background(), outbox.Event{ID: 7, Type: "user.created", Payload: `{"id":1}`}))
+	r.Equal("7", id)
+	r.Contains(string(got), `"type":"user.created"`)
+
+	status = 503
+	r.Error(s.Publish(context.Background(), outbox.Event{ID: 8}))
+}
@@ -0,0 +1,253 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// Relay publishes the events of the outbox to the sinks, each from its
+// own offset. The offsets of the shared sinks are in the outbox_offsets
+// table and a sink is relayed by one instance at a time; those of the
+// local sinks are in memory and start at the head of the outbox.
+type Relay struct {
+	DB     *pop.Connection
+	Logger buffalo.Logger
+	// Batch is the number of events read at once, 100 by default.
+	Batch int
+	// GapTimeout is how long an event waits for the ones before it
+	// that aren't visible yet, a minute by default. The IDs come in
+	// the order of the inserts rather than of the commits: a gap is a
+	// transaction still running, or one rolled back, and an event past
+	// the timeout is published anyway. Transactions writing events
+	// should be shorter than that.
+	GapTimeout time.Duration
+
+	moot  *sync.Mutex
+	sinks map[string]*sink
+}
+
+type sink struct {
+	Sink
+	local    bool
+	position int
+	moot     *sync.Mutex
+}
+
+// NewRelay returns a relay without sinks.
+func NewRelay(db *pop.Connection) *Relay {
+	return &Relay{
+		DB:         db,
+		Logger:     buffalo.NewLogger("info"),
+		Batch:      100,
+		GapTimeout: time.Minute,
+		moot:       &sync.Mutex{},
+		sinks:      map[string]*sink{},
+	}
+}
+
+// Add registers a sink shared by the instances.
+func (r *Relay) Add(name string, s Sink) {
+	r.moot.Lock()
+	defer r.moot.Unlock()
+	r.sinks[name] = &sink{Sink: s, moot: &sync.Mutex{}}
+}
+
+// AddLocal registers a sink of the process, one every instance publishes
+// to. It gets the events written from now on.
+func (r *Relay) AddLocal(name string, s Sink) error {
+	head, err := Head(r.DB)
+	if err != nil {
+		return err
+	}
+	r.moot.Lock()
+	defer r.moot.Unlock()
+	r.sinks[name] = &sink{Sink: s, local: true, position: head, moot: &sync.Mutex{}}
+	return nil
+}
+
+// Sinks returns the names of the sinks, sorted.
+func (r *Relay) Sinks() []string {
+	r.moot.Lock()
+	defer r.moot.Unlock()
+	names := make([]string, 0, len(r.sinks))
+	for name := range r.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Local tells if the sink name is local to the process.
+func (r *Relay) Local(name string) bool {
+	s, err := r.sink(name)
+	return err == nil && s.local
+}
+
+func (r *Relay) sink(name string) (*sink, error) {
+	r.moot.Lock()
+	defer r.moot.Unlock()
+	s, ok := r.sinks[name]
+	if !ok {
+		return nil, errors.Errorf("outbox: no sink named %s", name)
+	}
+	return s, nil
+}
+
+// Run relays the events every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		for _, name := range r.Sinks() {
+			for {
+				n, err := r.Relay(ctx, name)
+				if err != nil {
+					r.Logger.Errorf("outbox: %s: %+v", name, err)
+				}
+				// keep going while there's a backlog
+				if err != nil || n < r.Batch || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Relay publishes the next batch of events to the sink name and returns
+// how many it published.
+func (r *Relay) Relay(ctx context.Context, name string) (int, error) {
+	s, err := r.sink(name)
+	if err != nil {
+		return 0, err
+	}
+	s.moot.Lock()
+	defer s.moot.Unlock()
+
+	if s.local {
+		var n int
+		n, s.position, err = r.publish(ctx, r.DB, s, s.position)
+		return n, err
+	}
+
+	n := 0
+	var perr error
+	// the lock on the offset row holds the other instances back for
+	// the batch (on SQLite, transactions don't run concurrently anyway)
+	err = r.DB.Transaction(func(tx *pop.Connection) error {
+		off, err := r.offset(tx, name, true)
+		if err != nil {
+			return err
+		}
+		n, off.Position, perr = r.publish(ctx, tx, s, off.Position)
+		if n == 0 {
+			return nil
+		}
+		return errors.WithStack(tx.Update(off))
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, perr
+}
+
+// publish sends the batch of events after position to s, stopping at
+// the first failure or gap, and returns how many it sent and the new
+// position.
+func (r *Relay) publish(ctx context.Context, tx *pop.Connection, s *sink, position int) (int, int, error) {
+	events := Events{}
+	err := tx.RawQuery("SELECT * FROM outbox WHERE id > ? ORDER BY id LIMIT ?", position, r.Batch).All(&events)
+	if err != nil {
+		return 0, position, errors.WithStack(err)
+	}
+	n := 0
+	for _, e := range events {
+		if e.ID != position+1 && time.Since(e.CreatedAt) < r.GapTimeout {
+			break
+		}
+		if err := s.Publish(ctx, e); err != nil {
+			return n, position, errors.Wrapf(err, "publishing event %d", e.ID)
+		}
+		position = e.ID
+		n++
+	}
+	return n, position, nil
+}
+
+// offset returns the row of the sink name, creating it if needed, locked
+// for update when lock is set (on the databases that can).
+func (r *Relay) offset(tx *pop.Connection, name string, lock bool) (*Offset, error) {
+	now := time.Now().UTC()
+	err := tx.RawQuery(`INSERT INTO outbox_offsets (created_at, updated_at, sink, position)
+		VALUES (?, ?, ?, 0) ON CONFLICT (sink) DO NOTHING`, now, now, name).Exec()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	q := "SELECT * FROM outbox_offsets WHERE sink = ?"
+	if lock && tx.Dialect.Details().Dialect != "sqlite3" {
+		q += " FOR UPDATE"
+	}
+	off := &Offset{}
+	return off, errors.WithStack(tx.RawQuery(q, name).First(off))
+}
+
+// Offsets returns the positions of the sinks by name.
+func (r *Relay) Offsets() (map[string]int, error) {
+	positions := map[string]int{}
+	for _, name := range r.Sinks() {
+		s, err := r.sink(name)
+		if err != nil {
+			return nil, err
+		}
+		if s.local {
+			s.moot.Lock()
+			positions[name] = s.position
+			s.moot.Unlock()
+			continue
+		}
+		off := &Offset{}
+		err = r.DB.Where("sink = ?", name).First(off)
+		if err != nil && errors.Cause(err) != sql.ErrNoRows {
+			return nil, errors.WithStack(err)
+		}
+		positions[name] = off.Position
+	}
+	return positions, nil
+}
+
+// Rewind moves the sink name back (or forward) so that it gets the
+// events from offset on again.
+func (r *Relay) Rewind(name string, offset int) error {
+	s, err := r.sink(name)
+	if err != nil {
+		return err
+	}
+	if offset < 1 {
+		offset = 1
+	}
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	if s.local {
+		s.position = offset - 1
+		return nil
+	}
+	return r.DB.Transaction(func(tx *pop.Connection) error {
+		off, err := r.offset(tx, name, true)
+		if err != nil {
+			return err
+		}
+		off.Position = offset - 1
+		return errors.WithStack(tx.Update(off))
+	})
+}
@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sink is where the relay publishes the events. An error stops the
+// relay at the event, it's published again on the next round: sinks
+// must tolerate duplicates.
+type Sink interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// SinkFunc is a Sink function.
+type SinkFunc func(ctx context.Context, e Event) error
+
+// Publish implements Sink.
+func (f SinkFunc) Publish(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// FileSink appends the events to a file, one JSON document per line
+// (NDJSON).
+type FileSink struct {
+	Path string
+
+	moot sync.Mutex
+}
+
+// Publish implements Sink.
+func (s *FileSink) Publish(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	// the offset moves past the event once it's on disk
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(f.Close())
+}
+
+// HTTPSink POSTs the events, as JSON, to URL. Any 2xx response is a
+// success. The Outbox-Event-Id header lets the receiver drop the
+// duplicates.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPSink returns a sink posting to url with a 10s timeout.
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Publish implements Sink.
+func (s *HTTPSink) Publish(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(b))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Outbox-Event-Id", strconv.Itoa(e.ID))
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("outbox: %s answered %s", s.URL, res.Status)
+	}
+	return nil
+}
+
+// Bus hands the events over to the subscribers of the process. It's
+// meant for a local sink, see Relay.AddLocal.
+type Bus struct {
+	moot *sync.RWMutex
+	subs map[int]func(Event)
+	next int
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{moot: &sync.RWMutex{}, subs: map[int]func(Event){}}
+}
+
+// Subscribe calls fn with every event published from now on, until the
+// returned function is called. fn must not block.
+func (b *Bus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.moot.Lock()
+	defer b.moot.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.moot.Lock()
+		defer b.moot.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish implements Sink.
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	b.moot.RLock()
+	defer b.moot.RUnlock()
+	for _, fn := range b.subs {
+		fn(e)
+	}
+	return nil
+}
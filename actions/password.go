@@ -0,0 +1,88 @@
+package actions
+
+import (
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/auth"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"gopkg.in/authboss.v1"
+)
+
+// initPasswordRoutes serves the page the mail of a forced password reset
+// links to, see AdminExpirePasswordHandler.
+func initPasswordRoutes(app *buffalo.App) {
+	api.Document(app.GET("/password/reset", PasswordResetHandler), openapi.Operation{
+		Summary:     "Form to pick a new password, after an admin forced a reset",
+		ContentType: "text/html",
+		Query:       []openapi.Parameter{{Name: "token", Required: true}},
+		Response:    "",
+	})
+	api.Document(app.POST("/password/reset", mw.CSRF(PasswordResetUpdateHandler)), openapi.Operation{
+		Summary:     "Set the new password of a forced reset, and sign in",
+		Description: "The form of GET /password/reset: it takes the token, password, confirm_password and csrf_token fields.",
+		Status:      http.StatusSeeOther,
+	})
+}
+
+// PasswordResetHandler shows the form for the token of the link.
+func PasswordResetHandler(c buffalo.Context) error {
+	if _, err := recoveringUser(c.Param("token")); err != nil {
+		return err
+	}
+	c.Set("token", c.Param("token"))
+	c.Set("errors", []string{})
+	return c.Render(200, r.HTML("password/reset.html"))
+}
+
+// PasswordResetUpdateHandler sets the new password, once: the token is
+// dropped with the old password. The user is signed in, so authboss
+// mails it that the password was changed.
+func PasswordResetUpdateHandler(c buffalo.Context) error {
+	user, err := recoveringUser(c.Param("token"))
+	if err != nil {
+		return err
+	}
+	req := c.Request()
+	policies := authboss.FilterValidators(ab.Policies, "password")
+	if errs := authboss.Validate(req, policies, "password", "confirm_password"); len(errs) > 0 {
+		messages := []string{}
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		c.Set("token", c.Param("token"))
+		c.Set("errors", messages)
+		return c.Render(http.StatusUnprocessableEntity, r.HTML("password/reset.html"))
+	}
+
+	key := user.Email
+	err = ab.UpdatePassword(c.Response(), req, req.FormValue("password"), user, func() error {
+		if err := authStore.SetPassword(key, user.Password); err != nil {
+			return err
+		}
+		ab.SessionStoreMaker(c.Response(), req).Put(authboss.SessionKey, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+// recoveringUser is the user whose password an admin expired with
+// token, while the link works.
+func recoveringUser(token string) (*store.User, error) {
+	b, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || authStore == nil {
+		return nil, problem.NotFound("this link to reset a password is unknown or expired")
+	}
+	user, err := authStore.RecoveringUser(recoverSum(b), time.Now())
+	if err != nil {
+		return nil, problem.NotFound("this link to reset a password is unknown or expired")
+	}
+	return user, nil
+}
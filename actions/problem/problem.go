@@ -0,0 +1,119 @@
+// Package problem holds the typed application errors handlers return to
+// pick the response status, and their RFC 7807 (application/problem+json)
+// representation.
+//
+//	if err := tx.Find(u, id); err != nil {
+//		return problem.NotFound("no user with id %s", id)
+//	}
+package problem
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/validate"
+	"github.com/pkg/errors"
+)
+
+// ContentType of problem documents.
+const ContentType = "application/problem+json"
+
+// Error is an application error with everything a problem document
+// needs. Handlers don't build it directly but use the constructors below,
+// which wrap it in a buffalo.HTTPError so buffalo picks the status too.
+type Error struct {
+	Status int                 `json:"status"`
+	Type   string              `json:"type"`
+	Title  string              `json:"title"`
+	Detail string              `json:"detail,omitempty"`
+	Errors map[string][]string `json:"errors,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return e.Title
+}
+
+func newError(status int, detail string) error {
+	return buffalo.HTTPError{
+		Status: status,
+		Cause: errors.WithStack(&Error{
+			Status: status,
+			Type:   "about:blank",
+			Title:  http.StatusText(status),
+			Detail: detail,
+		}),
+	}
+}
+
+// BadRequest is a 400 error.
+func BadRequest(format string, args ...interface{}) error {
+	return newError(http.StatusBadRequest, fmt.Sprintf(format, args...))
+}
+
+// NotFound is a 404 error.
+func NotFound(format string, args ...interface{}) error {
+	return newError(http.StatusNotFound, fmt.Sprintf(format, args...))
+}
+
+// Unauthorized is a 401 error.
+func Unauthorized(format string, args ...interface{}) error {
+	return newError(http.StatusUnauthorized, fmt.Sprintf(format, args...))
+}
+
+// Forbidden is a 403 error.
+func Forbidden(format string, args ...interface{}) error {
+	return newError(http.StatusForbidden, fmt.Sprintf(format, args...))
+}
+
+// Conflict is a 409 error.
+func Conflict(format string, args ...interface{}) error {
+	return newError(http.StatusConflict, fmt.Sprintf(format, args...))
+}
+
+// TooLarge is a 413 error.
+func TooLarge(format string, args ...interface{}) error {
+	return newError(http.StatusRequestEntityTooLarge, fmt.Sprintf(format, args...))
+}
+
+// UnsupportedMediaType is a 415 error.
+func UnsupportedMediaType(format string, args ...interface{}) error {
+	return newError(http.StatusUnsupportedMediaType, fmt.Sprintf(format, args...))
+}
+
+// Validation is a 422 error carrying the per-field messages of verrs.
+func Validation(verrs *validate.Errors) error {
+	err := newError(http.StatusUnprocessableEntity, "the request has invalid fields")
+	p, _ := From(http.StatusUnprocessableEntity, err)
+	p.Errors = verrs.Errors
+	return err
+}
+
+// From returns the problem carried by err, or a generic one for status.
+// The bool reports whether err was a typed application error.
+func From(status int, err error) (*Error, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *Error:
+			return e, true
+		case buffalo.HTTPError:
+			err = e.Cause
+			continue
+		}
+		c, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		err = c.Cause()
+	}
+	return &Error{
+		Status: status,
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+	}, false
+}
@@ -6,20 +6,41 @@ import (
 	rice "github.com/GeertJohan/go.rice"
 	"github.com/gobuffalo/buffalo/render"
 	"github.com/gobuffalo/buffalo/render/resolvers"
+	"github.com/leonids/test-buffalo/actions/i18n"
+	"github.com/leonids/test-buffalo/actions/jsonapi"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
 )
 
-var r *render.Engine
+// engine is buffalo's render.Engine plus the renderers of the app.
+type engine struct {
+	*render.Engine
+}
+
+// JSONAPI renders v, a model or a slice of models, as a JSON:API
+// document. q may be nil, see jsonapi.Marshal.
+func (e *engine) JSONAPI(v interface{}, q *jsonapi.Query) render.Renderer {
+	return jsonapi.Render(v, q)
+}
+
+var r *engine
 
 func init() {
-	r = render.New(render.Options{
+	r = &engine{render.New(render.Options{
 		HTMLLayout:     "application.html",
 		CacheTemplates: ENV == "production",
+		Helpers: map[string]interface{}{
+			"cache":      appCache.Helper(i18n.LocaleKey),
+			"csp_nonce":  mw.CSPNonceHelper,
+			"csrf_field": mw.CSRFFieldHelper,
+			"feature":    features.Helper,
+			"t":          translator.Helper,
+		},
 		FileResolverFunc: func() resolvers.FileResolver {
 			return &resolvers.RiceBox{
 				Box: rice.MustFindBox("../templates"),
 			}
 		},
-	})
+	})}
 }
 
 func assetsPath() http.FileSystem {
@@ -0,0 +1,81 @@
+package actions
+
+import (
+	"context"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/jobs"
+	"github.com/leonids/test-buffalo/actions/schedule"
+	"github.com/leonids/test-buffalo/actions/webhooks"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// How long the housekeeping tasks keep things around.
+const (
+	rememberTokenTTL  = 14 * 24 * time.Hour
+	jobsRetention     = 7 * 24 * time.Hour
+	webhooksRetention = 30 * 24 * time.Hour
+	logsKept          = 7
+)
+
+// NewScheduler returns a scheduler running the housekeeping tasks of the
+// app. Sessions live in cookies, so there's no session to expire on the
+// server: the remember tokens are what keeps a user logged in, and
+// tokens:purge expires those.
+func NewScheduler() *schedule.Scheduler {
+	s := schedule.New(models.DB)
+	must := func(_ *schedule.Task, err error) {
+		if err != nil {
+			panic(err)
+		}
+	}
+	must(s.Add("db:purge", "@hourly", purgeDB))
+
+	t, err := s.Add("tokens:purge", "@hourly", func(ctx context.Context) error {
+		if authStore == nil {
+			return nil
+		}
+		n := authStore.PurgeTokens(time.Now().Add(-rememberTokenTTL))
+		s.Logger.Infof("schedule: purged %d remember tokens", n)
+		return nil
+	})
+	must(t, err)
+	// the tokens and the log file are those of the process
+	t.Local = true
+
+	t, err = s.Add("logs:rotate", "@daily", func(ctx context.Context) error {
+		if logFile == nil {
+			return nil
+		}
+		return logFile.Rotate(logsKept)
+	})
+	must(t, err)
+	t.Local = true
+	return s
+}
+
+// purgeDB deletes the rows the app doesn't need anymore: the expired
+// idempotent responses and rate limit buckets, old finished jobs and
+// webhook deliveries.
+func purgeDB(ctx context.Context) error {
+	now := time.Now().UTC()
+	return models.DB.Transaction(func(tx *pop.Connection) error {
+		for _, q := range []struct {
+			sql    string
+			before time.Time
+		}{
+			{"DELETE FROM idempotency_keys WHERE created_at < ?", now.Add(-24 * time.Hour)},
+			// a full bucket taken an hour ago is as good as none
+			{"DELETE FROM rate_buckets WHERE taken_at < ?", now.Add(-time.Hour)},
+			{"DELETE FROM jobs WHERE status = '" + jobs.Done + "' AND updated_at < ?", now.Add(-jobsRetention)},
+			{"DELETE FROM webhook_deliveries WHERE status <> '" + webhooks.Pending + "' AND updated_at < ?", now.Add(-webhooksRetention)},
+		} {
+			if err := tx.RawQuery(q.sql, q.before).Exec(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+}
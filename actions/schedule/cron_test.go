@@ -0,0 +1,63 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/schedule"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Cron_Next(t *testing.T) {
+	r := require.New(t)
+	// a wednesday
+	from := time.Date(2026, 10, 14, 10, 17, 30, 0, time.UTC)
+
+	for spec, want := range map[string]time.Time{
+		"* * * * *":          time.Date(2026, 10, 14, 10, 18, 0, 0, time.UTC),
+		"*/15 * * * *":       time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC),
+		"5 * * * *":          time.Date(2026, 10, 14, 11, 5, 0, 0, time.UTC),
+		"0 9-17/4 * * *":     time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC),
+		"30 2 * * mon-fri":   time.Date(2026, 10, 15, 2, 30, 0, 0, time.UTC),
+		"0 0 * * 7":          time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		"0 0 1 jan *":        time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":         time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 12 1 * fri":       time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		"@hourly":            time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC),
+		"@daily":             time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+		"@weekly":            time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		"@every 90s":         from.Add(90 * time.Second),
+		"0,30 8 15-16 oct *": time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC),
+	} {
+		c, err := schedule.Parse(spec)
+		r.NoError(err, spec)
+		r.Equal(want, c.Next(from), spec)
+	}
+
+	c, err := schedule.Parse("0 0 30 2 *")
+	r.NoError(err)
+	r.True(c.Next(from).IsZero())
+}
+
+func Test_Cron_Parse_Errors(t *testing.T) {
+	r := require.New(t)
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+		"* * * foo *",
+		"@every 10",
+		"@every 10ms",
+		"@fortnightly",
+	} {
+		_, err := schedule.Parse(spec)
+		r.Error(err, spec)
+	}
+}
@@ -0,0 +1,359 @@
+// Package schedule runs recurring tasks on cron expressions. Every
+// instance of the app may run a Scheduler with the same tasks: the
+// scheduled_tasks table keeps when each task is due next, and a lock
+// makes sure a single instance runs it (Postgres advisory locks, a lease
+// on the row elsewhere). The table also records how the last run went.
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/grift/grift"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// The outcomes of a run.
+const (
+	OK     = "ok"
+	Failed = "failed"
+)
+
+// Func is the work of a task.
+type Func func(ctx context.Context) error
+
+// Task is a recurring task.
+type Task struct {
+	Name string
+	Spec string
+	Func Func
+	// Local tasks run on every instance, they deal with the state of the
+	// process (memory, files) rather than shared state.
+	Local bool
+
+	cron *Cron
+	next time.Time
+}
+
+// Record is the row of a task in the scheduled_tasks table.
+type Record struct {
+	ID         int       `json:"id" db:"id"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+	Name       string    `json:"name" db:"name"`
+	Spec       string    `json:"spec" db:"spec"`
+	NextRunAt  time.Time `json:"next_run_at" db:"next_run_at"`
+	LastRunAt  time.Time `json:"last_run_at" db:"last_run_at"`
+	LastStatus string    `json:"last_status" db:"last_status"`
+	LastError  string    `json:"last_error" db:"last_error"`
+	// LastDuration is in milliseconds.
+	LastDuration int64     `json:"last_duration" db:"last_duration"`
+	LockedUntil  time.Time `json:"locked_until" db:"locked_until"`
+	LockedBy     string    `json:"locked_by" db:"locked_by"`
+}
+
+func init() {
+	pop.MapTableName("Record", "scheduled_tasks")
+}
+
+// Scheduler runs tasks when they are due.
+type Scheduler struct {
+	DB     *pop.Connection
+	Logger buffalo.Logger
+	// ID tells the instances apart in the locked_by column.
+	ID string
+	// Timeout of a run, after which the lease on the task expires and
+	// another instance may run it, an hour when zero. Runs past the
+	// timeout have their context cancelled.
+	Timeout time.Duration
+
+	moot  *sync.Mutex
+	tasks map[string]*Task
+}
+
+// New returns a scheduler without tasks.
+func New(db *pop.Connection) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		DB:     db,
+		Logger: buffalo.NewLogger("info"),
+		ID:     host + ":" + strconv.Itoa(os.Getpid()),
+		moot:   &sync.Mutex{},
+		tasks:  map[string]*Task{},
+	}
+}
+
+// Add registers a task running fn on spec, see Parse.
+func (s *Scheduler) Add(name, spec string, fn Func) (*Task, error) {
+	c, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	t := &Task{Name: name, Spec: spec, Func: fn, cron: c}
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	s.tasks[name] = t
+	return t, nil
+}
+
+// AddGrift registers a task running the grift of the same name.
+func (s *Scheduler) AddGrift(name, spec string, args ...string) (*Task, error) {
+	return s.Add(name, spec, func(ctx context.Context) error {
+		c := grift.NewContext(name)
+		c.Args = args
+		return grift.Run(name, c)
+	})
+}
+
+// Tasks returns the tasks, sorted by name.
+func (s *Scheduler) Tasks() []*Task {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	list := make([]*Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Records returns the rows of the tasks by name.
+func (s *Scheduler) Records() (map[string]*Record, error) {
+	recs := map[string]*Record{}
+	for _, t := range s.Tasks() {
+		rec, err := s.record(t)
+		if err != nil {
+			return nil, err
+		}
+		recs[t.Name] = rec
+	}
+	return recs, nil
+}
+
+// record returns the row of t, creating it when it's missing and
+// rescheduling the task when its expression changed.
+func (s *Scheduler) record(t *Task) (*Record, error) {
+	now := time.Now().UTC()
+	rec := &Record{}
+	err := s.DB.Where("name = ?", t.Name).First(rec)
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		// instances starting together insert the same rows
+		err = s.DB.RawQuery(`INSERT INTO scheduled_tasks
+			(created_at, updated_at, name, spec, next_run_at, last_run_at, last_status, last_error, last_duration, locked_until, locked_by)
+			VALUES (?, ?, ?, ?, ?, ?, '', '', 0, ?, '')
+			ON CONFLICT (name) DO NOTHING`,
+			now, now, t.Name, t.Spec, t.cron.Next(now), time.Time{}, time.Time{}).Exec()
+		if err == nil {
+			err = s.DB.Where("name = ?", t.Name).First(rec)
+		}
+	case err == nil && rec.Spec != t.Spec:
+		rec.Spec = t.Spec
+		rec.NextRunAt = t.cron.Next(now)
+		err = s.DB.Update(rec)
+	}
+	return rec, errors.WithStack(err)
+}
+
+// Run runs the tasks when they are due until ctx is done, then waits for
+// the running ones.
+func (s *Scheduler) Run(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	tick := time.NewTicker(15 * time.Second)
+	defer tick.Stop()
+	for {
+		for _, t := range s.due(time.Now().UTC()) {
+			wg.Add(1)
+			go func(t *Task) {
+				defer wg.Done()
+				if _, err := s.RunTask(ctx, t.Name, false); err != nil {
+					s.Logger.Errorf("schedule: %s: %+v", t.Name, err)
+				}
+			}(t)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// due returns the tasks due at now as far as this instance knows, and
+// moves their next run along. The database has the final word.
+func (s *Scheduler) due(now time.Time) []*Task {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	list := []*Task{}
+	for _, t := range s.tasks {
+		if t.next.IsZero() {
+			// shared tasks may be overdue, the database tells; local
+			// ones wait for their time
+			t.next = now
+			if t.Local {
+				t.next = t.cron.Next(now)
+			}
+		}
+		if !t.next.After(now) {
+			list = append(list, t)
+			t.next = t.cron.Next(now)
+		}
+	}
+	return list
+}
+
+// RunTask runs a task if it's due (or right away when forced) and no
+// other instance is running it. It tells whether it ran the task; the
+// error is the one of the task or of the bookkeeping.
+func (s *Scheduler) RunTask(ctx context.Context, name string, force bool) (bool, error) {
+	s.moot.Lock()
+	t, ok := s.tasks[name]
+	s.moot.Unlock()
+	if !ok {
+		return false, errors.Errorf("schedule: no task named %s", name)
+	}
+	if _, err := s.record(t); err != nil {
+		return false, err
+	}
+	if t.Local {
+		return s.runLocal(ctx, t)
+	}
+	if s.DB.Dialect.Details().Dialect == "postgres" {
+		return s.runAdvisory(ctx, t, force)
+	}
+	return s.runLeased(ctx, t, force)
+}
+
+// runLocal runs a task of the process: there's nothing to lock and the
+// row just tells how the last run of any instance went.
+func (s *Scheduler) runLocal(ctx context.Context, t *Task) (bool, error) {
+	rec := &Record{}
+	if err := s.DB.Where("name = ?", t.Name).First(rec); err != nil {
+		return false, errors.WithStack(err)
+	}
+	err := s.perform(ctx, t, rec)
+	if uerr := s.DB.Update(rec); uerr != nil {
+		return true, errors.WithStack(uerr)
+	}
+	return true, err
+}
+
+// runAdvisory holds a transaction level advisory lock on the task while
+// it runs: it's released with the transaction, even if the instance
+// dies. pop's Dialect.Lock is a no-op on Postgres.
+func (s *Scheduler) runAdvisory(ctx context.Context, t *Task, force bool) (bool, error) {
+	ran := false
+	var runErr error
+	err := s.DB.Transaction(func(tx *pop.Connection) error {
+		var lock struct {
+			Locked bool `db:"locked"`
+		}
+		if err := tx.RawQuery("SELECT pg_try_advisory_xact_lock(?) AS locked", lockKey(t.Name)).First(&lock); err != nil {
+			return errors.WithStack(err)
+		}
+		if !lock.Locked {
+			return nil
+		}
+		rec := &Record{}
+		if err := tx.Where("name = ?", t.Name).First(rec); err != nil {
+			return errors.WithStack(err)
+		}
+		if !force && rec.NextRunAt.After(time.Now()) {
+			return nil
+		}
+		ran = true
+		runErr = s.perform(ctx, t, rec)
+		return errors.WithStack(tx.Update(rec))
+	})
+	if err != nil {
+		return ran, err
+	}
+	return ran, runErr
+}
+
+// runLeased takes a lease on the row of the task for the duration of the
+// run, for the databases without advisory locks. Within an instance
+// Dialect.Lock serializes the claims.
+func (s *Scheduler) runLeased(ctx context.Context, t *Task, force bool) (bool, error) {
+	now := time.Now().UTC()
+	timeout := s.timeout()
+	var claimed bool
+	err := s.DB.Dialect.Lock(func() error {
+		q := "UPDATE scheduled_tasks SET locked_until = ?, locked_by = ? WHERE name = ? AND locked_until < ?"
+		args := []interface{}{now.Add(timeout), s.ID, t.Name, now}
+		if !force {
+			q += " AND next_run_at <= ?"
+			args = append(args, now)
+		}
+		stmt, sargs := s.DB.RawQuery(q, args...).ToSQL(nil)
+		res, err := s.DB.Store.Exec(stmt, sargs...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		n, err := res.RowsAffected()
+		claimed = n == 1
+		return errors.WithStack(err)
+	})
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	rec := &Record{}
+	if err := s.DB.Where("name = ?", t.Name).First(rec); err != nil {
+		return true, errors.WithStack(err)
+	}
+	runErr := s.perform(ctx, t, rec)
+	rec.LockedUntil, rec.LockedBy = time.Time{}, ""
+	if err := s.DB.Update(rec); err != nil {
+		return true, errors.WithStack(err)
+	}
+	return true, runErr
+}
+
+// perform runs the task and writes down the outcome in rec.
+func (s *Scheduler) perform(ctx context.Context, t *Task, rec *Record) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+
+	start := time.Now().UTC()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		rec.LastRunAt = start
+		rec.LastDuration = int64(time.Since(start) / time.Millisecond)
+		rec.NextRunAt = t.cron.Next(time.Now().UTC())
+		rec.LastStatus, rec.LastError = OK, ""
+		if err != nil {
+			rec.LastStatus, rec.LastError = Failed, err.Error()
+			s.Logger.Errorf("schedule: %s failed after %s: %s", t.Name, time.Since(start), err)
+			return
+		}
+		s.Logger.Infof("schedule: %s ran in %s", t.Name, time.Since(start))
+	}()
+	return t.Func(ctx)
+}
+
+func (s *Scheduler) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return time.Hour
+	}
+	return s.Timeout
+}
+
+// lockKey maps a task name to a Postgres advisory lock key.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("schedule:" + name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,161 @@
+package schedule_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/schedule"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RunTask(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	ctx := context.Background()
+
+	runs := 0
+	s := schedule.New(db)
+	_, err := s.Add("count", "@hourly", func(ctx context.Context) error {
+		runs++
+		return nil
+	})
+	r.NoError(err)
+
+	// not due before the next hour
+	ran, err := s.RunTask(ctx, "count", false)
+	r.NoError(err)
+	r.False(ran)
+	r.Equal(0, runs)
+
+	ran, err = s.RunTask(ctx, "count", true)
+	r.NoError(err)
+	r.True(ran)
+	r.Equal(1, runs)
+
+	recs, err := s.Records()
+	r.NoError(err)
+	rec := recs["count"]
+	r.Equal(schedule.OK, rec.LastStatus)
+	r.False(rec.LastRunAt.IsZero())
+	r.True(rec.NextRunAt.After(time.Now()))
+	r.True(rec.LockedUntil.IsZero())
+
+	// overdue
+	r.NoError(db.RawQuery("UPDATE scheduled_tasks SET next_run_at = ?", time.Now().Add(-time.Minute)).Exec())
+	ran, err = s.RunTask(ctx, "count", false)
+	r.NoError(err)
+	r.True(ran)
+	r.Equal(2, runs)
+
+	_, err = s.RunTask(ctx, "nope", true)
+	r.Error(err)
+}
+
+func Test_RunTask_Locked(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	ctx := context.Background()
+
+	runs := 0
+	count := func(ctx context.Context) error {
+		runs++
+		return nil
+	}
+	a, b := schedule.New(db), schedule.New(db)
+	a.ID, b.ID = "a", "b"
+	for _, s := range []*schedule.Scheduler{a, b} {
+		_, err := s.Add("count", "* * * * *", count)
+		r.NoError(err)
+	}
+	_, err := a.Records()
+	r.NoError(err)
+
+	// b holds the lease
+	r.NoError(db.RawQuery("UPDATE scheduled_tasks SET locked_until = ?, locked_by = 'b'", time.Now().Add(time.Hour)).Exec())
+	ran, err := a.RunTask(ctx, "count", true)
+	r.NoError(err)
+	r.False(ran)
+	r.Equal(0, runs)
+
+	// the lease expired
+	r.NoError(db.RawQuery("UPDATE scheduled_tasks SET locked_until = ?", time.Now().Add(-time.Second)).Exec())
+	ran, err = a.RunTask(ctx, "count", true)
+	r.NoError(err)
+	r.True(ran)
+	r.Equal(1, runs)
+}
+
+func Test_RunTask_Failed(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	ctx := context.Background()
+
+	s := schedule.New(db)
+	_, err := s.Add("fail", "@daily", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	r.NoError(err)
+	_, err = s.Add("panic", "@daily", func(ctx context.Context) error {
+		panic("oops")
+	})
+	r.NoError(err)
+
+	ran, err := s.RunTask(ctx, "fail", true)
+	r.True(ran)
+	r.EqualError(err, "boom")
+	ran, err = s.RunTask(ctx, "panic", true)
+	r.True(ran)
+	r.EqualError(err, "panic: oops")
+
+	recs, err := s.Records()
+	r.NoError(err)
+	r.Equal(schedule.Failed, recs["fail"].LastStatus)
+	r.Equal("boom", recs["fail"].LastError)
+	r.Equal(schedule.Failed, recs["panic"].LastStatus)
+	r.True(recs["panic"].LockedUntil.IsZero())
+}
+
+func Test_RunTask_Local(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	runs := 0
+	s := schedule.New(db)
+	task, err := s.Add("local", "@daily", func(ctx context.Context) error {
+		runs++
+		return nil
+	})
+	r.NoError(err)
+	task.Local = true
+
+	// a lease doesn't stop local tasks
+	_, err = s.Records()
+	r.NoError(err)
+	r.NoError(db.RawQuery("UPDATE scheduled_tasks SET locked_until = ?", time.Now().Add(time.Hour)).Exec())
+	ran, err := s.RunTask(context.Background(), "local", false)
+	r.NoError(err)
+	r.True(ran)
+	r.Equal(1, runs)
+}
+
+func Test_Records_Reschedule(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	s := schedule.New(db)
+	_, err := s.Add("t", "0 0 1 1 *", func(context.Context) error { return nil })
+	r.NoError(err)
+	recs, err := s.Records()
+	r.NoError(err)
+	yearly := recs["t"].NextRunAt
+
+	_, err = s.Add("t", "@hourly", func(context.Context) error { return nil })
+	r.NoError(err)
+	recs, err = s.Records()
+	r.NoError(err)
+	r.Equal("@hourly", recs["t"].Spec)
+	r.True(recs["t"].NextRunAt.Before(yearly))
+}
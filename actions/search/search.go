@@ -0,0 +1,145 @@
+// Package search finds rows by the words of their text columns: through
+// a tsvector column and its GIN index on Postgres, an FTS5 table on
+// SQLite. Every word of a search is a prefix, all of them must match,
+// and the best matches come first. The other databases fall back to
+// LIKE, unranked and matching anywhere in the words.
+//
+// The indexes are kept up to date by triggers, see the migrations.
+package search
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"unicode"
+
+	"github.com/markbates/pop"
+)
+
+// MaxTerms is the number of words of a search, the others are dropped.
+const MaxTerms = 8
+
+// Terms splits q in lowercase words of letters and digits, without
+// duplicates. The words are safe to put in SQL as they are.
+func Terms(q string) []string {
+	terms := []string{}
+	seen := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.Map(unicode.ToLower, q), notWord) {
+		if !seen[w] && len(terms) < MaxTerms {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+func notWord(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Index is the full-text index of a table.
+type Index struct {
+	Table string
+	// Columns are the indexed columns, the LIKE fallback looks in them.
+	Columns []string
+	// Vector is the tsvector column, on Postgres.
+	Vector string
+	// FTS is the FTS5 table, on SQLite. Its rowids are the ids of Table.
+	FTS string
+}
+
+// Match keeps the rows of pq matching every term. With rank the best
+// matches come first, before the other orders of pq.
+func (ix *Index) Match(pq *pop.Query, terms []string, rank bool) *pop.Query {
+	if len(terms) == 0 {
+		return pq
+	}
+	switch pq.Connection.Dialect.Details().Dialect {
+	case "postgres":
+		tsq := tsQuery(terms)
+		pq = pq.Where(fmt.Sprintf("%s.%s @@ to_tsquery('simple', ?)", ix.Table, ix.Vector), tsq)
+		if rank {
+			// order clauses take no arguments, the terms are safe though
+			pq = pq.Order(fmt.Sprintf("ts_rank(%s.%s, to_tsquery('simple', '%s')) DESC", ix.Table, ix.Vector, tsq))
+		}
+	case "sqlite3":
+		m := ftsQuery(terms)
+		pq = pq.Where(fmt.Sprintf("%s.id IN (SELECT rowid FROM %s WHERE %s MATCH ?)", ix.Table, ix.FTS, ix.FTS), m)
+		if rank {
+			pq = pq.Order(fmt.Sprintf("(SELECT rank FROM %s WHERE %s MATCH '%s' AND rowid = %s.id)", ix.FTS, ix.FTS, m, ix.Table))
+		}
+	default:
+		for _, t := range terms {
+			like := []string{}
+			args := []interface{}{}
+			for _, c := range ix.Columns {
+				like = append(like, fmt.Sprintf("LOWER(%s.%s) LIKE ?", ix.Table, c))
+				args = append(args, "%"+t+"%")
+			}
+			pq = pq.Where("("+strings.Join(like, " OR ")+")", args...)
+		}
+	}
+	return pq
+}
+
+// tsQuery is the Postgres query of terms: mark:* & example:*
+func tsQuery(terms []string) string {
+	q := make([]string, len(terms))
+	for i, t := range terms {
+		q[i] = t + ":*"
+	}
+	return strings.Join(q, " & ")
+}
+
+// ftsQuery is the FTS5 query of terms: "mark"* AND "example"*
+func ftsQuery(terms []string) string {
+	q := make([]string, len(terms))
+	for i, t := range terms {
+		q[i] = `"` + t + `"*`
+	}
+	return strings.Join(q, " AND ")
+}
+
+// Highlight escapes s and marks the beginnings of its words the terms
+// are prefixes of: <mark>Ma</mark>rk. The databases could highlight too,
+// but each in its own way and without escaping the rest.
+func Highlight(s string, terms []string) template.HTML {
+	var b bytes.Buffer
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		if notWord(rs[i]) {
+			j := i
+			for j < len(rs) && notWord(rs[j]) {
+				j++
+			}
+			b.WriteString(template.HTMLEscapeString(string(rs[i:j])))
+			i = j
+			continue
+		}
+		j := i
+		for j < len(rs) && !notWord(rs[j]) {
+			j++
+		}
+		word := rs[i:j]
+		n := matched(word, terms)
+		if n > 0 {
+			b.WriteString("<mark>" + template.HTMLEscapeString(string(word[:n])) + "</mark>")
+		}
+		b.WriteString(template.HTMLEscapeString(string(word[n:])))
+		i = j
+	}
+	return template.HTML(b.String())
+}
+
+// matched is the length in runes of the longest term word starts with.
+func matched(word []rune, terms []string) int {
+	lower := strings.Map(unicode.ToLower, string(word))
+	n := 0
+	for _, t := range terms {
+		if l := len([]rune(t)); l > n && strings.HasPrefix(lower, t) {
+			n = l
+		}
+	}
+	return n
+}
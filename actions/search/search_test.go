@@ -0,0 +1,72 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/leonids/test-buffalo/actions/search"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/markbates/pop"
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	ID    int    `db:"id"`
+	Name  string `db:"name"`
+	Email string `db:"email"`
+}
+
+var index = &search.Index{Table: "users", Columns: []string{"name", "email"}, Vector: "search", FTS: "users_search"}
+
+func find(t *testing.T, db *pop.Connection, q string) []string {
+	users := []user{}
+	require.NoError(t, index.Match(db.Q(), search.Terms(q), true).Order("id").All(&users))
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	return names
+}
+
+func Test_Match(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+	for _, u := range []user{
+		{Name: "Mark Bates", Email: "mark@example.com"},
+		{Name: "Marta Ozola", Email: "marta@example.lv"},
+		{Name: "Jānis Bērziņš", Email: "janis@example.lv"},
+		{Name: "Anna", Email: "markanna@example.com"},
+	} {
+		r.NoError(db.RawQuery("INSERT INTO users (created_at, updated_at, name, email, avatar) VALUES (datetime('now'), datetime('now'), ?, ?, '')", u.Name, u.Email).Exec())
+	}
+
+	// the name weighs more than the email
+	r.Equal([]string{"Mark Bates", "Marta Ozola", "Anna"}, find(t, db, "mar"))
+	r.Equal([]string{"Marta Ozola", "Jānis Bērziņš"}, find(t, db, "EXAMPLE lv"))
+	// every word is a prefix
+	r.Equal([]string{"Mark Bates", "Anna"}, find(t, db, "mark@example.com"))
+	r.Equal([]string{"Jānis Bērziņš"}, find(t, db, "jān"))
+	r.Empty(find(t, db, "bates anna"))
+	r.Empty(find(t, db, "' OR 1=1 --"))
+
+	// the triggers keep the index up to date
+	r.NoError(db.RawQuery("UPDATE users SET name = 'Anna Bates' WHERE name = 'Anna'").Exec())
+	r.Equal([]string{"Mark Bates", "Anna Bates"}, find(t, db, "bates"))
+	r.NoError(db.RawQuery("DELETE FROM users WHERE name = 'Mark Bates'").Exec())
+	r.Equal([]string{"Anna Bates"}, find(t, db, "bates"))
+}
+
+func Test_Terms(t *testing.T) {
+	r := require.New(t)
+	r.Equal([]string{"mark", "example", "com"}, search.Terms(" Mark@example.com mark "))
+	r.Equal([]string{"jānis", "1"}, search.Terms("JĀNIS; 1=1 --"))
+	r.Len(search.Terms("a b c d e f g h i j"), search.MaxTerms)
+	r.Empty(search.Terms("' --"))
+}
+
+func Test_Highlight(t *testing.T) {
+	r := require.New(t)
+	terms := search.Terms("ma ex")
+	r.Equal(`<mark>Ma</mark>rk &lt;<mark>ma</mark>rk@<mark>ex</mark>ample.com&gt;`, string(search.Highlight("Mark <mark@example.com>", terms)))
+	r.Equal(`<mark>Jā</mark>nis`, string(search.Highlight("Jānis", search.Terms("jā"))))
+	r.Equal(`Amanda`, string(search.Highlight("Amanda", terms)))
+}
@@ -0,0 +1,119 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// Reset is the type of the event telling a client it missed events: the
+// hub doesn't have them anymore, it should reload what it shows.
+const Reset = "reset"
+
+// Handler streams the events of h. The client resumes with the
+// Last-Event-ID header (or the last_event_id parameter), and may only
+// want some types of events with ?types=user.created,user.login. A
+// comment goes out every heartbeat, so proxies and clients can tell the
+// connection is alive, and a client the hub drops for being too slow
+// is disconnected: it comes back with its Last-Event-ID.
+//
+// buffalo's render.EventSource doesn't write event IDs and types, and
+// allows every origin, so the stream is written here.
+func Handler(h *Hub, heartbeat time.Duration) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		w := c.Response()
+		fl, ok := w.(http.Flusher)
+		if !ok {
+			return errors.New("sse: streaming is not supported")
+		}
+
+		lastID := c.Request().Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = c.Param("last_event_id")
+		}
+		last, _ := strconv.Atoi(lastID)
+		types := map[string]bool{}
+		if t := c.Param("types"); t != "" {
+			for _, typ := range strings.Split(t, ",") {
+				types[typ] = true
+			}
+		}
+		wanted := func(e Event) bool {
+			return len(types) == 0 || types[e.Type]
+		}
+
+		sub, backlog, missed := h.Subscribe(last)
+		defer h.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(200)
+		if _, err := io.WriteString(w, "retry: 3000\n\n"); err != nil {
+			return nil
+		}
+		if missed {
+			if err := write(w, Event{ID: last, Type: Reset, Time: time.Now().UTC(), Data: []byte("null")}); err != nil {
+				return nil
+			}
+		}
+		for _, e := range backlog {
+			if !wanted(e) {
+				continue
+			}
+			if err := write(w, e); err != nil {
+				return nil
+			}
+		}
+		fl.Flush()
+
+		tick := time.NewTicker(heartbeat)
+		defer tick.Stop()
+		done := c.Request().Context().Done()
+		for {
+			select {
+			case <-done:
+				return nil
+			case e, ok := <-sub.C:
+				if !ok {
+					if sub.Dropped() {
+						c.Logger().Warn("sse: dropped a slow client")
+					}
+					return nil
+				}
+				if !wanted(e) {
+					continue
+				}
+				if err := write(w, e); err != nil {
+					return nil
+				}
+			case <-tick.C:
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return nil
+				}
+			}
+			fl.Flush()
+		}
+	}
+}
+
+// write writes e in the SSE format, the data is e as JSON.
+func write(w io.Writer, e Event) error {
+	if len(e.Data) == 0 {
+		e.Data = []byte("null")
+	}
+	// JSON has no raw newlines, the data fits on a line
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+	return err
+}
@@ -0,0 +1,165 @@
+// Package sse streams events to browsers as Server-Sent Events. A Hub
+// fans the events out to the subscribers and keeps the last ones, so a
+// client reconnecting with Last-Event-ID gets what it missed; Handler
+// serves the stream.
+package sse
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is a message of the stream. IDs must grow, the hub ignores the
+// events it has already seen.
+type Event struct {
+	ID   int             `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Subscription is the events of a subscriber. C is closed when the
+// subscriber is dropped for not keeping up, or when the hub is closed.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	dropped bool
+}
+
+// Dropped tells if the hub dropped the subscriber.
+func (s *Subscription) Dropped() bool {
+	return s.dropped
+}
+
+// Hub is an in-process pub/sub of events.
+type Hub struct {
+	moot *sync.Mutex
+	// replay is a ring of the last events, next is where the next one
+	// goes; evicted is the ID of the last event pushed out of it
+	replay  []Event
+	next    int
+	size    int
+	last    int
+	evicted int
+	send    int
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// NewHub returns a hub replaying up to replay events, with send events
+// of buffer per subscriber: a subscriber whose buffer is full is
+// dropped rather than holding back the others.
+func NewHub(replay, send int) *Hub {
+	return &Hub{
+		moot:   &sync.Mutex{},
+		replay: make([]Event, replay),
+		send:   send,
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Skip tells the hub the events up to id happened before it started:
+// clients resuming from before that missed events.
+func (h *Hub) Skip(id int) {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	if id > h.last {
+		h.last, h.evicted = id, id
+	}
+}
+
+// Publish sends e to the subscribers.
+func (h *Hub) Publish(e Event) {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	if e.ID <= h.last {
+		return
+	}
+	h.last = e.ID
+	if len(h.replay) == 0 {
+		h.evicted = e.ID
+	} else {
+		if h.size == len(h.replay) {
+			h.evicted = h.replay[h.next].ID
+		} else {
+			h.size++
+		}
+		h.replay[h.next] = e
+		h.next = (h.next + 1) % len(h.replay)
+	}
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			h.drop(s)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events after lastID, and the
+// ones of them the hub still has. missed tells if some of them are gone
+// already. lastID 0 is only the events from now on.
+func (h *Hub) Subscribe(lastID int) (s *Subscription, backlog []Event, missed bool) {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	if lastID > 0 {
+		backlog, missed = h.since(lastID)
+	}
+	c := make(chan Event, h.send)
+	s = &Subscription{C: c, c: c}
+	if h.closed {
+		close(c)
+		return s, backlog, missed
+	}
+	h.subs[s] = struct{}{}
+	return s, backlog, missed
+}
+
+// since returns the buffered events after id, and whether events after
+// id were evicted already.
+func (h *Hub) since(id int) ([]Event, bool) {
+	events := []Event{}
+	for i := h.size; i > 0; i-- {
+		e := h.replay[(h.next-i+len(h.replay))%len(h.replay)]
+		if e.ID > id {
+			events = append(events, e)
+		}
+	}
+	return events, h.evicted > id
+}
+
+// Unsubscribe stops the events of s.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// Close closes the subscriptions, the ones coming after get a closed C.
+func (h *Hub) Close() {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// Subscribers returns the number of subscribers.
+func (h *Hub) Subscribers() int {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	return len(h.subs)
+}
+
+func (h *Hub) drop(s *Subscription) {
+	s.dropped = true
+	delete(h.subs, s)
+	close(s.c)
+}
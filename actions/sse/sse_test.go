@@ -0,0 +1,257 @@
+package sse_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/sse"
+	"github.com/stretchr/testify/require"
+)
+
+func event(id int, typ string) sse.Event {
+	return sse.Event{ID: id, Type: typ, Time: time.Now().UTC(), Data: json.RawMessage(`{"id":1}`)}
+}
+
+func ids(events []sse.Event) []int {
+	list := []int{}
+	for _, e := range events {
+		list = append(list, e.ID)
+	}
+	return list
+}
+
+func Test_Hub(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(3, 10)
+
+	sub, backlog, missed := h.Subscribe(0)
+	r.Empty(backlog)
+	r.False(missed)
+	r.Equal(1, h.Subscribers())
+
+	for i := 1; i <= 5; i++ {
+		h.Publish(event(i, "user.created"))
+	}
+	// seen already
+	h.Publish(event(4, "user.created"))
+	got := []sse.Event{}
+	for len(sub.C) > 0 {
+		got = append(got, <-sub.C)
+	}
+	r.Equal([]int{1, 2, 3, 4, 5}, ids(got))
+
+	// resuming within the buffer
+	_, backlog, missed = h.Subscribe(3)
+	r.Equal([]int{4, 5}, ids(backlog))
+	r.False(missed)
+	_, backlog, missed = h.Subscribe(5)
+	r.Empty(backlog)
+	r.False(missed)
+
+	// 2 is gone
+	_, backlog, missed = h.Subscribe(1)
+	r.Equal([]int{3, 4, 5}, ids(backlog))
+	r.True(missed)
+
+	h.Unsubscribe(sub)
+	_, ok := <-sub.C
+	r.False(ok)
+	r.False(sub.Dropped())
+}
+
+func Test_Hub_Slow(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(10, 2)
+	slow, _, _ := h.Subscribe(0)
+	fast, _, _ := h.Subscribe(0)
+
+	for i := 1; i <= 3; i++ {
+		h.Publish(event(i, "user.created"))
+		<-fast.C
+	}
+	r.True(slow.Dropped())
+	r.False(fast.Dropped())
+	r.Equal(1, h.Subscribers())
+	// what it got, then closed
+	r.Equal(1, (<-slow.C).ID)
+	r.Equal(2, (<-slow.C).ID)
+	_, ok := <-slow.C
+	r.False(ok)
+	// unsubscribing a dropped subscriber is fine
+	h.Unsubscribe(slow)
+}
+
+func Test_Hub_Close(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(10, 2)
+	sub, _, _ := h.Subscribe(0)
+
+	h.Close()
+	_, ok := <-sub.C
+	r.False(ok)
+	r.False(sub.Dropped())
+	r.Equal(0, h.Subscribers())
+
+	late, _, _ := h.Subscribe(0)
+	_, ok = <-late.C
+	r.False(ok)
+	r.Equal(0, h.Subscribers())
+	h.Unsubscribe(late)
+}
+
+func Test_Hub_Skip(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(10, 2)
+	h.Skip(10)
+	h.Publish(event(9, "user.created"))
+	h.Publish(event(11, "user.created"))
+
+	_, backlog, missed := h.Subscribe(5)
+	r.Equal([]int{11}, ids(backlog))
+	r.True(missed)
+	_, _, missed = h.Subscribe(10)
+	r.False(missed)
+}
+
+// stream reads the events of a stream, ignoring the comments.
+type stream struct {
+	res   *http.Response
+	lines *bufio.Scanner
+}
+
+func open(t *testing.T, url string, lastID string) *stream {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	return &stream{res: res, lines: bufio.NewScanner(res.Body)}
+}
+
+// next returns the fields of the next message, comments included.
+func (s *stream) next(t *testing.T) map[string]string {
+	msg := map[string]string{}
+	for s.lines.Scan() {
+		line := s.lines.Text()
+		if line == "" {
+			if len(msg) > 0 {
+				return msg
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			msg["comment"] = strings.TrimSpace(line[1:])
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		msg[parts[0]] = parts[1]
+	}
+	t.Fatal("the stream ended")
+	return nil
+}
+
+func Test_Handler(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(10, 10)
+	a := buffalo.Automatic(buffalo.Options{})
+	a.GET("/events", sse.Handler(h, 50*time.Millisecond))
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+
+	h.Publish(event(1, "user.created"))
+	h.Publish(event(2, "user.login"))
+
+	s := open(t, ts.URL+"/events", "1")
+	defer s.res.Body.Close()
+	r.Equal("3000", s.next(t)["retry"])
+	msg := s.next(t)
+	r.Equal("2", msg["id"])
+	r.Equal("user.login", msg["event"])
+	data := sse.Event{}
+	r.NoError(json.Unmarshal([]byte(msg["data"]), &data))
+	r.Equal("user.login", data.Type)
+	r.JSONEq(`{"id":1}`, string(data.Data))
+
+	// live, with heartbeats in between
+	go h.Publish(event(3, "user.updated"))
+	for {
+		msg = s.next(t)
+		if msg["comment"] == "" {
+			break
+		}
+		r.Equal("ping", msg["comment"])
+	}
+	r.Equal("3", msg["id"])
+	msg = s.next(t)
+	r.Equal("ping", msg["comment"])
+}
+
+func Test_Handler_Filter(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(10, 10)
+	a := buffalo.Automatic(buffalo.Options{})
+	a.GET("/events", sse.Handler(h, time.Hour))
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+
+	for i := 1; i <= 3; i++ {
+		h.Publish(event(i, "user.created"))
+	}
+	h.Publish(event(4, "user.login"))
+	s := open(t, ts.URL+"/events?types=user.login&last_event_id=0", "")
+	defer s.res.Body.Close()
+	s.next(t)
+	go h.Publish(event(5, "user.created"))
+	go h.Publish(event(6, "user.login"))
+	r.Equal("6", s.next(t)["id"])
+}
+
+func Test_Handler_Reset(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(1, 10)
+	a := buffalo.Automatic(buffalo.Options{})
+	a.GET("/events", sse.Handler(h, time.Hour))
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+
+	for i := 1; i <= 3; i++ {
+		h.Publish(event(i, "user.created"))
+	}
+	s := open(t, ts.URL+"/events", "1")
+	defer s.res.Body.Close()
+	s.next(t)
+	r.Equal(sse.Reset, s.next(t)["event"])
+	r.Equal("3", s.next(t)["id"])
+}
+
+func Test_Handler_Disconnect(t *testing.T) {
+	r := require.New(t)
+	h := sse.NewHub(10, 10)
+	a := buffalo.Automatic(buffalo.Options{})
+	a.GET("/events", sse.Handler(h, time.Hour))
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", ts.URL+"/events", nil)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	r.NoError(err)
+	r.Equal(1, h.Subscribers())
+	cancel()
+	res.Body.Close()
+	for i := 0; i < 100 && h.Subscribers() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Equal(0, h.Subscribers())
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Images are the image types taken, by MIME type, and their extension.
+var Images = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Sniff returns the MIME type of the content of r, from its first 512
+// bytes, and a reader of the whole content.
+func Sniff(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, errors.WithStack(err)
+	}
+	head = head[:n]
+	return http.DetectContentType(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// Thumbnail returns the middle square of img scaled down to size, each
+// pixel the average of the ones it covers. Smaller images aren't scaled
+// up.
+func Thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	if side < size {
+		size = side
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0, sy1 := y0+y*side/size, y0+(y+1)*side/size
+		for x := 0; x < size; x++ {
+			sx0, sx1 := x0+x*side/size, x0+(x+1)*side/size
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// Encode writes img as contentType, one of Images.
+func Encode(w io.Writer, img image.Image, contentType string) error {
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "image/png":
+		err = png.Encode(w, img)
+	case "image/gif":
+		err = gif.Encode(w, img, nil)
+	default:
+		return errors.Errorf("storage: can't encode %s", contentType)
+	}
+	return errors.WithStack(err)
+}
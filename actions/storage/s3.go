@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UnsignedPayload is the payload hash of the requests whose body isn't
+// part of the signature.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3 keeps the files in a bucket of an S3-compatible service (AWS,
+// MinIO, ...), addressed path style: Endpoint/Bucket/key.
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// NewS3 returns the storage of bucket at endpoint, e.g.
+// https://s3.eu-west-1.amazonaws.com or http://localhost:9000.
+func NewS3(endpoint, region, bucket, accessKey, secretKey string) *S3 {
+	return &S3{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Put uploads the file. S3 wants the length of the body up front, the
+// file is read in memory first.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	res, err := s.do(ctx, "PUT", key, bytes.NewReader(b), func(req *http.Request) {
+		req.ContentLength = int64(len(b))
+		req.Header.Set("Content-Type", contentType)
+	})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	res, err := s.do(ctx, "GET", key, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := &Object{Key: key, ContentType: res.Header.Get("Content-Type"), Size: res.ContentLength}
+	o.Modified, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+	return res.Body, o, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	res, err := s.do(ctx, "DELETE", key, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// do sends a signed request for key, the statuses out of 2xx are
+// errors.
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, prepare func(*http.Request)) (*http.Response, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	u := s.Endpoint + "/" + uriEncode(s.Bucket, false) + "/" + uriEncode(key, false)
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+	if prepare != nil {
+		prepare(req)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", UnsignedPayload)
+	SignV4(req, s.AccessKey, s.SecretKey, s.Region, time.Now())
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	return nil, errors.Errorf("s3: %s %s: %s %s", method, key, res.Status, msg)
+}
+
+// SignV4 signs req for S3 with AWS Signature Version 4, at t. The
+// payload hash is the X-Amz-Content-Sha256 header, the one of an empty
+// body without it. Host and every header already set are signed.
+func SignV4(req *http.Request, accessKey, secretKey, region string, t time.Time) {
+	t = t.UTC()
+	stamp := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", stamp)
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		sum := sha256.Sum256(nil)
+		payload = hex.EncodeToString(sum[:])
+		req.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		headers["host"] = req.Host
+	}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		switch k {
+		case "authorization", "user-agent", "content-length", "accept-encoding", "connection":
+			continue
+		}
+		headers[k] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := []string{}
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signed,
+		payload,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := []string{}
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		vs := append([]string{}, q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes s the way AWS wants: everything but the unreserved
+// characters, and the slashes too when encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/pkg/errors"
+)
+
+// ErrExpired is returned for the URLs past their expiry.
+var ErrExpired = errors.New("storage: the URL has expired")
+
+// ErrSignature is returned for the URLs with a wrong signature.
+var ErrSignature = errors.New("storage: invalid signature")
+
+// Signer signs the URLs of the files: Prefix, the key, then the expiry
+// and the signature in the query.
+type Signer struct {
+	Key    []byte
+	Prefix string
+	TTL    time.Duration
+}
+
+// URL returns the URL of key, good for TTL give or take a quarter: the
+// expiry is rounded so the URL stays the same for a while, and browsers
+// can cache the file.
+func (s *Signer) URL(key string) string {
+	return s.URLAt(key, time.Now().Add(s.TTL).Truncate(s.TTL/4))
+}
+
+// URLAt returns the URL of key, good until expires.
+func (s *Signer) URLAt(key string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{"expires": {exp}, "signature": {s.sign(key, exp)}}
+	return s.Prefix + uriEncode(key, false) + "?" + q.Encode()
+}
+
+// Verify checks the expiry and the signature of the URL of key.
+func (s *Signer) Verify(key, expires, signature string) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(s.sign(key, expires)), []byte(signature)) {
+		return ErrSignature
+	}
+	if time.Now().Unix() > exp {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (s *Signer) sign(key, expires string) string {
+	h := hmac.New(sha256.New, s.Key)
+	fmt.Fprintf(h, "%s\n%s", key, expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Handler serves the files of st at the URLs s signed. The key is the
+// key route parameter: app.GET("/files/{key:.+}", storage.Handler(st, s)).
+func Handler(st Storage, s *Signer) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		key := c.Param("key")
+		if err := s.Verify(key, c.Param("expires"), c.Param("signature")); err != nil {
+			return c.Error(403, err)
+		}
+		rc, o, err := st.Open(c.Request().Context(), key)
+		if err == ErrNotFound || err == ErrInvalidKey {
+			return c.Error(404, err)
+		}
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		h := c.Response().Header()
+		h.Set("Content-Type", o.ContentType)
+		if o.Size >= 0 {
+			h.Set("Content-Length", strconv.FormatInt(o.Size, 10))
+		}
+		if !o.Modified.IsZero() {
+			h.Set("Last-Modified", o.Modified.UTC().Format(http.TimeFormat))
+		}
+		// as long as the URL is good, and not in shared caches
+		exp, _ := strconv.ParseInt(c.Param("expires"), 10, 64)
+		h.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", exp-time.Now().Unix()))
+		c.Response().WriteHeader(200)
+		_, err = io.Copy(c.Response(), rc)
+		return err
+	}
+}
@@ -0,0 +1,126 @@
+// Package storage keeps the uploaded files. Storage is what the app
+// uses, Local keeps the files on disk and S3 in a bucket of an
+// S3-compatible service; the files are served through URLs a Signer
+// signs, good for a while.
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned for the keys without a file.
+var ErrNotFound = errors.New("storage: file not found")
+
+// ErrInvalidKey is returned for the keys that aren't relative slash
+// separated paths.
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Object describes a stored file.
+type Object struct {
+	Key         string
+	ContentType string
+	Size        int64
+	Modified    time.Time
+}
+
+// Storage keeps files by key, a slash separated path like
+// avatars/1/photo.jpg.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open returns the content of a file, ErrNotFound if there's none.
+	Open(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Delete removes a file, deleting a missing file is fine.
+	Delete(ctx context.Context, key string) error
+}
+
+// CheckKey tells if key is a relative path without dot segments.
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// Local keeps the files under Dir. The content type comes from the
+// extension of the key.
+type Local struct {
+	Dir string
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the file next to its place first, a reader never sees it
+// half written.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), p))
+}
+
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+	ct := mime.TypeByExtension(path.Ext(key))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	return f, &Object{Key: key, ContentType: ct, Size: fi.Size(), Modified: fi.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Package storagetest has a fake S3-compatible server, like a local
+// MinIO, to test storage.S3 without the network.
+package storagetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/storage"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// S3 keeps the objects of its buckets in memory. Every request must be
+// signed with AccessKey and SecretKey, or it gets a 403.
+type S3 struct {
+	*httptest.Server
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	moot    sync.Mutex
+	objects map[string]object
+}
+
+// NewS3 starts a server, close it when done.
+func NewS3() *S3 {
+	s := &S3{
+		AccessKey: "minio",
+		SecretKey: "minio123",
+		Region:    "us-east-1",
+		objects:   map[string]object{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Storage returns a storage.S3 of bucket on the server.
+func (s *S3) Storage(bucket string) *storage.S3 {
+	return storage.NewS3(s.URL, s.Region, bucket, s.AccessKey, s.SecretKey)
+}
+
+// Len returns the number of objects in every bucket.
+func (s *S3) Len() int {
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	return len(s.objects)
+}
+
+func (s *S3) serve(w http.ResponseWriter, req *http.Request) {
+	if !s.verify(req) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	s.moot.Lock()
+	defer s.moot.Unlock()
+	switch req.Method {
+	case "PUT":
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[path] = object{b, req.Header.Get("Content-Type"), time.Now()}
+	case "GET":
+		o, ok := s.objects[path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", o.contentType)
+		w.Header().Set("Last-Modified", o.modified.UTC().Format(http.TimeFormat))
+		w.Write(o.data)
+	case "DELETE":
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var authorization = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/[^,]+, SignedHeaders=([^,]+), Signature=[0-9a-f]+$`)
+
+// verify signs a copy of req with the headers the client signed, at the
+// time it signed it, and compares.
+func (s *S3) verify(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	m := authorization.FindStringSubmatch(auth)
+	if m == nil || m[1] != s.AccessKey {
+		return false
+	}
+	t, err := time.Parse("20060102T150405Z", req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	signed, _ := http.NewRequest(req.Method, "http://"+req.Host+req.URL.RequestURI(), nil)
+	for _, h := range strings.Split(m[2], ";") {
+		if h != "host" {
+			signed.Header[http.CanonicalHeaderKey(h)] = req.Header[http.CanonicalHeaderKey(h)]
+		}
+	}
+	storage.SignV4(signed, s.AccessKey, s.SecretKey, s.Region, t)
+	return signed.Header.Get("Authorization") == auth
+}
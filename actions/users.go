@@ -1,19 +1,110 @@
 package actions
 
-import "github.com/gobuffalo/buffalo"
+import (
+	"database/sql"
+	"html/template"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/jsonapi"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/actions/search"
+	"github.com/leonids/test-buffalo/actions/webhooks"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// userSortable are the columns the users may be sorted on.
+var userSortable = []string{"name", "email", "created_at"}
+
+// userSearch is the full-text index of the users, see the
+// add_search_to_users migration.
+var userSearch = &search.Index{Table: "users", Columns: []string{"name", "email"}, Vector: "search", FTS: "users_search"}
 
 type UsersResource struct {
 	buffalo.Resource
 }
 
-// List default implementation.
+// List renders the users as a JSON:API collection, sortable on name,
+// email and created_at and paginated with page[cursor]. ?q= searches
+// them, the best matches first unless sorted, with the matching words
+// highlighted in the meta of each user. Browsers get the HTML index.
 func (v UsersResource) List(c buffalo.Context) error {
-	return c.Render(200, r.String("Users#List"))
+	tx := c.Value("tx").(*pop.Connection)
+	q, err := jsonapi.ParseQuery(c, userSortable...)
+	if err != nil {
+		return err
+	}
+	pq, terms := searchUsers(c, tx, q)
+	users := &models.Users{}
+	// the query string is all there is to the query
+	key := "users?" + c.Request().URL.Query().Encode()
+	if err := appCache.All(q.Apply(pq), key, users, 0, "user"); err != nil {
+		return errors.WithStack(err)
+	}
+	if wantsHTML(c) {
+		return usersIndex(c, (*users)[:q.PageLen(len(*users))], terms)
+	}
+	if len(terms) > 0 {
+		q.Meta = func(model interface{}) map[string]interface{} {
+			u := model.(*models.User)
+			return map[string]interface{}{"highlight": map[string]template.HTML{
+				"name":  search.Highlight(u.Name, terms),
+				"email": search.Highlight(u.Email, terms),
+			}}
+		}
+	}
+	return c.Render(200, r.JSONAPI(users, q))
+}
+
+// searchUsers is the query of the users matching ?q=, ranked and paged
+// by offset when q doesn't sort them, and the words searched.
+func searchUsers(c buffalo.Context, tx *pop.Connection, q *jsonapi.Query) (*pop.Query, []string) {
+	terms := search.Terms(c.Param("q"))
+	// the rank isn't a column, the ranked pages are by offset
+	q.Offset = len(terms) > 0 && len(q.Sort) == 0
+	return userSearch.Match(pop.Q(tx), terms, q.Offset), terms
+}
+
+// userRow is a user of the HTML index, highlighted.
+type userRow struct {
+	ID    int
+	Name  template.HTML
+	Email template.HTML
+}
+
+// usersIndex renders the HTML index of users, with the instant search
+// box.
+func usersIndex(c buffalo.Context, users models.Users, terms []string) error {
+	rows := make([]userRow, len(users))
+	for i, u := range users {
+		rows[i] = userRow{ID: u.ID, Name: search.Highlight(u.Name, terms), Email: search.Highlight(u.Email, terms)}
+	}
+	query := url.Values{"q": {c.Param("q")}}.Encode()
+	c.Set("users", rows)
+	c.Set("q", c.Param("q"))
+	c.Set("csv_url", "/admin/users.csv?"+query)
+	c.Set("ndjson_url", "/admin/users.ndjson?"+query)
+	return c.Render(200, r.HTML("users/index.html"))
 }
 
-// Show default implementation.
+// Show renders a user.
 func (v UsersResource) Show(c buffalo.Context) error {
-	return c.Render(200, r.String("Users#Show"))
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := findUser(tx, c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+	q, err := jsonapi.ParseQuery(c)
+	if err != nil {
+		return err
+	}
+	return c.Render(200, r.JSONAPI(u, q))
 }
 
 // New default implementation.
@@ -21,9 +112,21 @@ func (v UsersResource) New(c buffalo.Context) error {
 	return c.Render(200, r.String("Users#New"))
 }
 
-// Create default implementation.
+// Create adds a user from a JSON:API, JSON or form body, for the
+// admins.
 func (v UsersResource) Create(c buffalo.Context) error {
-	return c.Render(200, r.String("Users#Create"))
+	tx := c.Value("tx").(*pop.Connection)
+	if err := authorizeUser(c, ""); err != nil {
+		return err
+	}
+	u := &models.User{}
+	if err := c.Bind(u); err != nil {
+		return problem.BadRequest("can't read the user: %s", err)
+	}
+	if err := createUser(tx, u); err != nil {
+		return err
+	}
+	return c.Render(201, r.JSONAPI(u, nil))
 }
 
 // Edit default implementation.
@@ -31,14 +134,141 @@ func (v UsersResource) Edit(c buffalo.Context) error {
 	return c.Render(200, r.String("Users#Edit"))
 }
 
-// Update default implementation.
+// Update changes a user from a JSON:API, JSON or form body. A
+// multipart form may carry a new avatar picture too. Only the user and
+// the admins change it.
 func (v UsersResource) Update(c buffalo.Context) error {
-	return c.Render(200, r.String("Users#Update"))
+	tx := c.Value("tx").(*pop.Connection)
+	if err := authorizeUser(c, c.Param("user_id")); err != nil {
+		return err
+	}
+	u, err := findUser(tx, c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+	id, avatar := u.ID, u.Avatar
+	var upload *multipart.FileHeader
+	if strings.HasPrefix(c.Request().Header.Get("Content-Type"), "multipart/form-data") {
+		if upload, err = bindMultipart(c, u); err != nil {
+			return err
+		}
+	} else if err := c.Bind(u); err != nil {
+		return problem.BadRequest("can't read the user: %s", err)
+	}
+	u.ID, u.Avatar = id, avatar
+	if upload != nil {
+		if err := saveAvatar(c, u, upload); err != nil {
+			return err
+		}
+	}
+	if err := updateUser(tx, u); err != nil {
+		if u.Avatar != avatar {
+			deleteAvatar(c, u.Avatar)
+		}
+		return err
+	}
+	if u.Avatar != avatar {
+		deleteAvatar(c, avatar)
+	}
+	return c.Render(200, r.JSONAPI(u, nil))
 }
 
-// Destroy default implementation.
+// Destroy deletes a user, for the user and the admins.
 func (v UsersResource) Destroy(c buffalo.Context) error {
-	return c.Render(200, r.String("Users#Destroy"))
+	tx := c.Value("tx").(*pop.Connection)
+	if err := authorizeUser(c, c.Param("user_id")); err != nil {
+		return err
+	}
+	u, err := findUser(tx, c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+	if err := destroyUser(tx, u); err != nil {
+		return err
+	}
+	deleteAvatar(c, u.Avatar)
+	return c.Render(204, nil)
+}
+
+// authorizeUser lets the admins, and the scripts with the basic auth
+// credentials, write any user. The other signed in users only write the
+// one of id when it's the row linked to their account: by ID, the email
+// of a row changes. An empty id is a new user, only the admins create
+// them.
+func authorizeUser(c buffalo.Context, id string) error {
+	if adminName(c) != "" {
+		return nil
+	}
+	user := currentUser(c)
+	if user == nil {
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		return problem.Unauthorized("sign in to change users")
+	}
+	if id != "" && user.UserID != 0 && strconv.Itoa(user.UserID) == id {
+		return nil
+	}
+	return problem.Forbidden("%s can't change that user", user.Email)
+}
+
+// createUser validates and inserts u. Every way of creating users goes
+// through it, so they all share the same rules, webhooks and welcome
+// mail. The account of its email, if any, is linked to it once
+// it's committed.
+func createUser(tx *pop.Connection, u *models.User) error {
+	u.ID = 0
+	verrs, err := tx.ValidateAndCreate(u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if verrs.HasAny() {
+		return problem.Validation(verrs)
+	}
+	err = sendMail(tx, u.Email, "Welcome to Test Buffalo", "welcome", map[string]interface{}{"email": u.Email})
+	if err != nil {
+		return err
+	}
+	email, id := u.Email, u.ID
+	err = mw.AfterCommit(tx, func() error {
+		if authStore != nil {
+			authStore.Link(email, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return notify(tx, webhooks.UserCreated, u)
+}
+
+// updateUser validates and saves u.
+func updateUser(tx *pop.Connection, u *models.User) error {
+	verrs, err := tx.ValidateAndUpdate(u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if verrs.HasAny() {
+		return problem.Validation(verrs)
+	}
+	return notify(tx, webhooks.UserUpdated, u)
+}
+
+// destroyUser deletes u.
+func destroyUser(tx *pop.Connection, u *models.User) error {
+	if err := tx.Destroy(u); err != nil {
+		return errors.WithStack(err)
+	}
+	return notify(tx, webhooks.UserDeleted, u)
+}
+
+func findUser(tx *pop.Connection, id string) (*models.User, error) {
+	u := &models.User{}
+	if err := tx.Find(u, id); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, problem.NotFound("no user with id %s", id)
+		}
+		return nil, errors.WithStack(err)
+	}
+	return u, nil
 }
 
 // UsersShow default implementation.
@@ -0,0 +1,248 @@
+package actions
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/leonids/test-buffalo/actions/auth"
+	"github.com/leonids/test-buffalo/actions/jobs"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/actions/webhooks"
+	"github.com/leonids/test-buffalo/models"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"gopkg.in/authboss.v1"
+)
+
+// WebhooksResource manages the webhook subscriptions.
+type WebhooksResource struct{}
+
+// List renders the subscriptions, without their secrets.
+func (v WebhooksResource) List(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	subs := &webhooks.Subscriptions{}
+	if err := tx.Order("id").All(subs); err != nil {
+		return errors.WithStack(err)
+	}
+	for i := range *subs {
+		(*subs)[i].Secret = ""
+	}
+	return c.Render(200, r.JSON(subs))
+}
+
+// Show renders a subscription, without its secret.
+func (v WebhooksResource) Show(c buffalo.Context) error {
+	s, err := findSubscription(c.Value("tx").(*pop.Connection), c.Param("webhook_id"))
+	if err != nil {
+		return err
+	}
+	s.Secret = ""
+	return c.Render(200, r.JSON(s))
+}
+
+// Create adds a subscription. A secret is made up when none is given;
+// this is the only response showing it.
+func (v WebhooksResource) Create(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	s := &webhooks.Subscription{Active: true}
+	if err := c.Bind(s); err != nil {
+		return problem.BadRequest("can't read the subscription: %s", err)
+	}
+	s.ID = 0
+	if s.Secret == "" {
+		s.Secret = webhooks.NewSecret()
+	}
+	verrs, err := tx.ValidateAndCreate(s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if verrs.HasAny() {
+		return problem.Validation(verrs)
+	}
+	return c.Render(201, r.JSON(s))
+}
+
+// Update changes a subscription. The secret is kept unless a new one is
+// given.
+func (v WebhooksResource) Update(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	s, err := findSubscription(tx, c.Param("webhook_id"))
+	if err != nil {
+		return err
+	}
+	id := s.ID
+	if err := c.Bind(s); err != nil {
+		return problem.BadRequest("can't read the subscription: %s", err)
+	}
+	s.ID = id
+	verrs, err := tx.ValidateAndUpdate(s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if verrs.HasAny() {
+		return problem.Validation(verrs)
+	}
+	s.Secret = ""
+	return c.Render(200, r.JSON(s))
+}
+
+// Destroy deletes a subscription and its delivery log.
+func (v WebhooksResource) Destroy(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	s, err := findSubscription(tx, c.Param("webhook_id"))
+	if err != nil {
+		return err
+	}
+	if err := tx.RawQuery("DELETE FROM webhook_deliveries WHERE subscription_id = ?", s.ID).Exec(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := tx.Destroy(s); err != nil {
+		return errors.WithStack(err)
+	}
+	return c.Render(204, nil)
+}
+
+// Deliveries renders the delivery log of a subscription, newest first,
+// 50 at a time (?page=).
+func (v WebhooksResource) Deliveries(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	s, err := findSubscription(tx, c.Param("webhook_id"))
+	if err != nil {
+		return err
+	}
+	page, _ := strconv.Atoi(c.Param("page"))
+	list := &webhooks.Deliveries{}
+	if err := tx.Where("subscription_id = ?", s.ID).Order("id DESC").Paginate(page, 50).All(list); err != nil {
+		return errors.WithStack(err)
+	}
+	return c.Render(200, r.JSON(list))
+}
+
+// Redeliver queues the event of a past delivery again.
+func (v WebhooksResource) Redeliver(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	s, err := findSubscription(tx, c.Param("webhook_id"))
+	if err != nil {
+		return err
+	}
+	d := &webhooks.Delivery{}
+	err = tx.Where("subscription_id = ? AND id = ?", s.ID, c.Param("delivery_id")).First(d)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return problem.NotFound("no delivery with id %s", c.Param("delivery_id"))
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	nd, err := webhooks.Redeliver(tx, d)
+	if err != nil {
+		return err
+	}
+	if err := jobs.Enqueue(tx, webhooksJob, nil, jobs.Options{Queue: "webhooks", Unique: webhooksJob}); err != nil {
+		return err
+	}
+	return c.Render(202, r.JSON(nd))
+}
+
+func findSubscription(tx *pop.Connection, id string) (*webhooks.Subscription, error) {
+	s := &webhooks.Subscription{}
+	if err := tx.Find(s, id); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, problem.NotFound("no webhook with id %s", id)
+		}
+		return nil, errors.WithStack(err)
+	}
+	return s, nil
+}
+
+func initWebhookRoutes(g *buffalo.App) {
+	v := WebhooksResource{}
+	tags := []string{"webhooks"}
+	sub := webhooks.Subscription{}
+	api.Document(g.GET("/webhooks", v.List), openapi.Operation{Summary: "List webhook subscriptions", Tags: tags, Response: webhooks.Subscriptions{}})
+	api.Document(g.POST("/webhooks", v.Create), openapi.Operation{Summary: "Subscribe to events", Tags: tags, Request: sub, Response: sub, Status: 201})
+	api.Document(g.GET("/webhooks/{webhook_id}", v.Show), openapi.Operation{Summary: "Show a webhook subscription", Tags: tags, Response: sub})
+	api.Document(g.PUT("/webhooks/{webhook_id}", v.Update), openapi.Operation{Summary: "Update a webhook subscription", Tags: tags, Request: sub, Response: sub})
+	api.Document(g.DELETE("/webhooks/{webhook_id}", v.Destroy), openapi.Operation{Summary: "Delete a webhook subscription", Tags: tags, Status: 204})
+	api.Document(g.GET("/webhooks/{webhook_id}/deliveries", v.Deliveries), openapi.Operation{
+		Summary:  "Delivery log of a webhook subscription",
+		Tags:     tags,
+		Query:    []openapi.Parameter{{Name: "page"}},
+		Response: webhooks.Deliveries{},
+	})
+	api.Document(g.POST("/webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver", v.Redeliver), openapi.Operation{
+		Summary:  "Send the event of a delivery again",
+		Tags:     tags,
+		Response: webhooks.Delivery{},
+		Status:   202,
+	})
+}
+
+// authUser is the webhook payload of authboss users.
+type authUser struct {
+	Email  string `json:"email"`
+	Source string `json:"source"`
+}
+
+// initAuthWebhooks sends webhooks for the authboss account events, and
+// locks users out after ab.LockAfter failed sign ins. Authboss has no
+// request transaction, every event gets its own.
+func initAuthWebhooks(ab *authboss.Authboss) {
+	enqueue := func(event string, ctx *authboss.Context) error {
+		email, _ := ctx.User.String(authboss.StoreEmail)
+		return models.DB.Transaction(func(tx *pop.Connection) error {
+			return notify(tx, event, authUser{Email: email, Source: "auth"})
+		})
+	}
+	ab.Callbacks.After(authboss.EventRegister, func(ctx *authboss.Context) error {
+		return enqueue(webhooks.UserCreated, ctx)
+	})
+	// the lock module of authboss isn't vendored, the failed attempts
+	// are counted here: EventAuth and EventAuthFail come from whichever
+	// module signs users in
+	ab.Callbacks.Before(authboss.EventAuth, func(ctx *authboss.Context) (authboss.Interrupt, error) {
+		if locked, ok := ctx.User.DateTime("locked"); ok && locked.After(time.Now()) {
+			return authboss.InterruptAccountLocked, nil
+		}
+		return authboss.InterruptNone, nil
+	})
+	ab.Callbacks.After(authboss.EventAuth, func(ctx *authboss.Context) error {
+		email, _ := ctx.User.String(authboss.StoreEmail)
+		return authStore.Unlock(email)
+	})
+	ab.Callbacks.After(authboss.EventAuthFail, func(ctx *authboss.Context) error {
+		email, _ := ctx.User.String(authboss.StoreEmail)
+		locked, err := authStore.Fail(email, time.Now(), ab.LockAfter, ab.LockWindow, ab.LockDuration)
+		if err != nil || !locked {
+			return err
+		}
+		return enqueue(webhooks.UserLocked, ctx)
+	})
+}
+
+// confirmStorer sends the user.confirmed webhook when the confirm module
+// saves a freshly confirmed user: authboss has no callback for it. The
+// MemStorer is embedded so the modules still find the methods of the
+// optional storer interfaces.
+type confirmStorer struct {
+	*store.MemStorer
+}
+
+// Put implements authboss.Storer.
+func (s confirmStorer) Put(key string, attr authboss.Attributes) error {
+	was := false
+	if old, err := s.MemStorer.Get(key); err == nil {
+		was = old.(*store.User).Confirmed
+	}
+	if err := s.MemStorer.Put(key, attr); err != nil {
+		return err
+	}
+	if now, _ := attr.Bool("confirmed"); now && !was {
+		return models.DB.Transaction(func(tx *pop.Connection) error {
+			return notify(tx, webhooks.UserConfirmed, authUser{Email: key, Source: "auth"})
+		})
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+)
+
+// Dispatcher sends the queued deliveries.
+type Dispatcher struct {
+	DB     *pop.Connection
+	Client *http.Client
+	Logger buffalo.Logger
+	// MaxAttempts before a delivery is marked as failed, 10 when zero.
+	MaxAttempts int
+	// Backoff is the delay before the retry following the given attempt,
+	// DefaultBackoff when nil.
+	Backoff func(attempt int) time.Duration
+	// Lease is how long a delivery being sent is hidden from the other
+	// dispatchers, a minute when zero. It must exceed the client timeout.
+	Lease time.Duration
+	// Batch is the number of deliveries claimed at once, 20 when zero.
+	Batch int
+}
+
+// NewDispatcher returns a dispatcher of the deliveries in db with a 10
+// seconds timeout per request.
+func NewDispatcher(db *pop.Connection) *Dispatcher {
+	return &Dispatcher{
+		DB:     db,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Logger: buffalo.NewLogger("info"),
+	}
+}
+
+// DefaultBackoff doubles the delay with each attempt, from 30 seconds up
+// to 12 hours: 10 attempts span about 4 hours.
+func DefaultBackoff(attempt int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempt && d < 12*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 12*time.Hour {
+		d = 12 * time.Hour
+	}
+	return d
+}
+
+// Run sends the due deliveries every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := d.Dispatch(); err != nil {
+			d.Logger.Errorf("webhooks: %+v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Dispatch sends the deliveries that are due and returns how many it
+// tried.
+func (d *Dispatcher) Dispatch() (int, error) {
+	list, err := d.claim()
+	if err != nil {
+		return 0, err
+	}
+	for i := range list {
+		if err := d.send(&list[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(list), nil
+}
+
+// claim picks the due deliveries and pushes their next attempt back by
+// the lease, so other dispatchers leave them alone while they are sent.
+// A dispatcher dying in the middle of a send only delays the delivery.
+func (d *Dispatcher) claim() (Deliveries, error) {
+	list := Deliveries{}
+	now := time.Now().UTC()
+	lease := d.Lease
+	if lease == 0 {
+		lease = time.Minute
+	}
+	batch := d.Batch
+	if batch == 0 {
+		batch = 20
+	}
+	err := d.DB.Transaction(func(tx *pop.Connection) error {
+		q := "SELECT * FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT " + strconv.Itoa(batch)
+		if tx.Dialect.Details().Dialect != "sqlite3" {
+			q += " FOR UPDATE"
+		}
+		if err := tx.RawQuery(q, Pending, now).All(&list); err != nil {
+			return errors.WithStack(err)
+		}
+		for i := range list {
+			list[i].NextAttemptAt = now.Add(lease)
+			if err := tx.Update(&list[i]); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+// send makes an attempt at delivering dl and records the outcome.
+func (d *Dispatcher) send(dl *Delivery) error {
+	sub := &Subscription{}
+	if err := d.DB.Find(sub, dl.SubscriptionID); err != nil {
+		if errors.Cause(err) != sql.ErrNoRows {
+			return errors.WithStack(err)
+		}
+		dl.Status, dl.Error = Failed, "the subscription is gone"
+		return errors.WithStack(d.DB.Update(dl))
+	}
+
+	dl.Attempts++
+	dl.ResponseStatus, dl.ResponseBody, dl.Error = 0, "", ""
+	status, body, err := d.post(sub, dl)
+	dl.ResponseStatus, dl.ResponseBody = status, body
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		dl.Status = Succeeded
+	default:
+		if err != nil {
+			dl.Error = err.Error()
+		} else {
+			dl.Error = "unexpected status " + strconv.Itoa(status)
+		}
+		max := d.MaxAttempts
+		if max == 0 {
+			max = 10
+		}
+		if dl.Attempts >= max || !sub.Active {
+			dl.Status = Failed
+			break
+		}
+		backoff := d.Backoff
+		if backoff == nil {
+			backoff = DefaultBackoff
+		}
+		dl.NextAttemptAt = time.Now().UTC().Add(backoff(dl.Attempts))
+	}
+	return errors.WithStack(d.DB.Update(dl))
+}
+
+func (d *Dispatcher) post(sub *Subscription, dl *Delivery) (int, string, error) {
+	body := []byte(dl.Payload)
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "test-buffalo-webhooks/1")
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(IDHeader, dl.EventID)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now().UTC(), body))
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	// only the start of the answer is kept in the log
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	return res.StatusCode, string(b), err
+}
+
+// NextAttempt returns when the next pending delivery is due, false when
+// there's none.
+func (d *Dispatcher) NextAttempt() (time.Time, bool, error) {
+	dl := &Delivery{}
+	err := d.DB.Where("status = ?", Pending).Order("next_attempt_at").First(dl)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, errors.WithStack(err)
+	}
+	return dl.NextAttemptAt, true, nil
+}
@@ -0,0 +1,218 @@
+// Package webhooks notifies other systems of what happens in the app. A
+// Subscription asks for the events of some types to be POSTed to a URL;
+// Enqueue stores a Delivery per matching subscription, in the caller's
+// transaction, and a Dispatcher sends them, signed with the subscription
+// secret, retrying failures with an exponential backoff.
+package webhooks
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/pkg/errors"
+)
+
+// The events the app sends.
+const (
+	UserCreated   = "user.created"
+	UserUpdated   = "user.updated"
+	UserConfirmed = "user.confirmed"
+	UserLocked    = "user.locked"
+	UserDeleted   = "user.deleted"
+)
+
+func init() {
+	pop.MapTableName("Subscription", "webhook_subscriptions")
+	pop.MapTableName("Delivery", "webhook_deliveries")
+}
+
+// Events lists every event type a subscription can ask for. "*" stands
+// for all of them.
+var Events = []string{UserCreated, UserUpdated, UserConfirmed, UserLocked, UserDeleted}
+
+// EventTypes is a list of event types, stored comma separated.
+type EventTypes []string
+
+// Value implements driver.Valuer.
+func (e EventTypes) Value() (driver.Value, error) {
+	return strings.Join(e, ","), nil
+}
+
+// Scan implements sql.Scanner.
+func (e *EventTypes) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return errors.Errorf("can't scan %T into EventTypes", src)
+	}
+	*e = EventTypes{}
+	if s != "" {
+		*e = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// Has tells if event is in the list.
+func (e EventTypes) Has(event string) bool {
+	for _, t := range e {
+		if t == event || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription is an endpoint listening to some event types.
+type Subscription struct {
+	ID        int        `json:"id" db:"id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	URL       string     `json:"url" db:"url"`
+	Secret    string     `json:"secret,omitempty" db:"secret"`
+	Events    EventTypes `json:"events" db:"events"`
+	Active    bool       `json:"active" db:"active"`
+}
+
+// Subscriptions is a list of subscriptions.
+type Subscriptions []Subscription
+
+// Validate gets run every time you call a "pop.Validate" method.
+func (s *Subscription) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	verrs := validate.Validate(
+		&validators.StringIsPresent{Field: s.URL, Name: "URL"},
+		&validators.StringIsPresent{Field: s.Secret, Name: "Secret"},
+	)
+	if u, err := url.Parse(s.URL); s.URL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		verrs.Add("url", "URL must be an absolute http or https URL.")
+	}
+	if len(s.Events) == 0 {
+		verrs.Add("events", "Events can not be empty.")
+	}
+	for _, e := range s.Events {
+		if e != "*" && !known(e) {
+			verrs.Add("events", e+" is not an event type.")
+		}
+	}
+	return verrs, nil
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() string {
+	return "whsec_" + randomHex(24)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// The states of a delivery.
+const (
+	Pending   = "pending"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// Delivery is an event on its way to a subscription, and the log of how
+// it went.
+type Delivery struct {
+	ID             int       `json:"id" db:"id"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+	SubscriptionID int       `json:"subscription_id" db:"subscription_id"`
+	EventID        string    `json:"event_id" db:"event_id"`
+	Event          string    `json:"event" db:"event"`
+	Payload        string    `json:"payload" db:"payload"`
+	Status         string    `json:"status" db:"status"`
+	Attempts       int       `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	ResponseStatus int       `json:"response_status" db:"response_status"`
+	ResponseBody   string    `json:"response_body" db:"response_body"`
+	Error          string    `json:"error" db:"error"`
+}
+
+// Deliveries is a list of deliveries.
+type Deliveries []Delivery
+
+// Envelope is the body POSTed to subscribers.
+type Envelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Enqueue queues event for every active subscription listening to it.
+// Call it with the transaction making the change, so the deliveries are
+// only stored when the change is.
+func Enqueue(tx *pop.Connection, event string, data interface{}) error {
+	if !known(event) {
+		return errors.Errorf("webhooks: unknown event %s", event)
+	}
+	subs := &Subscriptions{}
+	if err := tx.Where("active = ?", true).All(subs); err != nil {
+		return errors.WithStack(err)
+	}
+
+	env := Envelope{ID: "evt_" + randomHex(12), Event: event, CreatedAt: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, s := range *subs {
+		if !s.Events.Has(event) {
+			continue
+		}
+		d := &Delivery{
+			SubscriptionID: s.ID,
+			EventID:        env.ID,
+			Event:          event,
+			Payload:        string(payload),
+			Status:         Pending,
+			NextAttemptAt:  env.CreatedAt,
+		}
+		if err := tx.Create(d); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// Redeliver queues a new delivery of the same event as d, to be sent
+// right away. The log of d is left as it is.
+func Redeliver(tx *pop.Connection, d *Delivery) (*Delivery, error) {
+	nd := &Delivery{
+		SubscriptionID: d.SubscriptionID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		Payload:        d.Payload,
+		Status:         Pending,
+		NextAttemptAt:  time.Now().UTC(),
+	}
+	return nd, errors.WithStack(tx.Create(nd))
+}
@@ -0,0 +1,175 @@
+package webhooks_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions/webhooks"
+	"github.com/leonids/test-buffalo/models/modelstest"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the requests it gets and answers with the status at
+// the head of statuses, or 200.
+type receiver struct {
+	*httptest.Server
+	secret   string
+	statuses []int
+	bodies   []string
+	events   []string
+	verified []error
+}
+
+func newReceiver(secret string, statuses ...int) *receiver {
+	rc := &receiver{secret: secret, statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		rc.bodies = append(rc.bodies, string(body))
+		rc.events = append(rc.events, req.Header.Get(webhooks.EventHeader))
+		rc.verified = append(rc.verified, webhooks.Verify(rc.secret, req.Header.Get(webhooks.SignatureHeader), body, 5*time.Minute))
+		status := 200
+		if len(rc.statuses) > 0 {
+			status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("thanks"))
+	}))
+	return rc
+}
+
+func Test_Deliveries(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	rc := newReceiver("s3cret", 500)
+	defer rc.Close()
+	r.NoError(db.Create(&webhooks.Subscription{URL: rc.URL, Secret: "s3cret", Events: webhooks.EventTypes{webhooks.UserCreated}, Active: true}))
+	r.NoError(db.Create(&webhooks.Subscription{URL: rc.URL, Secret: "other", Events: webhooks.EventTypes{"*"}, Active: false}))
+
+	r.NoError(webhooks.Enqueue(db, webhooks.UserCreated, map[string]int{"id": 7}))
+	r.NoError(webhooks.Enqueue(db, webhooks.UserDeleted, map[string]int{"id": 7}))
+	r.Error(webhooks.Enqueue(db, "user.renamed", nil))
+
+	list := &webhooks.Deliveries{}
+	r.NoError(db.All(list))
+	r.Len(*list, 1, "only the active subscription listening to the event gets it")
+
+	d := webhooks.NewDispatcher(db)
+	d.Backoff = func(int) time.Duration { return 0 }
+	d.MaxAttempts = 2
+
+	// the first attempt fails and is retried
+	n, err := d.Dispatch()
+	r.NoError(err)
+	r.Equal(1, n)
+	dl := &webhooks.Delivery{}
+	r.NoError(db.First(dl))
+	r.Equal(webhooks.Pending, dl.Status)
+	r.Equal(1, dl.Attempts)
+	r.Equal(500, dl.ResponseStatus)
+	r.Equal("thanks", dl.ResponseBody)
+	r.Equal("unexpected status 500", dl.Error)
+
+	n, err = d.Dispatch()
+	r.NoError(err)
+	r.Equal(1, n)
+	r.NoError(db.Reload(dl))
+	r.Equal(webhooks.Succeeded, dl.Status)
+	r.Equal(2, dl.Attempts)
+
+	n, err = d.Dispatch()
+	r.NoError(err)
+	r.Equal(0, n)
+	_, pending, err := d.NextAttempt()
+	r.NoError(err)
+	r.False(pending)
+
+	r.Len(rc.bodies, 2)
+	r.Equal(rc.bodies[0], rc.bodies[1], "retries send the same event")
+	r.Contains(rc.bodies[0], `"event":"user.created"`)
+	r.Contains(rc.bodies[0], `"data":{"id":7}`)
+	r.Equal([]string{webhooks.UserCreated, webhooks.UserCreated}, rc.events)
+	r.NoError(rc.verified[0])
+	r.NoError(rc.verified[1])
+
+	// redelivering sends the event again and keeps the log
+	nd, err := webhooks.Redeliver(db, dl)
+	r.NoError(err)
+	r.NotEqual(dl.ID, nd.ID)
+	r.Equal(dl.EventID, nd.EventID)
+	n, err = d.Dispatch()
+	r.NoError(err)
+	r.Equal(1, n)
+	r.Len(rc.bodies, 3)
+	r.Equal(rc.bodies[0], rc.bodies[2])
+}
+
+func Test_Deliveries_GiveUp(t *testing.T) {
+	r := require.New(t)
+	db := modelstest.SQLite(t)
+
+	rc := newReceiver("s3cret", 500, 502, 503)
+	defer rc.Close()
+	r.NoError(db.Create(&webhooks.Subscription{URL: rc.URL, Secret: "s3cret", Events: webhooks.EventTypes{"*"}, Active: true}))
+	r.NoError(webhooks.Enqueue(db, webhooks.UserLocked, nil))
+
+	d := webhooks.NewDispatcher(db)
+	d.MaxAttempts = 2
+	_, err := d.Dispatch()
+	r.NoError(err)
+
+	dl := &webhooks.Delivery{}
+	r.NoError(db.First(dl))
+	r.True(dl.NextAttemptAt.After(time.Now().Add(20*time.Second)), "retries back off")
+	next, pending, err := d.NextAttempt()
+	r.NoError(err)
+	r.True(pending)
+	r.WithinDuration(dl.NextAttemptAt, next, time.Second)
+	n, err := d.Dispatch()
+	r.NoError(err)
+	r.Equal(0, n, "the retry isn't due yet")
+
+	dl.NextAttemptAt = time.Now().UTC().Add(-time.Second)
+	r.NoError(db.Update(dl))
+	_, err = d.Dispatch()
+	r.NoError(err)
+	r.NoError(db.Reload(dl))
+	r.Equal(webhooks.Failed, dl.Status)
+	r.Equal(2, dl.Attempts)
+	r.Equal(502, dl.ResponseStatus)
+}
+
+func Test_Sign(t *testing.T) {
+	r := require.New(t)
+	body := []byte(`{"id":"evt_1"}`)
+	sig := webhooks.Sign("s3cret", time.Now(), body)
+	r.Regexp(`^t=\d+,v1=[0-9a-f]{64}$`, sig)
+
+	r.NoError(webhooks.Verify("s3cret", sig, body, time.Minute))
+	r.Error(webhooks.Verify("wrong", sig, body, time.Minute))
+	r.Error(webhooks.Verify("s3cret", sig, []byte(`{"id":"evt_2"}`), time.Minute))
+	r.Error(webhooks.Verify("s3cret", "garbage", body, time.Minute))
+
+	old := webhooks.Sign("s3cret", time.Now().Add(-time.Hour), body)
+	r.Error(webhooks.Verify("s3cret", old, body, time.Minute))
+	r.NoError(webhooks.Verify("s3cret", old, body, 0))
+}
+
+func Test_Subscription_Validate(t *testing.T) {
+	r := require.New(t)
+	s := &webhooks.Subscription{URL: "ftp://example.com", Events: webhooks.EventTypes{"user.created", "user.renamed"}}
+	verrs, err := s.Validate(nil)
+	r.NoError(err)
+	r.True(verrs.HasAny())
+	r.NotEmpty(verrs.Get("url"))
+	r.NotEmpty(verrs.Get("secret"))
+	r.Len(verrs.Get("events"), 1)
+
+	s = &webhooks.Subscription{URL: "https://example.com/hook", Secret: webhooks.NewSecret(), Events: webhooks.EventTypes{"*"}}
+	verrs, err = s.Validate(nil)
+	r.NoError(err)
+	r.False(verrs.HasAny())
+}
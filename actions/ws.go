@@ -0,0 +1,129 @@
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gorilla/securecookie"
+	mw "github.com/leonids/test-buffalo/actions/middleware"
+	"github.com/leonids/test-buffalo/actions/openapi"
+	"github.com/leonids/test-buffalo/actions/outbox"
+	"github.com/leonids/test-buffalo/actions/problem"
+	"github.com/leonids/test-buffalo/actions/sse"
+	"github.com/leonids/test-buffalo/actions/ws"
+	"github.com/leonids/test-buffalo/models"
+)
+
+// socketTokenTTL is how long a socket token is good for.
+const socketTokenTTL = time.Hour
+
+// sockets are the WebSocket connections of /ws. The activity channel
+// gets the same events as /api/v1/events.
+var sockets = ws.NewHub(authorizeSocket)
+
+// socketTokens sign the bearer tokens of the sockets, for the clients
+// that can't send the session cookie.
+var socketTokens = securecookie.New(sessionSecret, nil).MaxAge(int(socketTokenTTL.Seconds()))
+
+func init() {
+	sockets.Origins = corsOrigins
+	eventBus.Subscribe(func(e outbox.Event) {
+		if !public(e) {
+			return
+		}
+		sockets.Publish("activity", "", sse.Event{
+			ID:   e.ID,
+			Type: e.Type,
+			Time: e.CreatedAt.UTC(),
+			Data: json.RawMessage(e.Payload),
+		})
+	})
+}
+
+// SocketHandler opens a WebSocket for the signed in user, or the one of
+// a bearer token.
+var SocketHandler = sockets.Handler(socketUser)
+
+func initSocketRoutes(app *buffalo.App) {
+	// a socket lasts, it mustn't hold a transaction for that long
+	app.Middleware.Skip(mw.Transaction(models.DB), SocketHandler)
+	api.Document(app.GET("/ws", SocketHandler), openapi.Operation{
+		Summary:     "WebSocket of the channels",
+		Description: "Sign in, or pass a token of /ws/token as a bearer token or the access_token parameter.",
+		Tags:        []string{"events"},
+		Query:       []openapi.Parameter{{Name: "access_token"}},
+		Status:      101,
+		Response:    "",
+	})
+	api.Document(app.GET("/ws/token", SocketTokenHandler), openapi.Operation{
+		Summary:  "Token of the signed in user for /ws",
+		Tags:     []string{"events"},
+		Response: socketToken{},
+	})
+}
+
+// authorizeSocket lets every user in the rooms and read the activity,
+// which only the server publishes to, and only the user in its own
+// channel.
+func authorizeSocket(user, channel, action string) bool {
+	switch {
+	case channel == "activity":
+		return action == ws.Subscribe
+	case channel == "user:"+user:
+		return true
+	case strings.HasPrefix(channel, "room:"):
+		return len(channel) > len("room:")
+	}
+	return false
+}
+
+// socketUser is the user signed in through authboss, or the one of the
+// bearer token. Browsers can't set the headers of a WebSocket, the
+// token may come as the access_token parameter instead.
+func socketUser(c buffalo.Context) string {
+	if id := currentUserID(c); id != "" {
+		return id
+	}
+	token := c.Param("access_token")
+	if h := c.Request().Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	var id string
+	if token == "" || socketTokens.Decode("ws", token, &id) != nil {
+		return ""
+	}
+	return id
+}
+
+type socketToken struct {
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// NewSocketToken returns a token of the user with the given ID.
+func NewSocketToken(id string) (string, error) {
+	return socketTokens.Encode("ws", id)
+}
+
+// SocketTokenHandler hands a socket token to the signed in user.
+func SocketTokenHandler(c buffalo.Context) error {
+	id := currentUserID(c)
+	if id == "" {
+		return c.Error(401, problem.Unauthorized("sign in first"))
+	}
+	token, err := NewSocketToken(id)
+	if err != nil {
+		return err
+	}
+	return c.Render(200, r.JSON(socketToken{Token: token, ExpiresIn: int(socketTokenTTL.Seconds())}))
+}
+
+// Shutdown closes the long lived connections of the app, the server
+// doesn't track them: the sockets and the activity streams.
+func Shutdown(ctx context.Context) error {
+	activity.Close()
+	return sockets.Shutdown(ctx)
+}
@@ -0,0 +1,210 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// Conn is a client of the hub, User is who it's signed in as.
+type Conn struct {
+	User string
+
+	hub  *Hub
+	ws   *websocket.Conn
+	send chan []byte
+	// channels are guarded by the hub
+	channels map[string]bool
+
+	once *sync.Once
+	// done is closed to close the connection with code and text
+	done      chan struct{}
+	code      int
+	text      string
+	readDone  chan struct{}
+	writeDone chan struct{}
+}
+
+// Handler upgrades the request to a WebSocket connection of the hub.
+// authenticate returns who the client is, an empty string refuses it.
+// The handler returns when the connection is closed.
+func (h *Hub) Handler(authenticate func(buffalo.Context) string) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		user := authenticate(c)
+		if user == "" {
+			return c.Error(401, errors.New("ws: not signed in"))
+		}
+		upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin, HandshakeTimeout: h.WriteWait}
+		ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+		if err != nil {
+			// the upgrader answered already
+			c.Logger().Warn(err)
+			return nil
+		}
+
+		conn := &Conn{
+			User:      user,
+			hub:       h,
+			ws:        ws,
+			send:      make(chan []byte, h.Send),
+			channels:  map[string]bool{},
+			once:      &sync.Once{},
+			done:      make(chan struct{}),
+			readDone:  make(chan struct{}),
+			writeDone: make(chan struct{}),
+		}
+		if !h.add(conn) {
+			ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(h.WriteWait))
+			ws.Close()
+			return nil
+		}
+		go conn.write()
+		conn.reply(Msg{Type: Welcome, From: user})
+		conn.read()
+		close(conn.readDone)
+		h.remove(conn)
+		conn.close(websocket.CloseNormalClosure, "")
+		<-conn.writeDone
+		if conn.code == websocket.CloseTryAgainLater {
+			c.Logger().Warn("ws: dropped a slow client")
+		}
+		return nil
+	}
+}
+
+// read handles the messages of the client until the connection fails
+// or is closed. Any message, pongs included, shows the client is alive.
+func (c *Conn) read() {
+	h := c.hub
+	c.ws.SetReadLimit(h.MaxMessage)
+	c.ws.SetReadDeadline(time.Now().Add(h.PongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(h.PongWait))
+	})
+	for {
+		_, b, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(h.PongWait))
+		m := Msg{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			c.reply(Msg{Type: Error, Error: "invalid message"})
+			continue
+		}
+		c.handle(m)
+	}
+}
+
+func (c *Conn) handle(m Msg) {
+	h := c.hub
+	switch m.Type {
+	case Subscribe, Unsubscribe, Publish:
+		if m.Channel == "" {
+			c.fail(m, "channel is required")
+			return
+		}
+	default:
+		c.fail(m, "unknown type")
+		return
+	}
+
+	switch m.Type {
+	case Subscribe:
+		if !h.allowed(c.User, m.Channel, Subscribe) {
+			c.fail(m, "forbidden")
+			return
+		}
+		h.subscribe(c, m.Channel)
+	case Unsubscribe:
+		h.unsubscribe(c, m.Channel)
+	case Publish:
+		if !h.allowed(c.User, m.Channel, Publish) {
+			c.fail(m, "forbidden")
+			return
+		}
+		if err := h.Publish(m.Channel, c.User, m.Data); err != nil {
+			c.fail(m, "invalid data")
+			return
+		}
+	}
+	c.reply(Msg{Type: Ack, ID: m.ID, Channel: m.Channel})
+}
+
+func (c *Conn) fail(m Msg, reason string) {
+	c.reply(Msg{Type: Error, ID: m.ID, Channel: m.Channel, Error: reason})
+}
+
+func (c *Conn) reply(m Msg) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	c.deliver(b)
+}
+
+// deliver queues b, closing the connection when its buffer is full.
+func (c *Conn) deliver(b []byte) {
+	select {
+	case c.send <- b:
+	default:
+		c.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// close has the writer close the connection, the first code wins.
+func (c *Conn) close(code int, text string) {
+	c.once.Do(func() {
+		c.code, c.text = code, text
+		close(c.done)
+	})
+}
+
+// write sends the queued messages and the pings. When the connection is
+// closed, it sends what's still queued and the close frame, and waits a
+// bit for the client to answer it.
+func (c *Conn) write() {
+	h := c.hub
+	tick := time.NewTicker(h.PingPeriod)
+	defer func() {
+		tick.Stop()
+		c.ws.Close()
+		close(c.writeDone)
+	}()
+	for {
+		select {
+		case b := <-c.send:
+			if err := c.writeMessage(b); err != nil {
+				return
+			}
+		case <-tick.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(h.WriteWait)); err != nil {
+				return
+			}
+		case <-c.done:
+			for len(c.send) > 0 {
+				if err := c.writeMessage(<-c.send); err != nil {
+					return
+				}
+			}
+			msg := websocket.FormatCloseMessage(c.code, c.text)
+			if err := c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(h.WriteWait)); err != nil {
+				return
+			}
+			select {
+			case <-c.readDone:
+			case <-time.After(h.WriteWait):
+			}
+			return
+		}
+	}
+}
+
+func (c *Conn) writeMessage(b []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(c.hub.WriteWait))
+	return c.ws.WriteMessage(websocket.TextMessage, b)
+}
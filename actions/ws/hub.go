@@ -0,0 +1,222 @@
+// Package ws is a hub of WebSocket connections. The clients subscribe to
+// named channels and publish to them with JSON messages:
+//
+//	{"type": "subscribe", "channel": "room:lobby", "id": "1"}
+//	{"type": "publish", "channel": "room:lobby", "data": {"text": "hi"}}
+//	{"type": "unsubscribe", "channel": "room:lobby"}
+//
+// The hub answers every request with an ack or an error carrying its
+// id, and sends what's published as a message:
+//
+//	{"type": "message", "channel": "room:lobby", "from": "1", "data": {"text": "hi"}}
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// The types of the messages.
+const (
+	Subscribe   = "subscribe"
+	Unsubscribe = "unsubscribe"
+	Publish     = "publish"
+
+	Welcome = "welcome"
+	Ack     = "ack"
+	Error   = "error"
+	Message = "message"
+)
+
+// Msg is a message of the protocol, both ways.
+type Msg struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Channel string          `json:"channel,omitempty"`
+	From    string          `json:"from,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Authorizer tells if user may subscribe or publish (action) to channel.
+type Authorizer func(user, channel, action string) bool
+
+// Hub holds the connections and their channels. The fields are read when
+// a connection opens, set them before serving.
+type Hub struct {
+	Authorize Authorizer
+	// Origins are the browser origins allowed besides the host itself.
+	Origins []string
+	// Send is the buffer of messages of a connection: a connection
+	// falling further behind is closed rather than holding back the others.
+	Send int
+	// a ping goes out every PingPeriod, the connection is closed when
+	// nothing comes back in PongWait
+	PingPeriod time.Duration
+	PongWait   time.Duration
+	WriteWait  time.Duration
+	// MaxMessage is the largest message a client may send, in bytes.
+	MaxMessage int64
+
+	moot     *sync.Mutex
+	conns    map[*Conn]struct{}
+	channels map[string]map[*Conn]struct{}
+	closed   bool
+	wg       *sync.WaitGroup
+}
+
+// NewHub returns a hub asking authorize what the clients may do.
+func NewHub(authorize Authorizer) *Hub {
+	return &Hub{
+		Authorize:  authorize,
+		Send:       64,
+		PingPeriod: 30 * time.Second,
+		PongWait:   60 * time.Second,
+		WriteWait:  10 * time.Second,
+		MaxMessage: 64 << 10,
+		moot:       &sync.Mutex{},
+		conns:      map[*Conn]struct{}{},
+		channels:   map[string]map[*Conn]struct{}{},
+		wg:         &sync.WaitGroup{},
+	}
+}
+
+// Publish sends data to the subscribers of channel, from is who sent it,
+// empty for the server.
+func (h *Hub) Publish(channel, from string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	b, err := json.Marshal(Msg{Type: Message, Channel: channel, From: from, Data: raw})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	for c := range h.channels[channel] {
+		c.deliver(b)
+	}
+	return nil
+}
+
+// Connections returns the number of open connections.
+func (h *Hub) Connections() int {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	return len(h.conns)
+}
+
+// Subscribers returns the number of connections subscribed to channel.
+func (h *Hub) Subscribers(channel string) int {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	return len(h.channels[channel])
+}
+
+// Shutdown closes the connections, telling the clients the server is
+// going away, and waits for them to be done or ctx to be. The hub
+// refuses new connections from then on.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.moot.Lock()
+	h.closed = true
+	for c := range h.conns {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+	}
+	h.moot.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hub) add(c *Conn) bool {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	if h.closed {
+		return false
+	}
+	h.conns[c] = struct{}{}
+	h.wg.Add(1)
+	return true
+}
+
+func (h *Hub) remove(c *Conn) {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	if _, ok := h.conns[c]; !ok {
+		return
+	}
+	delete(h.conns, c)
+	for channel := range c.channels {
+		h.leave(c, channel)
+	}
+	h.wg.Done()
+}
+
+func (h *Hub) subscribe(c *Conn, channel string) {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	if _, ok := h.conns[c]; !ok {
+		return
+	}
+	subs, ok := h.channels[channel]
+	if !ok {
+		subs = map[*Conn]struct{}{}
+		h.channels[channel] = subs
+	}
+	subs[c] = struct{}{}
+	c.channels[channel] = true
+}
+
+func (h *Hub) unsubscribe(c *Conn, channel string) {
+	h.moot.Lock()
+	defer h.moot.Unlock()
+	h.leave(c, channel)
+}
+
+func (h *Hub) leave(c *Conn, channel string) {
+	delete(c.channels, channel)
+	if subs, ok := h.channels[channel]; ok {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(h.channels, channel)
+		}
+	}
+}
+
+func (h *Hub) allowed(user, channel, action string) bool {
+	return h.Authorize != nil && h.Authorize(user, channel, action)
+}
+
+// checkOrigin lets in the clients without an Origin (not browsers), the
+// pages of the host itself and the ones of Origins. The session cookie
+// goes with any page's requests, other sites mustn't connect with it.
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range h.Origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,265 @@
+package ws_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gorilla/websocket"
+	"github.com/leonids/test-buffalo/actions/ws"
+	"github.com/stretchr/testify/require"
+)
+
+// authorize lets everyone in the rooms, and only the user in its own
+// channel; nobody publishes to news.
+func authorize(user, channel, action string) bool {
+	switch {
+	case strings.HasPrefix(channel, "room:"):
+		return true
+	case channel == "user:"+user:
+		return true
+	case channel == "news":
+		return action == ws.Subscribe
+	}
+	return false
+}
+
+func serve(h *ws.Hub) *httptest.Server {
+	a := buffalo.Automatic(buffalo.Options{})
+	a.GET("/ws", h.Handler(func(c buffalo.Context) string {
+		return c.Request().Header.Get("X-User")
+	}))
+	return httptest.NewServer(a)
+}
+
+// client is a connection of the tests, past its welcome.
+type client struct {
+	*websocket.Conn
+}
+
+func dial(t *testing.T, ts *httptest.Server, user string) *client {
+	header := http.Header{}
+	header.Set("X-User", user)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	require.NoError(t, err)
+	c := &client{conn}
+	m := c.next(t)
+	require.Equal(t, ws.Welcome, m.Type)
+	require.Equal(t, user, m.From)
+	return c
+}
+
+func (c *client) send(t *testing.T, m ws.Msg) {
+	require.NoError(t, c.WriteJSON(m))
+}
+
+func (c *client) next(t *testing.T) ws.Msg {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m := ws.Msg{}
+	require.NoError(t, c.ReadJSON(&m))
+	return m
+}
+
+// request sends m and returns the answer to it.
+func (c *client) request(t *testing.T, m ws.Msg) ws.Msg {
+	c.send(t, m)
+	for {
+		res := c.next(t)
+		if res.ID == m.ID && res.Type != ws.Message {
+			return res
+		}
+	}
+}
+
+func Test_PubSub(t *testing.T) {
+	r := require.New(t)
+	h := ws.NewHub(authorize)
+	ts := serve(h)
+	defer ts.Close()
+
+	alice := dial(t, ts, "1")
+	defer alice.Close()
+	bob := dial(t, ts, "2")
+	defer bob.Close()
+	r.Equal(2, h.Connections())
+
+	res := alice.request(t, ws.Msg{Type: ws.Subscribe, ID: "1", Channel: "room:lobby"})
+	r.Equal(ws.Ack, res.Type)
+	r.Equal("room:lobby", res.Channel)
+	r.Equal(1, h.Subscribers("room:lobby"))
+
+	res = bob.request(t, ws.Msg{Type: ws.Publish, ID: "2", Channel: "room:lobby", Data: []byte(`{"text":"hi"}`)})
+	r.Equal(ws.Ack, res.Type)
+	m := alice.next(t)
+	r.Equal(ws.Message, m.Type)
+	r.Equal("room:lobby", m.Channel)
+	r.Equal("2", m.From)
+	r.JSONEq(`{"text":"hi"}`, string(m.Data))
+
+	// from the server
+	r.NoError(h.Publish("room:lobby", "", map[string]int{"n": 1}))
+	m = alice.next(t)
+	r.Equal("", m.From)
+	r.JSONEq(`{"n":1}`, string(m.Data))
+
+	res = alice.request(t, ws.Msg{Type: ws.Unsubscribe, ID: "3", Channel: "room:lobby"})
+	r.Equal(ws.Ack, res.Type)
+	r.Equal(0, h.Subscribers("room:lobby"))
+	r.NoError(h.Publish("room:lobby", "", nil))
+	// nothing came before the answer
+	alice.send(t, ws.Msg{Type: ws.Subscribe, ID: "4", Channel: "user:1"})
+	r.Equal(ws.Ack, alice.next(t).Type)
+
+	bob.Close()
+	for i := 0; i < 100 && h.Connections() > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Equal(1, h.Connections())
+}
+
+func Test_Errors(t *testing.T) {
+	r := require.New(t)
+	h := ws.NewHub(authorize)
+	ts := serve(h)
+	defer ts.Close()
+	c := dial(t, ts, "1")
+	defer c.Close()
+
+	for _, tt := range []struct {
+		msg    ws.Msg
+		reason string
+	}{
+		{ws.Msg{Type: ws.Subscribe, ID: "1", Channel: "user:2"}, "forbidden"},
+		{ws.Msg{Type: ws.Publish, ID: "2", Channel: "news"}, "forbidden"},
+		{ws.Msg{Type: ws.Subscribe, ID: "3"}, "channel is required"},
+		{ws.Msg{Type: "shout", ID: "4", Channel: "news"}, "unknown type"},
+	} {
+		res := c.request(t, tt.msg)
+		r.Equal(ws.Error, res.Type)
+		r.Equal(tt.reason, res.Error)
+	}
+	r.Equal(0, h.Subscribers("user:2"))
+
+	r.NoError(c.WriteMessage(websocket.TextMessage, []byte("{")))
+	res := c.next(t)
+	r.Equal(ws.Error, res.Type)
+	r.Equal("invalid message", res.Error)
+	// still open
+	r.Equal(ws.Ack, c.request(t, ws.Msg{Type: ws.Subscribe, ID: "5", Channel: "news"}).Type)
+}
+
+func Test_Refused(t *testing.T) {
+	r := require.New(t)
+	h := ws.NewHub(authorize)
+	ts := serve(h)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	_, res, err := websocket.DefaultDialer.Dial(url, nil)
+	r.Error(err)
+	r.Equal(401, res.StatusCode)
+
+	header := http.Header{}
+	header.Set("X-User", "1")
+	header.Set("Origin", "http://evil.example.com")
+	_, res, err = websocket.DefaultDialer.Dial(url, header)
+	r.Error(err)
+	r.Equal(403, res.StatusCode)
+
+	h.Origins = []string{"http://app.example.com"}
+	header.Set("Origin", "http://app.example.com")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	r.NoError(err)
+	conn.Close()
+}
+
+func Test_Ping(t *testing.T) {
+	r := require.New(t)
+	h := ws.NewHub(authorize)
+	h.PingPeriod = 20 * time.Millisecond
+	h.PongWait = 100 * time.Millisecond
+	ts := serve(h)
+	defer ts.Close()
+
+	// the client answers the pings while reading
+	c := dial(t, ts, "1")
+	defer c.Close()
+	pings := make(chan struct{}, 100)
+	c.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go c.ReadMessage()
+	time.Sleep(300 * time.Millisecond)
+	r.True(len(pings) > 3)
+	r.Equal(1, h.Connections())
+
+	// this one doesn't, it's gone after PongWait
+	dead := dial(t, ts, "2")
+	defer dead.Close()
+	r.Equal(2, h.Connections())
+	for i := 0; i < 100 && h.Connections() > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Equal(1, h.Connections())
+}
+
+func Test_Slow(t *testing.T) {
+	r := require.New(t)
+	h := ws.NewHub(authorize)
+	h.Send = 1
+	ts := serve(h)
+	defer ts.Close()
+
+	c := dial(t, ts, "1")
+	defer c.Close()
+	r.Equal(ws.Ack, c.request(t, ws.Msg{Type: ws.Subscribe, ID: "1", Channel: "room:lobby"}).Type)
+
+	// more than the socket buffers hold, without reading
+	big := strings.Repeat("x", 64<<10)
+	for i := 0; i < 200; i++ {
+		r.NoError(h.Publish("room:lobby", "", big))
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = c.ReadMessage()
+	}
+	r.True(websocket.IsCloseError(err, websocket.CloseTryAgainLater), err.Error())
+}
+
+func Test_Shutdown(t *testing.T) {
+	r := require.New(t)
+	h := ws.NewHub(authorize)
+	ts := serve(h)
+	defer ts.Close()
+
+	c := dial(t, ts, "1")
+	defer c.Close()
+	r.Equal(ws.Ack, c.request(t, ws.Msg{Type: ws.Subscribe, ID: "1", Channel: "room:lobby"}).Type)
+	r.NoError(h.Publish("room:lobby", "", "bye"))
+
+	errs := make(chan error)
+	go func() {
+		errs <- h.Shutdown(context.Background())
+	}()
+	// what was queued, then the close frame
+	m := c.next(t)
+	r.JSONEq(`"bye"`, string(m.Data))
+	_, _, err := c.ReadMessage()
+	r.True(websocket.IsCloseError(err, websocket.CloseGoingAway), err.Error())
+	r.NoError(<-errs)
+	r.Equal(0, h.Connections())
+
+	header := http.Header{}
+	header.Set("X-User", "1")
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	r.NoError(err)
+	defer conn.Close()
+	_, _, err = conn.ReadMessage()
+	r.True(websocket.IsCloseError(err, websocket.CloseGoingAway), err.Error())
+}
@@ -0,0 +1,472 @@
+// Package config is the configuration of the app. It's loaded from the
+// defaults of the environment (GO_ENV), then the section of the
+// environment in database.yml and config.yml, then the environment
+// variables, and checked before the app starts.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/going/defaults"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of an environment. The yaml tags are the
+// keys of config.yml, the env tags the environment variables overriding
+// them. Lists are comma separated in the environment.
+type Config struct {
+	Env string `yaml:"env"`
+	// Port the server listens on.
+	Port string `yaml:"port" env:"PORT"`
+	// RootURL is the scheme, host and port the app is reached at, for
+	// the links of the mails. No trailing slash.
+	RootURL string `yaml:"root_url" env:"ROOT_URL"`
+	// SessionSecret keys the sessions and signs the URLs. Without it
+	// outside production a random one is used, nothing survives a
+	// restart then.
+	SessionSecret string `yaml:"session_secret" env:"SESSION_SECRET" secret:"true"`
+	// SessionName is the cookie of the sessions of buffalo.
+	SessionName string `yaml:"session_name" env:"SESSION_NAME"`
+	// AuthSessionName is the cookie of the sessions of authboss.
+	AuthSessionName string `yaml:"auth_session_name" env:"AUTH_SESSION_NAME"`
+	// BasicAuth are the credentials of the scripts calling /api/v1 and
+	// /admin.
+	BasicAuth BasicAuth `yaml:"basic_auth"`
+	// APIKeys are the keys of the API clients, sent as X-API-Key. A
+	// client with one has a rate limit of its own.
+	APIKeys []string `yaml:"api_keys" env:"API_KEYS" secret:"true"`
+	// CORSOrigins are the origins allowed to call the API from a browser,
+	// e.g. https://app.example.com,https://*.example.com
+	CORSOrigins []string `yaml:"cors_origins" env:"CORS_ORIGINS"`
+	// TrustedProxies are the addresses whose X-Forwarded-For is believed.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	Database       Database `yaml:"database"`
+	Mail           Mail     `yaml:"mail"`
+	Storage        Storage  `yaml:"storage"`
+	Outbox         Outbox   `yaml:"outbox"`
+}
+
+// BasicAuth are a user and its password.
+type BasicAuth struct {
+	User     string `yaml:"user" env:"BASIC_AUTH_USER"`
+	Password string `yaml:"password" env:"BASIC_AUTH_PASSWORD" secret:"true"`
+}
+
+// Database is the connection to the database, the section of the
+// environment in database.yml. URL, when it's set, has all the details
+// the other fields have.
+type Database struct {
+	Dialect  string            `yaml:"dialect"`
+	Database string            `yaml:"database"`
+	Host     string            `yaml:"host"`
+	Port     string            `yaml:"port"`
+	User     string            `yaml:"user"`
+	Password string            `yaml:"password" secret:"true"`
+	URL      string            `yaml:"url" env:"DATABASE_URL"`
+	Pool     int               `yaml:"pool"`
+	Options  map[string]string `yaml:"options"`
+}
+
+// Mail configures the mails. They go out over SMTP when SMTPAddr
+// (host:port) is set, with SMTPUser and SMTPPassword if there are some.
+type Mail struct {
+	From         string `yaml:"from" env:"MAIL_FROM"`
+	SMTPAddr     string `yaml:"smtp_addr" env:"SMTP_ADDR"`
+	SMTPUser     string `yaml:"smtp_user" env:"SMTP_USER"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+// Storage configures where the uploads are kept: on disk under
+// UploadsDir with the local driver, in a bucket with the s3 one.
+type Storage struct {
+	Driver      string `yaml:"driver" env:"STORAGE"`
+	UploadsDir  string `yaml:"uploads_dir" env:"UPLOADS_DIR"`
+	S3Endpoint  string `yaml:"s3_endpoint" env:"S3_ENDPOINT"`
+	S3Region    string `yaml:"s3_region" env:"S3_REGION"`
+	S3Bucket    string `yaml:"s3_bucket" env:"S3_BUCKET"`
+	S3AccessKey string `yaml:"s3_access_key" env:"S3_ACCESS_KEY"`
+	S3SecretKey string `yaml:"s3_secret_key" env:"S3_SECRET_KEY" secret:"true"`
+}
+
+// Outbox configures where the events of the outbox are relayed: to an
+// NDJSON file, and to URL when it's set.
+type Outbox struct {
+	File string `yaml:"file" env:"OUTBOX_FILE"`
+	URL  string `yaml:"url" env:"OUTBOX_URL" secret:"true"`
+}
+
+// Current is the configuration of the app, loaded from CONFIG_FILE
+// (config.yml by default). The app doesn't start with an invalid one.
+var Current *Config
+
+func init() {
+	var err error
+	Current, err = Load(envy.Get("CONFIG_FILE", "config.yml"))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Defaults returns the configuration of env before the file and the
+// environment variables. There are no secrets in production: they must
+// be set.
+func Defaults(env string) *Config {
+	c := &Config{
+		Env:             env,
+		Port:            "3000",
+		RootURL:         "http://localhost:3000",
+		SessionName:     "_test-buffalo_session",
+		AuthSessionName: "ab_blog",
+		BasicAuth:       BasicAuth{User: "leonids", Password: "maslovs"},
+		CORSOrigins:     []string{"http://localhost:8080"},
+		TrustedProxies:  []string{"127.0.0.1"},
+		Database:        Database{Dialect: "postgres", Database: "test-buffalo_" + env, Host: "127.0.0.1", User: "postgres", Password: "postgres"},
+		Mail:            Mail{From: "no-reply@test-buffalo.local"},
+		Storage:         Storage{Driver: "local", UploadsDir: "uploads", S3Region: "us-east-1"},
+		Outbox:          Outbox{File: "logs/outbox.ndjson"},
+	}
+	if env == "production" {
+		c.BasicAuth.Password = ""
+		c.CORSOrigins = nil
+		c.Database = Database{}
+	}
+	return c
+}
+
+// Load returns the configuration of the environment in GO_ENV from the
+// defaults, database.yml, file and the environment variables. A missing
+// file is no error, the configuration is checked with Validate.
+func Load(file string) (*Config, error) {
+	c := Defaults(envy.Get("GO_ENV", "development"))
+	if err := c.loadDatabase(); err != nil {
+		return nil, err
+	}
+	if err := c.loadFile(file); err != nil {
+		return nil, err
+	}
+	loadEnv(reflect.ValueOf(c).Elem())
+	return c, c.Validate()
+}
+
+// loadFile reads the section of the environment of file, a YAML file
+// with a section per environment like database.yml.
+func (c *Config) loadFile(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	sections := map[string]map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(b, &sections); err != nil {
+		return errors.Wrapf(err, "config: %s", file)
+	}
+	section, ok := sections[c.Env]
+	if !ok {
+		return nil
+	}
+	if unknown := unknownKeys(section, reflect.TypeOf(*c), ""); len(unknown) > 0 {
+		return errors.Errorf("config: %s: unknown keys in %s: %s", file, c.Env, strings.Join(unknown, ", "))
+	}
+	if _, ok := section["env"]; ok {
+		return errors.Errorf("config: %s: env is the name of the section, not a key", file)
+	}
+	b, err = yaml.Marshal(section)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.Wrapf(yaml.Unmarshal(b, c), "config: %s", file)
+}
+
+// loadDatabase reads the section of the environment of database.yml,
+// where pop looks for it. Like pop, it's a template with the env and
+// envOr functions first.
+func (c *Config) loadDatabase() error {
+	var file string
+	for _, dir := range pop.LookupPaths() {
+		path := filepath.Join(dir, pop.ConfigName)
+		if _, err := os.Stat(path); err == nil {
+			file = path
+			break
+		}
+	}
+	if file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	t, err := template.New(file).Funcs(template.FuncMap{
+		"env": os.Getenv,
+		"envOr": func(name, value string) string {
+			return defaults.String(os.Getenv(name), value)
+		},
+	}).Parse(string(b))
+	if err != nil {
+		return errors.Wrapf(err, "config: %s", file)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, nil); err != nil {
+		return errors.Wrapf(err, "config: %s", file)
+	}
+	sections := map[string]map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(buf.Bytes(), &sections); err != nil {
+		return errors.Wrapf(err, "config: %s", file)
+	}
+	section, ok := sections[c.Env]
+	if !ok {
+		return nil
+	}
+	if unknown := unknownKeys(section, reflect.TypeOf(c.Database), ""); len(unknown) > 0 {
+		return errors.Errorf("config: %s: unknown keys in %s: %s", file, c.Env, strings.Join(unknown, ", "))
+	}
+	// the file has all of it, not some keys over the defaults
+	c.Database = Database{}
+	b, err = yaml.Marshal(section)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.Wrapf(yaml.Unmarshal(b, &c.Database), "config: %s", file)
+}
+
+// unknownKeys returns the keys of section that aren't fields of t, so
+// typos don't go unnoticed.
+func unknownKeys(section map[interface{}]interface{}, t reflect.Type, prefix string) []string {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fields[f.Tag.Get("yaml")] = f.Type
+	}
+	var unknown []string
+	for k, v := range section {
+		key := fmt.Sprint(k)
+		ft, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		if sub, ok := v.(map[interface{}]interface{}); ok && ft.Kind() == reflect.Struct {
+			unknown = append(unknown, unknownKeys(sub, ft, prefix+key+".")...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// loadEnv sets the fields of v with an env tag to the environment
+// variables that are set.
+func loadEnv(v reflect.Value) {
+	env := envy.Map()
+	for i := 0; i < v.NumField(); i++ {
+		f, ft := v.Field(i), v.Type().Field(i)
+		if ft.Type.Kind() == reflect.Struct {
+			loadEnv(f)
+			continue
+		}
+		s, ok := env[ft.Tag.Get("env")]
+		if !ok || ft.Tag.Get("env") == "" {
+			continue
+		}
+		switch ft.Type.Kind() {
+		case reflect.String:
+			f.SetString(s)
+		case reflect.Slice:
+			f.Set(reflect.ValueOf(split(s)))
+		}
+	}
+}
+
+func split(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Errors are the problems of an invalid configuration.
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks c, the error is Errors.
+func (c *Config) Validate() error {
+	var errs Errors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		add("port (PORT) must be a port number, got %q", c.Port)
+	}
+	if u, err := url.Parse(c.RootURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("root_url (ROOT_URL) must be an http or https URL, got %q", c.RootURL)
+	} else if strings.HasSuffix(c.RootURL, "/") {
+		add("root_url (ROOT_URL) must not end with a slash, got %q", c.RootURL)
+	}
+	if c.Env == "production" && len(c.SessionSecret) < 32 {
+		add("session_secret (SESSION_SECRET) must be at least 32 characters in production")
+	}
+	if c.SessionName == "" || c.AuthSessionName == "" {
+		add("session_name (SESSION_NAME) and auth_session_name (AUTH_SESSION_NAME) are required")
+	} else if c.SessionName == c.AuthSessionName {
+		add("session_name (SESSION_NAME) and auth_session_name (AUTH_SESSION_NAME) must be different cookies, both are %q", c.SessionName)
+	}
+	if c.BasicAuth.User == "" || c.BasicAuth.Password == "" {
+		add("basic_auth.user (BASIC_AUTH_USER) and basic_auth.password (BASIC_AUTH_PASSWORD) are required")
+	}
+	for _, k := range c.APIKeys {
+		if len(k) < 16 {
+			// the key is a secret
+			add("api_keys (API_KEYS) must be at least 16 characters")
+			break
+		}
+	}
+	for _, o := range c.CORSOrigins {
+		if !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			add("cors_origins (CORS_ORIGINS) must be http or https origins, got %q", o)
+		}
+	}
+	for _, p := range c.TrustedProxies {
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				add("trusted_proxies (TRUSTED_PROXIES) must be IP addresses or CIDR ranges, got %q", p)
+			}
+		}
+	}
+
+	d := c.Database
+	dialect := d.Dialect
+	if d.URL != "" {
+		if u, err := url.Parse(d.URL); err != nil || u.Scheme == "" {
+			// the URL may have the password
+			add("database.url (DATABASE_URL) must be a URL")
+		} else {
+			dialect = u.Scheme
+		}
+	}
+	switch strings.ToLower(dialect) {
+	case "postgres", "postgresql", "pg", "mysql":
+		if d.URL == "" && (d.Database == "" || d.Host == "") {
+			add("database.database and database.host are required, or database.url (DATABASE_URL)")
+		}
+	case "sqlite", "sqlite3":
+		if d.URL == "" && d.Database == "" {
+			add("database.database, the file, is required with sqlite3")
+		}
+	default:
+		add("database.dialect must be postgres, mysql or sqlite3, or database.url (DATABASE_URL) set, got %q", dialect)
+	}
+	if d.Pool < 0 {
+		add("database.pool must be 0, no limit, or more, got %d", d.Pool)
+	}
+
+	if c.Mail.From == "" {
+		add("mail.from (MAIL_FROM) is required")
+	}
+	if c.Mail.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			add("mail.smtp_addr (SMTP_ADDR) must be host:port, got %q", c.Mail.SMTPAddr)
+		}
+	}
+	if c.Mail.SMTPUser != "" && c.Mail.SMTPPassword == "" {
+		add("mail.smtp_password (SMTP_PASSWORD) is required with mail.smtp_user (SMTP_USER)")
+	}
+
+	switch c.Storage.Driver {
+	case "local":
+		if c.Storage.UploadsDir == "" {
+			add("storage.uploads_dir (UPLOADS_DIR) is required with the local storage")
+		}
+	case "s3":
+		if c.Storage.S3Bucket == "" || c.Storage.S3AccessKey == "" || c.Storage.S3SecretKey == "" {
+			add("storage.s3_bucket (S3_BUCKET), storage.s3_access_key (S3_ACCESS_KEY) and storage.s3_secret_key (S3_SECRET_KEY) are required with the s3 storage")
+		}
+	default:
+		add("storage.driver (STORAGE) must be local or s3, got %q", c.Storage.Driver)
+	}
+
+	if c.Outbox.File == "" {
+		add("outbox.file (OUTBOX_FILE) is required")
+	}
+	if c.Outbox.URL != "" {
+		if u, err := url.Parse(c.Outbox.URL); err != nil || u.Host == "" {
+			// the URL may have credentials
+			add("outbox.url (OUTBOX_URL) must be an absolute URL")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Redacted returns a copy of c to show, the secrets that are set
+// replaced.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.CORSOrigins = append([]string(nil), c.CORSOrigins...)
+	r.TrustedProxies = append([]string(nil), c.TrustedProxies...)
+	r.APIKeys = append([]string(nil), c.APIKeys...)
+	redact(reflect.ValueOf(&r).Elem())
+	// the URL is shown without the password
+	if u, err := url.Parse(c.Database.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+			r.Database.URL = u.String()
+		}
+	}
+	return &r
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f, ft := v.Field(i), v.Type().Field(i)
+		if ft.Type.Kind() == reflect.Struct {
+			redact(f)
+			continue
+		}
+		if ft.Tag.Get("secret") != "true" {
+			continue
+		}
+		switch {
+		case ft.Type.Kind() == reflect.Slice:
+			for j := 0; j < f.Len(); j++ {
+				f.Index(j).SetString("[redacted]")
+			}
+		case f.String() != "":
+			f.SetString("[redacted]")
+		}
+	}
+}
+
+// String is c as YAML, redacted.
+func (c *Config) String() string {
+	b, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
@@ -0,0 +1,14 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/leonids/test-buffalo/config"
+	. "github.com/markbates/grift/grift"
+)
+
+var _ = Desc("config:show", "Prints the configuration of the environment (GO_ENV), secrets redacted")
+var _ = Add("config:show", func(c *Context) error {
+	fmt.Print(config.Current)
+	return nil
+})
@@ -0,0 +1,91 @@
+package grifts
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/leonids/test-buffalo/actions"
+	"github.com/leonids/test-buffalo/actions/flags"
+	. "github.com/markbates/grift/grift"
+	"github.com/olekukonko/tablewriter"
+)
+
+var _ = Desc("flags:list", "Lists the feature flags")
+var _ = Add("flags:list", func(c *Context) error {
+	list, err := actions.FeatureFlags()
+	if err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Enabled", "Percentage", "Users", "Description"})
+	for _, f := range list {
+		table.Append([]string{f.Name, strconv.FormatBool(f.Enabled), strconv.Itoa(f.Percentage) + "%", f.Users, f.Description})
+	}
+	table.SetCenterSeparator("|")
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.Render()
+	return nil
+})
+
+var _ = Desc("flags:set", "Creates or updates a feature flag, what's not given stays: flags:set <name> [on|off] [percentage=N] [users=1,2] [description=...]")
+var _ = Add("flags:set", func(c *Context) error {
+	if len(c.Args) < 1 {
+		return fmt.Errorf("usage: flags:set <name> [on|off] [percentage=N] [users=1,2] [description=...]")
+	}
+	f, err := actions.FindFeatureFlag(c.Args[0])
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		f = &flags.Flag{Name: c.Args[0]}
+	}
+	for _, arg := range c.Args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		switch {
+		case arg == "on" || arg == "off":
+			f.Enabled = arg == "on"
+		case len(kv) == 2 && kv[0] == "percentage":
+			n, err := strconv.Atoi(strings.TrimSuffix(kv[1], "%"))
+			if err != nil {
+				return fmt.Errorf("percentage %q is not a number", kv[1])
+			}
+			f.Percentage = n
+		case len(kv) == 2 && kv[0] == "users":
+			f.Users = strings.Join(flags.Flag{Users: kv[1]}.AllowList(), " ")
+		case len(kv) == 2 && kv[0] == "description":
+			f.Description = kv[1]
+		default:
+			return fmt.Errorf("unknown argument %q", arg)
+		}
+	}
+	if err := actions.SaveFeatureFlag(griftAdmin(), f); err != nil {
+		return err
+	}
+	fmt.Printf("> %s: enabled=%t percentage=%d%% users=%q\n", f.Name, f.Enabled, f.Percentage, f.Users)
+	return nil
+})
+
+var _ = Desc("flags:delete", "Deletes a feature flag: flags:delete <name>")
+var _ = Add("flags:delete", func(c *Context) error {
+	if len(c.Args) != 1 {
+		return fmt.Errorf("usage: flags:delete <name>")
+	}
+	if err := actions.DeleteFeatureFlag(griftAdmin(), c.Args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("> %s is deleted\n", c.Args[0])
+	return nil
+})
+
+// griftAdmin is who changed the flags in the audit trail: the system user
+// running the grift.
+func griftAdmin() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return "grift:" + name
+}
@@ -0,0 +1,47 @@
+package grifts
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/leonids/test-buffalo/actions"
+	"github.com/leonids/test-buffalo/actions/i18n"
+	. "github.com/markbates/grift/grift"
+)
+
+var _ = Desc("i18n:check", "Reports the translation keys missing from locales/, and the unused ones")
+var _ = Add("i18n:check", func(c *Context) error {
+	b := i18n.NewBundle("en")
+	if err := b.LoadDir("locales"); err != nil {
+		return err
+	}
+	used, err := i18n.Scan("templates", "actions")
+	if err != nil {
+		return err
+	}
+	report := i18n.Check(b, used, actions.DynamicKeys...)
+
+	missing := 0
+	for _, l := range b.Locales() {
+		keys := report.Missing[l]
+		if len(keys) == 0 {
+			continue
+		}
+		missing += len(keys)
+		fmt.Printf("> missing in %s:\n", l)
+		for _, key := range keys {
+			fmt.Printf("  %s %s\n", key, strings.Join(used[key], ", "))
+		}
+	}
+	if len(report.Unused) > 0 {
+		fmt.Println("> unused:")
+		for _, key := range report.Unused {
+			fmt.Printf("  %s\n", key)
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d translations are missing", missing)
+	}
+	fmt.Println("> every key is translated")
+	return nil
+})
@@ -0,0 +1,46 @@
+package grifts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/leonids/test-buffalo/actions"
+	. "github.com/markbates/grift/grift"
+)
+
+var _ = Desc("jobs:work", "Runs the job workers: jobs:work [concurrency] [queue,...]")
+var _ = Add("jobs:work", func(c *Context) error {
+	w := actions.NewJobWorker()
+	if len(c.Args) > 0 {
+		n, err := strconv.Atoi(c.Args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("bad concurrency %q", c.Args[0])
+		}
+		w.Concurrency = n
+	}
+	if len(c.Args) > 1 {
+		w.Queues = strings.Split(c.Args[1], ",")
+	}
+
+	// the first signal lets the running jobs finish, the second one
+	// doesn't wait for them
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Println("> shutting down, waiting for the running jobs")
+		cancel()
+		<-sigs
+		os.Exit(1)
+	}()
+
+	fmt.Printf("> working as %s\n", w.ID)
+	w.Run(ctx)
+	return nil
+})
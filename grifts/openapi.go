@@ -0,0 +1,28 @@
+package grifts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/leonids/test-buffalo/actions"
+	. "github.com/markbates/grift/grift"
+)
+
+var _ = Desc("openapi", "Writes the OpenAPI document to disk (default: openapi.json)")
+var _ = Add("openapi", func(c *Context) error {
+	path := "openapi.json"
+	if len(c.Args) > 0 {
+		path = c.Args[0]
+	}
+
+	b, err := json.MarshalIndent(actions.OpenAPI(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("> %s\n", path)
+	return nil
+})
@@ -0,0 +1,82 @@
+package grifts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/leonids/test-buffalo/actions"
+	"github.com/leonids/test-buffalo/actions/outbox"
+	"github.com/leonids/test-buffalo/models"
+	. "github.com/markbates/grift/grift"
+	"github.com/olekukonko/tablewriter"
+)
+
+var _ = Desc("outbox:status", "Lists the sinks of the outbox and how far they got")
+var _ = Add("outbox:status", func(c *Context) error {
+	r, err := actions.NewRelay()
+	if err != nil {
+		return err
+	}
+	head, err := outbox.Head(models.DB)
+	if err != nil {
+		return err
+	}
+	positions, err := r.Offsets()
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Sink", "Offset", "Behind"})
+	for _, name := range r.Sinks() {
+		if r.Local(name) {
+			table.Append([]string{name, "in process", ""})
+			continue
+		}
+		table.Append([]string{name, strconv.Itoa(positions[name]), strconv.Itoa(head - positions[name])})
+	}
+	table.SetCenterSeparator("|")
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.Render()
+	fmt.Printf("> the outbox is at %d\n", head)
+	return nil
+})
+
+var _ = Desc("outbox:replay", "Publishes the events again from an offset on: outbox:replay <sink> <offset>")
+var _ = Add("outbox:replay", func(c *Context) error {
+	if len(c.Args) != 2 {
+		return fmt.Errorf("usage: outbox:replay <sink> <offset>")
+	}
+	name := c.Args[0]
+	offset, err := strconv.Atoi(c.Args[1])
+	if err != nil {
+		return fmt.Errorf("bad offset %q", c.Args[1])
+	}
+	r, err := actions.NewRelay()
+	if err != nil {
+		return err
+	}
+	if r.Local(name) {
+		return fmt.Errorf("%s is local to each instance of the app, it can't be replayed from here", name)
+	}
+	if err := r.Rewind(name, offset); err != nil {
+		return err
+	}
+
+	// catch up right away rather than waiting for the relays of the app
+	total := 0
+	for {
+		n, err := r.Relay(context.Background(), name)
+		total += n
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	fmt.Printf("> replayed %d events to %s\n", total, name)
+	return nil
+})
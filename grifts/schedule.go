@@ -0,0 +1,78 @@
+package grifts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/leonids/test-buffalo/actions"
+	. "github.com/markbates/grift/grift"
+	"github.com/olekukonko/tablewriter"
+)
+
+var _ = Desc("schedule:list", "Lists the scheduled tasks and how their last run went")
+var _ = Add("schedule:list", func(c *Context) error {
+	s := actions.NewScheduler()
+	recs, err := s.Records()
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Task", "Schedule", "Next Run", "Last Run", "Status", "Duration", "Error"})
+	for _, t := range s.Tasks() {
+		rec := recs[t.Name]
+		last, duration := "never", ""
+		if !rec.LastRunAt.IsZero() {
+			last = rec.LastRunAt.Format(time.RFC3339)
+			duration = (time.Duration(rec.LastDuration) * time.Millisecond).String()
+		}
+		next := rec.NextRunAt.Format(time.RFC3339)
+		if t.Local {
+			next = "on every instance"
+		}
+		table.Append([]string{t.Name, t.Spec, next, last, rec.LastStatus, duration, rec.LastError})
+	}
+	table.SetCenterSeparator("|")
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.Render()
+	return nil
+})
+
+var _ = Desc("schedule:run", "Runs a scheduled task now: schedule:run <task>")
+var _ = Add("schedule:run", func(c *Context) error {
+	if len(c.Args) != 1 {
+		return fmt.Errorf("usage: schedule:run <task>")
+	}
+	ran, err := actions.NewScheduler().RunTask(context.Background(), c.Args[0], true)
+	if err != nil {
+		return err
+	}
+	if !ran {
+		fmt.Printf("> %s is running elsewhere\n", c.Args[0])
+	}
+	return nil
+})
+
+var _ = Desc("schedule:work", "Runs the scheduled tasks, for an instance dedicated to them")
+var _ = Add("schedule:work", func(c *Context) error {
+	s := actions.NewScheduler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Println("> shutting down, waiting for the running tasks")
+		cancel()
+		<-sigs
+		os.Exit(1)
+	}()
+
+	fmt.Printf("> scheduling as %s\n", s.ID)
+	s.Run(ctx)
+	return nil
+})
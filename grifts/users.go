@@ -0,0 +1,57 @@
+package grifts
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/leonids/test-buffalo/actions"
+	"github.com/leonids/test-buffalo/actions/bulk"
+	. "github.com/markbates/grift/grift"
+)
+
+var _ = Desc("users:import", "Creates the users of a CSV or NDJSON file, the invalid rows go to <file>.errors.csv: users:import [--dry-run] <file>")
+var _ = Add("users:import", func(c *Context) error {
+	args := c.Args
+	dryRun := len(args) > 0 && args[0] == "--dry-run"
+	if dryRun {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: users:import [--dry-run] <file>")
+	}
+	format := bulk.Detect(args[0], "")
+	if format == "" {
+		return fmt.Errorf("%s is neither a .csv nor a .ndjson file", args[0])
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := actions.ImportUsers(f, format, dryRun)
+	if res != nil {
+		verb := "imported"
+		if dryRun {
+			verb = "would import"
+		}
+		fmt.Printf("> %s %d users, %d rows failed\n", verb, res.Imported, res.Failed)
+	}
+	if err != nil {
+		return err
+	}
+	if res.Failed == 0 {
+		return nil
+	}
+	report := args[0] + ".errors.csv"
+	out, err := os.Create(report)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := actions.WriteImportReport(out, res); err != nil {
+		return err
+	}
+	fmt.Printf("> the failed rows are in %s\n", report)
+	return out.Close()
+})
@@ -0,0 +1,22 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/leonids/test-buffalo/actions"
+	. "github.com/markbates/grift/grift"
+)
+
+var _ = Desc("ws:token", "Prints a /ws token of a user, for a client without a session: ws:token <user id>")
+var _ = Add("ws:token", func(c *Context) error {
+	if len(c.Args) != 1 {
+		return fmt.Errorf("usage: ws:token <user id>")
+	}
+	// the server must have the same SESSION_SECRET to take it
+	token, err := actions.NewSocketToken(c.Args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+})
@@ -1,17 +1,68 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/leonids/test-buffalo/actions"
-	"github.com/markbates/going/defaults"
+	"github.com/leonids/test-buffalo/config"
 )
 
 func main() {
-	port := defaults.String(os.Getenv("PORT"), "3000")
+	port := config.Current.Port
+	// the background work stops on shutdown, main waits for it
+	ctx, stop := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+	// the server works off the job queues too, see `grift jobs:work` for
+	// dedicated workers
+	worker := actions.NewJobWorker()
+	run(func() { worker.Run(ctx) })
+	// every instance runs the scheduler, the tasks run on one of them
+	scheduler := actions.NewScheduler()
+	run(func() { scheduler.Run(ctx) })
+	// and relays the outbox, its in-process subscribers need it
+	relay, err := actions.NewRelay()
+	if err != nil {
+		log.Fatal(err)
+	}
+	run(func() { relay.Run(ctx, time.Second) })
+	srv := &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: actions.Handler()}
+	// the requests in flight and the sockets get some time to finish
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		<-sigs
+		log.Println("Shutting down")
+		stop()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := actions.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+		close(done)
+	}()
 	log.Printf("Starting test-buffalo on port %s\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), actions.App()))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	wg.Wait()
 }
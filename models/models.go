@@ -2,9 +2,8 @@ package models
 
 import (
 	"log"
-	"os"
 
-	"github.com/markbates/going/defaults"
+	"github.com/leonids/test-buffalo/config"
 	"github.com/markbates/pop"
 )
 
@@ -14,8 +13,23 @@ var DB *pop.Connection
 
 func init() {
 	var err error
-	env := defaults.String(os.Getenv("GO_ENV"), "development")
-	DB, err = pop.Connect(env)
+	env := config.Current.Env
+	// the details come from config, checked with the rest of it
+	d := config.Current.Database
+	DB, err = pop.NewConnection(&pop.ConnectionDetails{
+		Dialect:  d.Dialect,
+		Database: d.Database,
+		Host:     d.Host,
+		Port:     d.Port,
+		User:     d.User,
+		Password: d.Password,
+		URL:      d.URL,
+		Pool:     d.Pool,
+		Options:  d.Options,
+	})
+	if err == nil {
+		err = DB.Open()
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
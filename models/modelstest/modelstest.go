@@ -0,0 +1,36 @@
+// Package modelstest has the helpers of the tests using the database.
+package modelstest
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/markbates/pop"
+)
+
+// migrations is the directory of the migrations of the app.
+var migrations = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "migrations")
+}()
+
+// SQLite returns a throw away SQLite database with the tables of the
+// migrations of the app, so the tests and the schema can't drift apart.
+func SQLite(t testing.TB) *pop.Connection {
+	db, err := pop.NewConnection(&pop.ConnectionDetails{
+		Dialect:  "sqlite3",
+		Database: filepath.Join(t.TempDir(), "test.sqlite"),
+	})
+	if err == nil {
+		err = db.Open()
+	}
+	if err == nil {
+		err = db.MigrateUp(migrations)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
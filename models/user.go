@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+)
+
+// User is an account of the application.
+type User struct {
+	ID        int       `json:"id" db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	Name      string    `json:"name" db:"name"`
+	Email     string    `json:"email" db:"email"`
+	// Avatar is the storage key of the avatar picture, empty without one.
+	Avatar string `json:"-" db:"avatar"`
+}
+
+// String is not required by pop and may be deleted
+func (u User) String() string {
+	b, _ := json.Marshal(u)
+	return string(b)
+}
+
+// Users is not required by pop and may be deleted
+type Users []User
+
+// Validate gets run everytime you call a "pop.Validate" method.
+func (u *User) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: u.Name, Name: "Name"},
+		&validators.StringIsPresent{Field: u.Email, Name: "Email"},
+		&validators.RegexMatch{Field: u.Email, Name: "Email", Expr: `^[^@\s]+@[^@\s]+$`},
+	), nil
+}